@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Invariant names used in reports.
+const (
+	InvConvergence   = "convergence"
+	InvNoDoubleCount = "no-double-counting"
+	InvNoLostWrites  = "no-lost-increments"
+	InvMonotonic     = "monotonic-reads"
+)
+
+// InvariantResult is the verdict on one invariant.
+type InvariantResult struct {
+	Name   string   `json:"name"`
+	Passed bool     `json:"passed"`
+	Detail string   `json:"detail"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Result summarises a checked history.
+type Result struct {
+	Increments    int `json:"increments"`
+	Acked         int `json:"acked"`
+	Failed        int `json:"failed"`
+	Indeterminate int `json:"indeterminate"`
+	// AckedOnCrashed counts acknowledged increments served by a node
+	// incarnation that was later killed. They may be lost by design, since a
+	// node acknowledges an increment before it reaches any peer.
+	AckedOnCrashed int            `json:"acked_on_crashed"`
+	Reads          int            `json:"reads"`
+	Nemesis        map[OpKind]int `json:"nemesis"`
+	// Final holds each node's value; it is only meaningful when the nodes converged.
+	Final      []int64           `json:"final"`
+	Converged  bool              `json:"converged"`
+	Invariants []InvariantResult `json:"invariants"`
+	Valid      bool              `json:"valid"`
+}
+
+// maxErrors caps how many examples are kept per failed invariant.
+const maxErrors = 5
+
+type incarnation struct{ node, n int }
+
+// Check verifies the invariants of a history given the final value of every
+// node. convergeErr is the error, if any, from waiting for the nodes to agree.
+func Check(ops []Op, final []int64, convergeErr error) Result {
+	res := Result{Nemesis: make(map[OpKind]int), Final: final}
+	killed := make(map[incarnation]bool)
+	for _, op := range ops {
+		switch op.Kind {
+		case OpIncrement:
+			res.Increments++
+			switch op.Outcome {
+			case OutcomeOK:
+				res.Acked++
+			case OutcomeFail:
+				res.Failed++
+			default:
+				res.Indeterminate++
+			}
+		case OpRead:
+			if op.Outcome == OutcomeOK {
+				res.Reads++
+			}
+		case OpKill:
+			killed[incarnation{op.Node, op.Incarnation}] = true
+			res.Nemesis[op.Kind]++
+		default:
+			res.Nemesis[op.Kind]++
+		}
+	}
+	for _, op := range ops {
+		if op.Kind == OpIncrement && op.Outcome == OutcomeOK && killed[incarnation{op.Node, op.Incarnation}] {
+			res.AckedOnCrashed++
+		}
+	}
+
+	res.Invariants = []InvariantResult{
+		checkConvergence(final, convergeErr),
+		checkNoDoubleCounting(ops, final, res),
+		checkNoLostIncrements(final, res),
+		checkMonotonicReads(ops),
+	}
+	res.Converged = res.Invariants[0].Passed
+	res.Valid = true
+	for _, inv := range res.Invariants {
+		res.Valid = res.Valid && inv.Passed
+	}
+	return res
+}
+
+func checkConvergence(final []int64, convergeErr error) InvariantResult {
+	inv := InvariantResult{Name: InvConvergence, Passed: true}
+	if convergeErr != nil {
+		inv.Passed = false
+		inv.Errors = append(inv.Errors, convergeErr.Error())
+	}
+	for i, v := range final {
+		if v != final[0] {
+			inv.Passed = false
+			inv.Errors = append(inv.Errors, fmt.Sprintf("node %d has %d, node 0 has %d", i, v, final[0]))
+		}
+	}
+	if inv.Passed {
+		inv.Detail = fmt.Sprintf("all %d nodes at %d", len(final), finalValue(final))
+	} else {
+		inv.Detail = fmt.Sprintf("nodes disagree: %v", final)
+	}
+	return inv
+}
+
+// checkNoDoubleCounting bounds the final value, and every read, by the
+// number of increments that could have taken effect by then.
+func checkNoDoubleCounting(ops []Op, final []int64, res Result) InvariantResult {
+	inv := InvariantResult{Name: InvNoDoubleCount, Passed: true}
+	upper := int64(res.Acked + res.Indeterminate)
+	if v := finalValue(final); v > upper {
+		inv.Passed = false
+		inv.Errors = append(inv.Errors, fmt.Sprintf("final value %d exceeds the %d increments that may have been applied", v, upper))
+	}
+
+	// Increments that may have been applied, sorted by when they were issued.
+	var starts []int64
+	for _, op := range ops {
+		if op.Kind == OpIncrement && op.Outcome != OutcomeFail {
+			starts = append(starts, int64(op.Start))
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, op := range ops {
+		if op.Kind != OpRead || op.Outcome != OutcomeOK {
+			continue
+		}
+		possible := sort.Search(len(starts), func(i int) bool { return starts[i] >= int64(op.End) })
+		if op.Value > int64(possible) {
+			inv.Passed = false
+			inv.Errors = appendCapped(inv.Errors, fmt.Sprintf("op %d: node %d read %d but only %d increments had been issued", op.Index, op.Node, op.Value, possible))
+		}
+	}
+	inv.Detail = fmt.Sprintf("final %d <= %d acknowledged + %d indeterminate", finalValue(final), res.Acked, res.Indeterminate)
+	return inv
+}
+
+// checkNoLostIncrements requires every acknowledged increment to be counted.
+// Increments acknowledged by nodes that later crashed are allowed to be lost,
+// and reported separately.
+func checkNoLostIncrements(final []int64, res Result) InvariantResult {
+	inv := InvariantResult{Name: InvNoLostWrites, Passed: true}
+	v := finalValue(final)
+	lower := int64(res.Acked - res.AckedOnCrashed)
+	if v < lower {
+		inv.Passed = false
+		inv.Errors = append(inv.Errors, fmt.Sprintf("final value %d is below the %d increments acknowledged by nodes that never crashed", v, lower))
+	}
+	switch {
+	case res.AckedOnCrashed == 0:
+		inv.Detail = fmt.Sprintf("%d acknowledged, %d counted", res.Acked, v)
+	default:
+		lost := int64(res.Acked) - v
+		if lost < 0 {
+			lost = 0
+		}
+		inv.Detail = fmt.Sprintf("%d acknowledged, %d counted; %d acknowledged by crashed nodes, up to %d lost in crashes", res.Acked, v, res.AckedOnCrashed, lost)
+	}
+	return inv
+}
+
+// checkMonotonicReads requires that a read never returns less than a read of
+// the same node incarnation that completed before it began. A restarted node
+// starts from zero, so each incarnation is checked on its own.
+func checkMonotonicReads(ops []Op) InvariantResult {
+	inv := InvariantResult{Name: InvMonotonic, Passed: true}
+	byIncarnation := make(map[incarnation][]Op)
+	for _, op := range ops {
+		if op.Kind == OpRead && op.Outcome == OutcomeOK {
+			key := incarnation{op.Node, op.Incarnation}
+			byIncarnation[key] = append(byIncarnation[key], op)
+		}
+	}
+
+	for _, reads := range byIncarnation {
+		byEnd := append([]Op(nil), reads...)
+		sort.Slice(byEnd, func(i, j int) bool { return byEnd[i].End < byEnd[j].End })
+		sort.Slice(reads, func(i, j int) bool { return reads[i].Start < reads[j].Start })
+
+		var max Op
+		seen := false
+		next := 0
+		for _, op := range reads {
+			for next < len(byEnd) && byEnd[next].End < op.Start {
+				if !seen || byEnd[next].Value > max.Value {
+					max, seen = byEnd[next], true
+				}
+				next++
+			}
+			if seen && op.Value < max.Value {
+				inv.Passed = false
+				inv.Errors = appendCapped(inv.Errors, fmt.Sprintf("op %d: node %d read %d after op %d read %d", op.Index, op.Node, op.Value, max.Index, max.Value))
+			}
+		}
+	}
+	inv.Detail = fmt.Sprintf("%d node incarnations read", len(byIncarnation))
+	return inv
+}
+
+func finalValue(final []int64) int64 {
+	if len(final) == 0 {
+		return 0
+	}
+	return final[0]
+}
+
+func appendCapped(errs []string, msg string) []string {
+	if len(errs) < maxErrors {
+		return append(errs, msg)
+	}
+	if len(errs) == maxErrors {
+		return append(errs, "...")
+	}
+	return errs
+}
+
+// WriteReport prints a human-readable summary of res.
+func WriteReport(w io.Writer, header string, res Result) {
+	fmt.Fprintln(w, header)
+	fmt.Fprintf(w, "  increments: %d (%d ok, %d failed, %d indeterminate)\n", res.Increments, res.Acked, res.Failed, res.Indeterminate)
+	fmt.Fprintf(w, "  reads:      %d\n", res.Reads)
+	fmt.Fprintf(w, "  nemesis:    %d kills, %d restarts, %d partitions, %d heals\n",
+		res.Nemesis[OpKill], res.Nemesis[OpRestart], res.Nemesis[OpPartition], res.Nemesis[OpHeal])
+	values := make([]string, len(res.Final))
+	for i, v := range res.Final {
+		values[i] = fmt.Sprintf("node %d=%d", i, v)
+	}
+	fmt.Fprintf(w, "  final:      %s\n", strings.Join(values, " "))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Invariants:")
+	for _, inv := range res.Invariants {
+		verdict := "PASS"
+		if !inv.Passed {
+			verdict = "FAIL"
+		}
+		fmt.Fprintf(w, "  %s  %-20s %s\n", verdict, inv.Name, inv.Detail)
+		for _, e := range inv.Errors {
+			fmt.Fprintf(w, "        %s\n", e)
+		}
+	}
+	fmt.Fprintln(w)
+	if res.Valid {
+		fmt.Fprintln(w, "Result: valid")
+	} else {
+		fmt.Fprintln(w, "Result: INVALID")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func inc(node, incarnation int, outcome Outcome, start, end time.Duration) Op {
+	return Op{Kind: OpIncrement, Node: node, Incarnation: incarnation, Outcome: outcome, Start: start, End: end}
+}
+
+func rd(node, incarnation int, value int64, start, end time.Duration) Op {
+	return Op{Kind: OpRead, Node: node, Incarnation: incarnation, Outcome: OutcomeOK, Value: value, Start: start, End: end}
+}
+
+func invariant(res Result, name string) InvariantResult {
+	for _, inv := range res.Invariants {
+		if inv.Name == name {
+			return inv
+		}
+	}
+	return InvariantResult{}
+}
+
+func TestCheck_ValidHistory(t *testing.T) {
+	ops := []Op{
+		inc(0, 0, OutcomeOK, 0, 1),
+		inc(1, 0, OutcomeOK, 1, 2),
+		inc(1, 0, OutcomeFail, 2, 3),
+		rd(0, 0, 1, 2, 3),
+		rd(0, 0, 2, 4, 5),
+	}
+	res := Check(ops, []int64{2, 2}, nil)
+	assert.True(t, res.Valid)
+	assert.Equal(t, 2, res.Acked)
+	assert.Equal(t, 1, res.Failed)
+	assert.Equal(t, 2, res.Reads)
+}
+
+func TestCheck_DoubleCounting(t *testing.T) {
+	ops := []Op{
+		inc(0, 0, OutcomeOK, 0, 1),
+		rd(1, 0, 2, 2, 3),
+	}
+	res := Check(ops, []int64{2, 2}, nil)
+	assert.False(t, res.Valid)
+	inv := invariant(res, InvNoDoubleCount)
+	assert.False(t, inv.Passed)
+	assert.Len(t, inv.Errors, 2, "both the final value and the read exceed one increment")
+}
+
+func TestCheck_IndeterminateIncrementsMayCount(t *testing.T) {
+	ops := []Op{
+		inc(0, 0, OutcomeOK, 0, 1),
+		inc(0, 0, OutcomeInfo, 1, 2),
+	}
+	assert.True(t, Check(ops, []int64{2}, nil).Valid)
+	assert.True(t, Check(ops, []int64{1}, nil).Valid)
+}
+
+func TestCheck_LostIncrements(t *testing.T) {
+	ops := []Op{
+		inc(0, 0, OutcomeOK, 0, 1),
+		inc(1, 0, OutcomeOK, 1, 2),
+	}
+	res := Check(ops, []int64{1, 1}, nil)
+	assert.False(t, invariant(res, InvNoLostWrites).Passed)
+}
+
+func TestCheck_IncrementsOnCrashedNodesMayBeLost(t *testing.T) {
+	ops := []Op{
+		inc(0, 0, OutcomeOK, 0, 1),
+		inc(1, 0, OutcomeOK, 1, 2),
+		{Kind: OpKill, Node: 1, Incarnation: 0, Outcome: OutcomeOK, Start: 2, End: 3},
+		{Kind: OpRestart, Node: 1, Incarnation: 1, Outcome: OutcomeOK, Start: 4, End: 5},
+	}
+	res := Check(ops, []int64{1, 1}, nil)
+	assert.True(t, res.Valid)
+	assert.Equal(t, 1, res.AckedOnCrashed)
+	assert.Contains(t, invariant(res, InvNoLostWrites).Detail, "up to 1 lost in crashes")
+}
+
+func TestCheck_NonMonotonicReads(t *testing.T) {
+	ops := []Op{
+		inc(0, 0, OutcomeOK, 0, 1),
+		inc(0, 0, OutcomeOK, 1, 2),
+		rd(0, 0, 2, 3, 4),
+		rd(0, 0, 1, 5, 6),
+	}
+	res := Check(ops, []int64{2}, nil)
+	assert.False(t, invariant(res, InvMonotonic).Passed)
+}
+
+func TestCheck_ReadsMayGoBackAcrossRestartsOrWhenConcurrent(t *testing.T) {
+	ops := []Op{
+		inc(0, 0, OutcomeOK, 0, 1),
+		inc(0, 0, OutcomeOK, 1, 2),
+		rd(0, 0, 2, 3, 6),
+		rd(0, 0, 1, 4, 5), // concurrent with the read above
+		rd(0, 1, 0, 7, 8), // new incarnation starts empty
+	}
+	res := Check(ops, []int64{2}, nil)
+	assert.True(t, invariant(res, InvMonotonic).Passed)
+}
+
+func TestCheck_Divergence(t *testing.T) {
+	ops := []Op{inc(0, 0, OutcomeOK, 0, 1)}
+	res := Check(ops, []int64{1, 0}, errors.New("nodes did not converge"))
+	assert.False(t, res.Valid)
+	assert.False(t, res.Converged)
+}
+
+func TestWriteReport(t *testing.T) {
+	res := Check([]Op{inc(0, 0, OutcomeOK, 0, 1)}, []int64{0}, nil)
+	var buf bytes.Buffer
+	WriteReport(&buf, "Checked 1 nodes", res)
+	assert.Contains(t, buf.String(), "FAIL  no-lost-increments")
+	assert.Contains(t, buf.String(), "Result: INVALID")
+}
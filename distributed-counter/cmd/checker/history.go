@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// OpKind is what an operation in the history did.
+type OpKind string
+
+const (
+	OpIncrement OpKind = "increment"
+	OpRead      OpKind = "read"
+	OpKill      OpKind = "kill"
+	OpRestart   OpKind = "restart"
+	OpPartition OpKind = "partition"
+	OpHeal      OpKind = "heal"
+)
+
+// Outcome is what the client knows about an operation once it returns.
+type Outcome string
+
+const (
+	// OutcomeOK operations took effect.
+	OutcomeOK Outcome = "ok"
+	// OutcomeFail operations definitely did not take effect.
+	OutcomeFail Outcome = "fail"
+	// OutcomeInfo operations may or may not have taken effect, e.g. on a timeout.
+	OutcomeInfo Outcome = "info"
+)
+
+// Op is one client or nemesis operation as the checker observed it.
+type Op struct {
+	Index int    `json:"index"`
+	Kind  OpKind `json:"kind"`
+	// Client is the workload client that issued the op, or -1 for the nemesis.
+	Client int `json:"client"`
+	// Node and Incarnation identify the process that served the op. A node's
+	// incarnation goes up each time it is restarted.
+	Node        int           `json:"node"`
+	Incarnation int           `json:"incarnation"`
+	Outcome     Outcome       `json:"outcome"`
+	Value       int64         `json:"value,omitempty"`
+	Start       time.Duration `json:"start"`
+	End         time.Duration `json:"end"`
+	Detail      string        `json:"detail,omitempty"`
+}
+
+// History is the ordered, concurrency-safe record of a run.
+type History struct {
+	mu    sync.Mutex
+	began time.Time
+	ops   []Op
+}
+
+func NewHistory() *History {
+	return &History{began: time.Now()}
+}
+
+// Now returns the time since the run began.
+func (h *History) Now() time.Duration {
+	return time.Since(h.began)
+}
+
+// Record appends a completed op, stamping its end time.
+func (h *History) Record(op Op) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	op.Index = len(h.ops)
+	op.End = time.Since(h.began)
+	h.ops = append(h.ops, op)
+}
+
+// Ops returns a copy of the recorded ops.
+func (h *History) Ops() []Op {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Op(nil), h.ops...)
+}
+
+// WriteJSON writes the history as one JSON op per line.
+func (h *History) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, op := range h.Ops() {
+		if err := enc.Encode(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+// Command checker runs a random workload of increments and reads against a
+// cluster while killing, restarting and partitioning nodes, then verifies
+// that the recorded history is consistent with the counter's guarantees.
+package main
+
+import (
+	"context"
+	"distributed-counter/internal/sim"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// errInvalid is returned when the history violates an invariant.
+var errInvalid = errors.New("history is invalid")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		log.Printf("checker: %v", err)
+		os.Exit(1)
+	}
+}
+
+type config struct {
+	mode      string
+	nodes     int
+	ops       int
+	clients   int
+	opDelay   time.Duration
+	readRatio float64
+	nemesis   map[OpKind]bool
+	interval  time.Duration
+	seed      int64
+	settle    time.Duration
+	output    string
+	history   string
+}
+
+// run parses the command line, runs one check and writes the report. It is designed to be testable.
+func run(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("checker", flag.ContinueOnError)
+	fs.SetOutput(out)
+	mode := fs.String("mode", "sim", "Where to run the nodes: sim (in process) or procs (local server processes)")
+	nodes := fs.Int("nodes", 3, "Number of nodes")
+	ops := fs.Int("ops", 1000, "Number of client operations")
+	clients := fs.Int("clients", 4, "Number of concurrent clients")
+	opDelay := fs.Duration("op-delay", 5*time.Millisecond, "Mean pause between a client's operations")
+	readRatio := fs.Float64("read-ratio", 0.3, "Fraction of client operations that are reads")
+	nemesis := fs.String("nemesis", "kill,partition", "Comma-separated faults to inject: kill, partition, or none")
+	interval := fs.Duration("nemesis-interval", 200*time.Millisecond, "Time between injecting and recovering from a fault")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "Random seed for the workload, nemesis and simulated network")
+	settle := fs.Duration("settle", 30*time.Second, "How long to wait for the nodes to converge after the workload")
+	drop := fs.Float64("drop", 0, "sim: fraction of messages dropped")
+	duplicate := fs.Float64("duplicate", 0, "sim: fraction of messages delivered twice")
+	reorder := fs.Float64("reorder", 0, "sim: fraction of messages delayed by up to 500ms of simulated time")
+	bin := fs.String("server-bin", "", "procs: path to the server binary")
+	basePort := fs.Int("base-port", 18080, "procs: port of the first node; the others follow")
+	logDir := fs.String("log-dir", "", "procs: directory for node logs (discarded if empty)")
+	output := fs.String("o", "text", "Report format: text or json")
+	history := fs.String("history", "", "File to write the history to, as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("failed to parse flags: %w", err)
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	if *nodes < 1 {
+		return errors.New("need at least one node")
+	}
+
+	cfg := config{
+		mode:      *mode,
+		nodes:     *nodes,
+		ops:       *ops,
+		clients:   *clients,
+		opDelay:   *opDelay,
+		readRatio: *readRatio,
+		nemesis:   make(map[OpKind]bool),
+		interval:  *interval,
+		seed:      *seed,
+		settle:    *settle,
+		output:    *output,
+		history:   *history,
+	}
+	for _, name := range strings.Split(*nemesis, ",") {
+		switch strings.TrimSpace(name) {
+		case "kill":
+			cfg.nemesis[OpKill] = true
+		case "partition":
+			cfg.nemesis[OpPartition] = true
+		case "none", "":
+		default:
+			return fmt.Errorf("unknown nemesis %q", name)
+		}
+	}
+
+	var t target
+	var err error
+	switch cfg.mode {
+	case "sim":
+		// Keep the simulated logs out of the report.
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+		t, err = newSimTarget(cfg.nodes, cfg.seed, sim.Faults{
+			DropRate:      *drop,
+			DuplicateRate: *duplicate,
+			ReorderRate:   *reorder,
+			MaxDelay:      500 * time.Millisecond,
+		})
+	case "procs":
+		if *bin == "" {
+			return errors.New("procs mode needs --server-bin")
+		}
+		if cfg.nemesis[OpPartition] {
+			return errors.New("procs mode cannot partition nodes; use --nemesis=kill")
+		}
+		t, err = newProcTarget(*bin, cfg.nodes, *basePort, *logDir, 2*time.Second)
+	default:
+		return fmt.Errorf("unknown mode %q", cfg.mode)
+	}
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	h := NewHistory()
+	res := check(ctx, t, h, cfg)
+
+	if cfg.history != "" {
+		f, err := os.Create(cfg.history)
+		if err != nil {
+			return fmt.Errorf("failed to create history file: %w", err)
+		}
+		defer f.Close()
+		if err := h.WriteJSON(f); err != nil {
+			return fmt.Errorf("failed to write history: %w", err)
+		}
+	}
+
+	if cfg.output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	} else {
+		header := fmt.Sprintf("Checked %d nodes (%s) with seed %d in %s", cfg.nodes, cfg.mode, cfg.seed, h.Now().Round(time.Millisecond))
+		WriteReport(out, header, res)
+	}
+	if !res.Valid {
+		return errInvalid
+	}
+	return nil
+}
+
+// check runs the workload and nemesis, recovers every node, waits for the
+// cluster to converge and checks the history.
+func check(ctx context.Context, t target, h *History, cfg config) Result {
+	workCtx, cancel := context.WithCancel(ctx)
+	var nemesisDone sync.WaitGroup
+	nemesisDone.Add(1)
+	go func() {
+		defer nemesisDone.Done()
+		runNemesis(workCtx, t, h, cfg)
+	}()
+
+	runWorkload(ctx, t, h, cfg)
+	cancel()
+	nemesisDone.Wait()
+
+	// The nemesis recovers from its last fault; make sure no node stayed down.
+	for i := 0; i < t.Size(); i++ {
+		restart(t, h, i)
+	}
+
+	final, err := awaitStable(ctx, t, cfg.settle)
+	return Check(h.Ops(), final, err)
+}
+
+func runWorkload(ctx context.Context, t target, h *History, cfg config) {
+	var issued atomic.Int64
+	var wg sync.WaitGroup
+	for client := 0; client < cfg.clients; client++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(cfg.seed + int64(client) + 1))
+			for issued.Add(1) <= int64(cfg.ops) && ctx.Err() == nil {
+				node := rng.Intn(t.Size())
+				if rng.Float64() < cfg.readRatio {
+					read(ctx, t, h, client, node)
+				} else {
+					increment(ctx, t, h, client, node)
+				}
+				// Spread the workload over several nemesis intervals.
+				time.Sleep(time.Duration(rng.Int63n(int64(2*cfg.opDelay) + 1)))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func increment(ctx context.Context, t target, h *History, client, node int) {
+	op := Op{Kind: OpIncrement, Client: client, Node: node, Start: h.Now(), Outcome: OutcomeOK}
+	inc, err := t.Increment(ctx, node)
+	op.Incarnation = inc
+	if err != nil {
+		op.Outcome, op.Detail = OutcomeInfo, err.Error()
+		if t.Definite(err) {
+			op.Outcome = OutcomeFail
+		}
+	}
+	h.Record(op)
+}
+
+func read(ctx context.Context, t target, h *History, client, node int) {
+	op := Op{Kind: OpRead, Client: client, Node: node, Start: h.Now(), Outcome: OutcomeOK}
+	v, inc, err := t.Read(ctx, node)
+	op.Value, op.Incarnation = v, inc
+	if err != nil {
+		op.Outcome, op.Detail = OutcomeFail, err.Error()
+	}
+	h.Record(op)
+}
+
+// runNemesis alternates between injecting a random fault and recovering
+// from it, so no fault lasts longer than one interval. Longer partitions
+// outlast propagation retries and peer expiry, which the counter does not
+// yet repair.
+func runNemesis(ctx context.Context, t target, h *History, cfg config) {
+	var kinds []OpKind
+	for _, kind := range []OpKind{OpKill, OpPartition} {
+		if cfg.nemesis[kind] {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return
+	}
+	rng := rand.New(rand.NewSource(cfg.seed))
+	killed := -1
+	partitioned := false
+	for {
+		select {
+		case <-ctx.Done():
+			if partitioned {
+				heal(t, h)
+			}
+			return
+		case <-time.After(cfg.interval):
+		}
+
+		switch {
+		case killed >= 0:
+			restart(t, h, killed)
+			killed = -1
+		case partitioned:
+			heal(t, h)
+			partitioned = false
+		default:
+			switch kinds[rng.Intn(len(kinds))] {
+			case OpKill:
+				if t.Size() > 1 {
+					killed = rng.Intn(t.Size())
+					kill(t, h, killed)
+				}
+			case OpPartition:
+				partitioned = partition(t, h, rng)
+			}
+		}
+	}
+}
+
+func kill(t target, h *History, node int) {
+	op := Op{Kind: OpKill, Client: -1, Node: node, Start: h.Now(), Outcome: OutcomeOK}
+	inc, err := t.Kill(node)
+	op.Incarnation = inc
+	if err != nil {
+		op.Outcome, op.Detail = OutcomeFail, err.Error()
+	}
+	h.Record(op)
+}
+
+// restart records a restart only for nodes that were down.
+func restart(t target, h *History, node int) {
+	if _, _, err := t.Read(context.Background(), node); err == nil {
+		return
+	}
+	op := Op{Kind: OpRestart, Client: -1, Node: node, Start: h.Now(), Outcome: OutcomeOK}
+	inc, err := t.Restart(node)
+	op.Incarnation = inc
+	if err != nil {
+		op.Outcome, op.Detail = OutcomeFail, err.Error()
+	}
+	h.Record(op)
+}
+
+// partition splits the nodes in two random non-empty halves.
+func partition(t target, h *History, rng *rand.Rand) bool {
+	if t.Size() < 2 {
+		return false
+	}
+	perm := rng.Perm(t.Size())
+	cut := 1 + rng.Intn(t.Size()-1)
+	groups := [][]int{perm[:cut], perm[cut:]}
+	op := Op{Kind: OpPartition, Client: -1, Node: -1, Start: h.Now(), Outcome: OutcomeOK, Detail: fmt.Sprint(groups)}
+	if err := t.Partition(groups); err != nil {
+		op.Outcome, op.Detail = OutcomeFail, err.Error()
+		h.Record(op)
+		return false
+	}
+	h.Record(op)
+	return true
+}
+
+func heal(t target, h *History) {
+	op := Op{Kind: OpHeal, Client: -1, Node: -1, Start: h.Now(), Outcome: OutcomeOK}
+	if err := t.Heal(); err != nil {
+		op.Outcome, op.Detail = OutcomeFail, err.Error()
+	}
+	h.Record(op)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_SimWithFaults(t *testing.T) {
+	history := filepath.Join(t.TempDir(), "history.jsonl")
+	var out bytes.Buffer
+	err := run(context.Background(), []string{
+		"-seed", "1", "-ops", "200", "-op-delay", "2ms", "-nemesis-interval", "50ms",
+		"-history", history,
+	}, &out)
+	require.NoError(t, err, out.String())
+	assert.Contains(t, out.String(), "Result: valid")
+
+	data, err := os.ReadFile(history)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"kind":"increment"`)
+}
+
+func TestRun_JSONReport(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, run(context.Background(), []string{"-seed", "2", "-ops", "50", "-nemesis", "none", "-o", "json"}, &out))
+
+	var res Result
+	require.NoError(t, json.Unmarshal(out.Bytes(), &res))
+	assert.True(t, res.Valid)
+	assert.Equal(t, 50, res.Increments+res.Reads)
+}
+
+func TestRun_RejectsBadFlags(t *testing.T) {
+	var out bytes.Buffer
+	assert.Error(t, run(context.Background(), []string{"-mode", "cloud"}, &out))
+	assert.Error(t, run(context.Background(), []string{"-nemesis", "flood"}, &out))
+	assert.Error(t, run(context.Background(), []string{"-mode", "procs"}, &out))
+	assert.Error(t, run(context.Background(), []string{"-mode", "procs", "-server-bin", "x", "-nemesis", "partition"}, &out))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// procTarget runs each node as a local server process listening on
+// consecutive ports. It can kill and restart nodes but not partition them.
+type procTarget struct {
+	bin     string
+	ports   []int
+	logDir  string
+	client  *http.Client
+	timeout time.Duration
+
+	mu           sync.Mutex
+	procs        []*exec.Cmd
+	incarnations []int
+}
+
+func newProcTarget(bin string, nodes, basePort int, logDir string, timeout time.Duration) (*procTarget, error) {
+	t := &procTarget{
+		bin:          bin,
+		logDir:       logDir,
+		client:       &http.Client{Timeout: timeout},
+		timeout:      timeout,
+		procs:        make([]*exec.Cmd, nodes),
+		incarnations: make([]int, nodes),
+	}
+	for i := 0; i < nodes; i++ {
+		t.ports = append(t.ports, basePort+i)
+	}
+	for i := 0; i < nodes; i++ {
+		var seeds []string
+		if i > 0 {
+			seeds = []string{t.addr(0)}
+		}
+		if err := t.start(i, seeds); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *procTarget) addr(node int) string {
+	return fmt.Sprintf("localhost:%d", t.ports[node])
+}
+
+// start launches a node and waits until it answers /healthz.
+func (t *procTarget) start(node int, seeds []string) error {
+	args := []string{"--port", strconv.Itoa(t.ports[node]), "--peers", strings.Join(seeds, ",")}
+	cmd := exec.Command(t.bin, args...)
+	if t.logDir != "" {
+		name := filepath.Join(t.logDir, fmt.Sprintf("node-%d.log", node))
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open node log: %w", err)
+		}
+		defer f.Close()
+		cmd.Stdout, cmd.Stderr = f, f
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start node %d: %w", node, err)
+	}
+	t.mu.Lock()
+	t.procs[node] = cmd
+	t.mu.Unlock()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := t.client.Get("http://" + t.addr(node) + "/healthz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("node %d did not become healthy", node)
+}
+
+func (t *procTarget) incarnation(node int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.incarnations[node]
+}
+
+func (t *procTarget) Size() int { return len(t.ports) }
+
+func (t *procTarget) Increment(ctx context.Context, node int) (int, error) {
+	inc := t.incarnation(node)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+t.addr(node)+"/increment", nil)
+	if err != nil {
+		return inc, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return inc, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return inc, &statusError{code: resp.StatusCode}
+	}
+	return inc, nil
+}
+
+func (t *procTarget) Read(ctx context.Context, node int) (int64, int, error) {
+	inc := t.incarnation(node)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+t.addr(node)+"/count", nil)
+	if err != nil {
+		return 0, inc, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, inc, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, inc, &statusError{code: resp.StatusCode}
+	}
+	var body struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, inc, fmt.Errorf("failed to decode count: %w", err)
+	}
+	return body.Count, inc, nil
+}
+
+func (t *procTarget) Kill(node int) (int, error) {
+	t.mu.Lock()
+	cmd := t.procs[node]
+	t.procs[node] = nil
+	inc := t.incarnations[node]
+	t.mu.Unlock()
+	if cmd == nil {
+		return inc, nil
+	}
+	if err := cmd.Process.Kill(); err != nil {
+		return inc, fmt.Errorf("failed to kill node %d: %w", node, err)
+	}
+	cmd.Wait()
+	return inc, nil
+}
+
+func (t *procTarget) Restart(node int) (int, error) {
+	t.mu.Lock()
+	if t.procs[node] != nil {
+		inc := t.incarnations[node]
+		t.mu.Unlock()
+		return inc, nil
+	}
+	t.incarnations[node]++
+	inc := t.incarnations[node]
+	var seeds []string
+	for i, cmd := range t.procs {
+		if cmd != nil {
+			seeds = append(seeds, t.addr(i))
+		}
+	}
+	t.mu.Unlock()
+	return inc, t.start(node, seeds)
+}
+
+func (t *procTarget) Partition(groups [][]int) error { return errUnsupported }
+func (t *procTarget) Heal() error                    { return nil }
+
+// Definite is true for errors the node sent back, and for refused
+// connections; a timeout may have applied the increment.
+func (t *procTarget) Definite(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (t *procTarget) Close() {
+	for i := range t.ports {
+		t.Kill(i)
+	}
+}
+
+type statusError struct{ code int }
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("received non-OK status code: %d", e.code)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/sim"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errUnsupported is returned by targets that cannot inject a fault.
+var errUnsupported = errors.New("not supported by this target")
+
+// target is a cluster the checker drives.
+type target interface {
+	Size() int
+	// Increment and Read return the incarnation of the node that served them.
+	Increment(ctx context.Context, node int) (int, error)
+	Read(ctx context.Context, node int) (int64, int, error)
+	Kill(node int) (int, error)
+	Restart(node int) (int, error)
+	Partition(groups [][]int) error
+	Heal() error
+	// Definite reports whether a failed increment certainly did not apply.
+	Definite(err error) bool
+	Close()
+}
+
+// simTarget runs the cluster in process on the simulation harness, with a
+// background driver advancing its clock ten times faster than real time.
+type simTarget struct {
+	c *sim.Cluster
+
+	mu           sync.Mutex
+	incarnations []int
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+func newSimTarget(nodes int, seed int64, faults sim.Faults) (*simTarget, error) {
+	c := sim.NewCluster(sim.Options{Nodes: nodes, Seed: seed, Counter: counter.Config{}})
+	if err := c.AwaitMembership(10 * time.Second); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("cluster did not form: %w", err)
+	}
+	c.Net.SetFaults(faults)
+	t := &simTarget{
+		c:            c,
+		incarnations: make([]int, nodes),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go t.drive()
+	return t, nil
+}
+
+func (t *simTarget) drive() {
+	defer close(t.done)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.c.Step(100 * time.Millisecond)
+		}
+	}
+}
+
+func (t *simTarget) incarnation(node int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.incarnations[node]
+}
+
+func (t *simTarget) Size() int { return len(t.incarnations) }
+
+func (t *simTarget) Increment(ctx context.Context, node int) (int, error) {
+	inc := t.incarnation(node)
+	return inc, t.c.Increment(node)
+}
+
+func (t *simTarget) Read(ctx context.Context, node int) (int64, int, error) {
+	inc := t.incarnation(node)
+	if !t.c.Up(node) {
+		return 0, inc, sim.ErrNodeDown
+	}
+	return t.c.Node(node).Counter.Value(), inc, nil
+}
+
+func (t *simTarget) Kill(node int) (int, error) {
+	inc := t.incarnation(node)
+	t.c.Kill(node)
+	return inc, nil
+}
+
+func (t *simTarget) Restart(node int) (int, error) {
+	t.mu.Lock()
+	t.incarnations[node]++
+	inc := t.incarnations[node]
+	t.mu.Unlock()
+	t.c.Restart(node)
+	return inc, nil
+}
+
+func (t *simTarget) Partition(groups [][]int) error {
+	t.c.Partition(groups...)
+	return nil
+}
+
+func (t *simTarget) Heal() error {
+	t.c.Heal()
+	return nil
+}
+
+// Definite is always true in process: a failed increment was never applied.
+func (t *simTarget) Definite(err error) bool { return true }
+
+func (t *simTarget) Close() {
+	close(t.stop)
+	<-t.done
+	t.c.Close()
+}
+
+// awaitStable polls every node until all report the same value several
+// times in a row, returning the last values seen.
+func awaitStable(ctx context.Context, t target, timeout time.Duration) ([]int64, error) {
+	const stableRounds = 5
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	values := make([]int64, t.Size())
+	stable := 0
+	var last int64 = -1
+	for {
+		agreed := true
+		var readErr error
+		for i := range values {
+			v, _, err := t.Read(ctx, i)
+			if err != nil {
+				readErr = err
+				agreed = false
+				continue
+			}
+			values[i] = v
+			if v != values[0] {
+				agreed = false
+			}
+		}
+		switch {
+		case agreed && values[0] == last:
+			stable++
+		case agreed:
+			stable, last = 1, values[0]
+		default:
+			stable, last = 0, -1
+		}
+		if stable >= stableRounds {
+			return values, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if readErr != nil {
+				return values, fmt.Errorf("nodes did not converge: %w", readErr)
+			}
+			return values, fmt.Errorf("nodes did not converge within %s: %v", timeout, values)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,470 @@
+// Command counterctl operates a distributed-counter cluster through any of
+// its nodes' public and admin APIs.
+package main
+
+import (
+	"bytes"
+	"context"
+	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/transport"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: counterctl [flags] <command> [args]
+
+Commands:
+  status         status of every node in the cluster, with counter divergence
+  peers          peers as seen by the node
+  increment      increment the counter through the node
+  get            counter value of every node side by side
+  reset          start a new epoch across the cluster, zeroing the counter
+  epochs         count of the current and every past epoch
+  evict <peer>   remove a peer from every node's membership list
+  drain          stop the node accepting increments and report not ready
+  dump-state     everything the node knows, as JSON
+  export [file]  snapshot of the node's counter state, to stdout or a file
+  import <file>  merge a snapshot into every node; "-" reads stdin
+
+Flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		log.Printf("counterctl: %v", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the command line and executes one command. It is designed to be testable.
+func run(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("counterctl", flag.ContinueOnError)
+	node := fs.String("node", "localhost:8080", "Address of any node in the cluster")
+	output := fs.String("o", "table", "Output format: table or json")
+	timeout := fs.Duration("timeout", 5*time.Second, "Timeout for each request")
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprint(out, usage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("failed to parse flags: %w", err)
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	c := &ctl{
+		node:   *node,
+		client: &http.Client{Timeout: *timeout},
+		out:    out,
+		json:   *output == "json",
+	}
+
+	switch cmd := fs.Arg(0); cmd {
+	case "status":
+		return c.status(ctx)
+	case "peers":
+		return c.peers(ctx)
+	case "increment":
+		return c.increment(ctx)
+	case "get":
+		return c.get(ctx)
+	case "reset":
+		return c.reset(ctx)
+	case "epochs":
+		return c.epochs(ctx)
+	case "evict":
+		if fs.NArg() != 2 {
+			return errors.New("usage: counterctl evict <peer>")
+		}
+		return c.evict(ctx, fs.Arg(1))
+	case "drain":
+		return c.drain(ctx)
+	case "dump-state":
+		return c.dumpState(ctx)
+	case "export":
+		if fs.NArg() > 2 {
+			return errors.New("usage: counterctl export [file]")
+		}
+		return c.export(ctx, fs.Arg(1))
+	case "import":
+		if fs.NArg() != 2 {
+			return errors.New("usage: counterctl import <file|->")
+		}
+		return c.importSnapshot(ctx, fs.Arg(1))
+	case "":
+		fs.Usage()
+		return errors.New("no command given")
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// ctl holds the connection settings shared by all commands.
+type ctl struct {
+	node   string
+	client *http.Client
+	out    io.Writer
+	json   bool
+}
+
+// nodeResult is one node's answer when a command fans out to the cluster.
+type nodeResult struct {
+	Node       string                `json:"node"`
+	Status     *transport.NodeStatus `json:"status,omitempty"`
+	Count      *int64                `json:"count,omitempty"`
+	Divergence int64                 `json:"divergence"`
+	Error      string                `json:"error,omitempty"`
+}
+
+func (c *ctl) status(ctx context.Context) error {
+	results, err := c.fanOut(ctx, func(ctx context.Context, addr string, res *nodeResult) error {
+		var status transport.NodeStatus
+		if err := c.do(ctx, http.MethodGet, addr, "/cluster/status", nil, &status); err != nil {
+			return err
+		}
+		res.Status = &status
+		res.Count = &status.Count
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(results)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tSTATE\tSYNC\tUPTIME\tCOUNT\tDIVERGENCE\tPEERS\tSUSPECT\tDRAINING")
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(tw, "%s\tunreachable\t-\t-\t-\t-\t-\t-\t-\n", r.Node)
+			continue
+		}
+		st := r.Status
+		uptime := (time.Duration(st.UptimeSeconds) * time.Second).String()
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%t\n",
+			r.Node, st.State, st.SyncState, uptime, st.Count, r.Divergence, st.Peers, st.SuspectPeers, st.Draining)
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) get(ctx context.Context) error {
+	results, err := c.fanOut(ctx, func(ctx context.Context, addr string, res *nodeResult) error {
+		var resp map[string]int64
+		if err := c.do(ctx, http.MethodGet, addr, "/count", nil, &resp); err != nil {
+			return err
+		}
+		count := resp["count"]
+		res.Count = &count
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(results)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tCOUNT\tDIVERGENCE")
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(tw, "%s\tunreachable\t-\n", r.Node)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", r.Node, *r.Count, r.Divergence)
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) peers(ctx context.Context) error {
+	var peers []cluster.PeerInfo
+	if err := c.do(ctx, http.MethodGet, c.node, "/cluster/peers", nil, &peers); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(peers)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tLIVENESS\tLAST SEEN\tRTT\tFAILURES\tPENDING")
+	for _, p := range peers {
+		id := p.ID
+		if p.Self {
+			id += " (self)"
+		}
+		lastSeen := (time.Duration(p.LastSeenAgeMS) * time.Millisecond).String()
+		rtt := time.Duration(p.HeartbeatRTTMS * float64(time.Millisecond)).Round(time.Microsecond).String()
+		fmt.Fprintf(tw, "%s\t%s\t%s ago\t%s\t%d\t%d\n", id, p.Liveness, lastSeen, rtt, p.ConsecutiveFailures, p.PendingPropagations)
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) increment(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodPost, c.node, "/increment", nil, nil); err != nil {
+		return err
+	}
+	var resp map[string]int64
+	if err := c.do(ctx, http.MethodGet, c.node, "/count", nil, &resp); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(resp)
+	}
+	fmt.Fprintf(c.out, "incremented on %s, local count is now %d\n", c.node, resp["count"])
+	return nil
+}
+
+func (c *ctl) reset(ctx context.Context) error {
+	var result transport.ResetResult
+	if err := c.do(ctx, http.MethodPost, c.node, "/reset", nil, &result); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(result)
+	}
+	fmt.Fprintf(c.out, "started epoch %d, epoch %d closed at %d\n", result.Epoch, result.Closed.Epoch, result.Closed.Count)
+	return nil
+}
+
+func (c *ctl) epochs(ctx context.Context) error {
+	var epochs transport.Epochs
+	if err := c.do(ctx, http.MethodGet, c.node, "/epochs", nil, &epochs); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(epochs)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EPOCH\tCOUNT")
+	for _, e := range epochs.Epochs {
+		current := ""
+		if e.Epoch == epochs.Current {
+			current = " (current)"
+		}
+		fmt.Fprintf(tw, "%d%s\t%d\n", e.Epoch, current, e.Count)
+	}
+	return tw.Flush()
+}
+
+// evict removes the peer from every reachable node, since each keeps its own list.
+func (c *ctl) evict(ctx context.Context, peer string) error {
+	results, err := c.fanOut(ctx, func(ctx context.Context, addr string, res *nodeResult) error {
+		if addr == peer {
+			return errors.New("skipped, this is the evicted peer")
+		}
+		return c.do(ctx, http.MethodPost, addr, "/admin/evict", map[string]string{"id": peer}, nil)
+	})
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(results)
+	}
+	for _, r := range results {
+		outcome := "evicted"
+		if r.Error != "" {
+			outcome = r.Error
+		}
+		fmt.Fprintf(c.out, "%s: %s\n", r.Node, outcome)
+	}
+	return nil
+}
+
+func (c *ctl) drain(ctx context.Context) error {
+	var status transport.DrainStatus
+	if err := c.do(ctx, http.MethodPost, c.node, "/admin/drain", nil, &status); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(status)
+	}
+	fmt.Fprintf(c.out, "%s is draining, %d propagations pending\n", c.node, status.PendingPropagations)
+	return nil
+}
+
+func (c *ctl) dumpState(ctx context.Context) error {
+	var dump json.RawMessage
+	if err := c.do(ctx, http.MethodGet, c.node, "/admin/state", nil, &dump); err != nil {
+		return err
+	}
+	if err := writeIndented(c.out, dump); err != nil {
+		return fmt.Errorf("failed to format state: %w", err)
+	}
+	return nil
+}
+
+// export writes a snapshot of the node's counter state to a file, or to
+// stdout when none is given.
+func (c *ctl) export(ctx context.Context, file string) error {
+	var raw json.RawMessage
+	if err := c.do(ctx, http.MethodGet, c.node, "/admin/export", nil, &raw); err != nil {
+		return err
+	}
+	if file == "" {
+		return writeIndented(c.out, raw)
+	}
+	var snapshot counter.Snapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", file, err)
+	}
+	if err := writeIndented(f, raw); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if c.json {
+		return c.printJSON(map[string]interface{}{"id": snapshot.ID, "node": snapshot.Node, "file": file})
+	}
+	fmt.Fprintf(c.out, "exported snapshot %s of %s to %s\n", snapshot.ID, snapshot.Node, file)
+	return nil
+}
+
+// importSnapshot sends a snapshot file, or stdin for "-", to the node, which
+// merges it into every node. Importing the same file twice is harmless.
+func (c *ctl) importSnapshot(ctx context.Context, file string) error {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("%s is not a JSON snapshot", file)
+	}
+
+	var result counter.ImportResult
+	if err := c.do(ctx, http.MethodPost, c.node, "/admin/import", json.RawMessage(data), &result); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(result)
+	}
+	if result.Duplicate {
+		fmt.Fprintf(c.out, "snapshot %s was already imported, nothing changed\n", result.ID)
+		return nil
+	}
+	fmt.Fprintf(c.out, "imported snapshot %s: %d new increments and %d compacted, now in epoch %d\n",
+		result.ID, result.Increments, result.Compacted, result.Epoch)
+	return nil
+}
+
+// writeIndented writes raw JSON to w, indented.
+func writeIndented(w io.Writer, raw json.RawMessage) error {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, raw, "", "  "); err != nil {
+		return err
+	}
+	indented.WriteByte('\n')
+	_, err := indented.WriteTo(w)
+	return err
+}
+
+// fanOut discovers the cluster from the configured node and runs fn against
+// every member concurrently. Per-node failures are recorded in the results,
+// which are then annotated with each node's distance from the highest count.
+func (c *ctl) fanOut(ctx context.Context, fn func(ctx context.Context, addr string, res *nodeResult) error) ([]nodeResult, error) {
+	var peers []cluster.PeerInfo
+	if err := c.do(ctx, http.MethodGet, c.node, "/cluster/peers", nil, &peers); err != nil {
+		return nil, fmt.Errorf("failed to discover cluster from %s: %w", c.node, err)
+	}
+
+	results := make([]nodeResult, len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		results[i].Node = p.Addr
+		wg.Add(1)
+		go func(res *nodeResult) {
+			defer wg.Done()
+			if err := fn(ctx, res.Node, res); err != nil {
+				res.Error = err.Error()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	var highest int64
+	for _, r := range results {
+		if r.Count != nil && *r.Count > highest {
+			highest = *r.Count
+		}
+	}
+	for i := range results {
+		if results[i].Count != nil {
+			results[i].Divergence = highest - *results[i].Count
+		}
+	}
+	return results, nil
+}
+
+// do sends a request to a node and decodes a JSON response into out, if given.
+func (c *ctl) do(ctx context.Context, method, addr, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+addr+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %d %s", method, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response body: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *ctl) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
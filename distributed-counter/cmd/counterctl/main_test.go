@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/httpclient"
+	"distributed-counter/internal/transport"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNode is a real node served by httptest, addressed by its listener address.
+type testNode struct {
+	addr     string
+	registry *cluster.Registry
+	counter  *counter.Counter
+}
+
+// startCluster starts n nodes that already know each other. Propagation is
+// disabled, so counts only change where increments are applied.
+func startCluster(t *testing.T, n int) []*testNode {
+	var handlers []*http.ServeMux
+	var nodes []*testNode
+	for i := 0; i < n; i++ {
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		handlers = append(handlers, mux)
+		nodes = append(nodes, &testNode{addr: server.Listener.Addr().String()})
+	}
+	for i, node := range nodes {
+		node.registry = cluster.NewRegistry(node.addr, httpclient.New())
+		for _, other := range nodes {
+			node.registry.HandleHeartbeat(other.addr)
+		}
+		node.counter = counter.NewCounter(node.addr, &noPeers{}, nil)
+		handlers[i].Handle("/", transport.NewServer(node.registry, node.counter))
+	}
+	return nodes
+}
+
+type noPeers struct{}
+
+func (noPeers) GetPeerAddrs() []string { return nil }
+
+func runCtl(t *testing.T, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := run(context.Background(), args, &out)
+	return out.String(), err
+}
+
+func TestStatus_ShowsDivergence(t *testing.T) {
+	nodes := startCluster(t, 2)
+	require.NoError(t, nodes[0].counter.IncrementAndPropagate())
+	require.NoError(t, nodes[0].counter.IncrementAndPropagate())
+
+	out, err := runCtl(t, "-node", nodes[1].addr, "-o", "json", "status")
+	require.NoError(t, err)
+
+	var results []nodeResult
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, 2)
+	byNode := map[string]nodeResult{}
+	for _, r := range results {
+		byNode[r.Node] = r
+	}
+	assert.Equal(t, int64(2), byNode[nodes[0].addr].Status.Count)
+	assert.Equal(t, int64(0), byNode[nodes[0].addr].Divergence)
+	assert.Equal(t, int64(2), byNode[nodes[1].addr].Divergence)
+
+	out, err = runCtl(t, "-node", nodes[1].addr, "status")
+	require.NoError(t, err)
+	assert.Contains(t, out, "DIVERGENCE")
+	assert.Contains(t, out, nodes[0].addr)
+}
+
+func TestGetAndIncrement(t *testing.T) {
+	nodes := startCluster(t, 2)
+
+	out, err := runCtl(t, "-node", nodes[0].addr, "increment")
+	require.NoError(t, err)
+	assert.Contains(t, out, "local count is now 1")
+
+	out, err = runCtl(t, "-node", nodes[0].addr, "get")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"NODE", "COUNT", "DIVERGENCE"}, strings.Fields(lines[0]))
+}
+
+func TestResetAndEpochs(t *testing.T) {
+	nodes := startCluster(t, 1)
+	require.NoError(t, nodes[0].counter.IncrementAndPropagate())
+
+	out, err := runCtl(t, "-node", nodes[0].addr, "reset")
+	require.NoError(t, err)
+	assert.Contains(t, out, "started epoch 1, epoch 0 closed at 1")
+
+	out, err = runCtl(t, "-node", nodes[0].addr, "epochs")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"0", "1"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"1", "(current)", "0"}, strings.Fields(lines[2]))
+}
+
+func TestPeers(t *testing.T) {
+	nodes := startCluster(t, 3)
+	out, err := runCtl(t, "-node", nodes[0].addr, "peers")
+	require.NoError(t, err)
+	assert.Contains(t, out, nodes[0].addr+" (self)")
+	assert.Contains(t, out, nodes[2].addr)
+	assert.Contains(t, out, "alive")
+}
+
+func TestEvict_AppliesToEveryNode(t *testing.T) {
+	nodes := startCluster(t, 3)
+	gone := nodes[2].addr
+
+	out, err := runCtl(t, "-node", nodes[0].addr, "evict", gone)
+	require.NoError(t, err)
+	assert.Contains(t, out, nodes[1].addr+": evicted")
+
+	for _, node := range nodes[:2] {
+		assert.NotContains(t, node.registry.GetPeerAddrs(), gone)
+	}
+
+	_, err = runCtl(t, "-node", nodes[0].addr, "evict")
+	assert.Error(t, err)
+}
+
+func TestDrainAndDumpState(t *testing.T) {
+	nodes := startCluster(t, 1)
+	require.NoError(t, nodes[0].counter.IncrementAndPropagate())
+
+	out, err := runCtl(t, "-node", nodes[0].addr, "drain")
+	require.NoError(t, err)
+	assert.Contains(t, out, "is draining")
+
+	_, err = runCtl(t, "-node", nodes[0].addr, "increment")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503")
+
+	out, err = runCtl(t, "-node", nodes[0].addr, "dump-state")
+	require.NoError(t, err)
+	var dump transport.StateDump
+	require.NoError(t, json.Unmarshal([]byte(out), &dump))
+	assert.True(t, dump.Status.Draining)
+	assert.Len(t, dump.Counter.Increments, 1)
+}
+
+func TestExportAndImport(t *testing.T) {
+	nodes := startCluster(t, 2)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, nodes[0].counter.IncrementAndPropagate())
+	}
+
+	out, err := runCtl(t, "-node", nodes[0].addr, "export")
+	require.NoError(t, err)
+	var snapshot counter.Snapshot
+	require.NoError(t, json.Unmarshal([]byte(out), &snapshot))
+	assert.Len(t, snapshot.State.Increments, 3)
+
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	out, err = runCtl(t, "-node", nodes[0].addr, "export", file)
+	require.NoError(t, err)
+	assert.Contains(t, out, "exported snapshot")
+
+	out, err = runCtl(t, "-node", nodes[1].addr, "import", file)
+	require.NoError(t, err)
+	assert.Contains(t, out, "3 new increments")
+	assert.Equal(t, int64(3), nodes[1].counter.Value())
+
+	out, err = runCtl(t, "-node", nodes[1].addr, "-o", "json", "import", file)
+	require.NoError(t, err)
+	var result counter.ImportResult
+	require.NoError(t, json.Unmarshal([]byte(out), &result))
+	assert.True(t, result.Duplicate)
+	assert.Equal(t, int64(3), nodes[1].counter.Value())
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"version": 99, "id": "x"}`), 0o644))
+	_, err = runCtl(t, "-node", nodes[1].addr, "import", file)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400")
+	_, err = runCtl(t, "-node", nodes[1].addr, "import")
+	assert.Error(t, err)
+}
+
+func TestRun_Errors(t *testing.T) {
+	_, err := runCtl(t)
+	assert.Error(t, err)
+	_, err = runCtl(t, "frobnicate")
+	assert.Error(t, err)
+	_, err = runCtl(t, "-o", "yaml", "status")
+	assert.Error(t, err)
+	_, err = runCtl(t, "-node", "127.0.0.1:1", "status")
+	assert.Error(t, err)
+}
@@ -3,16 +3,30 @@ package main
 import (
 	"context"
 	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/cms"
 	"distributed-counter/internal/counter"
+	"distributed-counter/internal/discovery"
+	"distributed-counter/internal/distinct"
+	"distributed-counter/internal/escrow"
+	"distributed-counter/internal/eventlog"
+	"distributed-counter/internal/hll"
 	"distributed-counter/internal/httpclient"
+	"distributed-counter/internal/ratelimit"
+	"distributed-counter/internal/ring"
+	"distributed-counter/internal/topk"
 	"distributed-counter/internal/transport"
+	"distributed-counter/internal/webhook"
+	"distributed-counter/internal/wire"
+	"distributed-counter/internal/workpool"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -36,32 +50,223 @@ func run(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("node", flag.ExitOnError)
 	port := fs.String("port", "8080", "Port for the node to listen on")
 	peers := fs.String("peers", "", "Comma-separated list of initial peers (e.g., localhost:8081,localhost:8082)")
-	
+	transportMode := fs.String("transport", "http", "Inter-node transport: http or binary (must match across the cluster)")
+	discoveryMode := fs.String("discovery", "static", "Seed discovery: static (--peers), dns, dns-srv or file")
+	dnsName := fs.String("dns-name", "", "DNS name to resolve seeds from (dns and dns-srv discovery)")
+	dnsPort := fs.String("dns-port", "", "Port paired with A records in dns discovery (defaults to --port)")
+	peersFile := fs.String("peers-file", "", "File of seed addresses watched for changes (file discovery)")
+	discoveryInterval := fs.Duration("discovery-interval", 30*time.Second, "How often dns and file discovery re-resolve seeds")
+	propWorkers := fs.Int("propagation-workers", 64, "Maximum concurrent increment propagations overall")
+	propWorkersPerPeer := fs.Int("propagation-workers-per-peer", 4, "Maximum concurrent increment propagations per peer")
+	propQueue := fs.Int("propagation-queue", 10000, "Maximum queued increment propagations overall")
+	propQueuePerPeer := fs.Int("propagation-queue-per-peer", 1000, "Maximum queued increment propagations per peer")
+	propMode := fs.String("propagation-mode", string(counter.Broadcast), "How increments reach peers: broadcast (origin sends to every peer) or gossip")
+	gossipFanout := fs.Int("gossip-fanout", counter.DefaultGossipFanout, "Random peers each node forwards a new increment to (gossip mode)")
+	gossipTTL := fs.Int("gossip-ttl", 0, "Hops a gossiped increment travels; 0 derives it from the cluster size")
+	propOverflow := fs.String("propagation-overflow", "reject", "When propagation queues are full: reject (503) or drop-oldest")
+	heartbeatWorkers := fs.Int("heartbeat-workers", 64, "Maximum concurrent heartbeats overall")
+	breakerFailures := fs.Int("breaker-failures", 5, "Consecutive failures that open a peer's circuit")
+	breakerOpenTimeout := fs.Duration("breaker-open-timeout", 5*time.Second, "How long an open circuit fails fast before probing the peer again")
+	dedupRetention := fs.Duration("dedup-retention", counter.DefaultDedupRetention, "How long increment IDs and client idempotency keys are remembered")
+	bucketSize := fs.Duration("bucket-size", counter.DefaultBucketSize, "Granularity of windowed counts (GET /count?window=)")
+	clusterID := fs.String("cluster-id", "", "ID of the cluster, stamped on exported snapshots so imports tell backups from other clusters' counts; empty picks a random one shared as nodes sync (must match across the cluster)")
+	digestInterval := fs.Duration("digest-interval", counter.DefaultDigestInterval, "How often each peer's state digest is compared with ours to estimate divergence")
+	maxClockOffset := fs.Duration("max-clock-offset", counter.DefaultMaxClockOffset, "Largest clock skew between nodes; peer timestamps further ahead don't advance the hybrid logical clock")
+	windowRetention := fs.Duration("window-retention", counter.DefaultWindowRetention, "Longest window that can be queried; older buckets are dropped")
+	distinctPrecision := fs.Uint("distinct-precision", hll.DefaultPrecision, "HyperLogLog precision of distinct counters, 4-18 (must match across the cluster)")
+	shardReplicas := fs.Int("shard-replicas", 0, "Nodes holding each distinct counter and each bounded counter's spends, placed by consistent hashing; 0 keeps them on every node")
+	rebalanceInterval := fs.Duration("rebalance-interval", ring.DefaultRebalanceInterval, "How often a sharded node checks membership and hands distinct counters and bounded counter spends to their new owners")
+	distinctExchangeInterval := fs.Duration("distinct-exchange-interval", distinct.DefaultExchangeInterval, "How often distinct counters that changed are sent to the other nodes holding them")
+	topKWidth := fs.Int("topk-width", cms.DefaultWidth, "Counters per row of top-K count-min sketches (must match across the cluster)")
+	topKDepth := fs.Int("topk-depth", cms.DefaultDepth, "Rows of top-K count-min sketches (must match across the cluster)")
+	topKCapacity := fs.Int("topk-capacity", topk.DefaultCapacity, "Heavy hitters tracked per top-K counter, the largest k that can be queried")
+	streamInterval := fs.Duration("stream-interval", transport.DefaultStreamConfig.Interval, "Least time between two GET /count/stream events to one subscriber")
+	streamMaxSubscribers := fs.Int("stream-max-subscribers", transport.DefaultStreamConfig.MaxSubscribers, "Most concurrent GET /count/stream subscribers")
+	webhookSecret := fs.String("webhook-secret", os.Getenv("WEBHOOK_SECRET"), "Shared secret that signs threshold webhooks (defaults to $WEBHOOK_SECRET)")
+	webhookRulesFile := fs.String("webhook-rules-file", "", "File that persists threshold webhook rules across restarts")
+	eventLogSize := fs.Int("event-log-size", eventlog.DefaultCapacity, "Applied increments kept in memory for GET /increments")
+	eventLogFile := fs.String("event-log-file", "", "File that every applied increment is appended to, as JSON lines")
+	var rateLimits rateLimitFlags
+	fs.Var(&rateLimits, "ratelimit", "Rate limit rule pattern=limit/window, e.g. advertiser:*=1000/1m (repeatable)")
+	rateLimitBorrow := fs.Bool("ratelimit-borrow", true, "Let a node exceed its share of a rate limit while the cluster appears under it")
+	rateLimitExchange := fs.Duration("ratelimit-exchange-interval", ratelimit.DefaultExchangeInterval, "How often rate limit usage is sent to peers")
+	escrowTimeout := fs.Duration("escrow-transfer-timeout", escrow.DefaultTransferTimeout, "How long a node waits for a peer to transfer bounded counter budget")
+	catchUpTimeout := fs.Duration("catchup-timeout", 30*time.Second, "How long a joining node looks for a peer to copy counter state from before reporting catch-up failed; it keeps looking, not ready")
+	shutdownDelay := fs.Duration("shutdown-delay", 0, "How long to keep serving while reporting not ready before shutting down")
+	wireOffset := fs.Int("wire-port-offset", 1000, "Offset from --port where the binary transport listens (must match across the cluster)")
+
 	// Parse the provided arguments.
 	if err := fs.Parse(args); err != nil {
 		return fmt.Errorf("failed to parse flags: %w", err)
 	}
 
 	selfID := "localhost:" + *port
-	initialPeers := parsePeers(*peers)
+	if *dnsPort == "" {
+		*dnsPort = *port
+	}
+	provider, err := newDiscovery(*discoveryMode, *peers, *dnsName, *dnsPort, *peersFile)
+	if err != nil {
+		return err
+	}
 
 	// --- Dependency Injection ---
-	client := httpclient.New()
-	registry := cluster.NewRegistry(selfID, client)
-	cntr := counter.NewCounter(selfID, registry, client)
+	var client counter.Transport
+	var circuits cluster.CircuitReporter
+	var wireServer *wire.Server
+	breakerConfig := httpclient.BreakerConfig{
+		FailureThreshold: *breakerFailures,
+		OpenTimeout:      *breakerOpenTimeout,
+	}
+	switch *transportMode {
+	case "http":
+		httpClient := httpclient.NewWithBreaker(breakerConfig)
+		client = httpClient
+		circuits = httpClient
+	case "binary":
+		breakers := httpclient.NewBreakers(breakerConfig)
+		wireClient := wire.NewClientWithBreaker(wire.PortOffset(*wireOffset), breakers)
+		defer wireClient.Close()
+		client = wireClient
+		circuits = breakers
+		wireServer = wire.NewServer()
+	default:
+		return fmt.Errorf("unknown transport %q", *transportMode)
+	}
+	if *distinctPrecision < hll.MinPrecision || *distinctPrecision > hll.MaxPrecision {
+		return fmt.Errorf("--distinct-precision must be between %d and %d", hll.MinPrecision, hll.MaxPrecision)
+	}
+	overflow := workpool.Policy(*propOverflow)
+	if overflow != workpool.Reject && overflow != workpool.DropOldest {
+		return fmt.Errorf("unknown propagation overflow policy %q", *propOverflow)
+	}
+	mode := counter.PropagationMode(*propMode)
+	if mode != counter.Broadcast && mode != counter.Gossip {
+		return fmt.Errorf("unknown propagation mode %q", *propMode)
+	}
+	heartbeatPool := cluster.DefaultHeartbeatPool
+	heartbeatPool.MaxWorkers = *heartbeatWorkers
+	registry := cluster.NewRegistryWithConfig(selfID, client, cluster.Config{Heartbeats: heartbeatPool, Circuits: circuits})
+	defer registry.Stop()
+	sharding := ring.ShardingConfig{Replicas: *shardReplicas, RebalanceInterval: *rebalanceInterval}
+	cntr := counter.NewCounterWithConfig(selfID, registry, client, counter.Config{
+		Propagation: workpool.Config{
+			MaxWorkers:      *propWorkers,
+			MaxPerKey:       *propWorkersPerPeer,
+			MaxQueued:       *propQueue,
+			MaxQueuedPerKey: *propQueuePerPeer,
+			Policy:          overflow,
+		},
+		Mode:           mode,
+		Gossip:         counter.GossipConfig{Fanout: *gossipFanout, TTL: *gossipTTL},
+		DedupRetention: *dedupRetention,
+		Window:         counter.WindowConfig{BucketSize: *bucketSize, Retention: *windowRetention},
+		MaxClockOffset: *maxClockOffset,
+		DigestInterval: *digestInterval,
+		Sharding:       sharding,
+		ClusterID:      *clusterID,
+	})
+	defer cntr.Close()
+	distinctCounters := distinct.New(selfID, registry, client, cntr, distinct.Config{
+		Precision:        uint8(*distinctPrecision),
+		Sharding:         sharding,
+		ExchangeInterval: *distinctExchangeInterval,
+	})
+	defer distinctCounters.Close()
+	topKCounters := topk.New(selfID, registry, client, cntr, topk.Config{Width: *topKWidth, Depth: *topKDepth, Capacity: *topKCapacity})
+	events, err := eventlog.New(eventlog.Config{Capacity: *eventLogSize, File: *eventLogFile})
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+	cntr.OnApply(events.Observe)
+	limiter := ratelimit.New(selfID, registry, cntr, ratelimit.Config{
+		Rules:            rateLimits.rules,
+		Borrow:           *rateLimitBorrow,
+		ExchangeInterval: *rateLimitExchange,
+	})
+	defer limiter.Close()
+	budgets := escrow.New(selfID, cntr, registry, client, escrow.Config{TransferTimeout: *escrowTimeout})
+	cntr.OnApply(budgets.Observe)
+	hooks, err := webhook.New(selfID, webhook.Counters{Counter: cntr, Distinct: distinctCounters, Budgets: budgets}, registry, client, webhook.Config{
+		Secret:    *webhookSecret,
+		RulesFile: *webhookRulesFile,
+	})
+	if err != nil {
+		return err
+	}
+	defer hooks.Close()
+	cntr.OnApply(func(counter.Increment) { hooks.Notify() })
 	httpServer := transport.NewServer(registry, cntr)
+	httpServer.SetDistinct(distinctCounters)
+	httpServer.SetTopK(topKCounters)
+	httpServer.SetRateLimiter(limiter)
+	httpServer.SetEscrow(budgets)
+	httpServer.SetWebhooks(hooks)
+	httpServer.SetEventLog(events)
+	httpServer.SetStreamConfig(transport.StreamConfig{
+		Interval:       *streamInterval,
+		MaxSubscribers: *streamMaxSubscribers,
+		KeepAlive:      transport.DefaultStreamConfig.KeepAlive,
+	})
+
+	// Start service discovery. A static list is announced to once at startup;
+	// other providers keep feeding the registry as their seed set changes.
+	static, isStatic := provider.(discovery.Static)
+	if isStatic {
+		registry.Start(static)
+	} else {
+		registry.Start(nil)
+		go discovery.Watch(ctx, provider, *discoveryInterval, registry.Discover)
+	}
 
-	// Start service discovery
-	registry.Start(initialPeers)
+	// A node with seeds copies the increments it missed before reporting
+	// ready. Past --catchup-timeout it keeps trying, still not ready.
+	if !isStatic || len(static) > 0 {
+		go func() {
+			catchUpCtx, cancel := context.WithTimeout(ctx, *catchUpTimeout)
+			defer cancel()
+			err := cntr.CatchUp(catchUpCtx)
+			if err := distinctCounters.CatchUp(catchUpCtx); err != nil {
+				log.Printf("Distinct counter catch-up failed: %v", err)
+			}
+			if err := topKCounters.CatchUp(catchUpCtx); err != nil {
+				log.Printf("Top-K counter catch-up failed: %v", err)
+			}
+			hooks.Sync(catchUpCtx)
+			if err != nil && ctx.Err() == nil {
+				cntr.CatchUp(ctx)
+			}
+		}()
+	}
 
 	// --- Server Setup and Graceful Shutdown ---
 	server := &http.Server{
 		Addr:    ":" + *port,
 		Handler: httpServer,
 	}
+	// Event streams never finish on their own; end them when shutdown starts.
+	server.RegisterOnShutdown(httpServer.CloseStreams)
 
-	// Channel to receive errors from the server goroutine
-	serverErrors := make(chan error, 1)
+	// Channel to receive errors from the server goroutines
+	serverErrors := make(chan error, 2)
+	if wireServer != nil {
+		wirePort, err := strconv.Atoi(*port)
+		if err != nil {
+			return fmt.Errorf("invalid port %q: %w", *port, err)
+		}
+		wireListener, err := net.Listen("tcp", ":"+strconv.Itoa(wirePort+*wireOffset))
+		if err != nil {
+			return fmt.Errorf("wire listener failed: %w", err)
+		}
+		httpServer.RegisterWire(wireServer)
+		go func() {
+			log.Printf("Node %s serving binary transport on %s", selfID, wireListener.Addr())
+			if err := wireServer.Serve(wireListener); !errors.Is(err, wire.ErrServerClosed) {
+				serverErrors <- err
+			}
+		}()
+		defer wireServer.Close()
+	}
 	go func() {
 		log.Printf("Node %s starting on port %s", selfID, *port)
 		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
@@ -77,6 +282,10 @@ func run(ctx context.Context, args []string) error {
 		log.Println("Shutdown signal received")
 	}
 
+	// Report not ready first, so orchestrators stop routing to this node.
+	httpServer.BeginShutdown()
+	time.Sleep(*shutdownDelay)
+
 	// Gracefully shut down the server.
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -84,14 +293,60 @@ func run(ctx context.Context, args []string) error {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		return fmt.Errorf("server shutdown failed: %w", err)
 	}
+	// Nothing spends here anymore; keep the bounded counter budget in the cluster.
+	budgets.Handoff(shutdownCtx)
 
 	log.Println("Server gracefully stopped")
 	return nil
 }
 
+// rateLimitFlags collects repeated --ratelimit rules.
+type rateLimitFlags struct {
+	rules map[string]ratelimit.Rule
+}
+
+func (f *rateLimitFlags) String() string {
+	if f == nil {
+		return ""
+	}
+	return fmt.Sprint(f.rules)
+}
+
+func (f *rateLimitFlags) Set(value string) error {
+	pattern, rule, err := ratelimit.ParseRule(value)
+	if err != nil {
+		return err
+	}
+	if f.rules == nil {
+		f.rules = make(map[string]ratelimit.Rule)
+	}
+	f.rules[pattern] = rule
+	return nil
+}
+
+// newDiscovery builds the seed provider selected by the --discovery flag.
+func newDiscovery(mode, peers, dnsName, dnsPort, peersFile string) (discovery.Provider, error) {
+	switch mode {
+	case "static":
+		return discovery.Static(parsePeers(peers)), nil
+	case "dns", "dns-srv":
+		if dnsName == "" {
+			return nil, fmt.Errorf("--dns-name is required for %s discovery", mode)
+		}
+		return discovery.DNS{Name: dnsName, Port: dnsPort, SRV: mode == "dns-srv"}, nil
+	case "file":
+		if peersFile == "" {
+			return nil, errors.New("--peers-file is required for file discovery")
+		}
+		return &discovery.File{Path: peersFile}, nil
+	default:
+		return nil, fmt.Errorf("unknown discovery mode %q", mode)
+	}
+}
+
 func parsePeers(peerString string) []string {
 	if peerString == "" {
 		return nil
 	}
 	return strings.Split(peerString, ",")
-}
\ No newline at end of file
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/discovery"
+	"distributed-counter/internal/wire"
 	"net"
 	"net/http"
 	"sync"
@@ -69,4 +72,53 @@ func TestRun_PortInUse(t *testing.T) {
 	// We expect an error related to the address being in use.
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "address already in use", "Expected error for port in use")
-}
\ No newline at end of file
+}
+
+// TestRun_BinaryTransport starts a node with the binary transport and checks
+// that the wire listener accepts internal messages.
+func TestRun_BinaryTransport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, []string{"-port=8097", "-transport=binary", "-wire-port-offset=100"})
+	}()
+
+	client := wire.NewClient(wire.PortOffset(100))
+	defer client.Close()
+	require.Eventually(t, func() bool {
+		inc := counter.Increment{ID: "inc-1", NodeID: "localhost:9999"}
+		return client.Send(context.Background(), "localhost:8097", "/counter/propagate", inc, nil) == nil
+	}, 2*time.Second, 50*time.Millisecond, "wire listener did not start")
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestRun_UnknownTransport(t *testing.T) {
+	err := run(context.Background(), []string{"-port=8096", "-transport=carrier-pigeon"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown transport")
+}
+
+func TestNewDiscovery(t *testing.T) {
+	p, err := newDiscovery("static", "localhost:8081,localhost:8082", "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, discovery.Static{"localhost:8081", "localhost:8082"}, p)
+
+	p, err = newDiscovery("dns-srv", "", "_counter._tcp.example.com", "8080", "")
+	require.NoError(t, err)
+	assert.Equal(t, discovery.DNS{Name: "_counter._tcp.example.com", Port: "8080", SRV: true}, p)
+
+	p, err = newDiscovery("file", "", "", "", "/etc/counter/peers")
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/counter/peers", p.(*discovery.File).Path)
+
+	_, err = newDiscovery("dns", "", "", "8080", "")
+	assert.Error(t, err)
+	_, err = newDiscovery("file", "", "", "", "")
+	assert.Error(t, err)
+	_, err = newDiscovery("zookeeper", "", "", "", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake_TickerFiresOnAdvance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	ticker := c.NewTicker(time.Second)
+
+	c.Advance(500 * time.Millisecond)
+	assert.Empty(t, ticker.C())
+
+	c.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-ticker.C())
+
+	// Unread ticks are dropped rather than queued.
+	c.Advance(3 * time.Second)
+	assert.Len(t, ticker.C(), 1)
+	assert.Equal(t, start.Add(4*time.Second), c.Now())
+
+	ticker.Stop()
+	<-ticker.C()
+	c.Advance(time.Minute)
+	assert.Empty(t, ticker.C())
+	assert.Equal(t, 0, c.Pending())
+}
+
+func TestFake_After(t *testing.T) {
+	c := NewFake(time.Unix(0, 0))
+	short := c.After(time.Second)
+	long := c.After(time.Hour)
+
+	c.Advance(2 * time.Second)
+	assert.Equal(t, time.Unix(1, 0), <-short)
+	assert.Empty(t, long)
+	assert.Equal(t, 1, c.Pending())
+
+	assert.Len(t, c.After(0), 1, "a non-positive timer fires immediately")
+}
+
+func TestReal(t *testing.T) {
+	var c Clock = Real{}
+	assert.WithinDuration(t, time.Now(), c.Now(), time.Second)
+	ticker := c.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	<-ticker.C()
+	<-c.After(time.Millisecond)
+}
@@ -2,7 +2,11 @@ package cluster
 
 import (
 	"context"
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/wire"
+	"distributed-counter/internal/workpool"
 	"log"
+	"sort"
 	"sync"
 	"time"
 )
@@ -10,11 +14,41 @@ import (
 const (
 	heartbeatInterval = 1 * time.Second
 	peerExpiryTimeout = 15 * time.Second
+
+	// Rejoin attempts against seeds and departed peers back off between these bounds.
+	rejoinMinBackoff = 1 * time.Second
+	rejoinMaxBackoff = 30 * time.Second
+	// Expired peers are remembered as rejoin candidates for this long.
+	departedRetention = 10 * time.Minute
+)
+
+// NodeState describes whether this node is connected to the rest of the cluster.
+type NodeState string
+
+const (
+	StateIsolated NodeState = "isolated"
+	StateJoined   NodeState = "joined"
 )
 
-// HTTPClient defines the interface our registry needs for communication.
-type HTTPClient interface {
-	Post(ctx context.Context, url string, body interface{}, responseBody interface{}) error
+// Transport defines the interface our registry needs for communication.
+type Transport interface {
+	Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error
+}
+
+// Hello identifies the sending node in join and heartbeat messages.
+type Hello struct {
+	ID string `json:"id"`
+}
+
+// MarshalBinary implements the compact encoding used by the wire transport.
+func (h Hello) MarshalBinary() ([]byte, error) {
+	return wire.AppendString(nil, h.ID), nil
+}
+
+func (h *Hello) UnmarshalBinary(data []byte) error {
+	r := wire.NewReader(data)
+	h.ID = r.ReadString()
+	return r.Err()
 }
 
 // Peer represents a node in the cluster.
@@ -22,6 +56,22 @@ type Peer struct {
 	ID       string    `json:"id"` // host:port
 	Addr     string    `json:"addr"`
 	LastSeen time.Time `json:"-"`
+
+	// Outcome of our own heartbeats to the peer.
+	HeartbeatRTT        time.Duration `json:"-"`
+	ConsecutiveFailures int           `json:"-"`
+}
+
+// PeerInfo is the read-only view of a peer exposed by the introspection API.
+type PeerInfo struct {
+	ID                  string   `json:"id"`
+	Addr                string   `json:"addr"`
+	Self                bool     `json:"self"`
+	Liveness            Liveness `json:"liveness"`
+	LastSeenAgeMS       int64    `json:"last_seen_age_ms"`
+	HeartbeatRTTMS      float64  `json:"heartbeat_rtt_ms"`
+	ConsecutiveFailures int      `json:"consecutive_failures"`
+	PendingPropagations int      `json:"pending_propagations"`
 }
 
 // Registry manages the list of peers in the cluster.
@@ -29,22 +79,88 @@ type Registry struct {
 	mu         sync.RWMutex
 	selfID     string
 	peers      map[string]Peer
-	httpClient HTTPClient // <-- DEPEND ON THE INTERFACE
+	seeds      []string
+	departed   map[string]time.Time // expired peers, by expiry time
+	seen       map[string]bool      // every peer address ever known, until evicted
+	rejoins    map[string]*rejoinTarget
+	lastState  NodeState
+	announced  bool
+	transport  Transport // <-- DEPEND ON THE INTERFACE
+	heartbeats *workpool.Pool
+	circuits   CircuitReporter
+	clock      clock.Clock
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// CircuitReporter exposes the transport's circuit breakers as a health signal.
+type CircuitReporter interface {
+	CircuitOpen(addr string) bool
 }
 
-// NewRegistry creates a new registry.
-func NewRegistry(selfID string, client HTTPClient) *Registry {
+// Liveness is the health of a known peer.
+type Liveness string
+
+const (
+	// LivenessAlive peers are heard from and reachable.
+	LivenessAlive Liveness = "alive"
+	// LivenessSuspect peers are still heard from, but our circuit to them is open.
+	LivenessSuspect Liveness = "suspect"
+)
+
+// Config holds the tunables of a Registry.
+type Config struct {
+	// Heartbeats bounds the background heartbeat sends.
+	Heartbeats workpool.Config
+	// Circuits, if set, marks peers with open circuits as suspect.
+	Circuits CircuitReporter
+	// Clock drives heartbeats, expiry and rejoin backoff; nil means the wall clock.
+	Clock clock.Clock
+}
+
+// DefaultHeartbeatPool allows one heartbeat in flight per peer and keeps only
+// the newest one queued behind it.
+var DefaultHeartbeatPool = workpool.Config{MaxWorkers: 64, MaxPerKey: 1, MaxQueuedPerKey: 1, Policy: workpool.DropOldest}
+
+// rejoinTarget tracks backoff for one seed or departed peer.
+type rejoinTarget struct {
+	failures int
+	next     time.Time
+}
+
+// NewRegistry creates a new registry with the default configuration.
+func NewRegistry(selfID string, transport Transport) *Registry {
+	return NewRegistryWithConfig(selfID, transport, Config{Heartbeats: DefaultHeartbeatPool})
+}
+
+// NewRegistryWithConfig creates a new registry.
+func NewRegistryWithConfig(selfID string, transport Transport, cfg Config) *Registry {
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
 	return &Registry{
 		selfID:     selfID,
 		peers:      make(map[string]Peer),
-		httpClient: client,
+		departed:   make(map[string]time.Time),
+		seen:       make(map[string]bool),
+		rejoins:    make(map[string]*rejoinTarget),
+		lastState:  StateIsolated,
+		transport:  transport,
+		heartbeats: workpool.New(cfg.Heartbeats),
+		circuits:   cfg.Circuits,
+		clock:      cfg.Clock,
+		stop:       make(chan struct{}),
 	}
 }
 
 // Start begins the background tasks for announcing, heartbeating, and peer management.
 func (r *Registry) Start(initialPeers []string) {
 	// Add self to the peer list
-	r.addPeer(Peer{ID: r.selfID, Addr: r.selfID, LastSeen: time.Now()})
+	r.addPeer(Peer{ID: r.selfID, Addr: r.selfID, LastSeen: r.clock.Now()})
+
+	r.mu.Lock()
+	r.seeds = initialPeers
+	r.mu.Unlock()
 
 	// Announce to initial peers
 	go r.announce(initialPeers)
@@ -53,6 +169,68 @@ func (r *Registry) Start(initialPeers []string) {
 	go r.periodicHealthCheck()
 }
 
+// Stop ends the background heartbeat loop and waits for in-flight heartbeats.
+func (r *Registry) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		r.heartbeats.Close()
+	})
+}
+
+// Discover records the latest seed addresses from a discovery provider and
+// announces to any that are not already known peers.
+func (r *Registry) Discover(seeds []string) {
+	r.mu.Lock()
+	r.seeds = seeds
+	var unknown []string
+	for _, addr := range seeds {
+		if _, known := r.peers[addr]; !known && addr != r.selfID {
+			unknown = append(unknown, addr)
+		}
+	}
+	r.mu.Unlock()
+
+	if len(unknown) > 0 {
+		go r.announce(unknown)
+	}
+}
+
+// State reports whether any other node is currently known to be alive.
+func (r *Registry) State() NodeState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.stateLocked()
+}
+
+func (r *Registry) stateLocked() NodeState {
+	for id, peer := range r.peers {
+		if id != r.selfID && r.liveness(peer.Addr) == LivenessAlive {
+			return StateJoined
+		}
+	}
+	return StateIsolated
+}
+
+// Announced reports whether a join has succeeded since startup. A node
+// without seeds has nobody to announce to and counts as announced.
+func (r *Registry) Announced() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.announced || len(r.seeds) == 0
+}
+
+// Liveness reports the health of the peer at addr.
+func (r *Registry) Liveness(addr string) Liveness {
+	return r.liveness(addr)
+}
+
+func (r *Registry) liveness(addr string) Liveness {
+	if r.circuits != nil && r.circuits.CircuitOpen(addr) {
+		return LivenessSuspect
+	}
+	return LivenessAlive
+}
+
 // GetPeerAddrs returns a list of all known peer addresses, excluding self.
 func (r *Registry) GetPeerAddrs() []string {
 	r.mu.RLock()
@@ -66,13 +244,83 @@ func (r *Registry) GetPeerAddrs() []string {
 	return addrs
 }
 
+// Peers returns every known peer, including self, sorted by ID.
+func (r *Registry) Peers() []PeerInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := r.clock.Now()
+	infos := make([]PeerInfo, 0, len(r.peers))
+	for id, p := range r.peers {
+		infos = append(infos, PeerInfo{
+			ID:                  id,
+			Addr:                p.Addr,
+			Self:                id == r.selfID,
+			Liveness:            r.liveness(p.Addr),
+			LastSeenAgeMS:       now.Sub(p.LastSeen).Milliseconds(),
+			HeartbeatRTTMS:      float64(p.HeartbeatRTT) / float64(time.Millisecond),
+			ConsecutiveFailures: p.ConsecutiveFailures,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// Evict removes a peer from the membership list, and forgets it if it
+// already expired. It returns false if the peer is unknown or is this node.
+func (r *Registry) Evict(peerID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.peers[peerID]; (!exists && !r.seen[peerID]) || peerID == r.selfID {
+		return false
+	}
+	log.Printf("Evicting peer %s on operator request", peerID)
+	delete(r.peers, peerID)
+	delete(r.seen, peerID)
+	delete(r.departed, peerID)
+	return true
+}
+
+// KnownAddrs returns the seeds and every peer this node has known since it
+// started, alive or not, until they are evicted, excluding self. Unlike
+// the current members, a peer on the other side of a partition stays
+// listed after it expires.
+func (r *Registry) KnownAddrs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	known := make(map[string]bool, len(r.seen)+len(r.seeds))
+	for addr := range r.seen {
+		known[addr] = true
+	}
+	for _, addr := range r.seeds {
+		known[addr] = true
+	}
+	delete(known, r.selfID)
+	addrs := make([]string, 0, len(known))
+	for addr := range known {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// SelfID returns this node's ID.
+func (r *Registry) SelfID() string {
+	return r.selfID
+}
+
+// HeartbeatStats reports the load on the heartbeat worker pool.
+func (r *Registry) HeartbeatStats() workpool.Stats {
+	return r.heartbeats.Stats()
+}
+
 // HandleJoinRequest is called when a new node wants to join the cluster.
 func (r *Registry) HandleJoinRequest(peerID string) []Peer {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	log.Printf("Node %s is joining the cluster", peerID)
-	r.peers[peerID] = Peer{ID: peerID, Addr: peerID, LastSeen: time.Now()}
+	r.peers[peerID] = Peer{ID: peerID, Addr: peerID, LastSeen: r.clock.Now()}
+	r.seen[peerID] = true
 
 	var peerList []Peer
 	for _, p := range r.peers {
@@ -86,12 +334,13 @@ func (r *Registry) HandleHeartbeat(peerID string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if peer, exists := r.peers[peerID]; exists {
-		peer.LastSeen = time.Now()
+		peer.LastSeen = r.clock.Now()
 		r.peers[peerID] = peer
 	} else {
 		// If we get a heartbeat from an unknown peer, add them.
 		log.Printf("Received heartbeat from unknown peer %s, adding to list", peerID)
-		r.peers[peerID] = Peer{ID: peerID, Addr: peerID, LastSeen: time.Now()}
+		r.peers[peerID] = Peer{ID: peerID, Addr: peerID, LastSeen: r.clock.Now()}
+		r.seen[peerID] = true
 	}
 }
 
@@ -100,45 +349,174 @@ func (r *Registry) announce(initialPeers []string) {
 		if peerAddr == r.selfID {
 			continue
 		}
-		url := "http://" + peerAddr + "/cluster/join"
-		body := map[string]string{"id": r.selfID}
-		var responsePeers []Peer
+		r.join(peerAddr)
+	}
+}
 
-		log.Printf("Announcing self to peer %s", peerAddr)
-		err := r.httpClient.Post(context.Background(), url, body, &responsePeers)
-		if err != nil {
-			log.Printf("Failed to announce to peer %s: %v", peerAddr, err)
+// join announces self to peerAddr and merges the peer list it returns.
+func (r *Registry) join(peerAddr string) error {
+	var responsePeers []Peer
+
+	log.Printf("Announcing self to peer %s", peerAddr)
+	err := r.transport.Send(context.Background(), peerAddr, "/cluster/join", Hello{ID: r.selfID}, &responsePeers)
+	if err != nil {
+		log.Printf("Failed to announce to peer %s: %v", peerAddr, err)
+		return err
+	}
+	log.Printf("Successfully announced to %s, received %d peers", peerAddr, len(responsePeers))
+	r.mu.Lock()
+	r.announced = true
+	r.mu.Unlock()
+	r.syncPeers(responsePeers)
+	return nil
+}
+
+// rejoin re-announces to seeds and recently departed peers that are not
+// currently known. This lets an isolated node find the cluster once a seed
+// comes back, and lets the two sides of a healed partition merge again.
+func (r *Registry) rejoin() {
+	for _, addr := range r.rejoinCandidates(r.clock.Now()) {
+		go func(addr string) {
+			err := r.join(addr)
+			r.recordRejoin(addr, err, r.clock.Now())
+		}(addr)
+	}
+}
+
+// rejoinCandidates returns the addresses due for an attempt and schedules
+// their next one, so a slow attempt is not started twice.
+func (r *Registry) rejoinCandidates(now time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := make(map[string]struct{})
+	for _, addr := range r.seeds {
+		wanted[addr] = struct{}{}
+	}
+	for addr, expiredAt := range r.departed {
+		if now.Sub(expiredAt) > departedRetention {
+			delete(r.departed, addr)
 			continue
 		}
-		log.Printf("Successfully announced to %s, received %d peers", peerAddr, len(responsePeers))
-		r.syncPeers(responsePeers)
+		wanted[addr] = struct{}{}
+	}
+
+	var due []string
+	for addr := range wanted {
+		if _, known := r.peers[addr]; known || addr == r.selfID {
+			continue
+		}
+		target, ok := r.rejoins[addr]
+		if !ok {
+			target = &rejoinTarget{}
+			r.rejoins[addr] = target
+		}
+		if now.Before(target.next) {
+			continue
+		}
+		target.next = now.Add(rejoinBackoff(target.failures))
+		due = append(due, addr)
+	}
+	for addr := range r.rejoins {
+		if _, ok := wanted[addr]; !ok {
+			delete(r.rejoins, addr)
+		}
+	}
+	return due
+}
+
+func (r *Registry) recordRejoin(addr string, err error, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	target, ok := r.rejoins[addr]
+	if !ok {
+		return
+	}
+	if err != nil {
+		target.failures++
+		target.next = now.Add(rejoinBackoff(target.failures))
+		return
+	}
+	// A seed may be known under a different ID, so keep probing it slowly.
+	delete(r.departed, addr)
+	target.failures = 0
+	target.next = now.Add(rejoinMaxBackoff)
+}
+
+func rejoinBackoff(failures int) time.Duration {
+	d := rejoinMinBackoff
+	for i := 0; i < failures && d < rejoinMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > rejoinMaxBackoff {
+		d = rejoinMaxBackoff
+	}
+	return d
+}
+
+// logStateChange logs transitions between isolated and joined.
+func (r *Registry) logStateChange() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := r.stateLocked()
+	if state != r.lastState {
+		log.Printf("Node %s is now %s", r.selfID, state)
+		r.lastState = state
 	}
 }
 
 func (r *Registry) periodicHealthCheck() {
-	ticker := time.NewTicker(heartbeatInterval)
+	ticker := r.clock.NewTicker(heartbeatInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C():
+		}
 		r.sendHeartbeats()
 		r.removeExpiredPeers()
+		r.rejoin()
+		r.logStateChange()
 	}
 }
 
 func (r *Registry) sendHeartbeats() {
-	for _, peerAddr := range r.GetPeerAddrs() {
-		go func(addr string) {
-			url := "http://" + addr + "/cluster/heartbeat"
-			body := map[string]string{"id": r.selfID}
-			err := r.httpClient.Post(context.Background(), url, body, nil)
+	for _, addr := range r.GetPeerAddrs() {
+		err := r.heartbeats.Submit(addr, func() {
+			start := r.clock.Now()
+			err := r.transport.Send(context.Background(), addr, "/cluster/heartbeat", Hello{ID: r.selfID}, nil)
+			r.recordHeartbeat(addr, r.clock.Now().Sub(start), err)
 			if err != nil {
 				log.Printf("Failed to send heartbeat to %s: %v", addr, err)
 			}
 			log.Printf("sendHeartbeats to %s", addr)
-		}(peerAddr)
+		})
+		if err != nil {
+			log.Printf("Skipped heartbeat to %s: %v", addr, err)
+		}
 	}
 }
 
+// recordHeartbeat keeps the round trip time of the last successful heartbeat
+// and counts consecutive failures.
+func (r *Registry) recordHeartbeat(addr string, rtt time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	peer, exists := r.peers[addr]
+	if !exists {
+		return
+	}
+	if err != nil {
+		peer.ConsecutiveFailures++
+	} else {
+		peer.HeartbeatRTT = rtt
+		peer.ConsecutiveFailures = 0
+	}
+	r.peers[addr] = peer
+}
+
 func (r *Registry) removeExpiredPeers() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -146,9 +524,10 @@ func (r *Registry) removeExpiredPeers() {
 		if id == r.selfID {
 			continue
 		}
-		if time.Since(peer.LastSeen) > peerExpiryTimeout {
+		if r.clock.Now().Sub(peer.LastSeen) > peerExpiryTimeout {
 			log.Printf("Peer %s expired, removing from list", id)
 			delete(r.peers, id)
+			r.departed[peer.Addr] = r.clock.Now()
 		}
 	}
 }
@@ -157,6 +536,7 @@ func (r *Registry) addPeer(p Peer) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.peers[p.ID] = p
+	r.seen[p.Addr] = true
 }
 
 func (r *Registry) syncPeers(newPeers []Peer) {
@@ -165,7 +545,8 @@ func (r *Registry) syncPeers(newPeers []Peer) {
 	for _, peer := range newPeers {
 		if _, exists := r.peers[peer.ID]; !exists {
 			log.Printf("Discovered new peer %s from sync", peer.ID)
-			r.peers[peer.ID] = Peer{ID: peer.ID, Addr: peer.Addr, LastSeen: time.Now()}
+			r.peers[peer.ID] = Peer{ID: peer.ID, Addr: peer.Addr, LastSeen: r.clock.Now()}
+			r.seen[peer.Addr] = true
 		}
 	}
-}
\ No newline at end of file
+}
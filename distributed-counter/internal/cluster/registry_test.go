@@ -2,38 +2,42 @@ package cluster
 
 import (
 	"context"
+	"distributed-counter/internal/clock"
+	"errors"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-// mockClient now correctly implements the HTTPClient interface
+// mockClient now correctly implements the Transport interface
 type mockClient struct {
-	postFunc func(ctx context.Context, url string, body interface{}, responseBody interface{}) error
+	sendFunc func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error
 }
 
-func (m *mockClient) Post(ctx context.Context, url string, body interface{}, responseBody interface{}) error {
-	return m.postFunc(ctx, url, body, responseBody)
+func (m *mockClient) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	return m.sendFunc(ctx, addr, path, body, reply)
 }
 
 func TestRegistry_StartAndAnnounce(t *testing.T) {
 	var announceCalled bool
 	var mu sync.Mutex
 
-	mockHTTPClient := &mockClient{
-		postFunc: func(ctx context.Context, url string, body interface{}, responseBody interface{}) error {
+	mockTransport := &mockClient{
+		sendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
 			mu.Lock()
 			defer mu.Unlock()
-			assert.Equal(t, "http://peer1:8081/cluster/join", url)
+			assert.Equal(t, "peer1:8081", addr)
+			assert.Equal(t, "/cluster/join", path)
 			announceCalled = true
 			return nil
 		},
 	}
 
 	// This now compiles correctly
-	r := NewRegistry("self:8080", mockHTTPClient)
+	r := NewRegistry("self:8080", mockTransport)
 	r.Start([]string{"peer1:8081"})
 
 	time.Sleep(100 * time.Millisecond) // Allow announce goroutine to run
@@ -56,7 +60,8 @@ func TestRegistry_HandleJoinRequest(t *testing.T) {
 }
 
 func TestRegistry_HandleHeartbeat(t *testing.T) {
-	r := NewRegistry("self:8080", nil)
+	fake := clock.NewFake(time.Unix(0, 0))
+	r := NewRegistryWithConfig("self:8080", nil, Config{Heartbeats: DefaultHeartbeatPool, Clock: fake})
 
 	// Heartbeat from unknown peer
 	r.HandleHeartbeat("peer1:8081")
@@ -64,11 +69,34 @@ func TestRegistry_HandleHeartbeat(t *testing.T) {
 
 	// Heartbeat from known peer
 	p := r.peers["peer1:8081"]
-	time.Sleep(10 * time.Millisecond)
+	fake.Advance(10 * time.Millisecond)
 	r.HandleHeartbeat("peer1:8081")
 	assert.True(t, r.peers["peer1:8081"].LastSeen.After(p.LastSeen))
 }
 
+func TestRegistry_SilentPeerExpiresOnClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	transport := &mockClient{
+		sendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			return errors.New("unreachable")
+		},
+	}
+	r := NewRegistryWithConfig("self:8080", transport, Config{Heartbeats: DefaultHeartbeatPool, Clock: fake})
+	r.Start(nil)
+	defer r.Stop()
+	r.HandleHeartbeat("peer1:8081")
+
+	fake.Advance(peerExpiryTimeout)
+	assert.Contains(t, r.GetPeerAddrs(), "peer1:8081")
+
+	// The next tick after the timeout removes the peer.
+	assert.Eventually(t, func() bool {
+		fake.Advance(heartbeatInterval)
+		return len(r.GetPeerAddrs()) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, StateIsolated, r.State())
+}
+
 func TestRegistry_RemoveExpiredPeers(t *testing.T) {
 	r := NewRegistry("self:8080", nil)
 	r.addPeer(Peer{ID: "self:8080", Addr: "self:8080", LastSeen: time.Now()})
@@ -80,4 +108,183 @@ func TestRegistry_RemoveExpiredPeers(t *testing.T) {
 	assert.NotContains(t, r.peers, "expired-peer:8081")
 	assert.Contains(t, r.peers, "active-peer:8082")
 	assert.Len(t, r.peers, 2)
-}
\ No newline at end of file
+}
+func TestRegistry_DiscoverAnnouncesToUnknownSeeds(t *testing.T) {
+	announced := make(chan string, 4)
+	mockTransport := &mockClient{
+		sendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			announced <- addr
+			return nil
+		},
+	}
+	r := NewRegistry("self:8080", mockTransport)
+	r.addPeer(Peer{ID: "self:8080", Addr: "self:8080", LastSeen: time.Now()})
+	r.addPeer(Peer{ID: "known:8081", Addr: "known:8081", LastSeen: time.Now()})
+
+	r.Discover([]string{"self:8080", "known:8081", "new:8082"})
+
+	select {
+	case addr := <-announced:
+		assert.Equal(t, "new:8082", addr)
+	case <-time.After(time.Second):
+		t.Fatal("Discover did not announce to the new seed")
+	}
+	assert.Empty(t, announced)
+}
+
+func TestRegistry_RejoinBacksOffUntilSeedReturns(t *testing.T) {
+	var mu sync.Mutex
+	seedUp := false
+	attempts := 0
+	mockTransport := &mockClient{
+		sendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if !seedUp {
+				return errors.New("connection refused")
+			}
+			*reply.(*[]Peer) = []Peer{{ID: "seed:8081", Addr: "seed:8081"}}
+			return nil
+		},
+	}
+	r := NewRegistry("self:8080", mockTransport)
+	r.addPeer(Peer{ID: "self:8080", Addr: "self:8080", LastSeen: time.Now()})
+	r.seeds = []string{"seed:8081"}
+	assert.Equal(t, StateIsolated, r.State())
+
+	now := time.Now()
+	due := r.rejoinCandidates(now)
+	assert.Equal(t, []string{"seed:8081"}, due)
+	r.recordRejoin("seed:8081", r.join("seed:8081"), now)
+
+	// The failed attempt is not retried before its backoff elapses.
+	assert.Empty(t, r.rejoinCandidates(now.Add(rejoinMinBackoff)))
+	due = r.rejoinCandidates(now.Add(2 * rejoinMinBackoff))
+	assert.Equal(t, []string{"seed:8081"}, due)
+
+	mu.Lock()
+	seedUp = true
+	mu.Unlock()
+	r.recordRejoin("seed:8081", r.join("seed:8081"), now)
+
+	assert.Equal(t, StateJoined, r.State())
+	assert.Empty(t, r.rejoinCandidates(now.Add(time.Hour)), "known peers are not rejoined")
+	mu.Lock()
+	assert.Equal(t, 2, attempts)
+	mu.Unlock()
+}
+
+func TestRegistry_ExpiredPeersAreRejoinCandidates(t *testing.T) {
+	r := NewRegistry("self:8080", nil)
+	r.addPeer(Peer{ID: "self:8080", Addr: "self:8080", LastSeen: time.Now()})
+	r.addPeer(Peer{ID: "peer:8081", Addr: "peer:8081", LastSeen: time.Now().Add(-20 * time.Second)})
+
+	r.removeExpiredPeers()
+	assert.Equal(t, StateIsolated, r.State())
+	assert.Equal(t, []string{"peer:8081"}, r.rejoinCandidates(time.Now()))
+
+	// Departed peers are forgotten after the retention period.
+	assert.Empty(t, r.rejoinCandidates(time.Now().Add(departedRetention+time.Minute)))
+	assert.NotContains(t, r.departed, "peer:8081")
+}
+
+func TestRegistry_KnownAddrsOutliveExpiry(t *testing.T) {
+	r := NewRegistry("self:8080", nil)
+	r.seeds = []string{"seed:8083", "self:8080"}
+	r.addPeer(Peer{ID: "self:8080", Addr: "self:8080", LastSeen: time.Now()})
+	r.addPeer(Peer{ID: "peer:8081", Addr: "peer:8081", LastSeen: time.Now().Add(-20 * time.Second)})
+	r.HandleHeartbeat("peer:8082")
+
+	r.removeExpiredPeers()
+	assert.NotContains(t, r.GetPeerAddrs(), "peer:8081")
+	assert.Equal(t, []string{"peer:8081", "peer:8082", "seed:8083"}, r.KnownAddrs())
+
+	assert.True(t, r.Evict("peer:8081"), "an expired peer can be evicted")
+	assert.Equal(t, []string{"peer:8082", "seed:8083"}, r.KnownAddrs())
+	assert.False(t, r.Evict("peer:8081"))
+}
+
+func TestRejoinBackoff(t *testing.T) {
+	assert.Equal(t, rejoinMinBackoff, rejoinBackoff(0))
+	assert.Equal(t, 4*rejoinMinBackoff, rejoinBackoff(2))
+	assert.Equal(t, rejoinMaxBackoff, rejoinBackoff(20))
+}
+
+func TestRegistry_HeartbeatsAreBoundedPerPeer(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	mockTransport := &mockClient{
+		sendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			<-release
+			return nil
+		},
+	}
+	r := NewRegistry("self:8080", mockTransport)
+	r.addPeer(Peer{ID: "slow-peer:8081", Addr: "slow-peer:8081", LastSeen: time.Now()})
+
+	for i := 0; i < 10; i++ {
+		r.sendHeartbeats()
+	}
+
+	stats := r.HeartbeatStats()
+	assert.Equal(t, 1, stats.Running)
+	assert.Equal(t, 1, stats.Queued)
+	assert.Equal(t, uint64(8), stats.Dropped)
+}
+
+// openCircuits satisfies the CircuitReporter interface.
+type openCircuits map[string]bool
+
+func (o openCircuits) CircuitOpen(addr string) bool {
+	return o[addr]
+}
+
+func TestRegistry_OpenCircuitMarksPeerSuspect(t *testing.T) {
+	circuits := openCircuits{"down:8081": true}
+	r := NewRegistryWithConfig("self:8080", nil, Config{Heartbeats: DefaultHeartbeatPool, Circuits: circuits})
+	r.addPeer(Peer{ID: "self:8080", Addr: "self:8080", LastSeen: time.Now()})
+	r.addPeer(Peer{ID: "down:8081", Addr: "down:8081", LastSeen: time.Now()})
+
+	assert.Equal(t, LivenessSuspect, r.Liveness("down:8081"))
+	assert.Equal(t, StateIsolated, r.State(), "a node that can only reach suspect peers is isolated")
+
+	r.addPeer(Peer{ID: "up:8082", Addr: "up:8082", LastSeen: time.Now()})
+	assert.Equal(t, LivenessAlive, r.Liveness("up:8082"))
+	assert.Equal(t, StateJoined, r.State())
+}
+
+func TestRegistry_Announced(t *testing.T) {
+	r := NewRegistry("self:8080", &mockClient{
+		sendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			return nil
+		},
+	})
+	assert.True(t, r.Announced(), "a node without seeds has nobody to announce to")
+
+	r.seeds = []string{"seed:8081"}
+	assert.False(t, r.Announced())
+
+	require.NoError(t, r.join("seed:8081"))
+	assert.True(t, r.Announced())
+}
+
+func TestRegistry_PeersReportHeartbeatOutcomes(t *testing.T) {
+	r := NewRegistry("self:8080", nil)
+	r.addPeer(Peer{ID: "self:8080", Addr: "self:8080", LastSeen: time.Now()})
+	r.addPeer(Peer{ID: "peer:8081", Addr: "peer:8081", LastSeen: time.Now().Add(-2 * time.Second)})
+
+	r.recordHeartbeat("peer:8081", 0, errors.New("timeout"))
+	r.recordHeartbeat("peer:8081", 0, errors.New("timeout"))
+	peers := r.Peers()
+	require.Len(t, peers, 2)
+	assert.Equal(t, "peer:8081", peers[0].ID)
+	assert.Equal(t, 2, peers[0].ConsecutiveFailures)
+	assert.GreaterOrEqual(t, peers[0].LastSeenAgeMS, int64(2000))
+	assert.True(t, peers[1].Self)
+
+	r.recordHeartbeat("peer:8081", 3*time.Millisecond, nil)
+	peers = r.Peers()
+	assert.Equal(t, 0, peers[0].ConsecutiveFailures)
+	assert.Equal(t, 3.0, peers[0].HeartbeatRTTMS)
+}
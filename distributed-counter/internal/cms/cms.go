@@ -0,0 +1,234 @@
+// Package cms implements a count-min sketch, which estimates per-item counts
+// of a stream in fixed memory, and a top-K tracker of the heaviest items.
+//
+// A sketch of width w and depth d keeps d rows of w counters; each row hashes
+// an item to one counter. An item's estimate is the minimum of its d
+// counters, which never undercounts and, with probability 1 - e^-d,
+// overcounts by at most e/w times the total of the stream. Sketches of
+// disjoint streams merge by adding their counters.
+package cms
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+const (
+	// DefaultWidth and DefaultDepth bound the overcount to 0.13% of the
+	// total with 99.3% probability, in 80 KiB.
+	DefaultWidth = 2048
+	DefaultDepth = 5
+)
+
+// Sketch is a count-min sketch. It is not safe for concurrent use.
+type Sketch struct {
+	width, depth int
+	total        int64
+	cells        []int64 // depth rows of width counters
+}
+
+// New returns an empty sketch. It panics unless width and depth are positive.
+func New(width, depth int) *Sketch {
+	if width <= 0 || depth <= 0 {
+		panic(fmt.Sprintf("cms: invalid dimensions %dx%d", width, depth))
+	}
+	return &Sketch{width: width, depth: depth, cells: make([]int64, width*depth)}
+}
+
+// FromCells rebuilds a sketch from the counters of another one.
+func FromCells(width, depth int, cells []int64) (*Sketch, error) {
+	if width <= 0 || depth <= 0 || len(cells) != width*depth {
+		return nil, fmt.Errorf("cms: %d counters don't make a %dx%d sketch", len(cells), width, depth)
+	}
+	s := New(width, depth)
+	copy(s.cells, cells)
+	for _, n := range cells[:width] {
+		s.total += n
+	}
+	return s, nil
+}
+
+// Width and Depth return the dimensions of the sketch.
+func (s *Sketch) Width() int { return s.width }
+func (s *Sketch) Depth() int { return s.depth }
+
+// Total returns the sum of everything added.
+func (s *Sketch) Total() int64 { return s.total }
+
+// Cells returns a copy of the counters, row by row.
+func (s *Sketch) Cells() []int64 {
+	return append([]int64(nil), s.cells...)
+}
+
+// Add counts n more of item.
+func (s *Sketch) Add(item string, n int64) {
+	h1, h2 := hashes(item)
+	for row := 0; row < s.depth; row++ {
+		s.cells[s.index(row, h1, h2)] += n
+	}
+	s.total += n
+}
+
+// Positions returns the index, into Cells, of item's counter in each row.
+func (s *Sketch) Positions(item string) []int {
+	h1, h2 := hashes(item)
+	positions := make([]int, s.depth)
+	for row := range positions {
+		positions[row] = s.index(row, h1, h2)
+	}
+	return positions
+}
+
+// AddCell adds n to the counter at index i of Cells. Adding to one counter
+// of every row, as Add does, keeps Total right.
+func (s *Sketch) AddCell(i int, n int64) {
+	s.cells[i] += n
+	if i < s.width {
+		s.total += n
+	}
+}
+
+// Estimate returns an upper bound on the count of item, exact unless other
+// items collide with it in every row.
+func (s *Sketch) Estimate(item string) int64 {
+	h1, h2 := hashes(item)
+	estimate := int64(math.MaxInt64)
+	for row := 0; row < s.depth; row++ {
+		estimate = min(estimate, s.cells[s.index(row, h1, h2)])
+	}
+	return estimate
+}
+
+// Merge adds the counts of other, which must have the same dimensions.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other.width != s.width || other.depth != s.depth {
+		return fmt.Errorf("cms: cannot merge a %dx%d sketch into %dx%d", other.width, other.depth, s.width, s.depth)
+	}
+	for i, n := range other.cells {
+		s.cells[i] += n
+	}
+	s.total += other.total
+	return nil
+}
+
+// MaxOvercount is the overcount that estimates stay within with probability
+// Confidence: e/width times the total.
+func (s *Sketch) MaxOvercount() int64 {
+	return int64(math.Ceil(math.E / float64(s.width) * float64(s.total)))
+}
+
+// Confidence is the probability that an estimate is within MaxOvercount.
+func (s *Sketch) Confidence() float64 {
+	return 1 - math.Exp(-float64(s.depth))
+}
+
+// index picks row's counter by double hashing.
+func (s *Sketch) index(row int, h1, h2 uint32) int {
+	return row*s.width + int((h1+uint32(row)*h2)%uint32(s.width))
+}
+
+func hashes(item string) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// Item is a tracked item with its estimated count.
+type Item struct {
+	Item  string `json:"item"`
+	Count int64  `json:"count"`
+}
+
+// TopK tracks the items with the highest counts offered to it, keeping at
+// most its capacity. It is not safe for concurrent use.
+type TopK struct {
+	capacity int
+	h        itemHeap
+	index    map[string]int // position of each item in h
+}
+
+// NewTopK returns an empty tracker for the capacity heaviest items.
+func NewTopK(capacity int) *TopK {
+	t := &TopK{capacity: capacity, index: make(map[string]int)}
+	t.h.index = t.index
+	return t
+}
+
+// Capacity returns the number of items tracked.
+func (t *TopK) Capacity() int { return t.capacity }
+
+// Offer records item's current count, replacing the lightest tracked item
+// if the tracker is full and item is heavier.
+func (t *TopK) Offer(item string, count int64) {
+	if i, ok := t.index[item]; ok {
+		t.h.items[i].Count = count
+		heap.Fix(&t.h, i)
+		return
+	}
+	if len(t.h.items) < t.capacity {
+		heap.Push(&t.h, Item{Item: item, Count: count})
+		return
+	}
+	if len(t.h.items) > 0 && count > t.h.items[0].Count {
+		delete(t.index, t.h.items[0].Item)
+		t.h.items[0] = Item{Item: item, Count: count}
+		t.index[item] = 0
+		heap.Fix(&t.h, 0)
+	}
+}
+
+// Items returns the tracked items in no particular order.
+func (t *TopK) Items() []string {
+	items := make([]string, 0, len(t.h.items))
+	for _, it := range t.h.items {
+		items = append(items, it.Item)
+	}
+	return items
+}
+
+// Top returns up to k of the heaviest items, heaviest first.
+func (t *TopK) Top(k int) []Item {
+	items := append([]Item(nil), t.h.items...)
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Item < items[j].Item
+	})
+	if k < len(items) {
+		items = items[:k]
+	}
+	return items
+}
+
+// itemHeap is a min-heap of items by count that keeps index up to date.
+type itemHeap struct {
+	items []Item
+	index map[string]int
+}
+
+func (h itemHeap) Len() int           { return len(h.items) }
+func (h itemHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+
+func (h itemHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].Item] = i
+	h.index[h.items[j].Item] = j
+}
+
+func (h *itemHeap) Push(x interface{}) {
+	it := x.(Item)
+	h.index[it.Item] = len(h.items)
+	h.items = append(h.items, it)
+}
+
+func (h *itemHeap) Pop() interface{} {
+	it := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, it.Item)
+	return it
+}
@@ -0,0 +1,109 @@
+package cms
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSketch_NeverUndercounts(t *testing.T) {
+	s := New(256, 4)
+	truth := make(map[string]int64)
+	for i := 0; i < 5000; i++ {
+		item := fmt.Sprintf("ad-%d", i%700)
+		n := int64(i%3 + 1)
+		s.Add(item, n)
+		truth[item] += n
+	}
+
+	var total int64
+	overcounted := 0
+	for item, n := range truth {
+		total += n
+		estimate := s.Estimate(item)
+		assert.GreaterOrEqual(t, estimate, n, item)
+		if estimate-n > s.MaxOvercount() {
+			overcounted++
+		}
+	}
+	assert.Equal(t, total, s.Total())
+	assert.LessOrEqual(t, float64(overcounted)/float64(len(truth)), 1-s.Confidence()+0.01)
+}
+
+func TestSketch_MergeAddsStreams(t *testing.T) {
+	a, b, both := New(64, 3), New(64, 3), New(64, 3)
+	a.Add("ad-1", 5)
+	b.Add("ad-1", 2)
+	b.Add("ad-2", 7)
+	both.Add("ad-1", 7)
+	both.Add("ad-2", 7)
+
+	require.NoError(t, a.Merge(b))
+	assert.Equal(t, both.Cells(), a.Cells())
+	assert.Equal(t, int64(14), a.Total())
+	assert.Error(t, a.Merge(New(32, 3)))
+}
+
+func TestSketch_AddCellAtPositionsMatchesAdd(t *testing.T) {
+	a, b := New(64, 3), New(64, 3)
+	a.Add("ad-1", 4)
+	positions := b.Positions("ad-1")
+	require.Len(t, positions, 3)
+	for _, i := range positions {
+		b.AddCell(i, 4)
+	}
+	assert.Equal(t, a.Cells(), b.Cells())
+	assert.Equal(t, int64(4), b.Total())
+	assert.Equal(t, int64(4), b.Estimate("ad-1"))
+}
+
+func TestFromCells(t *testing.T) {
+	s := New(16, 2)
+	s.Add("ad-1", 3)
+	copied, err := FromCells(16, 2, s.Cells())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), copied.Estimate("ad-1"))
+	assert.Equal(t, int64(3), copied.Total())
+
+	_, err = FromCells(16, 3, s.Cells())
+	assert.Error(t, err)
+}
+
+func TestTopK_KeepsHeaviestItems(t *testing.T) {
+	top := NewTopK(3)
+	top.Offer("ad-1", 5)
+	top.Offer("ad-2", 1)
+	top.Offer("ad-3", 3)
+	top.Offer("ad-4", 2) // replaces ad-2
+	top.Offer("ad-5", 1) // lighter than everything tracked
+	top.Offer("ad-3", 9) // updates in place
+
+	assert.Equal(t, []Item{{"ad-3", 9}, {"ad-1", 5}, {"ad-4", 2}}, top.Top(10))
+	assert.Equal(t, []Item{{"ad-3", 9}}, top.Top(1))
+	assert.ElementsMatch(t, []string{"ad-1", "ad-3", "ad-4"}, top.Items())
+}
+
+func TestTopK_FindsHeavyHittersThroughSketch(t *testing.T) {
+	s := New(DefaultWidth, DefaultDepth)
+	top := NewTopK(5)
+	add := func(item string, n int64) {
+		s.Add(item, n)
+		top.Offer(item, s.Estimate(item))
+	}
+	for i := 0; i < 20000; i++ {
+		add(fmt.Sprintf("tail-%d", i), 1)
+		if i%100 == 0 {
+			for h := 0; h < 5; h++ {
+				add(fmt.Sprintf("heavy-%d", h), int64(h+1))
+			}
+		}
+	}
+
+	var names []string
+	for _, it := range top.Top(5) {
+		names = append(names, it.Item)
+	}
+	assert.Equal(t, []string{"heavy-4", "heavy-3", "heavy-2", "heavy-1", "heavy-0"}, names)
+}
@@ -0,0 +1,394 @@
+package counter
+
+import (
+	"context"
+	"distributed-counter/internal/hlc"
+	"distributed-counter/internal/metrics"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDigestInterval is how often peers' digests are compared with
+	// the local state by default.
+	DefaultDigestInterval = 10 * time.Second
+	// digestTimeout bounds each digest and repair request.
+	digestTimeout = 2 * time.Second
+	// maxRepairIncrements caps the increments a peer sends in one repair.
+	maxRepairIncrements = 10000
+	// resyncTimeout bounds pulling a peer's full state when a repair
+	// leaves increments missing.
+	resyncTimeout = 30 * time.Second
+	// resyncEvery is how many digest intervals pass before the same peer's
+	// full state is pulled again.
+	resyncEvery = 10
+)
+
+// Digest summarises a node's state so peers can estimate how far apart
+// they are without exchanging increments.
+type Digest struct {
+	Epoch uint64 `json:"epoch"`
+	Count int64  `json:"count"`
+	// Latest is the newest timestamp applied from each origin node.
+	Latest map[string]hlc.Timestamp `json:"latest"`
+	// Cluster is the node's cluster ID, which peers that started with
+	// another one adopt.
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// Divergence estimates how far a peer's state is from ours, from its last
+// digest.
+type Divergence struct {
+	// At is when the peer's digest was fetched.
+	At time.Time `json:"at"`
+	// Epoch is the peer's epoch; the other fields compare like epochs only.
+	Epoch uint64 `json:"epoch"`
+	// Increments is our count minus the peer's: positive when the peer is
+	// missing increments we have, negative when we are.
+	Increments int64 `json:"increments"`
+	// BehindSeconds is how far the peer trails us on the origin it trails
+	// most: the gap between the newest increment we applied from that
+	// origin and the newest the peer applied.
+	BehindSeconds float64 `json:"behind_seconds"`
+}
+
+// RepairRequest asks a peer for the increments it applied recently.
+type RepairRequest struct {
+	// Within is how far back to look, on the peer's clock.
+	Within time.Duration `json:"within"`
+}
+
+// RepairResponse holds the increments a peer applied recently, newest
+// first.
+type RepairResponse struct {
+	Increments []Increment `json:"increments"`
+}
+
+// PeerMetrics is what this node measured about one peer.
+type PeerMetrics struct {
+	Peer string `json:"peer"`
+	// PropagationLag is the delay from an increment being made on the peer
+	// to it being applied here.
+	PropagationLag metrics.Summary `json:"propagation_lag"`
+	// AckLatency is the round trip of successful propagations to the peer.
+	AckLatency metrics.Summary `json:"ack_latency"`
+	Divergence *Divergence     `json:"divergence,omitempty"`
+}
+
+// PeerHistograms are the raw histograms behind PeerMetrics, for exporting.
+type PeerHistograms struct {
+	Peer           string
+	PropagationLag metrics.Snapshot
+	AckLatency     metrics.Snapshot
+	Divergence     *Divergence
+}
+
+// convergence holds the propagation and divergence measurements.
+type convergence struct {
+	mu         sync.Mutex
+	lag        map[string]*metrics.Histogram // by origin node
+	acks       map[string]*metrics.Histogram // by peer
+	divergence map[string]Divergence         // by peer
+	previous   map[string]Digest             // each peer's last digest
+	resynced   map[string]time.Time          // when each peer's state was last pulled
+}
+
+func newConvergence() *convergence {
+	return &convergence{
+		lag:        make(map[string]*metrics.Histogram),
+		acks:       make(map[string]*metrics.Histogram),
+		divergence: make(map[string]Divergence),
+		previous:   make(map[string]Digest),
+		resynced:   make(map[string]time.Time),
+	}
+}
+
+func (cv *convergence) observe(hists map[string]*metrics.Histogram, peer string, d time.Duration) {
+	cv.mu.Lock()
+	h, ok := hists[peer]
+	if !ok {
+		h = metrics.NewHistogram(metrics.DefaultLatencyBuckets)
+		hists[peer] = h
+	}
+	cv.mu.Unlock()
+	h.Observe(d)
+}
+
+// ApplyPropagated applies an increment a peer propagated, like
+// ApplyIncrement after Receive, and measures how long it took to get here
+// from its origin.
+func (c *Counter) ApplyPropagated(inc Increment) bool {
+	c.Receive(inc)
+	if !c.ApplyIncrement(inc) {
+		return false
+	}
+	c.markStray(inc)
+	if inc.Time != 0 && inc.NodeID != c.selfID {
+		// An origin whose clock runs ahead of ours, within MaxClockOffset,
+		// stamps increments after we apply them; count those as no lag.
+		lag := max(c.clock.Now().Sub(inc.Timestamp().Time()), 0)
+		c.convergence.observe(c.convergence.lag, inc.NodeID, lag)
+	}
+	return true
+}
+
+// observeAck records the round trip of a successful send to a peer.
+func (c *Counter) observeAck(peer string, d time.Duration) {
+	c.convergence.observe(c.convergence.acks, peer, d)
+}
+
+// Digest returns the summary of this node's state that peers compare
+// themselves with.
+func (c *Counter) Digest() Digest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	d := Digest{Epoch: c.epoch, Count: c.totals[c.epoch], Latest: make(map[string]hlc.Timestamp, len(c.latest)), Cluster: c.cluster}
+	for origin, ts := range c.latest {
+		d.Latest[origin] = ts
+	}
+	return d
+}
+
+// compare estimates how far a peer with digest theirs is from ours.
+func (d Digest) compare(theirs Digest, at time.Time) Divergence {
+	div := Divergence{At: at, Epoch: theirs.Epoch}
+	if d.Epoch != theirs.Epoch {
+		return div
+	}
+	div.Increments = d.Count - theirs.Count
+	for origin, ours := range d.Latest {
+		if peer, ok := theirs.Latest[origin]; ok && peer.Less(ours) {
+			div.BehindSeconds = max(div.BehindSeconds, ours.Time().Sub(peer.Time()).Seconds())
+		}
+	}
+	return div
+}
+
+// missing reports whether we lack increments a peer had when it sent
+// theirs: we count fewer in the same epoch, or have nothing as new from
+// some origin.
+func (d Digest) missing(theirs Digest) bool {
+	if d.Epoch != theirs.Epoch {
+		return false
+	}
+	if d.Count < theirs.Count {
+		return true
+	}
+	for origin, ts := range theirs.Latest {
+		if d.Latest[origin].Less(ts) {
+			return true
+		}
+	}
+	return false
+}
+
+// digestLoop periodically compares every peer's digest with ours.
+func (c *Counter) digestLoop() {
+	ticker := c.clock.NewTicker(c.cfg.DigestInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C():
+		}
+		c.CompareDigests(context.Background())
+	}
+}
+
+// CompareDigests fetches every peer's digest and updates the divergence
+// estimates. Peers that no longer exist are forgotten, with their lag and
+// latency histograms. It is also the
+// anti-entropy of the cluster: when we still lack increments a peer had at
+// the previous comparison, which propagation should have delivered by now,
+// the increments the peer applied recently are pulled from it, and if that
+// does not fill the gap, its full state.
+func (c *Counter) CompareDigests(ctx context.Context) {
+	peers := c.registry.GetPeerAddrs()
+	var wg sync.WaitGroup
+	for _, addr := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			theirs, err := c.fetchDigest(ctx, addr)
+			if err != nil {
+				log.Printf("Failed to fetch digest from %s: %v", addr, err)
+				return
+			}
+			c.joinCluster(theirs.Cluster, nil)
+			ours := c.Digest()
+			c.convergence.mu.Lock()
+			previous, seen := c.convergence.previous[addr]
+			c.convergence.previous[addr] = theirs
+			c.convergence.divergence[addr] = ours.compare(theirs, c.clock.Now())
+			c.convergence.mu.Unlock()
+			if !seen || !ours.missing(previous) {
+				return
+			}
+			c.repair(ctx, addr)
+			if c.Digest().missing(previous) {
+				c.resync(ctx, addr)
+			}
+		}()
+	}
+	wg.Wait()
+
+	known := make(map[string]bool, len(peers))
+	for _, addr := range peers {
+		known[addr] = true
+	}
+	c.convergence.mu.Lock()
+	defer c.convergence.mu.Unlock()
+	for addr := range c.convergence.divergence {
+		if !known[addr] {
+			delete(c.convergence.divergence, addr)
+			delete(c.convergence.previous, addr)
+		}
+	}
+	for addr := range c.convergence.resynced {
+		if !known[addr] {
+			delete(c.convergence.resynced, addr)
+		}
+	}
+	for _, hists := range []map[string]*metrics.Histogram{c.convergence.lag, c.convergence.acks} {
+		for addr := range hists {
+			if !known[addr] {
+				delete(hists, addr)
+			}
+		}
+	}
+}
+
+// repair pulls the increments a peer applied during the last three digest
+// intervals and applies those we lack.
+func (c *Counter) repair(ctx context.Context, addr string) {
+	ctx, cancel := context.WithTimeout(ctx, digestTimeout)
+	defer cancel()
+	var resp RepairResponse
+	if err := c.transport.Send(ctx, addr, "/counter/recent", RepairRequest{Within: 3 * c.cfg.DigestInterval}, &resp); err != nil {
+		log.Printf("Failed to repair from %s: %v", addr, err)
+		return
+	}
+	applied := 0
+	for _, inc := range resp.Increments {
+		if c.holds(inc) && c.ApplyPropagated(inc) {
+			applied++
+		}
+	}
+	if applied > 0 {
+		log.Printf("Repaired %d increments missing from %s", applied, addr)
+	}
+}
+
+// resync pulls a peer's full state and applies the increments we lack,
+// for gaps older than a repair reaches. Increments older than half the
+// dedup window, or of unknown age, are left out: their IDs may have expired here, and applying
+// them again would count them twice. A peer is resynced at most once every
+// resyncEvery digest intervals.
+func (c *Counter) resync(ctx context.Context, addr string) {
+	now := c.clock.Now()
+	c.convergence.mu.Lock()
+	last, ok := c.convergence.resynced[addr]
+	if ok && now.Sub(last) < resyncEvery*c.cfg.DigestInterval {
+		c.convergence.mu.Unlock()
+		return
+	}
+	c.convergence.resynced[addr] = now
+	c.convergence.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, resyncTimeout)
+	defer cancel()
+	var state State
+	if err := c.transport.Send(ctx, addr, "/counter/state", nil, &state); err != nil {
+		log.Printf("Failed to resync from %s: %v", addr, err)
+		return
+	}
+	cutoff := now.Add(-c.cfg.DedupRetention / 2)
+	applied := 0
+	for _, inc := range state.Increments {
+		if inc.Time == 0 || inc.Timestamp().Time().Before(cutoff) || !c.holds(inc) {
+			continue
+		}
+		if c.ApplyPropagated(inc) {
+			applied++
+		}
+	}
+	if applied > 0 {
+		log.Printf("Resynced %d increments missing from %s", applied, addr)
+	}
+}
+
+// Recent returns the increments applied here during the last within,
+// newest first, up to a limit.
+func (c *Counter) Recent(within time.Duration) RepairResponse {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cutoff := c.clock.Now().Add(-within)
+	var resp RepairResponse
+	for i := len(c.applied) - 1; i >= 0 && len(resp.Increments) < maxRepairIncrements; i-- {
+		if c.applied[i].at.Before(cutoff) {
+			break
+		}
+		resp.Increments = append(resp.Increments, c.seenIncrements[c.applied[i].id])
+	}
+	return resp
+}
+
+// fetchDigest asks a peer for its digest.
+func (c *Counter) fetchDigest(ctx context.Context, addr string) (Digest, error) {
+	ctx, cancel := context.WithTimeout(ctx, digestTimeout)
+	defer cancel()
+	var d Digest
+	err := c.transport.Send(ctx, addr, "/counter/digest", nil, &d)
+	return d, err
+}
+
+// PeerHistograms returns the measurements of every peer, ordered by peer.
+func (c *Counter) PeerHistograms() []PeerHistograms {
+	cv := c.convergence
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	peers := make(map[string]bool)
+	for _, m := range []map[string]*metrics.Histogram{cv.lag, cv.acks} {
+		for peer := range m {
+			peers[peer] = true
+		}
+	}
+	for peer := range cv.divergence {
+		peers[peer] = true
+	}
+	empty := metrics.NewHistogram(metrics.DefaultLatencyBuckets).Snapshot()
+	out := make([]PeerHistograms, 0, len(peers))
+	for peer := range peers {
+		h := PeerHistograms{Peer: peer, PropagationLag: empty, AckLatency: empty}
+		if lag, ok := cv.lag[peer]; ok {
+			h.PropagationLag = lag.Snapshot()
+		}
+		if ack, ok := cv.acks[peer]; ok {
+			h.AckLatency = ack.Snapshot()
+		}
+		if div, ok := cv.divergence[peer]; ok {
+			h.Divergence = &div
+		}
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Peer < out[j].Peer })
+	return out
+}
+
+// PeerMetrics summarises PeerHistograms.
+func (c *Counter) PeerMetrics() []PeerMetrics {
+	hists := c.PeerHistograms()
+	out := make([]PeerMetrics, 0, len(hists))
+	for _, h := range hists {
+		out = append(out, PeerMetrics{
+			Peer:           h.Peer,
+			PropagationLag: h.PropagationLag.Summary(),
+			AckLatency:     h.AckLatency.Summary(),
+			Divergence:     h.Divergence,
+		})
+	}
+	return out
+}
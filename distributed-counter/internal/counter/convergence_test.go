@@ -0,0 +1,190 @@
+package counter
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/hlc"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter_MeasuresPropagationLag(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, Config{Clock: fake})
+	defer c.Close()
+
+	made := fake.Now().Add(-30 * time.Millisecond).UnixNano()
+	assert.True(t, c.ApplyPropagated(Increment{ID: "a", NodeID: "peer1:8081", Time: made}))
+	assert.False(t, c.ApplyPropagated(Increment{ID: "a", NodeID: "peer1:8081", Time: made}), "duplicates aren't measured again")
+	c.ApplyPropagated(Increment{ID: "legacy", NodeID: "peer2:8082"})
+
+	peers := c.PeerMetrics()
+	require.Len(t, peers, 1)
+	assert.Equal(t, "peer1:8081", peers[0].Peer)
+	assert.Equal(t, uint64(1), peers[0].PropagationLag.Count)
+	assert.InDelta(t, 0.03, peers[0].PropagationLag.MeanSeconds, 1e-9)
+	assert.Zero(t, peers[0].AckLatency.Count)
+
+	// An origin clock ahead of ours doesn't make for negative lag.
+	c.ApplyPropagated(Increment{ID: "b", NodeID: "peer1:8081", Time: fake.Now().Add(100 * time.Millisecond).UnixNano()})
+	peers = c.PeerMetrics()
+	assert.Equal(t, uint64(2), peers[0].PropagationLag.Count)
+	assert.InDelta(t, 0.015, peers[0].PropagationLag.MeanSeconds, 1e-9)
+}
+
+func TestCounter_MeasuresAckLatency(t *testing.T) {
+	fail := true
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{peers: []string{"peer1:8081"}}, &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			if fail {
+				fail = false
+				return errors.New("unreachable")
+			}
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		},
+	}, Config{RetryInitialInterval: time.Millisecond})
+	defer c.Close()
+
+	c.send("peer1:8081", "/counter/propagate", Increment{ID: "a"}, "increment a")
+	peers := c.PeerMetrics()
+	require.Len(t, peers, 1)
+	assert.Equal(t, uint64(1), peers[0].AckLatency.Count, "only successful sends count")
+	assert.GreaterOrEqual(t, peers[0].AckLatency.MeanSeconds, 0.005)
+}
+
+func TestDigest_Compare(t *testing.T) {
+	at := time.Unix(1_700_000_000, 0)
+	ts := func(d time.Duration) hlc.Timestamp { return hlc.Timestamp{Wall: at.Add(d).UnixNano()} }
+	ours := Digest{Epoch: 1, Count: 10, Latest: map[string]hlc.Timestamp{"a": ts(0), "b": ts(-time.Second), "c": ts(0)}}
+
+	div := ours.compare(Digest{Epoch: 1, Count: 7, Latest: map[string]hlc.Timestamp{"a": ts(-2 * time.Second), "b": ts(0)}}, at)
+	assert.Equal(t, Divergence{At: at, Epoch: 1, Increments: 3, BehindSeconds: 2}, div)
+
+	div = ours.compare(Digest{Epoch: 2, Count: 1}, at)
+	assert.Equal(t, Divergence{At: at, Epoch: 2}, div, "different epochs don't compare")
+}
+
+func TestCounter_CompareDigests(t *testing.T) {
+	registry := &MockRegistry{peers: []string{"peer1:8081", "peer2:8082"}}
+	c := NewCounterWithConfig("node1:8080", registry, &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			if addr == "peer2:8082" {
+				return errors.New("unreachable")
+			}
+			assert.Equal(t, "/counter/digest", path)
+			*reply.(*Digest) = Digest{Count: 1}
+			return nil
+		},
+	}, Config{})
+	defer c.Close()
+	c.ApplyIncrement(Increment{ID: "a", NodeID: "node1:8080", Time: time.Now().UnixNano()})
+	c.ApplyIncrement(Increment{ID: "b", NodeID: "node1:8080", Time: time.Now().UnixNano()})
+	c.ApplyPropagated(Increment{ID: "c", NodeID: "peer1:8081", Time: time.Now().UnixNano()})
+	c.observeAck("peer1:8081", time.Millisecond)
+
+	c.CompareDigests(context.Background())
+	peers := c.PeerMetrics()
+	require.Len(t, peers, 1)
+	require.NotNil(t, peers[0].Divergence)
+	assert.Equal(t, int64(2), peers[0].Divergence.Increments)
+	assert.Equal(t, uint64(1), peers[0].PropagationLag.Count)
+
+	registry.peers = nil
+	c.CompareDigests(context.Background())
+	assert.Empty(t, c.PeerMetrics(), "departed peers are forgotten")
+	assert.Empty(t, c.PeerHistograms(), "with their histograms")
+}
+
+func TestDigest_Missing(t *testing.T) {
+	ts := func(wall int64) hlc.Timestamp { return hlc.Timestamp{Wall: wall} }
+	ours := Digest{Epoch: 1, Count: 5, Latest: map[string]hlc.Timestamp{"a": ts(2), "b": ts(2)}}
+
+	assert.False(t, ours.missing(Digest{Epoch: 1, Count: 5, Latest: map[string]hlc.Timestamp{"a": ts(2), "b": ts(1)}}))
+	assert.True(t, ours.missing(Digest{Epoch: 1, Count: 6}), "the peer counts more")
+	assert.True(t, ours.missing(Digest{Epoch: 1, Count: 5, Latest: map[string]hlc.Timestamp{"a": ts(3)}}), "the peer has newer from a")
+	assert.True(t, ours.missing(Digest{Epoch: 1, Latest: map[string]hlc.Timestamp{"c": ts(1)}}), "the peer has an origin we lack")
+	assert.False(t, ours.missing(Digest{Epoch: 2, Count: 9}), "different epochs don't compare")
+}
+
+func TestCounter_CompareDigestsRepairsWhatStaysMissing(t *testing.T) {
+	lost := Increment{ID: "lost", NodeID: "peer1:8081", Time: time.Now().UnixNano()}
+	var repairs int
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{peers: []string{"peer1:8081"}}, &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			switch path {
+			case "/counter/digest":
+				*reply.(*Digest) = Digest{Count: 1, Latest: map[string]hlc.Timestamp{lost.NodeID: lost.Timestamp()}}
+			case "/counter/recent":
+				repairs++
+				assert.Equal(t, RepairRequest{Within: 3 * time.Minute}, body)
+				*reply.(*RepairResponse) = RepairResponse{Increments: []Increment{lost}}
+			}
+			return nil
+		},
+	}, Config{DigestInterval: time.Minute})
+	defer c.Close()
+
+	c.CompareDigests(context.Background())
+	assert.Zero(t, repairs, "the increment may still be on its way")
+	assert.Zero(t, c.Value())
+
+	c.CompareDigests(context.Background())
+	assert.Equal(t, 1, repairs)
+	assert.Equal(t, int64(1), c.Value())
+
+	c.CompareDigests(context.Background())
+	assert.Equal(t, 1, repairs, "nothing left to repair")
+}
+
+func TestCounter_CompareDigestsResyncsGapsRepairMisses(t *testing.T) {
+	lost := Increment{ID: "lost", NodeID: "peer1:8081", Time: time.Now().Add(-10 * time.Minute).UnixNano()}
+	// Too old to apply again safely: it may have left our dedup window.
+	expired := Increment{ID: "expired", NodeID: "peer1:8081", Time: time.Now().Add(-2 * time.Hour).UnixNano()}
+	var repairs, resyncs int
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{peers: []string{"peer1:8081"}}, &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			switch path {
+			case "/counter/digest":
+				*reply.(*Digest) = Digest{Count: 2, Latest: map[string]hlc.Timestamp{lost.NodeID: lost.Timestamp()}}
+			case "/counter/recent":
+				repairs++ // both increments are older than a repair reaches
+			case "/counter/state":
+				resyncs++
+				*reply.(*State) = State{Increments: []Increment{expired, lost}}
+			}
+			return nil
+		},
+	}, Config{DigestInterval: time.Minute, DedupRetention: time.Hour})
+	defer c.Close()
+
+	c.CompareDigests(context.Background())
+	c.CompareDigests(context.Background())
+	assert.Equal(t, 1, repairs)
+	assert.Equal(t, 1, resyncs)
+	assert.Equal(t, int64(1), c.Value())
+
+	c.CompareDigests(context.Background())
+	assert.Equal(t, 2, repairs)
+	assert.Equal(t, 1, resyncs, "the peer was resynced recently")
+}
+
+func TestCounter_Recent(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, Config{Clock: fake})
+	defer c.Close()
+	c.ApplyIncrement(Increment{ID: "old", NodeID: "peer1:8081"})
+	fake.Advance(time.Minute)
+	c.ApplyIncrement(Increment{ID: "a", NodeID: "peer1:8081"})
+	c.ApplyIncrement(Increment{ID: "b", NodeID: "peer2:8082"})
+
+	var ids []string
+	for _, inc := range c.Recent(30 * time.Second).Increments {
+		ids = append(ids, inc.ID)
+	}
+	assert.Equal(t, []string{"b", "a"}, ids)
+}
@@ -2,6 +2,14 @@ package counter
 
 import (
 	"context"
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/hlc"
+	"distributed-counter/internal/httpclient"
+	"distributed-counter/internal/ring"
+	"distributed-counter/internal/wire"
+	"distributed-counter/internal/workpool"
+	"encoding/binary"
+	"errors"
 	"log"
 	"sync"
 	"time"
@@ -16,84 +24,373 @@ type PeerRegistry interface {
 	GetPeerAddrs() []string
 }
 
-// HTTPClient defines the interface for making HTTP requests.
-type HTTPClient interface {
-	Post(ctx context.Context, url string, body interface{}, responseBody interface{}) error
+// Transport defines the interface for sending internal messages to peers.
+// It is satisfied by both the HTTP and the binary wire clients.
+type Transport interface {
+	Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error
 }
 
 type Increment struct {
 	ID     string `json:"id"`
 	NodeID string `json:"node_id"`
+	// Epoch is the reset generation the increment counts towards.
+	Epoch uint64 `json:"epoch"`
+	// Time and Logical are the hybrid logical timestamp of the increment on
+	// its origin node: Time is its wall time in Unix nanoseconds.
+	Time    int64  `json:"time,omitempty"`
+	Logical uint32 `json:"logical,omitempty"`
+	// Key names the keyed counter the increment belongs to, such as a rate
+	// limit key. Keyed increments don't count towards the main counter.
+	Key string `json:"key,omitempty"`
+	// Delta is the amount added; zero means one.
+	Delta int64 `json:"delta,omitempty"`
 }
 
+// Amount returns how much the increment adds.
+func (inc Increment) Amount() int64 {
+	if inc.Delta == 0 {
+		return 1
+	}
+	return inc.Delta
+}
+
+// Timestamp returns the hybrid logical timestamp of the increment.
+func (inc Increment) Timestamp() hlc.Timestamp {
+	return hlc.Timestamp{Wall: inc.Time, Logical: inc.Logical}
+}
+
+// MarshalBinary implements the compact encoding used by the wire transport.
+func (inc Increment) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, len(inc.ID)+len(inc.NodeID)+len(inc.Key)+16)
+	b = wire.AppendString(b, inc.ID)
+	b = wire.AppendString(b, inc.NodeID)
+	b = binary.AppendUvarint(b, inc.Epoch)
+	b = binary.AppendVarint(b, inc.Time)
+	b = wire.AppendString(b, inc.Key)
+	b = binary.AppendVarint(b, inc.Delta)
+	b = binary.AppendUvarint(b, uint64(inc.Logical))
+	return b, nil
+}
+
+func (inc *Increment) UnmarshalBinary(data []byte) error {
+	r := wire.NewReader(data)
+	inc.ID = r.ReadString()
+	inc.NodeID = r.ReadString()
+	inc.Epoch = r.ReadUvarint()
+	inc.Time = r.ReadVarint()
+	inc.Key = r.ReadString()
+	inc.Delta = r.ReadVarint()
+	inc.Logical = uint32(r.ReadUvarint())
+	return r.Err()
+}
+
+// Config holds the tunables of a Counter.
+type Config struct {
+	// Propagation bounds the background sends of increments to peers.
+	Propagation workpool.Config
+	// Mode selects how increments reach peers. Zero means Broadcast.
+	Mode PropagationMode
+	// Gossip tunes the Gossip mode.
+	Gossip GossipConfig
+	// RetryInitialInterval and RetryMaxElapsedTime shape the exponential
+	// backoff of failed propagations. Zero values keep the defaults.
+	RetryInitialInterval time.Duration
+	RetryMaxElapsedTime  time.Duration
+	// DedupRetention is how long applied increment IDs, and so client
+	// idempotency keys, are remembered. Zero means DefaultDedupRetention.
+	DedupRetention time.Duration
+	// Window sets up the buckets behind windowed counts.
+	Window WindowConfig
+	// Clock drives the hybrid logical clock that stamps increments, and
+	// times the dedup window and buckets; nil means the wall clock.
+	Clock clock.Clock
+	// MaxClockOffset is how far ahead of this node's clock a peer's
+	// timestamps may be and still advance it. Zero means
+	// DefaultMaxClockOffset.
+	MaxClockOffset time.Duration
+	// DigestInterval is how often peers' digests are compared with the
+	// local state to estimate divergence. Zero means DefaultDigestInterval.
+	DigestInterval time.Duration
+	// Sharding places the increments of each keyed counter on a subset of
+	// nodes. Unkeyed increments, which make up the main counter, always go
+	// to every node.
+	Sharding ring.ShardingConfig
+	// ClusterID names the cluster in the snapshots it exports, so that
+	// importing one tells a backup from another cluster's counts. Empty
+	// means a random ID that the nodes agree on as they sync; give every
+	// node the same one to keep it across restarts of the whole cluster.
+	ClusterID string
+}
+
+// DefaultMaxClockOffset is the clock skew between nodes tolerated by default.
+const DefaultMaxClockOffset = 500 * time.Millisecond
 
 // Counter is a thread-safe, distributed, in-memory counter.
 type Counter struct {
 	mu             sync.RWMutex
-	value          int64
-	seenIncrements map[string]struct{}
+	epoch          uint64
+	totals         map[uint64]int64 // applied increments per epoch
+	seenIncrements map[string]Increment
+	applied        []appliedAt              // seenIncrements in the order they were applied
+	compacted      map[uint64]int64         // increments per epoch that left the dedup window
+	compactedTo    map[string]hlc.Timestamp // newest increment per origin that left it
+	buckets        map[bucketKey]int64
+	latest         map[string]hlc.Timestamp // newest increment applied per origin
+	imports        map[string]bool          // IDs of imported snapshots
+	cluster        string                   // ID of the cluster, see Config.ClusterID
+	lineage        map[string]bool          // every cluster ID this node has had
+	convergence    *convergence
+	*ring.Shards   // placement of keyed increments
+	syncState      SyncState
 	registry       PeerRegistry // Depend on the interface
-	httpClient     HTTPClient   // Depend on the interface
+	transport      Transport    // Depend on the interface
+	pool           *workpool.Pool
+	cfg            Config
+	clock          clock.Clock
+	hlc            *hlc.Clock
 	selfID         string
+	stop           chan struct{}
+	stopOnce       sync.Once
+	observers      []func(Increment)
+}
+
+// NewCounter creates a new distributed counter with the default configuration.
+func NewCounter(selfID string, registry PeerRegistry, transport Transport) *Counter {
+	return NewCounterWithConfig(selfID, registry, transport, Config{})
 }
 
-// NewCounter creates a new distributed counter.
-func NewCounter(selfID string, registry PeerRegistry, client HTTPClient) *Counter {
-	return &Counter{
-		value:          0,
-		seenIncrements: make(map[string]struct{}),
+// NewCounterWithConfig creates a new distributed counter.
+func NewCounterWithConfig(selfID string, registry PeerRegistry, transport Transport, cfg Config) *Counter {
+	if cfg.DedupRetention <= 0 {
+		cfg.DedupRetention = DefaultDedupRetention
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+	if cfg.DigestInterval <= 0 {
+		cfg.DigestInterval = DefaultDigestInterval
+	}
+	if cfg.MaxClockOffset <= 0 {
+		cfg.MaxClockOffset = DefaultMaxClockOffset
+	}
+	cfg.Window.setDefaults()
+	cfg.Gossip.setDefaults()
+	if cfg.Mode == "" {
+		cfg.Mode = Broadcast
+	}
+	if cfg.ClusterID == "" {
+		cfg.ClusterID = uuid.NewString()
+	}
+	c := &Counter{
+		totals:         make(map[uint64]int64),
+		seenIncrements: make(map[string]Increment),
+		compacted:      make(map[uint64]int64),
+		compactedTo:    make(map[string]hlc.Timestamp),
+		buckets:        make(map[bucketKey]int64),
+		latest:         make(map[string]hlc.Timestamp),
+		imports:        make(map[string]bool),
+		cluster:        cfg.ClusterID,
+		lineage:        map[string]bool{cfg.ClusterID: true},
+		convergence:    newConvergence(),
+		syncState:      SyncBootstrap,
 		registry:       registry,
-		httpClient:     client,
+		transport:      transport,
+		pool:           workpool.New(cfg.Propagation),
+		cfg:            cfg,
+		clock:          cfg.Clock,
+		hlc:            hlc.New(cfg.Clock, cfg.MaxClockOffset),
 		selfID:         selfID,
+		stop:           make(chan struct{}),
+		Shards:         ring.NewShards(selfID, registry, transport, cfg.Sharding),
+	}
+	go c.expireLoop()
+	go c.digestLoop()
+	if c.Sharded() {
+		go c.RebalanceLoop(c.clock, c.stop, c.Rebalance)
 	}
+	return c
 }
 
 // IncrementAndPropagate increments the local counter and propagates the change to peers.
-func (c *Counter) IncrementAndPropagate() {
-	increment := Increment{
-		ID:     uuid.NewString(),
-		NodeID: c.selfID,
+// It returns workpool.ErrSaturated, without counting the increment, when the
+// propagation queues are full.
+func (c *Counter) IncrementAndPropagate() error {
+	_, _, err := c.IncrementWithID(uuid.NewString())
+	return err
+}
+
+// IncrementWithID is IncrementAndPropagate with a caller-chosen increment ID,
+// such as a client's idempotency key. If an increment with that ID was
+// already applied within the dedup window, here or on any peer that got it
+// here, nothing changes and the original increment is returned as replayed.
+func (c *Counter) IncrementWithID(id string) (Increment, bool, error) {
+	return c.Submit(Increment{ID: id})
+}
+
+// Submit counts a new increment made on this node and propagates it to
+// peers, like IncrementWithID. The caller sets the ID and optionally Key and
+// Delta; the origin node, epoch and hybrid logical timestamp are filled in.
+func (c *Counter) Submit(increment Increment) (Increment, bool, error) {
+	id := increment.ID
+	if inc, seen := c.Lookup(id); seen {
+		return inc, true, nil
 	}
+	increment.NodeID = c.selfID
+	increment.Epoch = c.Epoch()
+	ts := c.hlc.Now()
+	increment.Time, increment.Logical = ts.Wall, ts.Logical
 
-	// Apply locally first
-	c.ApplyIncrement(increment)
+	// Queue propagation first, so a saturated node sheds the write entirely.
+	if err := c.spread(increment); err != nil {
+		return Increment{}, false, err
+	}
 
-	// Propagate to all peers
-	peerAddrs := c.registry.GetPeerAddrs()
-	for _, addr := range peerAddrs {
-		go c.propagate(addr, increment)
+	if !c.ApplyIncrement(increment) {
+		// A concurrent request with the same ID won the race.
+		inc, _ := c.Lookup(id)
+		return inc, true, nil
 	}
+	return increment, false, nil
 }
 
-// ApplyIncrement applies a given increment if it hasn't been seen before. Returns true if applied.
-func (c *Counter) ApplyIncrement(inc Increment) bool {
+// PropagationStats reports the load on the propagation worker pool.
+func (c *Counter) PropagationStats() workpool.Stats {
+	return c.pool.Stats()
+}
+
+// Close stops propagation, dropping queued sends and waiting for running ones.
+func (c *Counter) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.pool.Close()
+}
+
+// OnApply registers fn to be called with every newly applied increment,
+// after the counter is updated. Register observers before serving traffic.
+func (c *Counter) OnApply(fn func(Increment)) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.observers = append(c.observers, fn)
+}
 
+// ApplyIncrement applies a given increment if it hasn't been seen before. Returns true if applied.
+// An increment from a newer epoch moves this node to that epoch; one from an
+// older epoch counts towards that epoch's archived total. An increment no
+// newer than one of its origin that left the dedup window is not applied.
+func (c *Counter) ApplyIncrement(inc Increment) bool {
+	return c.applyIncrement(inc, false)
+}
+
+// applyIncrement is ApplyIncrement; foreign is set for another cluster's
+// increments, whose origins have nothing to do with this cluster's.
+func (c *Counter) applyIncrement(inc Increment, foreign bool) bool {
+	c.mu.Lock()
 	if _, seen := c.seenIncrements[inc.ID]; seen {
+		c.mu.Unlock()
 		return false // Already applied
 	}
+	if !foreign && c.leftWindowLocked(inc) {
+		c.mu.Unlock()
+		return false // Applied before and left the dedup window
+	}
 
-	c.value++
-	c.seenIncrements[inc.ID] = struct{}{}
-	log.Printf("Applied increment %s from node %s. New value: %d", inc.ID, inc.NodeID, c.value)
+	if inc.Epoch > c.epoch {
+		log.Printf("Increment %s is from epoch %d, advancing from epoch %d", inc.ID, inc.Epoch, c.epoch)
+		c.epoch = inc.Epoch
+	}
+	if inc.Key == "" {
+		c.totals[inc.Epoch] += inc.Amount()
+		c.addToBucketLocked(inc)
+	}
+	c.seenIncrements[inc.ID] = inc
+	// Sharded keyed increments reach only some nodes, so digests compare
+	// the increments every node gets.
+	if ts := inc.Timestamp(); (inc.Key == "" || !c.Sharded()) && c.latest[inc.NodeID].Less(ts) {
+		c.latest[inc.NodeID] = ts
+	}
+	c.applied = append(c.applied, appliedAt{id: inc.ID, at: c.clock.Now()})
+	if inc.Key == "" {
+		log.Printf("Applied increment %s from node %s at %s in epoch %d. New value: %d", inc.ID, inc.NodeID, inc.Timestamp(), inc.Epoch, c.totals[c.epoch])
+	}
+	observers := c.observers
+	c.mu.Unlock()
+
+	for _, fn := range observers {
+		fn(inc)
+	}
 	return true
 }
 
-// Value returns the current counter value.
+// Receive advances the hybrid logical clock past an increment received from
+// a peer, so increments made here afterwards order after it. Timestamps too
+// far ahead of this node's clock are logged and ignored.
+func (c *Counter) Receive(inc Increment) {
+	if inc.Time == 0 {
+		return // sent by a node that doesn't stamp increments
+	}
+	if err := c.hlc.Update(inc.Timestamp()); err != nil {
+		log.Printf("Not advancing the clock for increment %s from node %s: %v", inc.ID, inc.NodeID, err)
+	}
+}
+
+// Now returns the current reading of the hybrid logical clock.
+func (c *Counter) Now() hlc.Timestamp {
+	return c.hlc.Peek()
+}
+
+// Value returns the counter value of the current epoch.
 func (c *Counter) Value() int64 {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.value
+	return c.totals[c.epoch]
+}
+
+// spread starts propagating an increment made here, in the configured mode.
+// A sharded keyed increment goes straight to the other owners of its key.
+func (c *Counter) spread(inc Increment) error {
+	if inc.Key != "" && c.Sharded() {
+		return c.BroadcastTo(c.OtherOwners(inc.Key), "/counter/propagate", inc, "increment "+inc.ID)
+	}
+	if c.cfg.Mode == Gossip {
+		return c.gossip(Rumor{Increment: inc, TTL: c.cfg.Gossip.ttl(len(c.registry.GetPeerAddrs()) + 1)})
+	}
+	return c.broadcast("/counter/propagate", inc, "increment "+inc.ID)
+}
+
+// broadcast queues body for delivery to every peer, or none if the
+// propagation queues are full.
+func (c *Counter) broadcast(path string, body interface{}, what string) error {
+	return c.BroadcastTo(c.registry.GetPeerAddrs(), path, body, what)
+}
+
+// BroadcastTo is broadcast to the given peers only. Other replicated
+// structures, such as distinct counters, send through it to share the
+// propagation pool.
+func (c *Counter) BroadcastTo(peerAddrs []string, path string, body interface{}, what string) error {
+	jobs := make(map[string]func(), len(peerAddrs))
+	for _, addr := range peerAddrs {
+		jobs[addr] = func() { c.send(addr, path, body, what) }
+	}
+	return c.pool.SubmitAll(jobs)
 }
 
 func (c *Counter) propagate(peerAddr string, inc Increment) {
-	url := "http://" + peerAddr + "/counter/propagate"
+	c.send(peerAddr, "/counter/propagate", inc, "increment "+inc.ID)
+}
 
+// send delivers body to a peer, retrying with exponential backoff.
+func (c *Counter) send(peerAddr, path string, body interface{}, what string) {
 	op := func() error {
-		err := c.httpClient.Post(context.Background(), url, inc, nil)
+		start := c.clock.Now()
+		err := c.transport.Send(context.Background(), peerAddr, path, body, nil)
+		if err == nil {
+			c.observeAck(peerAddr, c.clock.Now().Sub(start))
+		}
+		if errors.Is(err, httpclient.ErrCircuitOpen) {
+			// The peer is known to be down; don't spend the retry budget on it.
+			return backoff.Permanent(err)
+		}
 		if err != nil {
-			log.Printf("Failed to propagate increment %s to %s. Retrying... Error: %v", inc.ID, peerAddr, err)
+			log.Printf("Failed to propagate %s to %s. Retrying... Error: %v", what, peerAddr, err)
 		}
 		return err
 	}
@@ -101,9 +398,15 @@ func (c *Counter) propagate(peerAddr string, inc Increment) {
 	// Retry with exponential backoff
 	b := backoff.NewExponentialBackOff()
 	b.MaxElapsedTime = 10 * time.Second // Stop retrying after 10 seconds
+	if c.cfg.RetryInitialInterval > 0 {
+		b.InitialInterval = c.cfg.RetryInitialInterval
+	}
+	if c.cfg.RetryMaxElapsedTime > 0 {
+		b.MaxElapsedTime = c.cfg.RetryMaxElapsedTime
+	}
 
 	err := backoff.Retry(op, b)
 	if err != nil {
-		log.Printf("Permanently failed to propagate increment %s to %s after multiple retries", inc.ID, peerAddr)
+		log.Printf("Permanently failed to propagate %s to %s: %v", what, peerAddr, err)
 	}
-}
\ No newline at end of file
+}
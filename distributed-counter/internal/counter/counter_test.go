@@ -4,10 +4,15 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"distributed-counter/internal/httpclient"
+	"distributed-counter/internal/workpool"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // MockRegistry satisfies the PeerRegistry interface.
@@ -19,21 +24,21 @@ func (m *MockRegistry) GetPeerAddrs() []string {
 	return m.peers
 }
 
-// MockHTTPClient satisfies the HTTPClient interface.
-type MockHTTPClient struct {
-	PostFunc func(ctx context.Context, url string, body interface{}, responseBody interface{}) error
+// MockTransport satisfies the Transport interface.
+type MockTransport struct {
+	SendFunc func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error
 }
 
-func (m *MockHTTPClient) Post(ctx context.Context, url string, body interface{}, responseBody interface{}) error {
-	if m.PostFunc != nil {
-		return m.PostFunc(ctx, url, body, responseBody)
+func (m *MockTransport) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	if m.SendFunc != nil {
+		return m.SendFunc(ctx, addr, path, body, reply)
 	}
 	return nil
 }
 
 func TestCounter_SingleNodeIncrement(t *testing.T) {
 	registry := &MockRegistry{peers: []string{}}
-	client := &MockHTTPClient{}
+	client := &MockTransport{}
 	c := NewCounter("node1", registry, client)
 
 	c.ApplyIncrement(Increment{ID: "inc1", NodeID: "node1"})
@@ -42,7 +47,7 @@ func TestCounter_SingleNodeIncrement(t *testing.T) {
 
 func TestCounter_Deduplication(t *testing.T) {
 	registry := &MockRegistry{}
-	client := &MockHTTPClient{}
+	client := &MockTransport{}
 	c := NewCounter("node1", registry, client)
 	inc := Increment{ID: "inc1", NodeID: "node1"}
 
@@ -57,7 +62,7 @@ func TestCounter_Deduplication(t *testing.T) {
 
 func TestCounter_ConcurrentIncrements(t *testing.T) {
 	registry := &MockRegistry{}
-	client := &MockHTTPClient{}
+	client := &MockTransport{}
 	c := NewCounter("node1", registry, client)
 	var wg sync.WaitGroup
 	numIncrements := 1000
@@ -76,9 +81,10 @@ func TestCounter_ConcurrentIncrements(t *testing.T) {
 
 func TestCounter_IncrementAndPropagate(t *testing.T) {
 	propagateCalled := make(chan Increment, 1)
-	mockClient := &MockHTTPClient{
-		PostFunc: func(ctx context.Context, url string, body interface{}, responseBody interface{}) error {
-			assert.Equal(t, "http://peer1:8081/counter/propagate", url)
+	mockClient := &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			assert.Equal(t, "peer1:8081", addr)
+			assert.Equal(t, "/counter/propagate", path)
 			propagateCalled <- body.(Increment)
 			return nil
 		},
@@ -87,7 +93,7 @@ func TestCounter_IncrementAndPropagate(t *testing.T) {
 	registry := &MockRegistry{peers: []string{"peer1:8081"}}
 	c := NewCounter("node1:8080", registry, mockClient)
 
-	c.IncrementAndPropagate()
+	assert.NoError(t, c.IncrementAndPropagate())
 
 	assert.Equal(t, int64(1), c.Value(), "Local counter should be incremented")
 
@@ -98,4 +104,80 @@ func TestCounter_IncrementAndPropagate(t *testing.T) {
 	case <-time.After(1 * time.Second):
 		t.Fatal("Propagation was not called")
 	}
-}
\ No newline at end of file
+}
+func TestCounter_IncrementRejectedWhenPropagationSaturated(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	blockingClient := &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			<-release
+			return nil
+		},
+	}
+	registry := &MockRegistry{peers: []string{"slow-peer:8081"}}
+	c := NewCounterWithConfig("node1:8080", registry, blockingClient, Config{
+		Propagation: workpool.Config{MaxWorkers: 1, MaxPerKey: 1, MaxQueued: 1},
+	})
+
+	assert.NoError(t, c.IncrementAndPropagate()) // running
+	assert.NoError(t, c.IncrementAndPropagate()) // queued
+	assert.ErrorIs(t, c.IncrementAndPropagate(), workpool.ErrSaturated)
+
+	assert.Equal(t, int64(2), c.Value(), "A rejected increment must not be counted")
+	stats := c.PropagationStats()
+	assert.Equal(t, 1, stats.Running)
+	assert.Equal(t, 1, stats.Queued)
+	assert.Equal(t, uint64(1), stats.Rejected)
+}
+
+func TestCounter_PropagationStopsOnOpenCircuit(t *testing.T) {
+	var calls int32
+	client := &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			atomic.AddInt32(&calls, 1)
+			return fmt.Errorf("request to %s failed: %w", addr, httpclient.ErrCircuitOpen)
+		},
+	}
+	c := NewCounter("node1:8080", &MockRegistry{}, client)
+
+	done := make(chan struct{})
+	go func() {
+		c.propagate("down:8081", Increment{ID: "inc-1", NodeID: "node1:8080"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("propagate kept retrying against an open circuit")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestIncrement_BinaryRoundTrip(t *testing.T) {
+	inc := Increment{ID: "inc-1", NodeID: "node1:8080", Epoch: 7, Time: 1_700_000_000_000_000_000, Logical: 3, Key: "ratelimit:a", Delta: 4}
+	data, err := inc.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded Increment
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, inc, decoded)
+
+	assert.Error(t, decoded.UnmarshalBinary(data[:3]))
+}
+
+func TestCounter_KeyedIncrementsNotifyObservers(t *testing.T) {
+	c := NewCounter("node1:8080", &MockRegistry{}, &MockTransport{})
+	var observed []Increment
+	c.OnApply(func(inc Increment) { observed = append(observed, inc) })
+
+	inc, _, err := c.Submit(Increment{ID: "take-1", Key: "ratelimit:a", Delta: 5})
+	require.NoError(t, err)
+	assert.Equal(t, "node1:8080", inc.NodeID)
+	c.ApplyIncrement(Increment{ID: "inc-1", Delta: 3})
+	c.ApplyIncrement(Increment{ID: "inc-1", Delta: 3})
+
+	assert.Equal(t, int64(3), c.Value(), "keyed increments stay out of the main count")
+	require.Len(t, observed, 2)
+	assert.Equal(t, int64(5), observed[0].Amount())
+	assert.Equal(t, int64(1), Increment{}.Amount())
+}
@@ -0,0 +1,159 @@
+package counter
+
+import (
+	"distributed-counter/internal/hlc"
+	"log"
+	"time"
+)
+
+// DefaultDedupRetention is how long increment IDs are remembered by default.
+// It must comfortably exceed the propagation retry time, or a late retry
+// could be counted twice.
+const DefaultDedupRetention = 24 * time.Hour
+
+const (
+	minExpireInterval = 1 * time.Second
+	maxExpireInterval = 1 * time.Minute
+)
+
+// appliedAt records when an increment was applied locally.
+type appliedAt struct {
+	id string
+	at time.Time
+}
+
+// Lookup returns the increment applied with id, if it is still remembered.
+// An ID alone doesn't tell whether it left the dedup window, so a client
+// key retried later than DedupRetention after its increment counts again.
+func (c *Counter) Lookup(id string) (Increment, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	inc, seen := c.seenIncrements[id]
+	return inc, seen
+}
+
+// DedupRetention returns how long increment IDs are remembered.
+func (c *Counter) DedupRetention() time.Duration {
+	return c.cfg.DedupRetention
+}
+
+// leftWindowLocked reports whether an increment is no newer than the newest
+// of its origin that left the dedup window. It is taken for a late copy of
+// one that left it, and so is counted already: propagation retries end long
+// before an increment leaves the window.
+func (c *Counter) leftWindowLocked(inc Increment) bool {
+	to, ok := c.compactedTo[inc.NodeID]
+	return ok && inc.Time != 0 && !to.Less(inc.Timestamp())
+}
+
+// expireLoop periodically trims the dedup window and the window buckets.
+func (c *Counter) expireLoop() {
+	interval := c.cfg.DedupRetention / 10
+	if b := c.cfg.Window.BucketSize; b < interval {
+		interval = b
+	}
+	if interval < minExpireInterval {
+		interval = minExpireInterval
+	}
+	if interval > maxExpireInterval {
+		interval = maxExpireInterval
+	}
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C():
+		}
+		c.expireIncrements()
+		c.expireBuckets()
+	}
+}
+
+// expireIncrements forgets increments applied longer ago than the retention.
+// They stay in the totals, and move to the compacted counts that catch-up
+// hands to new nodes in place of the individual increments. The timestamp
+// of the newest one per origin marks how far the compacted counts reach.
+func (c *Counter) expireIncrements() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := c.clock.Now().Add(-c.cfg.DedupRetention)
+	n := 0
+	for n < len(c.applied) && c.applied[n].at.Before(cutoff) {
+		id := c.applied[n].id
+		inc := c.seenIncrements[id]
+		if inc.Key == "" {
+			c.compacted[inc.Epoch] += inc.Amount()
+		}
+		if ts := inc.Timestamp(); inc.Time != 0 && c.compactedTo[inc.NodeID].Less(ts) {
+			c.compactedTo[inc.NodeID] = ts
+		}
+		delete(c.seenIncrements, id)
+		n++
+	}
+	if n > 0 {
+		c.applied = c.applied[n:]
+		log.Printf("Expired %d increments from the dedup window, %d remain", n, len(c.applied))
+	}
+}
+
+// mergeCompacted adopts a peer's counts of expired increments where they
+// exceed ours, returning how much was added. The peer may have compacted
+// increments this node still holds and counted already: those no newer
+// than the peer's mark for their origin, and not listed in its state, are
+// taken off its counts first.
+func (c *Counter) mergeCompacted(state State) int64 {
+	listed := make(map[string]bool, len(state.Increments))
+	for _, inc := range state.Increments {
+		listed[inc.ID] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	held := make(map[uint64]int64)
+	for id, inc := range c.seenIncrements {
+		if inc.Key != "" || inc.Time == 0 || listed[id] {
+			continue
+		}
+		if to, ok := state.CompactedTo[inc.NodeID]; ok && !to.Less(inc.Timestamp()) {
+			held[inc.Epoch] += inc.Amount()
+		}
+	}
+	var added int64
+	for _, t := range state.Compacted {
+		if diff := t.Count - held[t.Epoch] - c.compacted[t.Epoch]; diff > 0 {
+			c.compacted[t.Epoch] += diff
+			c.totals[t.Epoch] += diff
+			added += diff
+		}
+	}
+	for node, ts := range state.CompactedTo {
+		if c.compactedTo[node].Less(ts) {
+			c.compactedTo[node] = ts
+		}
+	}
+	return added
+}
+
+// compactedLocked returns the compacted counts, oldest epoch first.
+func (c *Counter) compactedLocked() []EpochTotal {
+	var totals []EpochTotal
+	for e, n := range c.compacted {
+		totals = append(totals, EpochTotal{Epoch: e, Count: n})
+	}
+	sortEpochs(totals)
+	return totals
+}
+
+// compactedToLocked returns a copy of the compaction marks per origin.
+func (c *Counter) compactedToLocked() map[string]hlc.Timestamp {
+	if len(c.compactedTo) == 0 {
+		return nil
+	}
+	marks := make(map[string]hlc.Timestamp, len(c.compactedTo))
+	for node, ts := range c.compactedTo {
+		marks[node] = ts
+	}
+	return marks
+}
@@ -0,0 +1,184 @@
+package counter
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter_IncrementWithIDReplays(t *testing.T) {
+	c := NewCounter("node1:8080", &MockRegistry{}, &MockTransport{})
+	defer c.Close()
+
+	inc, replayed, err := c.IncrementWithID("key-1")
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, "key-1", inc.ID)
+	assert.Equal(t, "node1:8080", inc.NodeID)
+
+	again, replayed, err := c.IncrementWithID("key-1")
+	require.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, inc, again)
+	assert.Equal(t, int64(1), c.Value())
+}
+
+func TestCounter_IncrementWithIDSeenFromPeer(t *testing.T) {
+	c := NewCounter("node1:8080", &MockRegistry{}, &MockTransport{})
+	defer c.Close()
+	c.ApplyIncrement(Increment{ID: "key-1", NodeID: "peer1:8081"})
+
+	inc, replayed, err := c.IncrementWithID("key-1")
+	require.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, "peer1:8081", inc.NodeID)
+	assert.Equal(t, int64(1), c.Value())
+}
+
+func TestCounter_IncrementsExpireFromDedupWindow(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, Config{DedupRetention: time.Minute, Clock: fake})
+	defer c.Close()
+
+	c.ApplyIncrement(Increment{ID: "old"})
+	fake.Advance(30 * time.Second)
+	c.ApplyIncrement(Increment{ID: "new"})
+	fake.Advance(31 * time.Second)
+	c.expireIncrements()
+
+	_, seen := c.Lookup("old")
+	assert.False(t, seen)
+	_, seen = c.Lookup("new")
+	assert.True(t, seen)
+	assert.Equal(t, int64(2), c.Value(), "expired increments stay counted")
+
+	state := c.State()
+	assert.Len(t, state.Increments, 1)
+	assert.Equal(t, []EpochTotal{{Epoch: 0, Count: 1}}, state.Compacted)
+
+	// Past the window, the same ID counts again.
+	assert.True(t, c.ApplyIncrement(Increment{ID: "old"}))
+}
+
+func TestCounter_ExpiryRunsOnTheClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, Config{DedupRetention: 10 * time.Second, Clock: fake})
+	defer c.Close()
+	c.ApplyIncrement(Increment{ID: "inc-1"})
+
+	assert.Eventually(t, func() bool {
+		fake.Advance(time.Second)
+		_, seen := c.Lookup("inc-1")
+		return !seen
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCounter_CatchUpMergesCompactedCounts(t *testing.T) {
+	client := &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			*reply.(*State) = State{
+				Increments: []Increment{{ID: "recent", NodeID: "up:8082"}},
+				Compacted:  []EpochTotal{{Epoch: 0, Count: 40}},
+			}
+			return nil
+		},
+	}
+	c := NewCounter("node1:8080", &MockRegistry{peers: []string{"up:8082"}}, client)
+	defer c.Close()
+	c.ApplyIncrement(Increment{ID: "recent", NodeID: "up:8082"})
+
+	require.NoError(t, c.CatchUp(context.Background()))
+	assert.Equal(t, int64(41), c.Value())
+
+	// Catching up again from the same state changes nothing.
+	require.NoError(t, c.CatchUp(context.Background()))
+	assert.Equal(t, int64(41), c.Value())
+}
+
+func TestCounter_MergeCompactedSkipsIncrementsHeldHere(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	cfg := Config{DedupRetention: time.Minute, Clock: fake, ClusterID: "cluster-1"}
+	compacted := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, cfg)
+	defer compacted.Close()
+	incs := []Increment{
+		{ID: "inc-1", NodeID: "node3:8082", Time: 1},
+		{ID: "inc-2", NodeID: "node3:8082", Time: 2},
+		{ID: "inc-3", NodeID: "node3:8082", Time: 3},
+	}
+	for _, inc := range incs {
+		compacted.ApplyIncrement(inc)
+	}
+	compacted.ApplyIncrement(Increment{ID: "missed", NodeID: "node3:8082", Time: 4})
+	fake.Advance(2 * time.Minute)
+	compacted.expireIncrements()
+	compacted.ApplyIncrement(Increment{ID: "recent", NodeID: "node3:8082", Time: 5})
+	require.Equal(t, []EpochTotal{{Epoch: 0, Count: 4}}, compacted.State().Compacted)
+
+	// This node got inc-1 to inc-3 later and still holds them individually.
+	holder := NewCounterWithConfig("node2:8081", &MockRegistry{peers: []string{"node1:8080"}}, &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			*reply.(*State) = compacted.State()
+			return nil
+		},
+	}, cfg)
+	defer holder.Close()
+	for _, inc := range incs {
+		holder.ApplyIncrement(inc)
+	}
+
+	require.NoError(t, holder.CatchUp(context.Background()))
+	assert.Equal(t, int64(5), holder.Value(), "only the missed increment and the recent one are new")
+
+	// Once they leave this node's window too, both nodes agree.
+	fake.Advance(2 * time.Minute)
+	compacted.expireIncrements()
+	holder.expireIncrements()
+	require.NoError(t, holder.CatchUp(context.Background()))
+	assert.Equal(t, int64(5), holder.Value())
+	assert.Equal(t, compacted.State().Compacted, holder.State().Compacted)
+}
+
+func TestCounter_ImportBackupSkipsIncrementsHeldHere(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	cfg := Config{DedupRetention: time.Minute, Clock: fake, ClusterID: "cluster-1"}
+	src := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, cfg)
+	defer src.Close()
+	dst := NewCounterWithConfig("node2:8081", &MockRegistry{}, &MockTransport{}, cfg)
+	defer dst.Close()
+	for i := 1; i <= 3; i++ {
+		inc := Increment{ID: fmt.Sprintf("inc-%d", i), NodeID: "node3:8082", Time: int64(i)}
+		src.ApplyIncrement(inc)
+		dst.ApplyIncrement(inc)
+	}
+	fake.Advance(2 * time.Minute)
+	src.expireIncrements()
+
+	result, err := dst.Import(src.Snapshot())
+	require.NoError(t, err)
+	assert.Zero(t, result.Compacted)
+	assert.Equal(t, int64(3), dst.Value())
+}
+
+func TestCounter_LateCopiesOfCompactedIncrementsDontCount(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, Config{DedupRetention: time.Minute, Clock: fake})
+	defer c.Close()
+	first := Increment{ID: "inc-1", NodeID: "node2:8081", Time: 1}
+	c.ApplyIncrement(first)
+	c.ApplyIncrement(Increment{ID: "inc-2", NodeID: "node2:8081", Time: 2})
+	fake.Advance(2 * time.Minute)
+	c.expireIncrements()
+	_, seen := c.Lookup("inc-1")
+	require.False(t, seen)
+
+	assert.False(t, c.ApplyPropagated(first), "a retry of a compacted increment")
+	assert.False(t, c.ApplyIncrement(Increment{ID: "inc-0", NodeID: "node2:8081", Time: 1, Logical: 1}), "an older one of the same origin")
+	assert.True(t, c.ApplyIncrement(Increment{ID: "inc-3", NodeID: "node2:8081", Time: 3}))
+	assert.True(t, c.ApplyIncrement(Increment{ID: "inc-1", NodeID: "node3:8082", Time: 1}), "another origin's marks are its own")
+	assert.Equal(t, int64(4), c.Value())
+}
@@ -0,0 +1,109 @@
+package counter
+
+import (
+	"distributed-counter/internal/wire"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// EpochTotal is the number of increments counted in one epoch.
+type EpochTotal struct {
+	Epoch uint64 `json:"epoch"`
+	Count int64  `json:"count"`
+}
+
+// EpochAdvance tells a peer that a new epoch has started.
+type EpochAdvance struct {
+	Epoch uint64 `json:"epoch"`
+}
+
+// MarshalBinary implements the compact encoding used by the wire transport.
+func (e EpochAdvance) MarshalBinary() ([]byte, error) {
+	return binary.AppendUvarint(nil, e.Epoch), nil
+}
+
+func (e *EpochAdvance) UnmarshalBinary(data []byte) error {
+	r := wire.NewReader(data)
+	e.Epoch = r.ReadUvarint()
+	return r.Err()
+}
+
+// Epoch returns the current epoch. Every node starts in epoch 0.
+func (c *Counter) Epoch() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.epoch
+}
+
+// Current returns the current epoch together with its count.
+func (c *Counter) Current() EpochTotal {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return EpochTotal{Epoch: c.epoch, Count: c.totals[c.epoch]}
+}
+
+// ErrResetConflict is returned by Reset when the epoch it was closing had
+// already been closed, by a concurrent reset or one that reached this node
+// first.
+var ErrResetConflict = errors.New("the epoch was already closed by another reset")
+
+// Reset starts a new epoch on this node and every peer, so the counter reads
+// zero again. It returns the epoch that was closed with its total so far;
+// increments tagged with it that arrive later still count towards it.
+// Like IncrementAndPropagate it returns workpool.ErrSaturated, without
+// resetting, when the propagation queues are full.
+func (c *Counter) Reset() (EpochTotal, error) {
+	current := c.Epoch()
+	next := current + 1
+	if err := c.broadcast("/counter/epoch", EpochAdvance{Epoch: next}, fmt.Sprintf("epoch %d", next)); err != nil {
+		return EpochTotal{}, err
+	}
+
+	// If the epoch moved on meanwhile the advance was already made, and
+	// sending it again changed nothing on peers.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.advanceEpochLocked(next) {
+		return EpochTotal{}, ErrResetConflict
+	}
+	return EpochTotal{Epoch: current, Count: c.totals[current]}, nil
+}
+
+// AdvanceEpoch moves this node to epoch if it is newer than the current one.
+// Returns true if it advanced.
+func (c *Counter) AdvanceEpoch(epoch uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.advanceEpochLocked(epoch)
+}
+
+func (c *Counter) advanceEpochLocked(epoch uint64) bool {
+	if epoch <= c.epoch {
+		return false
+	}
+	log.Printf("Advancing from epoch %d (count %d) to epoch %d", c.epoch, c.totals[c.epoch], epoch)
+	c.epoch = epoch
+	return true
+}
+
+// Epochs returns the total of the current epoch and of every past epoch
+// that counted increments, oldest first.
+func (c *Counter) Epochs() []EpochTotal {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	totals := []EpochTotal{{Epoch: c.epoch, Count: c.totals[c.epoch]}}
+	for e, n := range c.totals {
+		if e != c.epoch {
+			totals = append(totals, EpochTotal{Epoch: e, Count: n})
+		}
+	}
+	sortEpochs(totals)
+	return totals
+}
+
+func sortEpochs(totals []EpochTotal) {
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Epoch < totals[j].Epoch })
+}
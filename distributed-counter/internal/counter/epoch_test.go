@@ -0,0 +1,138 @@
+package counter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter_ResetStartsNewEpochOnPeers(t *testing.T) {
+	sent := make(chan EpochAdvance, 1)
+	client := &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			if path == "/counter/epoch" {
+				sent <- body.(EpochAdvance)
+			}
+			return nil
+		},
+	}
+	c := NewCounter("node1:8080", &MockRegistry{peers: []string{"peer1:8081"}}, client)
+	require.NoError(t, c.IncrementAndPropagate())
+	require.NoError(t, c.IncrementAndPropagate())
+
+	closed, err := c.Reset()
+	require.NoError(t, err)
+	assert.Equal(t, EpochTotal{Epoch: 0, Count: 2}, closed)
+	assert.Equal(t, uint64(1), c.Epoch())
+	assert.Equal(t, int64(0), c.Value())
+
+	select {
+	case advance := <-sent:
+		assert.Equal(t, uint64(1), advance.Epoch)
+	case <-time.After(time.Second):
+		t.Fatal("reset was not propagated")
+	}
+
+	require.NoError(t, c.IncrementAndPropagate())
+	assert.Equal(t, int64(1), c.Value())
+	assert.Equal(t, []EpochTotal{{Epoch: 0, Count: 2}, {Epoch: 1, Count: 1}}, c.Epochs())
+}
+
+// raceRegistry advances the counter to epoch 1 whenever peers are listed,
+// as if another node's reset arrived while this one was in flight.
+type raceRegistry struct{ c *Counter }
+
+func (r *raceRegistry) GetPeerAddrs() []string {
+	r.c.AdvanceEpoch(1)
+	return nil
+}
+
+func TestCounter_ResetConflicts(t *testing.T) {
+	registry := &raceRegistry{}
+	c := NewCounter("node1:8080", registry, &MockTransport{})
+	defer c.Close()
+	registry.c = c
+
+	_, err := c.Reset()
+	assert.ErrorIs(t, err, ErrResetConflict)
+	assert.Equal(t, uint64(1), c.Epoch())
+
+	closed, err := c.Reset()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), closed.Epoch)
+}
+
+func TestCounter_ConcurrentResetsCloseOneEpochEach(t *testing.T) {
+	c := NewCounter("node1:8080", &MockRegistry{}, &MockTransport{})
+	defer c.Close()
+
+	var mu sync.Mutex
+	closed := make(map[uint64]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if total, err := c.Reset(); err == nil {
+				mu.Lock()
+				closed[total.Epoch]++
+				mu.Unlock()
+			} else {
+				assert.ErrorIs(t, err, ErrResetConflict)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, closed, int(c.Epoch()), "every success closed a different epoch")
+	for epoch, n := range closed {
+		assert.Equal(t, 1, n, "epoch %d", epoch)
+	}
+}
+
+func TestCounter_LateIncrementsCountTowardsTheirEpoch(t *testing.T) {
+	c := NewCounter("node1:8080", &MockRegistry{}, &MockTransport{})
+	c.ApplyIncrement(Increment{ID: "inc-1", NodeID: "peer1:8081"})
+	assert.True(t, c.AdvanceEpoch(2))
+	assert.False(t, c.AdvanceEpoch(1), "epochs never go back")
+
+	assert.True(t, c.ApplyIncrement(Increment{ID: "inc-2", NodeID: "peer1:8081", Epoch: 0}))
+	assert.False(t, c.ApplyIncrement(Increment{ID: "inc-2", NodeID: "peer1:8081", Epoch: 0}))
+	assert.Equal(t, int64(0), c.Value())
+	assert.Equal(t, []EpochTotal{{Epoch: 0, Count: 2}, {Epoch: 2, Count: 0}}, c.Epochs())
+}
+
+func TestCounter_NewerEpochIncrementAdvances(t *testing.T) {
+	c := NewCounter("node1:8080", &MockRegistry{}, &MockTransport{})
+	c.ApplyIncrement(Increment{ID: "inc-1", NodeID: "peer1:8081"})
+
+	// The reset message was lost, but an increment from the new epoch arrived.
+	c.ApplyIncrement(Increment{ID: "inc-2", NodeID: "peer1:8081", Epoch: 1})
+	assert.Equal(t, EpochTotal{Epoch: 1, Count: 1}, c.Current())
+}
+
+func TestCounter_CatchUpAdoptsEpoch(t *testing.T) {
+	client := &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			*reply.(*State) = State{Epoch: 3, Increments: []Increment{{ID: "inc-1", NodeID: "up:8082", Epoch: 2}}}
+			return nil
+		},
+	}
+	c := NewCounter("node1:8080", &MockRegistry{peers: []string{"up:8082"}}, client)
+
+	require.NoError(t, c.CatchUp(context.Background()))
+	assert.Equal(t, uint64(3), c.Epoch())
+	assert.Equal(t, int64(0), c.Value())
+}
+
+func TestEpochAdvance_BinaryRoundTrip(t *testing.T) {
+	data, err := EpochAdvance{Epoch: 300}.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded EpochAdvance
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, uint64(300), decoded.Epoch)
+}
@@ -0,0 +1,120 @@
+package counter
+
+import (
+	"distributed-counter/internal/wire"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+)
+
+// PropagationMode selects how increments reach peers.
+type PropagationMode string
+
+const (
+	// Broadcast sends each increment from its origin to every peer.
+	Broadcast PropagationMode = "broadcast"
+	// Gossip sends each increment to a few random peers, each of which
+	// forwards it to a few more the first time it sees it.
+	Gossip PropagationMode = "gossip"
+)
+
+// DefaultGossipFanout is how many peers a gossiped increment is sent to by
+// each node by default.
+const DefaultGossipFanout = 3
+
+// GossipConfig tunes the Gossip propagation mode.
+type GossipConfig struct {
+	// Fanout is how many random peers each node sends a new increment to.
+	// Zero means DefaultGossipFanout.
+	Fanout int
+	// TTL is how many hops an increment travels. Zero picks enough hops for
+	// the cluster size: the depth of a fanout-ary tree over every node, plus
+	// two for the peers that already had it.
+	TTL int
+}
+
+func (g *GossipConfig) setDefaults() {
+	if g.Fanout <= 0 {
+		g.Fanout = DefaultGossipFanout
+	}
+}
+
+// ttl returns the hops an increment starts with in a cluster of n nodes.
+func (g GossipConfig) ttl(n int) uint32 {
+	if g.TTL > 0 {
+		return uint32(g.TTL)
+	}
+	if g.Fanout == 1 {
+		return uint32(n + 1)
+	}
+	depth := uint32(0)
+	for reach := 1; reach < n; reach *= g.Fanout {
+		depth++
+	}
+	return depth + 2
+}
+
+// Rumor is a gossiped increment with the hops it has left.
+type Rumor struct {
+	Increment Increment `json:"increment"`
+	TTL       uint32    `json:"ttl"`
+}
+
+// MarshalBinary implements the compact encoding used by the wire transport.
+func (r Rumor) MarshalBinary() ([]byte, error) {
+	inc, err := r.Increment.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, len(inc)+8)
+	b = wire.AppendString(b, string(inc))
+	b = binary.AppendUvarint(b, uint64(r.TTL))
+	return b, nil
+}
+
+func (r *Rumor) UnmarshalBinary(data []byte) error {
+	rd := wire.NewReader(data)
+	inc := rd.ReadString()
+	r.TTL = uint32(rd.ReadUvarint())
+	if err := rd.Err(); err != nil {
+		return err
+	}
+	return r.Increment.UnmarshalBinary([]byte(inc))
+}
+
+// gossip sends a rumor to Fanout random peers, never back to the origin.
+// It returns workpool.ErrSaturated when the propagation queues are full.
+func (c *Counter) gossip(r Rumor) error {
+	var peers []string
+	for _, addr := range c.registry.GetPeerAddrs() {
+		if addr != r.Increment.NodeID {
+			peers = append(peers, addr)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	peers = peers[:min(c.cfg.Gossip.Fanout, len(peers))]
+
+	what := fmt.Sprintf("increment %s (ttl %d)", r.Increment.ID, r.TTL)
+	jobs := make(map[string]func(), len(peers))
+	for _, addr := range peers {
+		jobs[addr] = func() { c.send(addr, "/counter/gossip", r, what) }
+	}
+	return c.pool.SubmitAll(jobs)
+}
+
+// ApplyRumor applies a gossiped increment like ApplyPropagated and, if it
+// is new here and has hops left, forwards it to more random peers. Known
+// increments stop there, so each node forwards a rumor at most once.
+func (c *Counter) ApplyRumor(r Rumor) bool {
+	if !c.ApplyPropagated(r.Increment) {
+		return false
+	}
+	if r.TTL > 1 {
+		r.TTL--
+		if err := c.gossip(r); err != nil {
+			log.Printf("Not forwarding increment %s: %v", r.Increment.ID, err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package counter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sent records what a counter sends, by peer.
+type sent struct {
+	mu     sync.Mutex
+	rumors map[string][]Rumor
+	all    chan struct{}
+}
+
+func newGossipCounter(t *testing.T, peers int, gossip GossipConfig) (*Counter, *sent) {
+	var addrs []string
+	for i := 1; i <= peers; i++ {
+		addrs = append(addrs, fmt.Sprintf("peer%d:808%d", i, i))
+	}
+	s := &sent{rumors: make(map[string][]Rumor), all: make(chan struct{}, 100)}
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{peers: addrs}, &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			if path != "/counter/gossip" {
+				return nil
+			}
+			s.mu.Lock()
+			s.rumors[addr] = append(s.rumors[addr], body.(Rumor))
+			s.mu.Unlock()
+			s.all <- struct{}{}
+			return nil
+		},
+	}, Config{Mode: Gossip, Gossip: gossip})
+	t.Cleanup(c.Close)
+	return c, s
+}
+
+func (s *sent) await(t *testing.T, n int) map[string][]Rumor {
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.all:
+		case <-time.After(time.Second):
+			t.Fatalf("got %d of %d sends", i, n)
+		}
+	}
+	select {
+	case <-s.all:
+		t.Fatal("more sends than expected")
+	case <-time.After(20 * time.Millisecond):
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rumors
+}
+
+func TestCounter_GossipSendsToFanoutPeers(t *testing.T) {
+	c, s := newGossipCounter(t, 8, GossipConfig{Fanout: 3})
+	require.NoError(t, c.IncrementAndPropagate())
+
+	rumors := s.await(t, 3)
+	assert.Len(t, rumors, 3, "three distinct peers")
+	for _, r := range rumors {
+		assert.Equal(t, uint32(4), r[0].TTL, "log3(9) rounded up, plus two")
+		assert.Equal(t, "node1:8080", r[0].Increment.NodeID)
+	}
+	assert.Equal(t, int64(1), c.Value())
+}
+
+func TestCounter_ApplyRumorForwardsNewIncrementsOnce(t *testing.T) {
+	c, s := newGossipCounter(t, 5, GossipConfig{Fanout: 2})
+	rumor := Rumor{Increment: Increment{ID: "a", NodeID: "peer1:8081", Time: time.Now().UnixNano()}, TTL: 3}
+
+	assert.True(t, c.ApplyRumor(rumor))
+	assert.False(t, c.ApplyRumor(rumor), "a known increment stops the rumor")
+	rumors := s.await(t, 2)
+	assert.NotContains(t, rumors, "peer1:8081", "never sent back to its origin")
+	for _, r := range rumors {
+		assert.Equal(t, uint32(2), r[0].TTL)
+	}
+	assert.Equal(t, int64(1), c.Value())
+
+	// The last hop applies without forwarding.
+	assert.True(t, c.ApplyRumor(Rumor{Increment: Increment{ID: "b", NodeID: "peer1:8081"}, TTL: 1}))
+	s.await(t, 0)
+}
+
+func TestGossipConfig_TTL(t *testing.T) {
+	assert.Equal(t, uint32(7), GossipConfig{Fanout: 3, TTL: 7}.ttl(100))
+	assert.Equal(t, uint32(2), GossipConfig{Fanout: 3}.ttl(1))
+	assert.Equal(t, uint32(7), GossipConfig{Fanout: 2}.ttl(32))
+	assert.Equal(t, uint32(5), GossipConfig{Fanout: 1}.ttl(4))
+}
+
+func TestRumor_BinaryRoundTrip(t *testing.T) {
+	r := Rumor{Increment: Increment{ID: "a", NodeID: "node1:8080", Epoch: 2, Time: 42, Logical: 1, Delta: 3}, TTL: 5}
+	data, err := r.MarshalBinary()
+	require.NoError(t, err)
+	var decoded Rumor
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, r, decoded)
+	assert.Error(t, decoded.UnmarshalBinary(data[:4]))
+}
@@ -0,0 +1,52 @@
+package counter
+
+import (
+	"log"
+	"sort"
+)
+
+// ClusterID returns the ID of the cluster this node belongs to, which it
+// stamps on the snapshots it exports.
+func (c *Counter) ClusterID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cluster
+}
+
+// joinCluster learns a peer's cluster ID and the IDs it had before, during
+// catch-up and digest comparisons. Nodes that started with different IDs
+// all move to the smallest, and keep the others in their lineage, so that
+// snapshots exported under any of them are still recognised as this
+// cluster's.
+func (c *Counter) joinCluster(id string, lineage []string) {
+	if id == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, former := range lineage {
+		c.lineage[former] = true
+	}
+	c.lineage[id] = true
+	if id < c.cluster {
+		log.Printf("Joining cluster %s, was %s", id, c.cluster)
+		c.cluster = id
+	}
+}
+
+// inLineage reports whether a cluster ID is, or was, this cluster's.
+func (c *Counter) inLineage(id string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lineage[id]
+}
+
+// lineageLocked returns the cluster IDs this node has had, sorted.
+func (c *Counter) lineageLocked() []string {
+	ids := make([]string, 0, len(c.lineage))
+	for id := range c.lineage {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,108 @@
+package counter
+
+import (
+	"context"
+	"log"
+)
+
+// Handoff carries the keyed increments a node hands to the new owners of
+// their keys.
+type Handoff struct {
+	Increments []Increment `json:"increments"`
+}
+
+// holds reports whether an increment belongs on this node: it is unkeyed,
+// made here, or of a key this node owns. Catch-up and repair skip the
+// others.
+func (c *Counter) holds(inc Increment) bool {
+	return inc.Key == "" || inc.NodeID == c.selfID || c.Owns(inc.Key)
+}
+
+// markStray schedules the increments of a key, just applied here, for the
+// next rebalance if the key belongs elsewhere.
+func (c *Counter) markStray(inc Increment) {
+	if !c.holds(inc) {
+		c.Retry(inc.Key)
+	}
+}
+
+// Rebalance runs when membership changed since the last one, keyed
+// increments this node doesn't own arrived, or the last one left work
+// undone. It sends the keyed increments in the dedup window to the owners
+// their keys gained, and those of keys marked for retry to all of their
+// owners. Increments stay here until they leave the dedup window, so that
+// applying them again is still recognised; owners that already have one
+// ignore it.
+func (c *Counter) Rebalance(ctx context.Context) {
+	rb, ok := c.StartRebalance()
+	if !ok {
+		return
+	}
+	batches := make(map[string][]Increment)  // by owner
+	keys := make(map[string]map[string]bool) // by owner
+	for _, inc := range c.keyedIncrements() {
+		for _, addr := range rb.Targets(inc.Key, false) {
+			batches[addr] = append(batches[addr], inc)
+			if keys[addr] == nil {
+				keys[addr] = make(map[string]bool)
+			}
+			keys[addr][inc.Key] = true
+		}
+	}
+
+	failed := make(map[string]bool)
+	handed := 0
+	for addr, incs := range batches {
+		if !c.handoff(ctx, addr, incs) {
+			for key := range keys[addr] {
+				failed[key] = true
+			}
+			continue
+		}
+		handed += len(incs)
+	}
+	rb.Finish(failed)
+	if handed > 0 {
+		log.Printf("Rebalanced keyed increments over %d nodes: sent %d", len(rb.Current.Members()), handed)
+	}
+}
+
+// keyedIncrements returns the keyed increments in the dedup window, in the
+// order they were applied.
+func (c *Counter) keyedIncrements() []Increment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var incs []Increment
+	for _, a := range c.applied {
+		if inc := c.seenIncrements[a.id]; inc.Key != "" {
+			incs = append(incs, inc)
+		}
+	}
+	return incs
+}
+
+// handoff sends increments to an owner in batches, reporting whether it
+// got all of them.
+func (c *Counter) handoff(ctx context.Context, addr string, incs []Increment) bool {
+	for len(incs) > 0 {
+		n := min(len(incs), maxRepairIncrements)
+		if err := c.SendHandoff(ctx, addr, "/counter/handoff", Handoff{Increments: incs[:n]}); err != nil {
+			log.Printf("Failed to hand %d keyed increments to %s: %v", len(incs), addr, err)
+			return false
+		}
+		incs = incs[n:]
+	}
+	return true
+}
+
+// ApplyHandoff applies the increments a peer handed over, like
+// ApplyPropagated, and returns how many were new here.
+func (c *Counter) ApplyHandoff(h Handoff) int {
+	applied := 0
+	for _, inc := range h.Increments {
+		if c.ApplyPropagated(inc) {
+			applied++
+		}
+	}
+	return applied
+}
@@ -0,0 +1,196 @@
+package counter
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/ring"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a transport that records the peers each path was sent to and
+// fails sends to the peers in fail.
+type recorder struct {
+	mu      sync.Mutex
+	sends   map[string][]string // path -> peers
+	handoff map[string][]Increment
+	fail    map[string]bool
+	state   State
+}
+
+func newRecorder() *recorder {
+	return &recorder{sends: make(map[string][]string), handoff: make(map[string][]Increment), fail: make(map[string]bool)}
+}
+
+func (r *recorder) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail[addr] {
+		return errors.New("unreachable")
+	}
+	r.sends[path] = append(r.sends[path], addr)
+	if h, ok := body.(Handoff); ok {
+		r.handoff[addr] = append(r.handoff[addr], h.Increments...)
+	}
+	if s, ok := reply.(*State); ok {
+		*s = r.state
+	}
+	return nil
+}
+
+// sent returns the peers that got sends to path, sorted, and forgets them.
+func (r *recorder) sent(path string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	peers := r.sends[path]
+	delete(r.sends, path)
+	sort.Strings(peers)
+	return peers
+}
+
+// newSharded returns a counter placing each key on two nodes. Rebalancing
+// only runs when called.
+func newSharded(t *testing.T, peers ...string) (*Counter, *recorder, *MockRegistry) {
+	registry := &MockRegistry{peers: peers}
+	transport := newRecorder()
+	c := NewCounterWithConfig("node1:8080", registry, transport, Config{
+		Sharding: ring.ShardingConfig{Replicas: 2},
+		Clock:    clock.NewFake(time.Unix(1_700_000_000, 0)),
+	})
+	t.Cleanup(c.Close)
+	return c, transport, registry
+}
+
+// key returns a key this node owns, or doesn't.
+func key(t *testing.T, c *Counter, owned bool) string {
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("bounded:campaign:%d", i)
+		if c.Owns(key) == owned {
+			return key
+		}
+	}
+	t.Fatal("no such key")
+	return ""
+}
+
+func TestCounter_Owners(t *testing.T) {
+	c := NewCounter("node1:8080", &MockRegistry{peers: []string{"peer1:8081"}}, &MockTransport{})
+	defer c.Close()
+	assert.Nil(t, c.Owners("bounded:ads"), "unsharded")
+	assert.True(t, c.Owns("bounded:ads"))
+
+	sc, _, _ := newSharded(t, "peer1:8081", "peer2:8082", "peer3:8083")
+	owners := sc.Owners("bounded:ads")
+	assert.Len(t, owners, 2)
+	assert.Equal(t, owners, sc.Owners("bounded:ads"))
+	assert.Nil(t, sc.Owners(""), "unkeyed increments go everywhere")
+}
+
+func TestCounter_KeyedIncrementsGoToOtherOwnersOnly(t *testing.T) {
+	c, transport, _ := newSharded(t, "peer1:8081", "peer2:8082", "peer3:8083")
+	for _, owned := range []bool{true, false} {
+		key := key(t, c, owned)
+		_, _, err := c.Submit(Increment{ID: key, Key: key})
+		require.NoError(t, err)
+		var want []string
+		for _, addr := range c.Owners(key) {
+			if addr != "node1:8080" {
+				want = append(want, addr)
+			}
+		}
+		var sent []string
+		require.Eventually(t, func() bool {
+			sent = sortedCopy(append(sent, transport.sent("/counter/propagate")...))
+			return len(sent) >= len(want)
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, sortedCopy(want), sent)
+	}
+
+	require.NoError(t, c.IncrementAndPropagate())
+	var sent []string
+	require.Eventually(t, func() bool {
+		sent = append(sent, transport.sent("/counter/propagate")...)
+		return len(sent) == 3
+	}, time.Second, 5*time.Millisecond, "unkeyed increments go to every peer")
+}
+
+func TestCounter_RebalanceHandsKeyedIncrementsToNewOwners(t *testing.T) {
+	c, transport, registry := newSharded(t, "peer1:8081")
+	for i := 0; i < 20; i++ {
+		c.ApplyPropagated(Increment{ID: fmt.Sprintf("inc-%d", i), NodeID: "peer1:8081", Key: fmt.Sprintf("bounded:campaign:%d", i)})
+	}
+	c.ApplyPropagated(Increment{ID: "unkeyed", NodeID: "peer1:8081"})
+	c.Rebalance(context.Background())
+	assert.Len(t, transport.handoff["peer1:8081"], 20, "the first rebalance syncs the co-owner")
+	delete(transport.handoff, "peer1:8081")
+	transport.sent("/counter/handoff")
+	c.Rebalance(context.Background())
+	assert.Empty(t, transport.sent("/counter/handoff"), "nothing changed")
+
+	registry.peers = []string{"peer1:8081", "peer2:8082", "peer3:8083"}
+	transport.fail["peer3:8083"] = true
+	c.Rebalance(context.Background())
+	// Each owner gets the increments of the keys it gained, and nothing
+	// leaves this node.
+	for _, addr := range []string{"peer2:8082", "peer3:8083"} {
+		var want []string
+		for i := 0; i < 20; i++ {
+			if slices.Contains(c.Owners(fmt.Sprintf("bounded:campaign:%d", i)), addr) {
+				want = append(want, fmt.Sprintf("inc-%d", i))
+			}
+		}
+		if addr == "peer3:8083" {
+			assert.Empty(t, transport.handoff[addr])
+			transport.fail[addr] = false
+			c.Rebalance(context.Background())
+		}
+		var got []string
+		for _, inc := range transport.handoff[addr] {
+			got = append(got, inc.ID)
+		}
+		assert.ElementsMatch(t, want, got, addr)
+	}
+	assert.Len(t, c.keyedIncrements(), 20)
+}
+
+func TestCounter_StrayKeyedIncrementTriggersRebalance(t *testing.T) {
+	c, transport, _ := newSharded(t, "peer1:8081", "peer2:8082", "peer3:8083")
+	c.Rebalance(context.Background())
+	transport.sent("/counter/handoff")
+	key := key(t, c, false)
+	c.ApplyPropagated(Increment{ID: "stray", NodeID: "peer1:8081", Key: key})
+
+	c.Rebalance(context.Background())
+	assert.Equal(t, sortedCopy(c.Owners(key)), transport.sent("/counter/handoff"))
+}
+
+func TestCounter_CatchUpSkipsKeysOwnedElsewhere(t *testing.T) {
+	c, transport, _ := newSharded(t, "peer1:8081", "peer2:8082", "peer3:8083")
+	owned, other := key(t, c, true), key(t, c, false)
+	transport.state = State{Increments: []Increment{
+		{ID: "a", NodeID: "peer1:8081"},
+		{ID: "b", NodeID: "peer1:8081", Key: owned},
+		{ID: "c", NodeID: "peer1:8081", Key: other},
+		{ID: "d", NodeID: "node1:8080", Key: other},
+	}}
+	require.NoError(t, c.CatchUp(context.Background()))
+
+	for id, want := range map[string]bool{"a": true, "b": true, "c": false, "d": true} {
+		_, seen := c.Lookup(id)
+		assert.Equal(t, want, seen, id)
+	}
+}
+
+func sortedCopy(s []string) []string {
+	s = slices.Clone(s)
+	sort.Strings(s)
+	return s
+}
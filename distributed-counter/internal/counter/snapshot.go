@@ -0,0 +1,377 @@
+package counter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SnapshotVersion is the snapshot format this build writes. Snapshots of a
+// newer version are refused. Version 2 added the cluster ID.
+const SnapshotVersion = 2
+
+// importTimeout bounds each part of a snapshot sent to a peer.
+const importTimeout = 30 * time.Second
+
+// Snapshot is a portable copy of a node's counter state, for backups and for
+// moving counts between clusters.
+type Snapshot struct {
+	Version int `json:"version"`
+	// ID identifies the snapshot, so importing it again changes nothing.
+	ID    string    `json:"id"`
+	Node  string    `json:"node"`
+	Taken time.Time `json:"taken"`
+	// Cluster is the ID of the cluster the snapshot was taken in. Version 1
+	// snapshots have none.
+	Cluster string `json:"cluster,omitempty"`
+	// Epoch is the current epoch and Totals the count of every epoch.
+	Epoch  uint64       `json:"epoch"`
+	Totals []EpochTotal `json:"totals"`
+	// Components break the totals down by origin node, as far as the dedup
+	// window reaches; increments that left it are listed with an empty node.
+	Components []Component `json:"components"`
+	// State holds what is imported: the increments of the dedup window, the
+	// compacted counts, buckets and earlier imports.
+	State State `json:"state"`
+}
+
+// Component is what one origin node's increments add up to in one epoch.
+type Component struct {
+	Node  string `json:"node"`
+	Epoch uint64 `json:"epoch"`
+	Count int64  `json:"count"`
+}
+
+// ImportResult reports what importing a snapshot changed on this node.
+type ImportResult struct {
+	ID string `json:"id"`
+	// Duplicate is set when the snapshot was imported before; nothing changed.
+	Duplicate bool `json:"duplicate"`
+	// Increments is how many of the snapshot's increments were new here, and
+	// Compacted how much its compacted counts added. Another cluster's
+	// compacted counts are imported as increments, and counted as such.
+	Increments int    `json:"increments"`
+	Compacted  int64  `json:"compacted"`
+	Epoch      uint64 `json:"epoch"`
+	// Peers reports, after ImportAndPropagate, whether each peer imported
+	// the snapshot too.
+	Peers []PeerImport `json:"peers,omitempty"`
+}
+
+// PeerImport is the outcome of sending a snapshot to one peer. Error is
+// empty when the peer imported all of it.
+type PeerImport struct {
+	Peer  string `json:"peer"`
+	Error string `json:"error,omitempty"`
+}
+
+// Delivered reports whether every peer imported the snapshot.
+func (r ImportResult) Delivered() bool {
+	for _, p := range r.Peers {
+		if p.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns a copy of this node's counter state.
+func (c *Counter) Snapshot() Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := Snapshot{
+		Version: SnapshotVersion,
+		ID:      uuid.NewString(),
+		Node:    c.selfID,
+		Taken:   c.clock.Now().UTC(),
+		Cluster: c.cluster,
+		Epoch:   c.epoch,
+		State:   c.stateLocked(),
+	}
+	for e, n := range c.totals {
+		s.Totals = append(s.Totals, EpochTotal{Epoch: e, Count: n})
+	}
+	sortEpochs(s.Totals)
+	s.Components = components(s.State)
+	return s
+}
+
+// total sets the totals and components of a snapshot from its state, for
+// snapshots derived from another.
+func (s *Snapshot) total() {
+	s.Components = components(s.State)
+	sums := make(map[uint64]int64)
+	for _, comp := range s.Components {
+		sums[comp.Epoch] += comp.Count
+	}
+	s.Totals = nil
+	for e, n := range sums {
+		s.Totals = append(s.Totals, EpochTotal{Epoch: e, Count: n})
+	}
+	sortEpochs(s.Totals)
+}
+
+// components adds up the unkeyed increments of a state by origin node and
+// epoch, and lists its compacted counts under an empty node.
+func components(state State) []Component {
+	sums := make(map[Component]int64)
+	for _, inc := range state.Increments {
+		if inc.Key == "" {
+			sums[Component{Node: inc.NodeID, Epoch: inc.Epoch}] += inc.Amount()
+		}
+	}
+	for _, t := range state.Compacted {
+		sums[Component{Epoch: t.Epoch}] += t.Count
+	}
+	out := make([]Component, 0, len(sums))
+	for k, n := range sums {
+		k.Count = n
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Epoch != out[j].Epoch {
+			return out[i].Epoch < out[j].Epoch
+		}
+		return out[i].Node < out[j].Node
+	})
+	return out
+}
+
+// validate checks that s is a version this build reads and that its totals
+// match the state it carries, which catches truncated or edited files.
+func (s Snapshot) validate() error {
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return fmt.Errorf("snapshot version %d is not supported, this node reads versions 1 to %d", s.Version, SnapshotVersion)
+	}
+	if s.ID == "" {
+		return fmt.Errorf("snapshot has no ID")
+	}
+	want := make(map[uint64]int64)
+	for _, t := range s.Totals {
+		want[t.Epoch] += t.Count
+	}
+	got := make(map[uint64]int64)
+	for _, comp := range components(s.State) {
+		got[comp.Epoch] += comp.Count
+	}
+	for e := range want {
+		if got[e] != want[e] {
+			return fmt.Errorf("snapshot totals %d in epoch %d but its state adds up to %d", want[e], e, got[e])
+		}
+	}
+	for e := range got {
+		if got[e] != want[e] {
+			return fmt.Errorf("snapshot totals %d in epoch %d but its state adds up to %d", want[e], e, got[e])
+		}
+	}
+	return nil
+}
+
+// Import merges a snapshot into this node's state, like catch-up merges a
+// peer's: increments are deduplicated by ID, and buckets merge by maximum.
+// A snapshot of this cluster, a backup, moves the node to its epoch if it
+// is newer, and its compacted counts, whose increments can't be
+// deduplicated any more, merge by maximum too. Another cluster's snapshot
+// is localized first. Imported snapshot IDs are remembered for good, so
+// importing the same snapshot again, or one that contains it, changes
+// nothing.
+func (c *Counter) Import(s Snapshot) (ImportResult, error) {
+	if err := s.validate(); err != nil {
+		return ImportResult{}, err
+	}
+	foreign := !c.ownSnapshot(s)
+	if foreign {
+		s = c.localize(s)
+	}
+	c.mu.Lock()
+	duplicate := c.imports[s.ID]
+	c.imports[s.ID] = true
+	for _, id := range s.State.Imports {
+		c.imports[id] = true
+	}
+	c.mu.Unlock()
+	if duplicate {
+		return ImportResult{ID: s.ID, Duplicate: true, Epoch: c.Epoch()}, nil
+	}
+
+	result := ImportResult{ID: s.ID}
+	c.AdvanceEpoch(s.Epoch)
+	for _, inc := range s.State.Increments {
+		if !c.holds(inc) {
+			continue // a keyed increment this node doesn't own
+		}
+		c.Receive(inc)
+		if c.applyIncrement(inc, foreign) {
+			result.Increments++
+		}
+	}
+	result.Compacted = c.mergeCompacted(s.State)
+	c.mergeBuckets(s.State.Buckets)
+	result.Epoch = c.Epoch()
+	log.Printf("Imported snapshot %s of %s taken at %s: %d new increments, %d compacted", s.ID, s.Node, s.Taken.Format(time.RFC3339), result.Increments, result.Compacted)
+	return result, nil
+}
+
+// ownSnapshot reports whether a snapshot was taken in this cluster. Version
+// 1 snapshots have no cluster ID; they count as this cluster's when the
+// node that took them is a member.
+func (c *Counter) ownSnapshot(s Snapshot) bool {
+	if s.Cluster == "" {
+		return s.Node == c.selfID || slices.Contains(c.registry.GetPeerAddrs(), s.Node)
+	}
+	return c.inLineage(s.Cluster)
+}
+
+// localize rewrites another cluster's snapshot as one of this cluster, in
+// its current epoch. The epochs of the two clusters have nothing in
+// common: the counts of the snapshot's current epoch move to ours, and
+// those of its earlier epochs, which were reset there, are left out. Its
+// compacted counts become one increment per snapshot, with an ID derived
+// from the snapshot's and dated before the window, so that no windowed
+// count includes them twice. Import only one snapshot of a cluster that
+// keeps running, as the compacted counts of the next include the first's.
+func (c *Counter) localize(s Snapshot) Snapshot {
+	epoch := c.Epoch()
+	out := Snapshot{
+		Version: SnapshotVersion,
+		ID:      s.ID,
+		Node:    s.Node,
+		Taken:   s.Taken,
+		Cluster: c.ClusterID(),
+		Epoch:   epoch,
+		State:   State{Epoch: epoch, Imports: s.State.Imports},
+	}
+	for _, inc := range s.State.Increments {
+		if inc.Key == "" && inc.Epoch != s.Epoch {
+			continue
+		}
+		inc.Epoch = epoch
+		out.State.Increments = append(out.State.Increments, inc)
+	}
+	before := s.Taken.Add(-max(c.cfg.DedupRetention, c.cfg.Window.Retention)).UnixNano()
+	for _, t := range s.State.Compacted {
+		if t.Epoch != s.Epoch || t.Count <= 0 {
+			continue
+		}
+		out.State.Increments = append(out.State.Increments, Increment{
+			ID:     fmt.Sprintf("%s/compacted/%d", s.ID, t.Epoch),
+			NodeID: s.Node,
+			Epoch:  epoch,
+			Time:   before,
+			Delta:  t.Count,
+		})
+	}
+	for _, b := range s.State.Buckets {
+		if b.Epoch == s.Epoch {
+			b.Epoch = epoch
+			out.State.Buckets = append(out.State.Buckets, b)
+		}
+	}
+	out.total()
+	return out
+}
+
+// chunks splits a snapshot into snapshots of at most maxRepairIncrements
+// increments, each small enough for one message. The first carries the
+// compacted counts, buckets and earlier imports; the last lists the
+// snapshot itself among its imports, so a peer that got every part knows
+// the snapshot as imported.
+func (s Snapshot) chunks() []Snapshot {
+	incs := s.State.Increments
+	n := max((len(incs)+maxRepairIncrements-1)/maxRepairIncrements, 1)
+	parts := make([]Snapshot, n)
+	for i := range parts {
+		part := Snapshot{
+			Version: s.Version,
+			ID:      fmt.Sprintf("%s/%d", s.ID, i+1),
+			Node:    s.Node,
+			Taken:   s.Taken,
+			Cluster: s.Cluster,
+			Epoch:   s.Epoch,
+			State:   State{Epoch: s.Epoch},
+		}
+		m := min(len(incs), maxRepairIncrements)
+		part.State.Increments, incs = incs[:m], incs[m:]
+		if i == 0 {
+			part.State.Compacted = s.State.Compacted
+			part.State.CompactedTo = s.State.CompactedTo
+			part.State.Buckets = s.State.Buckets
+			part.State.Imports = s.State.Imports
+		}
+		if i == n-1 {
+			part.State.Imports = append(slices.Clone(part.State.Imports), s.ID)
+		}
+		part.total()
+		parts[i] = part
+	}
+	return parts
+}
+
+// ImportAndPropagate imports a snapshot here and sends it to every peer, in
+// parts small enough for one message each, waiting for them to import it
+// the same way. Another cluster's snapshot is localized here first, so
+// every node imports the same counts. A duplicate is sent again, so
+// repeating an import reaches peers that missed it; result.Peers reports
+// which did.
+func (c *Counter) ImportAndPropagate(ctx context.Context, s Snapshot) (ImportResult, error) {
+	if err := s.validate(); err != nil {
+		return ImportResult{}, err
+	}
+	foreign := !c.ownSnapshot(s)
+	if foreign {
+		s = c.localize(s)
+	}
+	result, err := c.Import(s)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	parts := s.chunks()
+	peers := c.registry.GetPeerAddrs()
+	result.Peers = make([]PeerImport, len(peers))
+	var wg sync.WaitGroup
+	for i, addr := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result.Peers[i] = PeerImport{Peer: addr}
+			for _, part := range parts {
+				sendCtx, cancel := context.WithTimeout(ctx, importTimeout)
+				err := c.transport.Send(sendCtx, addr, "/counter/import", part, nil)
+				cancel()
+				if err != nil {
+					log.Printf("Failed to send snapshot %s to %s: %v", s.ID, addr, err)
+					result.Peers[i].Error = err.Error()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	sort.Slice(result.Peers, func(i, j int) bool { return result.Peers[i].Peer < result.Peers[j].Peer })
+	return result, nil
+}
+
+// mergeImports remembers the snapshots a peer imported, during catch-up.
+func (c *Counter) mergeImports(ids []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		c.imports[id] = true
+	}
+}
+
+// importsLocked returns the imported snapshot IDs, sorted.
+func (c *Counter) importsLocked() []string {
+	ids := make([]string, 0, len(c.imports))
+	for id := range c.imports {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
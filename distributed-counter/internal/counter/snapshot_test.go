@@ -0,0 +1,254 @@
+package counter
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var snapshotTaken = time.Unix(1_700_000_000, 0).Add(2 * time.Minute)
+
+// snapshotSource returns a counter with increments from two nodes, some of
+// them expired from the dedup window, across two epochs. Its clock reads
+// snapshotTaken.
+func snapshotSource(t *testing.T) *Counter {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, Config{DedupRetention: time.Minute, Clock: fake})
+	t.Cleanup(c.Close)
+	c.ApplyIncrement(Increment{ID: "old-1", NodeID: "node1:8080", Epoch: 1})
+	c.ApplyIncrement(Increment{ID: "old-2", NodeID: "node2:8080", Epoch: 1, Delta: 4})
+	fake.Advance(2 * time.Minute)
+	c.expireIncrements()
+	c.ApplyIncrement(Increment{ID: "inc-1", NodeID: "node1:8080", Time: fake.Now().UnixNano()})
+	c.ApplyIncrement(Increment{ID: "inc-2", NodeID: "node2:8080", Epoch: 1, Time: fake.Now().UnixNano()})
+	c.ApplyIncrement(Increment{ID: "inc-3", NodeID: "node2:8080", Epoch: 1, Time: fake.Now().UnixNano(), Delta: 2})
+	return c
+}
+
+func TestCounter_Snapshot(t *testing.T) {
+	src := snapshotSource(t)
+	s := src.Snapshot()
+	assert.Equal(t, SnapshotVersion, s.Version)
+	assert.NotEmpty(t, s.ID)
+	assert.Equal(t, "node1:8080", s.Node)
+	assert.Equal(t, src.ClusterID(), s.Cluster)
+	assert.Equal(t, uint64(1), s.Epoch)
+	assert.Equal(t, snapshotTaken.UTC(), s.Taken)
+	assert.Equal(t, []EpochTotal{{Epoch: 0, Count: 1}, {Epoch: 1, Count: 8}}, s.Totals)
+	assert.Equal(t, []Component{
+		{Node: "node1:8080", Epoch: 0, Count: 1},
+		{Node: "", Epoch: 1, Count: 5},
+		{Node: "node2:8080", Epoch: 1, Count: 3},
+	}, s.Components)
+	assert.Len(t, s.State.Increments, 3)
+	assert.NoError(t, s.validate())
+}
+
+func TestCounter_ImportMapsAnotherClusterIntoCurrentEpoch(t *testing.T) {
+	src := snapshotSource(t)
+	data, err := json.Marshal(src.Snapshot())
+	require.NoError(t, err)
+	var s Snapshot
+	require.NoError(t, json.Unmarshal(data, &s))
+
+	// Same addresses, another cluster.
+	dst := NewCounterWithConfig("node2:8080", &MockRegistry{peers: []string{"node1:8080"}}, &MockTransport{}, Config{Clock: clock.NewFake(snapshotTaken)})
+	defer dst.Close()
+	result, err := dst.Import(s)
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{ID: s.ID, Increments: 3, Epoch: 0}, result, "inc-2, inc-3 and the compacted counts")
+	assert.Equal(t, []EpochTotal{{Epoch: 0, Count: 8}}, dst.Epochs(), "epoch 1 there is epoch 0 here, and the epoch reset there is left out")
+	want, err := src.WindowValue(time.Hour)
+	require.NoError(t, err)
+	got, err := dst.WindowValue(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, "compacted counts are in the buckets already")
+
+	result, err = dst.Import(s)
+	require.NoError(t, err)
+	assert.True(t, result.Duplicate)
+	assert.Equal(t, int64(8), dst.Value(), "importing twice changes nothing")
+}
+
+func TestCounter_ImportBackupIntoSameCluster(t *testing.T) {
+	src := snapshotSource(t)
+	backup := src.Snapshot()
+	src.ApplyIncrement(Increment{ID: "inc-4", NodeID: "node1:8080", Epoch: 1})
+	src.expireIncrements()
+
+	result, err := src.Import(backup)
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{ID: backup.ID, Epoch: 1}, result)
+	assert.Equal(t, int64(9), src.Value(), "compacted counts merge by maximum, not added twice")
+
+	peer := NewCounterWithConfig("node2:8080", &MockRegistry{}, &MockTransport{}, Config{ClusterID: src.ClusterID()})
+	defer peer.Close()
+	result, err = peer.Import(backup)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), result.Compacted)
+	assert.Equal(t, backup.Totals, peer.Epochs(), "a wiped node restores the backup")
+}
+
+func TestCounter_ImportVersion1SnapshotByMembership(t *testing.T) {
+	s := snapshotSource(t).Snapshot()
+	s.Version, s.Cluster = 1, ""
+
+	member := NewCounter("node2:8080", &MockRegistry{peers: []string{"node1:8080"}}, &MockTransport{})
+	defer member.Close()
+	_, err := member.Import(s)
+	require.NoError(t, err)
+	assert.Equal(t, s.Totals, member.Epochs())
+
+	other := NewCounter("other:8080", &MockRegistry{}, &MockTransport{})
+	defer other.Close()
+	_, err = other.Import(s)
+	require.NoError(t, err)
+	assert.Equal(t, []EpochTotal{{Epoch: 0, Count: 8}}, other.Epochs())
+}
+
+func TestCounter_ClusterIDConverges(t *testing.T) {
+	a := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, Config{ClusterID: "b"})
+	defer a.Close()
+	backup := a.Snapshot()
+	a.joinCluster("a", nil)
+	assert.Equal(t, "a", a.ClusterID(), "the smallest ID wins")
+	a.joinCluster("c", []string{"d"})
+	assert.Equal(t, "a", a.ClusterID())
+	assert.Equal(t, []string{"a", "b", "c", "d"}, a.State().Lineage)
+
+	result, err := a.Import(backup)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), result.Epoch)
+	assert.True(t, a.ownSnapshot(backup), "snapshots taken under a former ID are still this cluster's")
+}
+
+func TestCounter_ImportMergesWithExistingCounts(t *testing.T) {
+	s := snapshotSource(t).Snapshot()
+	dst := NewCounter("other:8080", &MockRegistry{}, &MockTransport{})
+	defer dst.Close()
+	dst.ApplyIncrement(Increment{ID: "inc-2", NodeID: "node2:8080", Epoch: 1})
+	dst.ApplyIncrement(Increment{ID: "mine", NodeID: "other:8080", Epoch: 1})
+
+	result, err := dst.Import(s)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Increments, "inc-2 was already counted")
+	assert.Equal(t, int64(9), dst.Value())
+}
+
+func TestCounter_ImportRejectsInvalidSnapshots(t *testing.T) {
+	c := NewCounter("node1:8080", &MockRegistry{}, &MockTransport{})
+	defer c.Close()
+	valid := snapshotSource(t).Snapshot()
+
+	for name, edit := range map[string]func(s *Snapshot){
+		"no version":    func(s *Snapshot) { s.Version = 0 },
+		"newer version": func(s *Snapshot) { s.Version = SnapshotVersion + 1 },
+		"no ID":         func(s *Snapshot) { s.ID = "" },
+		"edited totals": func(s *Snapshot) { s.Totals = []EpochTotal{{Epoch: 0, Count: 1}, {Epoch: 1, Count: 800}} },
+		"truncated":     func(s *Snapshot) { s.State.Increments = s.State.Increments[:1] },
+	} {
+		s := valid
+		s.State.Increments = append([]Increment(nil), valid.State.Increments...)
+		edit(&s)
+		_, err := c.Import(s)
+		assert.Error(t, err, name)
+	}
+	assert.Equal(t, int64(0), c.Value())
+	assert.Empty(t, c.State().Imports, "rejected snapshots are not remembered")
+}
+
+func TestCounter_CatchUpCarriesImports(t *testing.T) {
+	s := snapshotSource(t).Snapshot()
+	seed := NewCounter("seed:8080", &MockRegistry{}, &MockTransport{})
+	defer seed.Close()
+	_, err := seed.Import(s)
+	require.NoError(t, err)
+
+	c := NewCounter("node3:8080", &MockRegistry{peers: []string{"seed:8080"}}, &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			*reply.(*State) = seed.State()
+			return nil
+		},
+	})
+	defer c.Close()
+	require.NoError(t, c.CatchUp(context.Background()))
+	assert.Equal(t, []string{s.ID}, c.State().Imports)
+	assert.Equal(t, seed.Epochs(), c.Epochs())
+
+	result, err := c.Import(s)
+	require.NoError(t, err)
+	assert.True(t, result.Duplicate)
+	assert.Equal(t, seed.Epochs(), c.Epochs())
+}
+
+func TestCounter_ImportAndPropagate(t *testing.T) {
+	var mu sync.Mutex
+	sent := make(map[string]int)
+	c := NewCounter("node1:8080", &MockRegistry{peers: []string{"peer2:8082", "peer1:8081"}}, &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, "/counter/import", path)
+			if addr == "peer2:8082" {
+				return errors.New("unreachable")
+			}
+			sent[addr]++
+			return nil
+		},
+	})
+	defer c.Close()
+	s := snapshotSource(t).Snapshot()
+
+	result, err := c.ImportAndPropagate(context.Background(), s)
+	require.NoError(t, err)
+	assert.False(t, result.Duplicate)
+	assert.Equal(t, []PeerImport{{Peer: "peer1:8081"}, {Peer: "peer2:8082", Error: "unreachable"}}, result.Peers)
+	assert.False(t, result.Delivered())
+	result, err = c.ImportAndPropagate(context.Background(), s)
+	require.NoError(t, err)
+	assert.True(t, result.Duplicate)
+	assert.Equal(t, 2, sent["peer1:8081"], "repeats reach peers too")
+
+	s.Version = 0
+	_, err = c.ImportAndPropagate(context.Background(), s)
+	assert.Error(t, err)
+}
+
+func TestCounter_ImportAndPropagateSendsLargeSnapshotsInParts(t *testing.T) {
+	src := snapshotSource(t)
+	for i := 0; i < 2*maxRepairIncrements+1; i++ {
+		src.ApplyIncrement(Increment{ID: fmt.Sprintf("bulk-%d", i), NodeID: "node2:8080", Epoch: 1})
+	}
+	s := src.Snapshot()
+
+	peer := NewCounter("peer1:8081", &MockRegistry{}, &MockTransport{})
+	defer peer.Close()
+	var parts int
+	c := NewCounter("node1:8080", &MockRegistry{peers: []string{"peer1:8081"}}, &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			part := body.(Snapshot)
+			assert.LessOrEqual(t, len(part.State.Increments), maxRepairIncrements)
+			parts++
+			_, err := peer.Import(part)
+			return err
+		},
+	})
+	defer c.Close()
+
+	result, err := c.ImportAndPropagate(context.Background(), s)
+	require.NoError(t, err)
+	assert.True(t, result.Delivered())
+	assert.Equal(t, 3, parts)
+	assert.Equal(t, c.Epochs(), peer.Epochs())
+	result, err = peer.Import(s)
+	require.NoError(t, err)
+	assert.True(t, result.Duplicate, "the last part marks the whole snapshot imported")
+}
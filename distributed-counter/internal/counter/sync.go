@@ -0,0 +1,136 @@
+package counter
+
+import (
+	"context"
+	"distributed-counter/internal/hlc"
+	"log"
+	"time"
+)
+
+const catchUpRetryInterval = 500 * time.Millisecond
+
+// SyncState describes whether a node has copied the increments it missed
+// before joining.
+type SyncState string
+
+const (
+	// SyncBootstrap means there was nothing to catch up from, e.g. the first node.
+	SyncBootstrap SyncState = "bootstrap"
+	// SyncCatchingUp means the node is still looking for a peer to copy state from.
+	SyncCatchingUp SyncState = "catching-up"
+	// SyncCaughtUp means the node has merged a peer's state.
+	SyncCaughtUp SyncState = "caught-up"
+	// SyncFailed means the node gave up waiting for a peer to copy state
+	// from, so its counts may be stale. It keeps trying if asked to.
+	SyncFailed SyncState = "catch-up-failed"
+)
+
+// Ready reports whether a node in this state holds the cluster's counts as
+// far as it knows.
+func (s SyncState) Ready() bool {
+	return s == SyncBootstrap || s == SyncCaughtUp
+}
+
+// State is the full set of increments a node has applied.
+type State struct {
+	Increments []Increment `json:"increments"`
+	// Compacted counts the increments per epoch that left the dedup window
+	// and are no longer listed individually.
+	Compacted []EpochTotal `json:"compacted,omitempty"`
+	// CompactedTo is the timestamp of the newest increment per origin that
+	// left the dedup window.
+	CompactedTo map[string]hlc.Timestamp `json:"compacted_to,omitempty"`
+	Epoch       uint64                   `json:"epoch"`
+	// Buckets are the per-node time buckets behind windowed counts.
+	Buckets []Bucket `json:"buckets,omitempty"`
+	// Imports are the IDs of the snapshots imported into the cluster.
+	Imports []string `json:"imports,omitempty"`
+	// Cluster is the ID of the cluster and Lineage every ID it has had.
+	Cluster string   `json:"cluster,omitempty"`
+	Lineage []string `json:"lineage,omitempty"`
+	// Sync is the serving node's catch-up progress; peers don't copy state
+	// from a node that is itself still catching up.
+	Sync SyncState `json:"sync_state,omitempty"`
+}
+
+// State returns a snapshot of every applied increment.
+func (c *Counter) State() State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stateLocked()
+}
+
+func (c *Counter) stateLocked() State {
+	incs := make([]Increment, 0, len(c.seenIncrements))
+	for _, inc := range c.seenIncrements {
+		incs = append(incs, inc)
+	}
+	return State{Increments: incs, Compacted: c.compactedLocked(), CompactedTo: c.compactedToLocked(), Epoch: c.epoch, Buckets: c.bucketsLocked(), Imports: c.importsLocked(), Cluster: c.cluster, Lineage: c.lineageLocked(), Sync: c.syncState}
+}
+
+// SyncState reports the catch-up progress.
+func (c *Counter) SyncState() SyncState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.syncState
+}
+
+func (c *Counter) setSyncState(s SyncState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncState = s
+}
+
+// CatchUp copies the increments this node missed from the first peer that
+// answers, retrying until one does. If ctx ends first the node is marked
+// SyncFailed; calling CatchUp again keeps trying. Peers that are still
+// catching up are skipped, and so are peers that gave up, unless this node
+// gave up too: when a whole cluster starts at once no node has anything to
+// copy, and the nodes that waited out their catch-up take each other's
+// state.
+func (c *Counter) CatchUp(ctx context.Context) error {
+	failed := c.SyncState() == SyncFailed
+	if !failed {
+		c.setSyncState(SyncCatchingUp)
+	}
+	for {
+		for _, addr := range c.registry.GetPeerAddrs() {
+			var state State
+			if err := c.transport.Send(ctx, addr, "/counter/state", nil, &state); err != nil {
+				log.Printf("Failed to fetch state from %s: %v", addr, err)
+				continue
+			}
+			if state.Sync == SyncCatchingUp || (state.Sync == SyncFailed && !failed) {
+				log.Printf("Skipping %s for catch-up: it is %s", addr, state.Sync)
+				continue
+			}
+			c.AdvanceEpoch(state.Epoch)
+			applied := 0
+			for _, inc := range state.Increments {
+				if !c.holds(inc) {
+					continue // a keyed increment this node doesn't own
+				}
+				c.Receive(inc)
+				if c.ApplyIncrement(inc) {
+					applied++
+				}
+			}
+			// After the increments, whose origins' marks the peer's may pass.
+			c.mergeCompacted(state)
+			c.mergeBuckets(state.Buckets)
+			c.mergeImports(state.Imports)
+			c.joinCluster(state.Cluster, state.Lineage)
+			log.Printf("Caught up from %s: applied %d of %d increments", addr, applied, len(state.Increments))
+			c.setSyncState(SyncCaughtUp)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Gave up catching up: %v", ctx.Err())
+			c.setSyncState(SyncFailed)
+			return ctx.Err()
+		case <-time.After(catchUpRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package counter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter_CatchUpMergesPeerState(t *testing.T) {
+	client := &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			if addr == "down:8081" {
+				return errors.New("connection refused")
+			}
+			assert.Equal(t, "/counter/state", path)
+			*reply.(*State) = State{Increments: []Increment{
+				{ID: "inc-1", NodeID: "up:8082"},
+				{ID: "inc-2", NodeID: "up:8082"},
+			}}
+			return nil
+		},
+	}
+	c := NewCounter("node1:8080", &MockRegistry{peers: []string{"down:8081", "up:8082"}}, client)
+	c.ApplyIncrement(Increment{ID: "inc-1", NodeID: "up:8082"})
+	assert.Equal(t, SyncBootstrap, c.SyncState())
+
+	require.NoError(t, c.CatchUp(context.Background()))
+	assert.Equal(t, SyncCaughtUp, c.SyncState())
+	assert.Equal(t, int64(2), c.Value(), "already applied increments are not counted twice")
+	assert.Len(t, c.State().Increments, 2)
+}
+
+func TestCounter_CatchUpGivesUpWithoutPeers(t *testing.T) {
+	c := NewCounter("node1:8080", &MockRegistry{}, &MockTransport{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.CatchUp(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, SyncFailed, c.SyncState())
+	assert.False(t, c.SyncState().Ready())
+}
+
+func TestCounter_CatchUpFromFailedPeersOnlyAfterFailing(t *testing.T) {
+	client := &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			*reply.(*State) = State{Increments: []Increment{{ID: "inc-1", NodeID: "peer:8081"}}, Sync: SyncFailed}
+			return nil
+		},
+	}
+	c := NewCounter("node1:8080", &MockRegistry{peers: []string{"peer:8081"}}, client)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, c.CatchUp(ctx), context.DeadlineExceeded)
+	assert.Equal(t, SyncFailed, c.SyncState(), "a peer that gave up is no source for a node still waiting")
+
+	// Both gave up, as when a whole cluster starts at once.
+	require.NoError(t, c.CatchUp(context.Background()))
+	assert.Equal(t, SyncCaughtUp, c.SyncState())
+	assert.Equal(t, int64(1), c.Value())
+}
+
+func TestCounter_CatchUpSkipsPeersStillCatchingUp(t *testing.T) {
+	client := &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			if addr == "restarted:8081" {
+				*reply.(*State) = State{Sync: SyncCatchingUp}
+				return nil
+			}
+			*reply.(*State) = State{Increments: []Increment{{ID: "inc-1", NodeID: "up:8082"}}, Sync: SyncCaughtUp}
+			return nil
+		},
+	}
+	c := NewCounter("node1:8080", &MockRegistry{peers: []string{"restarted:8081", "up:8082"}}, client)
+
+	require.NoError(t, c.CatchUp(context.Background()))
+	assert.Equal(t, int64(1), c.Value())
+}
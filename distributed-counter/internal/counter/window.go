@@ -0,0 +1,149 @@
+package counter
+
+import (
+	"distributed-counter/internal/hlc"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultBucketSize is the default granularity of windowed counts.
+	DefaultBucketSize = 1 * time.Minute
+	// DefaultWindowRetention is how far back windowed counts reach by default.
+	DefaultWindowRetention = 24 * time.Hour
+)
+
+// WindowConfig sets up the time buckets behind windowed counts.
+type WindowConfig struct {
+	// BucketSize is the granularity of windowed counts. Zero means DefaultBucketSize.
+	BucketSize time.Duration
+	// Retention is the longest window that can be queried; older buckets are
+	// dropped. Zero means DefaultWindowRetention.
+	Retention time.Duration
+}
+
+// Bucket counts one origin node's increments whose hybrid logical
+// timestamps fall in one time bucket of the current epoch.
+type Bucket struct {
+	Node  string `json:"node"`
+	Epoch uint64 `json:"epoch"`
+	// Start is the beginning of the bucket in Unix seconds.
+	Start int64 `json:"start"`
+	Count int64 `json:"count"`
+}
+
+type bucketKey struct {
+	node  string
+	epoch uint64
+	start int64
+}
+
+func (w *WindowConfig) setDefaults() {
+	if w.BucketSize <= 0 {
+		w.BucketSize = DefaultBucketSize
+	}
+	if w.Retention <= 0 {
+		w.Retention = DefaultWindowRetention
+	}
+}
+
+func (c *Counter) bucketStart(t time.Time) int64 {
+	return t.Truncate(c.cfg.Window.BucketSize).Unix()
+}
+
+// addToBucketLocked counts an increment in the bucket of its timestamp.
+// Hybrid logical timestamps order increments after those that preceded them
+// on any node, so skewed clocks can't put an increment in an earlier bucket
+// than one that happened before it.
+func (c *Counter) addToBucketLocked(inc Increment) {
+	at := time.Unix(0, inc.Time)
+	if inc.Time == 0 {
+		// Sent by a node that doesn't stamp increments.
+		at = c.clock.Now()
+	}
+	if at.Before(c.clock.Now().Add(-c.cfg.Window.Retention)) {
+		return
+	}
+	c.buckets[bucketKey{node: inc.NodeID, epoch: inc.Epoch, start: c.bucketStart(at)}] += inc.Amount()
+}
+
+// WindowCount is the number of increments in a trailing window.
+type WindowCount struct {
+	Count int64 `json:"count"`
+	// From is the start of the oldest bucket counted, in Unix seconds.
+	From int64 `json:"from"`
+	// AsOf is the hybrid logical time the window ends at.
+	AsOf hlc.Timestamp `json:"as_of"`
+}
+
+// Window returns the number of increments of the current epoch made during
+// the last d, across the cluster, measured on the hybrid logical clock. It
+// counts whole buckets, so it may include up to one bucket's worth of
+// increments from just before the window.
+func (c *Counter) Window(d time.Duration) (WindowCount, error) {
+	if d <= 0 || d > c.cfg.Window.Retention {
+		return WindowCount{}, fmt.Errorf("window must be between 0 and %s", c.cfg.Window.Retention)
+	}
+	now := c.hlc.Peek()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := WindowCount{From: c.bucketStart(now.Time().Add(-d)), AsOf: now}
+	for key, n := range c.buckets {
+		if key.epoch == c.epoch && key.start >= result.From {
+			result.Count += n
+		}
+	}
+	return result, nil
+}
+
+// WindowValue returns the count of Window.
+func (c *Counter) WindowValue(d time.Duration) (int64, error) {
+	result, err := c.Window(d)
+	return result.Count, err
+}
+
+// expireBuckets drops buckets that have left the retention period.
+func (c *Counter) expireBuckets() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := c.bucketStart(c.clock.Now().Add(-c.cfg.Window.Retention))
+	for key := range c.buckets {
+		if key.start < cutoff {
+			delete(c.buckets, key)
+		}
+	}
+}
+
+// bucketsLocked returns every bucket, ordered by node and time.
+func (c *Counter) bucketsLocked() []Bucket {
+	buckets := make([]Bucket, 0, len(c.buckets))
+	for key, n := range c.buckets {
+		buckets = append(buckets, Bucket{Node: key.node, Epoch: key.epoch, Start: key.start, Count: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Node != buckets[j].Node {
+			return buckets[i].Node < buckets[j].Node
+		}
+		if buckets[i].Epoch != buckets[j].Epoch {
+			return buckets[i].Epoch < buckets[j].Epoch
+		}
+		return buckets[i].Start < buckets[j].Start
+	})
+	return buckets
+}
+
+// mergeBuckets raises our buckets to a peer's counts. After catch-up has
+// applied the peer's increments, a peer bucket can only be higher where it
+// holds increments that left the dedup window.
+func (c *Counter) mergeBuckets(buckets []Bucket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := c.bucketStart(c.clock.Now().Add(-c.cfg.Window.Retention))
+	for _, b := range buckets {
+		key := bucketKey{node: b.Node, epoch: b.Epoch, start: b.Start}
+		if b.Start >= cutoff && b.Count > c.buckets[key] {
+			c.buckets[key] = b.Count
+		}
+	}
+}
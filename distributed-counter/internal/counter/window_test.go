@@ -0,0 +1,140 @@
+package counter
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWindowedCounter(t *testing.T, peers ...string) (*Counter, *clock.Fake) {
+	fake := clock.NewFake(time.Unix(1_000_000_020, 0)) // 20s into a minute bucket
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{peers: peers}, &MockTransport{}, Config{
+		Window: WindowConfig{BucketSize: time.Minute, Retention: time.Hour},
+		Clock:  fake,
+	})
+	t.Cleanup(c.Close)
+	return c, fake
+}
+
+func TestCounter_WindowValue(t *testing.T) {
+	c, fake := newWindowedCounter(t)
+	require.NoError(t, c.IncrementAndPropagate())
+	fake.Advance(10 * time.Minute)
+	require.NoError(t, c.IncrementAndPropagate())
+	// A peer's increment counts at its origin time, not when it arrived.
+	c.ApplyIncrement(Increment{ID: "remote", NodeID: "peer1:8081", Time: fake.Now().Add(-5 * time.Minute).UnixNano()})
+
+	count, err := c.WindowValue(time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	count, err = c.WindowValue(6 * time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	count, err = c.WindowValue(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	assert.Equal(t, int64(3), c.Value())
+}
+
+func TestCounter_WindowOutsideRetention(t *testing.T) {
+	c, _ := newWindowedCounter(t)
+	_, err := c.WindowValue(2 * time.Hour)
+	assert.Error(t, err)
+	_, err = c.WindowValue(0)
+	assert.Error(t, err)
+}
+
+func TestCounter_OldBucketsExpire(t *testing.T) {
+	c, fake := newWindowedCounter(t)
+	require.NoError(t, c.IncrementAndPropagate())
+	fake.Advance(2 * time.Hour)
+	c.expireBuckets()
+	assert.Empty(t, c.State().Buckets)
+
+	// Increments older than the retention are not bucketed at all.
+	c.ApplyIncrement(Increment{ID: "stale", NodeID: "peer1:8081", Time: fake.Now().Add(-90 * time.Minute).UnixNano()})
+	assert.Empty(t, c.State().Buckets)
+	assert.Equal(t, int64(2), c.Value())
+}
+
+func TestCounter_WindowCountsCurrentEpochOnly(t *testing.T) {
+	c, _ := newWindowedCounter(t)
+	require.NoError(t, c.IncrementAndPropagate())
+	_, err := c.Reset()
+	require.NoError(t, err)
+	require.NoError(t, c.IncrementAndPropagate())
+
+	count, err := c.WindowValue(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestCounter_CatchUpMergesBuckets(t *testing.T) {
+	c, fake := newWindowedCounter(t, "up:8082")
+	start := fake.Now().Truncate(time.Minute).Unix()
+	c.transport = &MockTransport{
+		SendFunc: func(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+			*reply.(*State) = State{
+				Increments: []Increment{{ID: "recent", NodeID: "up:8082", Time: fake.Now().UnixNano()}},
+				Compacted:  []EpochTotal{{Epoch: 0, Count: 4}},
+				Buckets:    []Bucket{{Node: "up:8082", Start: start, Count: 5}},
+			}
+			return nil
+		},
+	}
+
+	require.NoError(t, c.CatchUp(context.Background()))
+	count, err := c.WindowValue(time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+	assert.Equal(t, int64(5), c.Value())
+}
+
+func TestCounter_IncrementsCarryHybridLogicalTimestamps(t *testing.T) {
+	c, fake := newWindowedCounter(t)
+	first, _, err := c.Submit(Increment{ID: "a"})
+	require.NoError(t, err)
+	second, _, err := c.Submit(Increment{ID: "b"})
+	require.NoError(t, err)
+	assert.Equal(t, fake.Now().UnixNano(), first.Time)
+	assert.True(t, first.Timestamp().Less(second.Timestamp()), "increments in the same nanosecond still order")
+
+	// A peer whose clock is 200ms ahead sends an increment; ours made after
+	// receiving it orders after it, although our clock is behind.
+	remote := Increment{ID: "remote", NodeID: "peer1:8081", Time: fake.Now().Add(200 * time.Millisecond).UnixNano(), Logical: 2}
+	c.Receive(remote)
+	c.ApplyIncrement(remote)
+	after, _, err := c.Submit(Increment{ID: "c"})
+	require.NoError(t, err)
+	assert.True(t, remote.Timestamp().Less(after.Timestamp()))
+
+	// A peer too far ahead doesn't drag the clock along.
+	c.Receive(Increment{ID: "skewed", NodeID: "peer2:8082", Time: fake.Now().Add(time.Hour).UnixNano()})
+	assert.Less(t, c.Now().Wall, fake.Now().Add(time.Second).UnixNano())
+}
+
+func TestCounter_WindowEndsAtHybridLogicalTime(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_000_000_060, 0)) // 40s into a minute bucket
+	c := NewCounterWithConfig("node1:8080", &MockRegistry{}, &MockTransport{}, Config{
+		Window:         WindowConfig{BucketSize: time.Minute, Retention: time.Hour},
+		Clock:          fake,
+		MaxClockOffset: time.Minute,
+	})
+	defer c.Close()
+	// A peer 30s ahead is already in the next bucket.
+	remote := Increment{ID: "remote", NodeID: "peer1:8081", Time: fake.Now().Add(30 * time.Second).UnixNano()}
+	c.Receive(remote)
+	c.ApplyIncrement(remote)
+
+	result, err := c.Window(time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Count)
+	assert.Equal(t, remote.Time, result.AsOf.Wall)
+	assert.Equal(t, int64(1_000_000_020), result.From, "the window ends at the peer's time, not ours")
+}
@@ -0,0 +1,174 @@
+// Package discovery finds seed peers for a node to announce itself to.
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider returns the current set of seed addresses (host:port).
+type Provider interface {
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// Static is a fixed list of seed addresses.
+type Static []string
+
+func (s Static) Peers(ctx context.Context) ([]string, error) {
+	return s, nil
+}
+
+// Resolver is the subset of net.Resolver used for DNS discovery.
+// This allows tests to use a fake resolver.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNS resolves seeds from DNS. With SRV set, Name is queried for SRV records
+// and each target is paired with its advertised port; otherwise Name is
+// resolved to A/AAAA records and paired with Port.
+type DNS struct {
+	Name     string
+	Port     string
+	SRV      bool
+	Resolver Resolver
+}
+
+func (d DNS) Peers(ctx context.Context) ([]string, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	var addrs []string
+	if d.SRV {
+		_, records, err := resolver.LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("SRV lookup of %s failed: %w", d.Name, err)
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+		return addrs, nil
+	}
+
+	hosts, err := resolver.LookupHost(ctx, d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("host lookup of %s failed: %w", d.Name, err)
+	}
+	for _, host := range hosts {
+		addrs = append(addrs, net.JoinHostPort(host, d.Port))
+	}
+	return addrs, nil
+}
+
+// File reads seed addresses from a file, one per line or comma separated.
+// Blank lines and lines starting with # are ignored. The file is only re-read
+// when its modification time or size changes.
+type File struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	peers   []string
+}
+
+func (f *File) Peers(ctx context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat peers file: %w", err)
+	}
+	if f.peers != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.peers, nil
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peers file: %w", err)
+	}
+	peers := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, addr := range strings.Split(line, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				peers = append(peers, addr)
+			}
+		}
+	}
+	f.modTime, f.size, f.peers = info.ModTime(), info.Size(), peers
+	return peers, nil
+}
+
+// Watch polls p every interval and calls update with the seed set whenever it
+// changes, starting with the first successful lookup. It returns when ctx is done.
+func Watch(ctx context.Context, p Provider, interval time.Duration, update func([]string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []string
+	for {
+		peers, err := p.Peers(ctx)
+		if err != nil {
+			log.Printf("Discovery lookup failed: %v", err)
+		} else {
+			peers = normalize(peers)
+			if last == nil || !equal(last, peers) {
+				log.Printf("Discovered seed peers: %v", peers)
+				last = peers
+				update(peers)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// normalize sorts and de-duplicates addresses so lookups can be compared.
+func normalize(addrs []string) []string {
+	out := make([]string, 0, len(addrs))
+	seen := make(map[string]struct{}, len(addrs))
+	for _, a := range addrs {
+		if _, ok := seen[a]; !ok {
+			seen[a] = struct{}{}
+			out = append(out, a)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
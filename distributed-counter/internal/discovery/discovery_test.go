@@ -0,0 +1,119 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolver satisfies the Resolver interface.
+type fakeResolver struct {
+	mu    sync.Mutex
+	hosts []string
+	srv   []*net.SRV
+	err   error
+}
+
+func (f *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hosts, f.err
+}
+
+func (f *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return name, f.srv, f.err
+}
+
+func (f *fakeResolver) setHosts(hosts ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hosts = hosts
+}
+
+func TestDNS_ARecords(t *testing.T) {
+	resolver := &fakeResolver{hosts: []string{"10.0.0.1", "10.0.0.2"}}
+	p := DNS{Name: "counter.internal", Port: "8080", Resolver: resolver}
+
+	peers, err := p.Peers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080"}, peers)
+}
+
+func TestDNS_SRVRecords(t *testing.T) {
+	resolver := &fakeResolver{srv: []*net.SRV{
+		{Target: "node-a.counter.internal.", Port: 8081},
+		{Target: "node-b.counter.internal.", Port: 8082},
+	}}
+	p := DNS{Name: "_counter._tcp.counter.internal", SRV: true, Resolver: resolver}
+
+	peers, err := p.Peers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-a.counter.internal:8081", "node-b.counter.internal:8082"}, peers)
+}
+
+func TestDNS_LookupError(t *testing.T) {
+	p := DNS{Name: "missing", Port: "8080", Resolver: &fakeResolver{err: errors.New("no such host")}}
+	_, err := p.Peers(context.Background())
+	assert.Error(t, err)
+}
+
+func TestFile_ReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	require.NoError(t, os.WriteFile(path, []byte("# seeds\nlocalhost:8081\n\nlocalhost:8082, localhost:8083\n"), 0o644))
+
+	f := &File{Path: path}
+	peers, err := f.Peers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost:8081", "localhost:8082", "localhost:8083"}, peers)
+
+	require.NoError(t, os.WriteFile(path, []byte("localhost:8084\n"), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	peers, err = f.Peers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost:8084"}, peers)
+}
+
+func TestFile_Missing(t *testing.T) {
+	f := &File{Path: filepath.Join(t.TempDir(), "missing")}
+	_, err := f.Peers(context.Background())
+	assert.Error(t, err)
+}
+
+func TestWatch_PushesOnlyChanges(t *testing.T) {
+	resolver := &fakeResolver{hosts: []string{"10.0.0.2", "10.0.0.1"}}
+	p := DNS{Name: "counter.internal", Port: "8080", Resolver: resolver}
+
+	updates := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Watch(ctx, p, 10*time.Millisecond, func(peers []string) { updates <- peers })
+
+	select {
+	case peers := <-updates:
+		assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080"}, peers)
+	case <-time.After(time.Second):
+		t.Fatal("no initial update")
+	}
+
+	// Several unchanged lookups must not produce updates.
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, updates)
+
+	resolver.setHosts("10.0.0.3")
+	select {
+	case peers := <-updates:
+		assert.Equal(t, []string{"10.0.0.3:8080"}, peers)
+	case <-time.After(time.Second):
+		t.Fatal("no update after DNS change")
+	}
+}
@@ -0,0 +1,329 @@
+// Package distinct counts distinct elements, such as the unique users an
+// ad reached, with HyperLogLog sketches replicated across the cluster.
+//
+// An add propagates only the registers it raised, and applying updates
+// takes the per-register maximum, so they can arrive in any order and any
+// number of times. Counters are held by every node, or with sharding by a
+// few owners picked by a consistent-hash ring over the membership.
+package distinct
+
+import (
+	"bytes"
+	"context"
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/hll"
+	"distributed-counter/internal/ring"
+	"distributed-counter/internal/wire"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Members reports the other known nodes.
+type Members interface {
+	GetPeerAddrs() []string
+}
+
+// Transport sends internal messages to peers.
+type Transport interface {
+	Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error
+}
+
+// Propagator queues a message for delivery to peers, retrying failures. It
+// returns workpool.ErrSaturated, queueing nothing, when its queues are full.
+// It is satisfied by counter.Counter, so distinct updates share the bounded
+// propagation pool of increments.
+type Propagator interface {
+	BroadcastTo(peers []string, path string, body interface{}, what string) error
+}
+
+// Config holds the tunables of Counters.
+type Config struct {
+	// Precision is the HyperLogLog precision of every counter, which must
+	// match across the cluster. Zero means hll.DefaultPrecision.
+	Precision uint8
+	// Sharding places each counter on a subset of nodes.
+	Sharding ring.ShardingConfig
+	// ExchangeInterval is how often counters that changed here are sent to
+	// the other nodes holding them. Zero means DefaultExchangeInterval.
+	ExchangeInterval time.Duration
+	// Clock drives rebalancing and exchanges; nil means the wall clock.
+	Clock clock.Clock
+}
+
+// Register is one raised HyperLogLog register.
+type Register struct {
+	Index uint32 `json:"index"`
+	Rank  uint8  `json:"rank"`
+}
+
+// Update carries the registers of a distinct counter that an add raised.
+// Applying it takes the per-register maximum, so updates can be applied in
+// any order and any number of times.
+type Update struct {
+	Name      string     `json:"name"`
+	Precision uint8      `json:"precision"`
+	Registers []Register `json:"registers"`
+}
+
+// MarshalBinary implements the compact encoding used by the wire transport.
+func (u Update) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, len(u.Name)+2+len(u.Registers)*4)
+	b = wire.AppendString(b, u.Name)
+	b = binary.AppendUvarint(b, uint64(u.Precision))
+	b = binary.AppendUvarint(b, uint64(len(u.Registers)))
+	for _, r := range u.Registers {
+		b = binary.AppendUvarint(b, uint64(r.Index))
+		b = binary.AppendUvarint(b, uint64(r.Rank))
+	}
+	return b, nil
+}
+
+func (u *Update) UnmarshalBinary(data []byte) error {
+	r := wire.NewReader(data)
+	u.Name = r.ReadString()
+	u.Precision = uint8(r.ReadUvarint())
+	n := r.ReadUvarint()
+	u.Registers = nil
+	for i := uint64(0); i < n && r.Err() == nil; i++ {
+		index, rank := r.ReadUvarint(), r.ReadUvarint()
+		u.Registers = append(u.Registers, Register{Index: uint32(index), Rank: uint8(rank)})
+	}
+	return r.Err()
+}
+
+// Sketch is the full sketch of a distinct counter, for catch-up, handoffs
+// and snapshots.
+type Sketch struct {
+	Name      string `json:"name"`
+	Registers []byte `json:"registers"`
+}
+
+// Count is the estimated number of distinct elements of a counter.
+type Count struct {
+	Name     string `json:"name"`
+	Estimate uint64 `json:"estimate"`
+	// StdError is the relative standard error of the estimate.
+	StdError  float64 `json:"std_error"`
+	Precision uint8   `json:"precision"`
+}
+
+// Counters holds this node's distinct counters.
+type Counters struct {
+	selfID     string
+	members    Members
+	transport  Transport
+	propagator Propagator
+	cfg        Config
+	clock      clock.Clock
+
+	mu       sync.RWMutex
+	sketches map[string]*hll.Sketch
+	changed  map[string]bool // since the last exchange
+	*ring.Shards
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates the distinct counters of a node, starts exchanging them with
+// the other nodes holding them and, when sharded, rebalancing them as
+// membership changes.
+func New(selfID string, members Members, transport Transport, propagator Propagator, cfg Config) *Counters {
+	if cfg.Precision == 0 {
+		cfg.Precision = hll.DefaultPrecision
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+	if cfg.ExchangeInterval <= 0 {
+		cfg.ExchangeInterval = DefaultExchangeInterval
+	}
+	d := &Counters{
+		selfID:     selfID,
+		members:    members,
+		transport:  transport,
+		propagator: propagator,
+		cfg:        cfg,
+		clock:      cfg.Clock,
+		sketches:   make(map[string]*hll.Sketch),
+		changed:    make(map[string]bool),
+		stop:       make(chan struct{}),
+		Shards:     ring.NewShards(selfID, members, transport, cfg.Sharding),
+	}
+	go d.exchangeLoop()
+	if d.Sharded() {
+		go d.RebalanceLoop(d.clock, d.stop, d.Rebalance)
+	}
+	return d
+}
+
+// Close stops exchanges and rebalancing.
+func (d *Counters) Close() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+// Add adds elements to the named counter and propagates the registers they
+// raised to peers. Elements the sketch already reflects cost nothing to
+// propagate. It returns workpool.ErrSaturated, without adding anything,
+// when the propagation queues are full.
+func (d *Counters) Add(name string, elements []string) (Count, error) {
+	d.mu.RLock()
+	sketch := d.sketches[name]
+	if sketch == nil {
+		sketch = hll.New(d.cfg.Precision)
+	}
+	raised := make(map[uint32]uint8)
+	for _, element := range elements {
+		index, rank := sketch.Position(element)
+		if rank > sketch.Rank(index) && rank > raised[index] {
+			raised[index] = rank
+		}
+	}
+	d.mu.RUnlock()
+
+	update := Update{Name: name, Precision: d.cfg.Precision}
+	for index, rank := range raised {
+		update.Registers = append(update.Registers, Register{Index: index, Rank: rank})
+	}
+	sort.Slice(update.Registers, func(i, j int) bool { return update.Registers[i].Index < update.Registers[j].Index })
+	if len(update.Registers) > 0 {
+		what := fmt.Sprintf("distinct %s (%d registers)", name, len(update.Registers))
+		if err := d.propagator.BroadcastTo(d.peers(name), "/distinct/update", update, what); err != nil {
+			return Count{}, err
+		}
+	}
+	if err := d.Apply(update); err != nil {
+		return Count{}, err
+	}
+	count, _ := d.Get(name)
+	return count, nil
+}
+
+// Apply merges raised registers into a counter, creating it if needed.
+// Updates from a node with another precision are rejected. Updates of
+// counters this node doesn't own are kept until the next rebalance hands
+// them to the owners.
+func (d *Counters) Apply(u Update) error {
+	if u.Precision != d.cfg.Precision {
+		return fmt.Errorf("distinct counter %s has precision %d, this node uses %d", u.Name, u.Precision, d.cfg.Precision)
+	}
+	for _, r := range u.Registers {
+		if r.Index >= 1<<u.Precision {
+			return fmt.Errorf("register %d out of range", r.Index)
+		}
+	}
+	d.mu.Lock()
+	sketch := d.sketchLocked(u.Name)
+	for _, r := range u.Registers {
+		if sketch.Set(r.Index, r.Rank) {
+			d.changed[u.Name] = true
+		}
+	}
+	d.mu.Unlock()
+	d.markStray(u.Name)
+	return nil
+}
+
+// Get returns the estimate of the named counter held here.
+func (d *Counters) Get(name string) (Count, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	sketch, ok := d.sketches[name]
+	if !ok {
+		return Count{}, false
+	}
+	return Count{
+		Name:      name,
+		Estimate:  sketch.Estimate(),
+		StdError:  hll.StdError(sketch.Precision()),
+		Precision: sketch.Precision(),
+	}, true
+}
+
+func (d *Counters) sketchLocked(name string) *hll.Sketch {
+	sketch, ok := d.sketches[name]
+	if !ok {
+		sketch = hll.New(d.cfg.Precision)
+		d.sketches[name] = sketch
+	}
+	return sketch
+}
+
+// Sketches returns every counter held here.
+func (d *Counters) Sketches() []Sketch {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	sketches := make([]Sketch, 0, len(d.sketches))
+	for name, sketch := range d.sketches {
+		sketches = append(sketches, Sketch{Name: name, Registers: sketch.Registers()})
+	}
+	sort.Slice(sketches, func(i, j int) bool { return sketches[i].Name < sketches[j].Name })
+	return sketches
+}
+
+// Merge merges a full sketch into a counter, creating it if needed, like
+// Apply.
+func (d *Counters) Merge(s Sketch) error {
+	other, err := hll.FromRegisters(s.Registers)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	sketch := d.sketchLocked(s.Name)
+	before := sketch.Registers()
+	err = sketch.Merge(other)
+	if err == nil && !bytes.Equal(before, sketch.Registers()) {
+		d.changed[s.Name] = true
+	}
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	d.markStray(s.Name)
+	return nil
+}
+
+// Import merges sketches, such as those of a snapshot, here and sends each
+// to the peers that hold it. Like Add it returns workpool.ErrSaturated when
+// the propagation queues are full; sketches merged before that stay merged,
+// and importing them again is harmless.
+func (d *Counters) Import(sketches []Sketch) error {
+	for _, s := range sketches {
+		if err := d.Merge(s); err != nil {
+			return fmt.Errorf("distinct counter %s: %w", s.Name, err)
+		}
+		if err := d.propagator.BroadcastTo(d.peers(s.Name), "/distinct/handoff", s, "distinct "+s.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CatchUp merges the counters of the first peer that answers, for a node
+// that just joined. Sharded nodes skip it: their peers' next rebalance
+// hands them the counters they now own.
+func (d *Counters) CatchUp(ctx context.Context) error {
+	if d.Sharded() {
+		return nil
+	}
+	var lastErr error
+	for _, addr := range d.members.GetPeerAddrs() {
+		var sketches []Sketch
+		if err := d.transport.Send(ctx, addr, "/distinct/state", nil, &sketches); err != nil {
+			lastErr = err
+			continue
+		}
+		for _, s := range sketches {
+			if err := d.Merge(s); err != nil {
+				log.Printf("Skipping distinct counter %s from %s: %v", s.Name, addr, err)
+			}
+		}
+		log.Printf("Caught up %d distinct counters from %s", len(sketches), addr)
+		return nil
+	}
+	return lastErr
+}
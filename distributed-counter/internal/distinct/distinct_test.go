@@ -0,0 +1,175 @@
+package distinct
+
+import (
+	"context"
+	"distributed-counter/internal/workpool"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type peerList []string
+
+func (p peerList) GetPeerAddrs() []string { return p }
+
+// fakeNetwork records every message by path and peer, answers forwarded
+// requests, and fails sends to the peers in fail. It is both the Transport
+// and, delivering right away, the Propagator of the counters under test.
+type fakeNetwork struct {
+	mu        sync.Mutex
+	sends     map[string][]string // path -> peers
+	bodies    map[string][]interface{}
+	fail      map[string]bool
+	saturated bool
+	// state answers /distinct/state.
+	state []Sketch
+}
+
+func newFakeNetwork() *fakeNetwork {
+	return &fakeNetwork{sends: make(map[string][]string), bodies: make(map[string][]interface{}), fail: make(map[string]bool)}
+}
+
+func (n *fakeNetwork) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sends[path] = append(n.sends[path], addr)
+	n.bodies[path] = append(n.bodies[path], body)
+	if n.fail[addr] {
+		return errors.New("unreachable")
+	}
+	switch r := reply.(type) {
+	case *Count:
+		*r = Count{Name: body.(AddRequest).Name, Estimate: 7}
+	case *ReadReply:
+		*r = ReadReply{Count: Count{Name: body.(ReadRequest).Name, Estimate: 7}, Found: true}
+	case *[]Sketch:
+		*r = n.state
+	}
+	return nil
+}
+
+func (n *fakeNetwork) BroadcastTo(peers []string, path string, body interface{}, what string) error {
+	n.mu.Lock()
+	saturated := n.saturated
+	n.mu.Unlock()
+	if saturated {
+		return workpool.ErrSaturated
+	}
+	for _, addr := range peers {
+		n.Send(context.Background(), addr, path, body, nil)
+	}
+	return nil
+}
+
+// sent returns the peers that got sends to path, sorted, and forgets them.
+func (n *fakeNetwork) sent(path string) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	peers := n.sends[path]
+	delete(n.sends, path)
+	sort.Strings(peers)
+	return peers
+}
+
+func newCounters(t *testing.T, cfg Config, peers ...string) (*Counters, *fakeNetwork) {
+	net := newFakeNetwork()
+	d := New("node1:8080", peerList(peers), net, net, cfg)
+	t.Cleanup(d.Close)
+	return d, net
+}
+
+func elements(from, to int) []string {
+	var ids []string
+	for i := from; i < to; i++ {
+		ids = append(ids, fmt.Sprintf("user-%d", i))
+	}
+	return ids
+}
+
+func TestCounters_AddPropagatesRaisedRegisters(t *testing.T) {
+	d, net := newCounters(t, Config{}, "node2:8080")
+
+	count, err := d.Add("campaign:1", elements(0, 1000))
+	require.NoError(t, err)
+	assert.InDelta(t, 1000, float64(count.Estimate), 30)
+	assert.Equal(t, uint8(14), count.Precision)
+
+	// Elements the sketch already reflects aren't sent again.
+	_, err = d.Add("campaign:1", elements(0, 1000))
+	require.NoError(t, err)
+	require.Equal(t, []string{"node2:8080"}, net.sent("/distinct/update"))
+
+	// A peer applying the update, in whatever order or repetition, ends up
+	// with the same sketch.
+	peer, _ := newCounters(t, Config{})
+	update := net.bodies["/distinct/update"][0].(Update)
+	require.NoError(t, peer.Apply(update))
+	require.NoError(t, peer.Apply(update))
+	got, ok := peer.Get("campaign:1")
+	require.True(t, ok)
+	assert.Equal(t, count.Estimate, got.Estimate)
+}
+
+func TestCounters_AddWhenSaturated(t *testing.T) {
+	d, net := newCounters(t, Config{}, "node2:8080")
+	net.saturated = true
+	_, err := d.Add("campaign:1", elements(0, 10))
+	assert.ErrorIs(t, err, workpool.ErrSaturated)
+	_, ok := d.Get("campaign:1")
+	assert.False(t, ok, "nothing is added")
+}
+
+func TestCounters_ApplyRejectsOtherPrecisions(t *testing.T) {
+	d, _ := newCounters(t, Config{Precision: 10})
+	assert.Error(t, d.Apply(Update{Name: "a", Precision: 14}))
+	assert.Error(t, d.Apply(Update{Name: "a", Precision: 10, Registers: []Register{{Index: 1 << 10, Rank: 1}}}))
+	_, ok := d.Get("a")
+	assert.False(t, ok)
+}
+
+func TestCounters_CatchUpMergesSketches(t *testing.T) {
+	source, _ := newCounters(t, Config{})
+	_, err := source.Add("campaign:1", elements(0, 500))
+	require.NoError(t, err)
+	want, _ := source.Get("campaign:1")
+
+	joiner, net := newCounters(t, Config{}, "node1:8080")
+	net.state = source.Sketches()
+	require.NoError(t, joiner.CatchUp(context.Background()))
+	got, ok := joiner.Get("campaign:1")
+	require.True(t, ok)
+	assert.Equal(t, want, got)
+
+	net.fail["node1:8080"] = true
+	assert.Error(t, joiner.CatchUp(context.Background()))
+}
+
+func TestCounters_ImportSendsSketchesToPeers(t *testing.T) {
+	source, _ := newCounters(t, Config{})
+	_, err := source.Add("campaign:1", elements(0, 500))
+	require.NoError(t, err)
+
+	d, net := newCounters(t, Config{}, "node2:8080", "node3:8080")
+	require.NoError(t, d.Import(source.Sketches()))
+	assert.Equal(t, []string{"node2:8080", "node3:8080"}, net.sent("/distinct/handoff"))
+	want, _ := source.Get("campaign:1")
+	got, _ := d.Get("campaign:1")
+	assert.Equal(t, want, got)
+
+	assert.Error(t, d.Import([]Sketch{{Name: "campaign:2", Registers: []byte{1}}}))
+}
+
+func TestUpdate_BinaryRoundTrip(t *testing.T) {
+	update := Update{Name: "campaign:1", Precision: 14, Registers: []Register{{Index: 3, Rank: 2}, {Index: 16383, Rank: 51}}}
+	data, err := update.MarshalBinary()
+	require.NoError(t, err)
+	var decoded Update
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, update, decoded)
+	assert.Error(t, decoded.UnmarshalBinary(data[:len(data)-1]))
+}
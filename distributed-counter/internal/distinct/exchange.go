@@ -0,0 +1,74 @@
+package distinct
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// DefaultExchangeInterval is how often changed counters are sent to the
+// other nodes holding them by default.
+const DefaultExchangeInterval = 30 * time.Second
+
+// exchangeLoop periodically sends changed counters to their other holders.
+func (d *Counters) exchangeLoop() {
+	ticker := d.clock.NewTicker(d.cfg.ExchangeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C():
+		}
+		d.Exchange(context.Background())
+	}
+}
+
+// Exchange sends every counter held here that changed since the last
+// exchange, by an add or by merging what a peer sent, to the other nodes
+// holding it. It repairs updates whose propagation gave up: a replica that
+// missed one changed less than its peers, which send it their sketches.
+// Counters this node doesn't own are left to rebalancing, and those that
+// fail to reach a holder are sent again next time.
+func (d *Counters) Exchange(ctx context.Context) {
+	d.mu.Lock()
+	changed := d.changed
+	d.changed = make(map[string]bool)
+	d.mu.Unlock()
+
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sent := 0
+	for _, name := range names {
+		s, ok := d.sketch(name)
+		if !ok || !d.Owns(name) {
+			continue
+		}
+		peers := d.peers(name)
+		if !d.handoff(ctx, peers, s) {
+			d.mu.Lock()
+			d.changed[name] = true
+			d.mu.Unlock()
+			continue
+		}
+		sent += len(peers)
+	}
+	if sent > 0 {
+		log.Printf("Exchanged %d distinct sketches with their other holders", sent)
+	}
+}
+
+// sketch returns the named counter held here.
+func (d *Counters) sketch(name string) (Sketch, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	sketch, ok := d.sketches[name]
+	if !ok {
+		return Sketch{}, false
+	}
+	return Sketch{Name: name, Registers: sketch.Registers()}, true
+}
@@ -0,0 +1,52 @@
+package distinct
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounters_ExchangeSendsChangedCounters(t *testing.T) {
+	d, net := newCounters(t, Config{}, "node2:8080", "node3:8080")
+	_, err := d.Add("campaign:1", elements(0, 100))
+	require.NoError(t, err)
+	net.sent("/distinct/update")
+
+	net.fail["node3:8080"] = true
+	d.Exchange(context.Background())
+	assert.Equal(t, []string{"node2:8080", "node3:8080"}, net.sent("/distinct/handoff"))
+
+	net.fail["node3:8080"] = false
+	d.Exchange(context.Background())
+	assert.Equal(t, []string{"node2:8080", "node3:8080"}, net.sent("/distinct/handoff"), "failed exchanges are retried")
+	d.Exchange(context.Background())
+	assert.Empty(t, net.sent("/distinct/handoff"), "nothing changed")
+
+	// A sketch a peer sends back changes nothing, so it isn't echoed.
+	s, _ := d.sketch("campaign:1")
+	require.NoError(t, d.Merge(s))
+	d.Exchange(context.Background())
+	assert.Empty(t, net.sent("/distinct/handoff"))
+
+	// One holding registers this node missed is passed on.
+	peer, _ := newCounters(t, Config{})
+	_, err = peer.Add("campaign:1", elements(100, 200))
+	require.NoError(t, err)
+	s, _ = peer.sketch("campaign:1")
+	require.NoError(t, d.Merge(s))
+	d.Exchange(context.Background())
+	assert.Equal(t, []string{"node2:8080", "node3:8080"}, net.sent("/distinct/handoff"))
+}
+
+func TestCounters_ExchangeSendsOwnedCountersToOtherOwners(t *testing.T) {
+	d, net, _ := newSharded(t, "peer1:8081", "peer2:8082", "peer3:8083")
+	owned, stray := name(t, d, true), name(t, d, false)
+	for _, name := range []string{owned, stray} {
+		require.NoError(t, d.Apply(Update{Name: name, Precision: d.cfg.Precision, Registers: []Register{{Index: 1, Rank: 3}}}))
+	}
+
+	d.Exchange(context.Background())
+	assert.Equal(t, sorted(d.peers(owned)), net.sent("/distinct/handoff"), "strays are left to rebalancing")
+}
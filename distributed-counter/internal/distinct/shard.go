@@ -0,0 +1,145 @@
+package distinct
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrOwnersUnreachable is returned when a request for a counter this node
+// doesn't hold could not be forwarded to any of its owners.
+var ErrOwnersUnreachable = errors.New("no owner of the counter is reachable")
+
+// AddRequest asks an owner to add elements to a counter.
+type AddRequest struct {
+	Name     string   `json:"name"`
+	Elements []string `json:"elements"`
+}
+
+// ReadRequest asks an owner for the estimate of a counter.
+type ReadRequest struct {
+	Name string `json:"name"`
+}
+
+// ReadReply answers a ReadRequest.
+type ReadReply struct {
+	Count Count `json:"count"`
+	Found bool  `json:"found"`
+}
+
+// peers returns the peers that updates of the named counter go to: its
+// other owners, or every peer when unsharded.
+func (d *Counters) peers(name string) []string {
+	if !d.Sharded() {
+		return d.members.GetPeerAddrs()
+	}
+	return d.OtherOwners(name)
+}
+
+// RouteAdd is Add for a counter that may live elsewhere: a node that
+// doesn't own it forwards the elements to its owners.
+func (d *Counters) RouteAdd(ctx context.Context, name string, elements []string) (Count, error) {
+	if d.Owns(name) {
+		return d.Add(name, elements)
+	}
+	var count Count
+	err := d.forward(ctx, d.Owners(name), "/distinct/add", AddRequest{Name: name, Elements: elements}, &count)
+	return count, err
+}
+
+// RouteGet is Get for a counter that may live elsewhere: a node that
+// doesn't own it asks its owners.
+func (d *Counters) RouteGet(ctx context.Context, name string) (Count, bool, error) {
+	if d.Owns(name) {
+		count, ok := d.Get(name)
+		return count, ok, nil
+	}
+	var reply ReadReply
+	err := d.forward(ctx, d.Owners(name), "/distinct/read", ReadRequest{Name: name}, &reply)
+	return reply.Count, reply.Found, err
+}
+
+// forward sends a request to each owner in turn until one answers.
+func (d *Counters) forward(ctx context.Context, owners []string, path string, body, reply interface{}) error {
+	var errs []error
+	for _, addr := range owners {
+		err := d.transport.Send(ctx, addr, path, body, reply)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+	}
+	return fmt.Errorf("%w: %w", ErrOwnersUnreachable, errors.Join(errs...))
+}
+
+// Rebalance runs when membership changed since the last one, counters this
+// node doesn't own arrived, or the last one left work undone. It sends each
+// counter held here to the owners it gained, and a counter this node no
+// longer owns to all of its owners, dropping it once they all have it.
+// Counters that fail are sent to all of their owners next time. Sketches
+// merge idempotently, so owners that already hold a counter lose nothing by
+// getting it again.
+func (d *Counters) Rebalance(ctx context.Context) {
+	rb, ok := d.StartRebalance()
+	if !ok {
+		return
+	}
+	failed := make(map[string]bool)
+	handed, dropped := 0, 0
+	for _, s := range d.Sketches() {
+		owned := rb.Owns(s.Name)
+		gained := rb.Targets(s.Name, !owned)
+		if !d.handoff(ctx, gained, s) {
+			failed[s.Name] = true
+			continue
+		}
+		handed += len(gained)
+		if !owned {
+			if d.drop(s) {
+				dropped++
+			} else {
+				failed[s.Name] = true
+			}
+		}
+	}
+	rb.Finish(failed)
+	if handed > 0 || dropped > 0 {
+		log.Printf("Rebalanced distinct counters over %d nodes: sent %d sketches, dropped %d", len(rb.Current.Members()), handed, dropped)
+	}
+}
+
+// handoff sends a sketch to every given owner, reporting whether all of
+// them got it.
+func (d *Counters) handoff(ctx context.Context, owners []string, s Sketch) bool {
+	ok := true
+	for _, addr := range owners {
+		if err := d.SendHandoff(ctx, addr, "/distinct/handoff", s); err != nil {
+			log.Printf("Failed to hand distinct counter %s to %s: %v", s.Name, addr, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// drop removes a counter handed off as s, unless it changed since, in which
+// case the next rebalance hands it off again.
+func (d *Counters) drop(s Sketch) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sketch, ok := d.sketches[s.Name]
+	if ok && !bytes.Equal(sketch.Registers(), s.Registers) {
+		return false
+	}
+	delete(d.sketches, s.Name)
+	return true
+}
+
+// markStray schedules the named counter, just updated here, for the next
+// rebalance if it belongs elsewhere.
+func (d *Counters) markStray(name string) {
+	if !d.Owns(name) {
+		d.Retry(name)
+	}
+}
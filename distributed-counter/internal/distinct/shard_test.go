@@ -0,0 +1,175 @@
+package distinct
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/ring"
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSharded returns counters with two replicas each. Rebalancing only
+// runs when called.
+func newSharded(t *testing.T, peers ...string) (*Counters, *fakeNetwork, *membership) {
+	members := &membership{peers: peers}
+	net := newFakeNetwork()
+	d := New("node1:8080", members, net, net, Config{
+		Sharding: ring.ShardingConfig{Replicas: 2},
+		Clock:    clock.NewFake(time.Unix(1_700_000_000, 0)),
+	})
+	t.Cleanup(d.Close)
+	return d, net, members
+}
+
+// membership is a peer list that tests change.
+type membership struct {
+	peers []string
+}
+
+func (m *membership) GetPeerAddrs() []string { return m.peers }
+
+// name returns a counter name this node owns, or doesn't.
+func name(t *testing.T, d *Counters, owned bool) string {
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("campaign:%d", i)
+		if d.Owns(name) == owned {
+			return name
+		}
+	}
+	t.Fatal("no such name")
+	return ""
+}
+
+func TestCounters_Owners(t *testing.T) {
+	d, _ := newCounters(t, Config{}, "peer1:8081")
+	assert.Nil(t, d.Owners("campaign:1"), "unsharded")
+	assert.True(t, d.Owns("campaign:1"))
+
+	sd, _, _ := newSharded(t, "peer1:8081", "peer2:8082", "peer3:8083")
+	owners := sd.Owners("campaign:1")
+	assert.Len(t, owners, 2)
+	assert.Equal(t, owners, sd.Owners("campaign:1"))
+}
+
+func TestCounters_AddUpdatesOtherOwnersOnly(t *testing.T) {
+	d, net, _ := newSharded(t, "peer1:8081", "peer2:8082", "peer3:8083")
+	name := name(t, d, true)
+	_, err := d.RouteAdd(context.Background(), name, []string{"u1"})
+	require.NoError(t, err)
+
+	var others []string
+	for _, addr := range d.Owners(name) {
+		if addr != "node1:8080" {
+			others = append(others, addr)
+		}
+	}
+	assert.Equal(t, others, net.sent("/distinct/update"))
+}
+
+func TestCounters_RouteForwardsToOwners(t *testing.T) {
+	d, net, _ := newSharded(t, "peer1:8081", "peer2:8082", "peer3:8083")
+	name := name(t, d, false)
+	owners := d.Owners(name)
+	net.fail[owners[0]] = true
+
+	count, err := d.RouteAdd(context.Background(), name, []string{"u1"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), count.Estimate)
+	assert.Equal(t, []string{owners[0], owners[1]}, net.sends["/distinct/add"], "falls back to the next owner")
+	_, ok := d.Get(name)
+	assert.False(t, ok, "nothing is kept here")
+
+	count, ok, err = d.RouteGet(context.Background(), name)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(7), count.Estimate)
+
+	net.fail[owners[1]] = true
+	_, _, err = d.RouteGet(context.Background(), name)
+	assert.ErrorIs(t, err, ErrOwnersUnreachable)
+}
+
+func TestCounters_RebalanceHandsOffOnMembershipChange(t *testing.T) {
+	d, net, members := newSharded(t, "peer1:8081")
+	for i := 0; i < 20; i++ {
+		require.NoError(t, d.Apply(Update{Name: fmt.Sprintf("campaign:%d", i), Precision: d.cfg.Precision, Registers: []Register{{Index: 1, Rank: 3}}}))
+	}
+	d.Rebalance(context.Background())
+	assert.Len(t, net.sent("/distinct/handoff"), 20, "the first rebalance syncs the co-owner")
+	d.Rebalance(context.Background())
+	assert.Empty(t, net.sent("/distinct/handoff"), "nothing changed")
+
+	members.peers = []string{"peer1:8081", "peer2:8082", "peer3:8083"}
+	net.fail["peer3:8083"] = true
+	d.Rebalance(context.Background())
+	// peer1 owned every counter before, so it only gets the ones this node
+	// gives up, which go to every owner before being dropped.
+	given := 0
+	for i := 0; i < 20; i++ {
+		if name := fmt.Sprintf("campaign:%d", i); !d.Owns(name) && slices.Contains(d.Owners(name), "peer1:8081") {
+			given++
+		}
+	}
+	sent := net.sent("/distinct/handoff")
+	assert.NotEmpty(t, sent)
+	assert.Equal(t, given, countOf(sent, "peer1:8081"))
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("campaign:%d", i)
+		_, held := d.Get(name)
+		owners := d.Owners(name)
+		if d.Owns(name) || slices.Contains(owners, "peer3:8083") {
+			assert.True(t, held, "%s is kept until every owner has it", name)
+		} else {
+			assert.False(t, held, "%s was handed off", name)
+		}
+	}
+
+	net.fail["peer3:8083"] = false
+	d.Rebalance(context.Background())
+	assert.NotEmpty(t, net.sent("/distinct/handoff"), "failed handoffs are retried")
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("campaign:%d", i)
+		_, held := d.Get(name)
+		assert.Equal(t, d.Owns(name), held, name)
+	}
+}
+
+func TestCounters_MergeOfStrayCounterTriggersRebalance(t *testing.T) {
+	d, net, _ := newSharded(t, "peer1:8081", "peer2:8082", "peer3:8083")
+	d.Rebalance(context.Background())
+	name := name(t, d, false)
+	require.NoError(t, d.Merge(Sketch{Name: name, Registers: make([]byte, 1<<d.cfg.Precision)}))
+
+	d.Rebalance(context.Background())
+	assert.Equal(t, sorted(d.Owners(name)), net.sent("/distinct/handoff"))
+	_, held := d.Get(name)
+	assert.False(t, held)
+}
+
+func TestCounters_CatchUpSkippedWhenSharded(t *testing.T) {
+	d, net, _ := newSharded(t, "peer1:8081")
+	require.NoError(t, d.CatchUp(context.Background()))
+	assert.Empty(t, net.sent("/distinct/state"), "owners hand counters over when membership changes")
+}
+
+func sorted(s []string) []string {
+	s = append([]string(nil), s...)
+	sort.Strings(s)
+	return s
+}
+
+func countOf(s []string, v string) int {
+	n := 0
+	for _, x := range s {
+		if x == v {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,544 @@
+// Package escrow implements bounded counters that never exceed their cap,
+// such as ad campaign budgets.
+//
+// The unspent budget of each counter is split into escrow shares held by the
+// nodes. A node spends only from its own share, without asking anyone, and
+// when its share runs short it asks peers to transfer part of theirs. A
+// donor deducts a transfer from its share before replying, so units are in
+// at most one share at a time and the sum of spends can never exceed the
+// cap. Units in flight when a transfer reply or a node is lost are lost with
+// it: a counter may end below its cap, never above it.
+//
+// A counter is minted only after the node joined the cluster and every
+// node it has known, the seeds and every peer since it started, confirmed
+// it doesn't know it. Creating a name twice, on two nodes or after a
+// restart, finds the first definition instead of minting a second budget,
+// and the two sides of a partition, which have each other's address
+// still, can't both mint one.
+//
+// Spends are keyed increments of the counter package. When those are
+// sharded, only the owners of a counter's key see every spend, and other
+// nodes ask them for the cluster-wide usage.
+package escrow
+
+import (
+	"context"
+	"distributed-counter/internal/counter"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUnknown is returned for counters neither this node nor its peers know.
+	ErrUnknown = errors.New("unknown bounded counter")
+	// ErrCapReached is returned when the cluster doesn't have the budget left
+	// for an increment.
+	ErrCapReached = errors.New("bounded counter cap reached")
+	// ErrExists is returned when creating a counter that exists with another cap.
+	ErrExists = errors.New("bounded counter exists with a different cap")
+	// ErrUnconfirmed is returned when a counter can't be created because the
+	// node hasn't joined the cluster yet, or a known node couldn't be asked
+	// whether it exists, or is creating it too.
+	ErrUnconfirmed = errors.New("could not confirm that no peer has the bounded counter")
+	// ErrOwnersUnreachable is returned when the usage of a counter whose
+	// spends are held elsewhere could not be read from any of its owners.
+	ErrOwnersUnreachable = errors.New("no owner of the bounded counter is reachable")
+)
+
+// keyPrefix namespaces bounded counters among keyed increments.
+const keyPrefix = "bounded:"
+
+// DefaultTransferTimeout bounds each share transfer request to a peer.
+const DefaultTransferTimeout = 2 * time.Second
+
+// Config holds the tunables of a Manager.
+type Config struct {
+	// TransferTimeout bounds each request to a peer. Zero means
+	// DefaultTransferTimeout.
+	TransferTimeout time.Duration
+}
+
+// Recorder records spends; it is satisfied by counter.Counter.
+type Recorder interface {
+	Submit(inc counter.Increment) (counter.Increment, bool, error)
+	// Owners returns the nodes that get every spend of a key, or nil when
+	// every node does.
+	Owners(key string) []string
+}
+
+// Members reports the other nodes: the current members to ask for shares,
+// and every node known since startup to confirm a new counter with.
+type Members interface {
+	GetPeerAddrs() []string
+	KnownAddrs() []string
+	// Announced reports whether the node joined the cluster.
+	Announced() bool
+}
+
+// Transport sends internal messages to peers.
+type Transport interface {
+	Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error
+}
+
+// Definition identifies a bounded counter and its cap.
+type Definition struct {
+	Name string `json:"name"`
+	Cap  int64  `json:"cap"`
+	// Origin is the node the counter was created on, which started out
+	// holding the whole cap.
+	Origin string `json:"origin"`
+}
+
+// TransferRequest asks a peer for up to Want units of a counter's budget.
+type TransferRequest struct {
+	Name string `json:"name"`
+	Want int64  `json:"want"`
+	From string `json:"from"`
+}
+
+// Transfer answers a TransferRequest. Known is false if the peer has never
+// heard of the counter; otherwise Granted units moved to the requester.
+// Pending is set while the peer is creating the counter itself.
+type Transfer struct {
+	Known      bool `json:"known"`
+	Pending    bool `json:"pending,omitempty"`
+	Definition `json:"definition"`
+	Granted    int64 `json:"granted"`
+}
+
+// UsageRequest asks an owner of a counter for its cluster-wide usage.
+type UsageRequest struct {
+	Name string `json:"name"`
+}
+
+// Usage answers a UsageRequest.
+type Usage struct {
+	Used int64 `json:"used"`
+}
+
+// Deposit hands a leaving node's share to a peer.
+type Deposit struct {
+	Definition `json:"definition"`
+	Amount     int64 `json:"amount"`
+}
+
+// Status describes a bounded counter as seen by this node.
+type Status struct {
+	Name string `json:"name"`
+	Cap  int64  `json:"cap"`
+	// Used is the cluster-wide spend seen by this node, which lags behind
+	// spends on other nodes by their propagation delay. With sharded spends
+	// Get and List count only what reached this node; RouteGet and RouteList
+	// ask the owners.
+	Used      int64 `json:"used"`
+	Remaining int64 `json:"remaining"`
+	// Share is the unspent budget held by this node.
+	Share int64 `json:"share"`
+}
+
+type account struct {
+	def   Definition
+	share int64
+	// pending is set while this node checks with its peers before minting.
+	pending bool
+}
+
+// Manager holds this node's escrow shares.
+type Manager struct {
+	selfID    string
+	recorder  Recorder
+	members   Members
+	transport Transport
+	cfg       Config
+
+	mu       sync.Mutex
+	accounts map[string]*account
+	used     map[string]int64
+}
+
+// New creates a manager. Register its Observe method with the counter's
+// OnApply so that spends on every node are reported in Status.
+func New(selfID string, recorder Recorder, members Members, transport Transport, cfg Config) *Manager {
+	if cfg.TransferTimeout <= 0 {
+		cfg.TransferTimeout = DefaultTransferTimeout
+	}
+	return &Manager{
+		selfID:    selfID,
+		recorder:  recorder,
+		members:   members,
+		transport: transport,
+		cfg:       cfg,
+		accounts:  make(map[string]*account),
+		used:      make(map[string]int64),
+	}
+}
+
+// Create defines a counter with the given cap and announces it to peers.
+// It first asks every known node whether it knows the counter: if one
+// does, its definition is adopted and nothing is minted. Otherwise this
+// node mints the part of the cap not already spent and holds it as its
+// share. Creating an existing counter with the same cap changes nothing.
+// ErrUnconfirmed is returned, and nothing minted, if the node hasn't
+// joined yet, or a known node can't be asked or is creating the same
+// counter at the same time.
+func (m *Manager) Create(ctx context.Context, name string, capacity int64) (Status, error) {
+	if name == "" || capacity <= 0 {
+		return Status{}, errors.New("a bounded counter needs a name and a positive cap")
+	}
+	def := Definition{Name: name, Cap: capacity, Origin: m.selfID}
+	m.mu.Lock()
+	if a, ok := m.accounts[name]; ok {
+		m.mu.Unlock()
+		return m.existing(a, capacity)
+	}
+	a := &account{def: def, pending: true}
+	m.accounts[name] = a
+	m.mu.Unlock()
+
+	known, err := m.lookup(ctx, name)
+
+	m.mu.Lock()
+	if !a.pending {
+		// A peer's announcement arrived meanwhile.
+		m.mu.Unlock()
+		return m.existing(a, capacity)
+	}
+	switch {
+	case err != nil:
+		delete(m.accounts, name)
+		m.mu.Unlock()
+		return Status{}, err
+	case known != nil:
+		a.def, a.pending = *known, false
+		m.mu.Unlock()
+		return m.existing(a, capacity)
+	}
+	a.pending = false
+	a.share = max(capacity-m.used[name], 0)
+	status := m.statusLocked(a)
+	m.mu.Unlock()
+
+	log.Printf("Created bounded counter %s with cap %d, minted %d", name, capacity, status.Share)
+	for _, addr := range m.members.GetPeerAddrs() {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), m.cfg.TransferTimeout)
+			defer cancel()
+			if err := m.transport.Send(ctx, addr, "/escrow/define", def, nil); err != nil {
+				// The peer learns the definition with its first transfer request.
+				log.Printf("Failed to announce bounded counter %s to %s: %v", name, addr, err)
+			}
+		}()
+	}
+	return status, nil
+}
+
+// existing answers a Create of a counter this node already has.
+func (m *Manager) existing(a *account, capacity int64) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a.pending {
+		return Status{}, ErrUnconfirmed
+	}
+	status := m.statusLocked(a)
+	if a.def.Cap != capacity {
+		return status, ErrExists
+	}
+	return status, nil
+}
+
+// lookup asks every known node for the definition of a counter, with an
+// empty transfer request. It returns the definition if one has it, and
+// ErrUnconfirmed if the node hasn't joined yet, or a known node can't
+// answer or is creating the counter too. Asking only the current members
+// isn't enough: a node that hasn't joined yet knows none, and the sides of
+// a partition drop each other once their heartbeats expire.
+func (m *Manager) lookup(ctx context.Context, name string) (*Definition, error) {
+	if !m.members.Announced() {
+		return nil, fmt.Errorf("%w: this node hasn't joined the cluster yet", ErrUnconfirmed)
+	}
+	for _, addr := range m.members.KnownAddrs() {
+		sendCtx, cancel := context.WithTimeout(ctx, m.cfg.TransferTimeout)
+		var reply Transfer
+		err := m.transport.Send(sendCtx, addr, "/escrow/transfer", TransferRequest{Name: name, From: m.selfID}, &reply)
+		cancel()
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("%w: %s: %v", ErrUnconfirmed, addr, err)
+		case reply.Pending:
+			return nil, fmt.Errorf("%w: %s is creating it too", ErrUnconfirmed, addr)
+		case reply.Known:
+			return &reply.Definition, nil
+		}
+	}
+	return nil, nil
+}
+
+// Get returns the status of a counter known to this node.
+func (m *Manager) Get(name string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.accounts[name]
+	if !ok || a.pending {
+		return Status{}, ErrUnknown
+	}
+	return m.statusLocked(a), nil
+}
+
+// List returns the status of every counter known to this node.
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]Status, 0, len(m.accounts))
+	for _, a := range m.accounts {
+		if !a.pending {
+			statuses = append(statuses, m.statusLocked(a))
+		}
+	}
+	return statuses
+}
+
+// RouteGet is Get with the usage read from the owners of the counter's
+// spends when they are held elsewhere.
+func (m *Manager) RouteGet(ctx context.Context, name string) (Status, error) {
+	status, err := m.Get(name)
+	if err != nil {
+		return Status{}, err
+	}
+	return m.route(ctx, status)
+}
+
+// RouteList is List with RouteGet's usage.
+func (m *Manager) RouteList(ctx context.Context) ([]Status, error) {
+	statuses := m.List()
+	for i, status := range statuses {
+		var err error
+		if statuses[i], err = m.route(ctx, status); err != nil {
+			return nil, err
+		}
+	}
+	return statuses, nil
+}
+
+// route fills in the usage of a counter this node doesn't own, asking its
+// owners in turn until one answers.
+func (m *Manager) route(ctx context.Context, status Status) (Status, error) {
+	owners := m.recorder.Owners(keyPrefix + status.Name)
+	if owners == nil || slices.Contains(owners, m.selfID) {
+		return status, nil
+	}
+	var errs []error
+	for _, addr := range owners {
+		sendCtx, cancel := context.WithTimeout(ctx, m.cfg.TransferTimeout)
+		var usage Usage
+		err := m.transport.Send(sendCtx, addr, "/escrow/usage", UsageRequest{Name: status.Name}, &usage)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		// Spends made here may not have reached the owner yet.
+		status.Used = max(status.Used, usage.Used)
+		status.Remaining = max(status.Cap-status.Used, 0)
+		return status, nil
+	}
+	return Status{}, fmt.Errorf("%w: %w", ErrOwnersUnreachable, errors.Join(errs...))
+}
+
+// HandleUsage reports the spends of a counter applied here.
+func (m *Manager) HandleUsage(req UsageRequest) Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Usage{Used: m.used[req.Name]}
+}
+
+// Increment spends n units of the counter's budget. If this node's share is
+// short, it asks peers one at a time for the difference; if the peers
+// together can't make it up either, ErrCapReached is returned and nothing
+// is spent.
+func (m *Manager) Increment(ctx context.Context, name string, n int64) (Status, error) {
+	if n <= 0 {
+		return Status{}, errors.New("n must be positive")
+	}
+	if status, ok, err := m.spend(name, n); ok || err != nil {
+		return status, err
+	}
+	for _, addr := range m.members.GetPeerAddrs() {
+		m.requestTransfer(ctx, addr, name, n)
+		if status, ok, err := m.spend(name, n); ok || err != nil {
+			return status, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.accounts[name]
+	if !ok || a.pending {
+		return Status{}, ErrUnknown
+	}
+	return m.statusLocked(a), ErrCapReached
+}
+
+// spend takes n from the local share if it holds that much, and records it.
+func (m *Manager) spend(name string, n int64) (Status, bool, error) {
+	m.mu.Lock()
+	a, ok := m.accounts[name]
+	if !ok || a.share < n {
+		m.mu.Unlock()
+		return Status{}, false, nil
+	}
+	a.share -= n
+	m.mu.Unlock()
+
+	_, _, err := m.recorder.Submit(counter.Increment{ID: uuid.NewString(), Key: keyPrefix + name, Delta: n})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		a.share += n
+		return Status{}, false, err
+	}
+	return m.statusLocked(a), true, nil
+}
+
+// requestTransfer asks a peer for want units and adds what it grants to the
+// local share, learning the counter's definition on the way if needed.
+func (m *Manager) requestTransfer(ctx context.Context, addr, name string, want int64) {
+	m.mu.Lock()
+	if a, ok := m.accounts[name]; ok {
+		want -= a.share
+	}
+	m.mu.Unlock()
+	if want <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.TransferTimeout)
+	defer cancel()
+	var reply Transfer
+	req := TransferRequest{Name: name, Want: want, From: m.selfID}
+	if err := m.transport.Send(ctx, addr, "/escrow/transfer", req, &reply); err != nil {
+		// Whatever the peer granted, if anything, is lost.
+		log.Printf("Failed to request a share of %s from %s: %v", name, addr, err)
+		return
+	}
+	if !reply.Known || reply.Pending {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := m.accountLocked(reply.Definition)
+	a.share += reply.Granted
+	if reply.Granted > 0 {
+		log.Printf("Received %d of %s from %s, share now %d", reply.Granted, name, addr, a.share)
+	}
+}
+
+// HandleDefine learns a counter announced by its origin.
+func (m *Manager) HandleDefine(def Definition) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accountLocked(def)
+}
+
+// HandleTransfer gives up to req.Want units of this node's share to a peer.
+// The units leave the share before the reply is sent.
+func (m *Manager) HandleTransfer(req TransferRequest) Transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.accounts[req.Name]
+	if !ok {
+		return Transfer{}
+	}
+	if a.pending {
+		return Transfer{Known: true, Pending: true, Definition: a.def}
+	}
+	granted := min(max(req.Want, 0), a.share)
+	a.share -= granted
+	return Transfer{Known: true, Definition: a.def, Granted: granted}
+}
+
+// HandleDeposit adds a leaving peer's share to this node's.
+func (m *Manager) HandleDeposit(dep Deposit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := m.accountLocked(dep.Definition)
+	a.share += max(dep.Amount, 0)
+	log.Printf("Received %d of %s from a leaving peer, share now %d", dep.Amount, dep.Name, a.share)
+}
+
+// Handoff deposits this node's shares with a peer so the budget outlives
+// the node. Call it once spending here has stopped, during shutdown. A share
+// whose deposit fails is given up rather than retried elsewhere, since the
+// peer may have received it.
+func (m *Manager) Handoff(ctx context.Context) {
+	m.mu.Lock()
+	var deposits []Deposit
+	for _, a := range m.accounts {
+		if a.share > 0 {
+			deposits = append(deposits, Deposit{Definition: a.def, Amount: a.share})
+			a.share = 0
+		}
+	}
+	m.mu.Unlock()
+
+	peers := m.members.GetPeerAddrs()
+	for i, dep := range deposits {
+		if len(peers) == 0 {
+			log.Printf("No peer to hand %d of %s to, giving it up", dep.Amount, dep.Name)
+			continue
+		}
+		// Spread the shares over the peers.
+		addr := peers[i%len(peers)]
+		sendCtx, cancel := context.WithTimeout(ctx, m.cfg.TransferTimeout)
+		err := m.transport.Send(sendCtx, addr, "/escrow/deposit", dep, nil)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to hand %d of %s to %s, giving it up: %v", dep.Amount, dep.Name, addr, err)
+			continue
+		}
+		log.Printf("Handed %d of %s to %s", dep.Amount, dep.Name, addr)
+	}
+}
+
+// Observe counts an applied spend towards its counter's cluster-wide usage.
+func (m *Manager) Observe(inc counter.Increment) {
+	name, ok := strings.CutPrefix(inc.Key, keyPrefix)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.used[name] += inc.Amount()
+}
+
+// accountLocked returns the account of def, creating an empty one. A
+// pending account takes def, which was created elsewhere first.
+func (m *Manager) accountLocked(def Definition) *account {
+	a, ok := m.accounts[def.Name]
+	if !ok {
+		a = &account{def: def}
+		m.accounts[def.Name] = a
+	}
+	if a.pending {
+		a.def, a.pending = def, false
+	}
+	return a
+}
+
+func (m *Manager) statusLocked(a *account) Status {
+	used := m.used[a.def.Name]
+	return Status{
+		Name:      a.def.Name,
+		Cap:       a.def.Cap,
+		Used:      used,
+		Remaining: max(a.def.Cap-used, 0),
+		Share:     a.share,
+	}
+}
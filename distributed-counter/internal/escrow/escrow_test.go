@@ -0,0 +1,418 @@
+package escrow
+
+import (
+	"context"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/workpool"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// network connects managers in process, JSON encoding messages like the
+// HTTP transport, and delivers every recorded spend to every manager, or
+// with owners set to the owners of its key and its origin.
+type network struct {
+	managers map[string]*Manager
+	owners   []string
+	// side partitions the nodes: those on different sides can't reach
+	// each other.
+	side map[string]int
+	// loseReplies drops replies after the handler has run.
+	loseReplies atomic.Bool
+	recordErr   error
+}
+
+type node struct {
+	net  *network
+	addr string
+}
+
+func newNetwork(n int) (*network, []*Manager) {
+	net := &network{managers: make(map[string]*Manager)}
+	var addrs []string
+	for i := 0; i < n; i++ {
+		addrs = append(addrs, fmt.Sprintf("node-%d:8080", i))
+	}
+	var managers []*Manager
+	for _, addr := range addrs {
+		var peers peerList
+		for _, other := range addrs {
+			if other != addr {
+				peers = append(peers, other)
+			}
+		}
+		nd := &node{net: net, addr: addr}
+		m := New(addr, nd, peers, nd, Config{})
+		net.managers[addr] = m
+		managers = append(managers, m)
+	}
+	return net, managers
+}
+
+type peerList []string
+
+func (p peerList) GetPeerAddrs() []string { return p }
+func (p peerList) KnownAddrs() []string   { return p }
+func (p peerList) Announced() bool        { return true }
+
+// members is a membership whose current peers and known nodes differ.
+type members struct {
+	peers, known []string
+	announced    bool
+}
+
+func (m *members) GetPeerAddrs() []string { return m.peers }
+func (m *members) KnownAddrs() []string   { return m.known }
+func (m *members) Announced() bool        { return m.announced }
+
+func (n *node) Submit(inc counter.Increment) (counter.Increment, bool, error) {
+	if n.net.recordErr != nil {
+		return counter.Increment{}, false, n.net.recordErr
+	}
+	inc.NodeID = n.addr
+	for addr, m := range n.net.managers {
+		if n.net.owners == nil || addr == n.addr || slices.Contains(n.net.owners, addr) {
+			m.Observe(inc)
+		}
+	}
+	return inc, false, nil
+}
+
+func (n *node) Owners(key string) []string {
+	return n.net.owners
+}
+
+func (n *node) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	m, ok := n.net.managers[addr]
+	if !ok || n.net.side[n.addr] != n.net.side[addr] {
+		return errors.New("unreachable")
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	var result interface{}
+	switch path {
+	case "/escrow/define":
+		var def Definition
+		json.Unmarshal(data, &def)
+		m.HandleDefine(def)
+	case "/escrow/transfer":
+		var req TransferRequest
+		json.Unmarshal(data, &req)
+		result = m.HandleTransfer(req)
+	case "/escrow/deposit":
+		var dep Deposit
+		json.Unmarshal(data, &dep)
+		m.HandleDeposit(dep)
+	case "/escrow/usage":
+		var req UsageRequest
+		json.Unmarshal(data, &req)
+		result = m.HandleUsage(req)
+	}
+	if n.net.loseReplies.Load() {
+		return errors.New("connection reset")
+	}
+	if reply != nil && result != nil {
+		data, _ := json.Marshal(result)
+		return json.Unmarshal(data, reply)
+	}
+	return nil
+}
+
+func TestManager_CreateIsIdempotent(t *testing.T) {
+	_, managers := newNetwork(1)
+	m := managers[0]
+
+	status, err := m.Create(context.Background(), "campaign:1", 100)
+	require.NoError(t, err)
+	assert.Equal(t, Status{Name: "campaign:1", Cap: 100, Remaining: 100, Share: 100}, status)
+
+	_, err = m.Create(context.Background(), "campaign:1", 100)
+	assert.NoError(t, err)
+	_, err = m.Create(context.Background(), "campaign:1", 50)
+	assert.ErrorIs(t, err, ErrExists)
+	_, err = m.Create(context.Background(), "campaign:2", 0)
+	assert.Error(t, err)
+}
+
+func TestManager_CreateAdoptsPeerDefinition(t *testing.T) {
+	net, managers := newNetwork(2)
+	a, b := managers[0], managers[1]
+	_, err := a.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+	_, err = a.Increment(context.Background(), "campaign:1", 4)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := b.Get("campaign:1")
+		return err == nil
+	}, time.Second, time.Millisecond)
+
+	// a restarts, losing its share, and the counter is created again.
+	restarted := New(a.selfID, &node{net: net, addr: a.selfID}, peerList{b.selfID}, &node{net: net, addr: a.selfID}, Config{})
+	net.managers[a.selfID] = restarted
+	status, err := restarted.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+	assert.Zero(t, status.Share, "the budget is not minted again")
+	_, err = restarted.Create(context.Background(), "campaign:1", 20)
+	assert.ErrorIs(t, err, ErrExists)
+}
+
+func TestManager_CreateOnBothSidesOfPartitionMintsOnce(t *testing.T) {
+	net := &network{managers: make(map[string]*Manager), side: map[string]int{"node-1:8080": 1}}
+	// The sides dropped each other from membership, but still know each
+	// other's address.
+	ma := &members{known: []string{"node-1:8080"}, announced: true}
+	mb := &members{known: []string{"node-0:8080"}, announced: true}
+	a := New("node-0:8080", &node{net: net, addr: "node-0:8080"}, ma, &node{net: net, addr: "node-0:8080"}, Config{})
+	b := New("node-1:8080", &node{net: net, addr: "node-1:8080"}, mb, &node{net: net, addr: "node-1:8080"}, Config{})
+	net.managers["node-0:8080"], net.managers["node-1:8080"] = a, b
+
+	_, errA := a.Create(context.Background(), "campaign:1", 10)
+	_, errB := b.Create(context.Background(), "campaign:1", 10)
+	assert.ErrorIs(t, errA, ErrUnconfirmed)
+	assert.ErrorIs(t, errB, ErrUnconfirmed)
+
+	// Once healed, one side mints and the other adopts its definition.
+	delete(net.side, "node-1:8080")
+	ma.peers, mb.peers = ma.known, mb.known
+	_, err := a.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+	status, err := b.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+	assert.Zero(t, status.Share)
+}
+
+func TestManager_CreateWaitsForJoin(t *testing.T) {
+	net := &network{managers: make(map[string]*Manager)}
+	m := New("node-0:8080", &node{net: net, addr: "node-0:8080"}, &members{known: []string{"seed:8080"}}, &node{net: net, addr: "node-0:8080"}, Config{})
+	_, err := m.Create(context.Background(), "campaign:1", 10)
+	assert.ErrorIs(t, err, ErrUnconfirmed)
+	_, err = m.Get("campaign:1")
+	assert.ErrorIs(t, err, ErrUnknown, "nothing was minted")
+}
+
+func TestManager_CreateMintsOnlyWhatIsLeft(t *testing.T) {
+	_, managers := newNetwork(1)
+	m := managers[0]
+	m.Observe(counter.Increment{ID: "spent", Key: keyPrefix + "campaign:1", Delta: 4})
+
+	status, err := m.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), status.Share, "spends that survived the definition count against the cap")
+}
+
+func TestManager_CreateRefusesWithoutConfirmation(t *testing.T) {
+	net, managers := newNetwork(2)
+	a, b := managers[0], managers[1]
+
+	net.loseReplies.Store(true)
+	_, err := a.Create(context.Background(), "campaign:1", 10)
+	assert.ErrorIs(t, err, ErrUnconfirmed, "b couldn't be asked")
+	net.loseReplies.Store(false)
+	_, err = a.Get("campaign:1")
+	assert.ErrorIs(t, err, ErrUnknown, "nothing was minted")
+
+	// b is creating the same counter.
+	b.mu.Lock()
+	b.accounts["campaign:1"] = &account{def: Definition{Name: "campaign:1", Cap: 10, Origin: b.selfID}, pending: true}
+	b.mu.Unlock()
+	_, err = a.Create(context.Background(), "campaign:1", 10)
+	assert.ErrorIs(t, err, ErrUnconfirmed)
+	_, err = b.Get("campaign:1")
+	assert.ErrorIs(t, err, ErrUnknown, "pending counters are not listed")
+
+	b.mu.Lock()
+	delete(b.accounts, "campaign:1")
+	b.mu.Unlock()
+	status, err := a.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), status.Share)
+}
+
+func TestManager_ConcurrentCreatesMintOnce(t *testing.T) {
+	_, managers := newNetwork(3)
+	var wg sync.WaitGroup
+	for _, m := range managers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, err := m.Create(context.Background(), "campaign:1", 10)
+				if !errors.Is(err, ErrUnconfirmed) {
+					assert.NoError(t, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var shares int64
+	for _, m := range managers {
+		status, err := m.Get("campaign:1")
+		require.NoError(t, err)
+		shares += status.Share
+	}
+	assert.Equal(t, int64(10), shares)
+}
+
+func TestManager_SpendsLocalShareThenBorrowsFromPeers(t *testing.T) {
+	_, managers := newNetwork(3)
+	a, b := managers[0], managers[1]
+	_, err := a.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+
+	status, err := a.Increment(context.Background(), "campaign:1", 4)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), status.Share)
+
+	// b learns the counter from a's transfer reply if it missed the announcement.
+	status, err = b.Increment(context.Background(), "campaign:1", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), status.Share, "b asks for exactly what it is short of")
+	assert.Equal(t, int64(9), status.Used)
+	assert.Equal(t, int64(1), status.Remaining)
+
+	status, err = b.Increment(context.Background(), "campaign:1", 2)
+	assert.ErrorIs(t, err, ErrCapReached)
+	assert.Equal(t, int64(1), status.Share, "a short increment keeps what it collected")
+
+	status, err = a.Get("campaign:1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), status.Share)
+}
+
+func TestManager_RouteGetReadsUsageFromOwners(t *testing.T) {
+	net, managers := newNetwork(3)
+	net.owners = []string{"node-1:8080"}
+	_, err := managers[0].Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+	_, err = managers[0].Increment(context.Background(), "campaign:1", 4)
+	require.NoError(t, err)
+	_, err = managers[2].Increment(context.Background(), "campaign:1", 3)
+	require.NoError(t, err)
+
+	status, err := managers[2].Get("campaign:1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), status.Used, "only its own spends reach a node that doesn't own the counter")
+	require.Eventually(t, func() bool {
+		_, err := managers[1].Get("campaign:1")
+		return err == nil
+	}, time.Second, 5*time.Millisecond, "the owner learns the counter from its announcement")
+	for _, m := range managers {
+		status, err := m.RouteGet(context.Background(), "campaign:1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), status.Used)
+		assert.Equal(t, int64(3), status.Remaining)
+	}
+	statuses, err := managers[2].RouteList(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, int64(7), statuses[0].Used)
+
+	net.owners = []string{"node-9:8080"}
+	_, err = managers[2].RouteGet(context.Background(), "campaign:1")
+	assert.ErrorIs(t, err, ErrOwnersUnreachable)
+	_, err = managers[2].RouteGet(context.Background(), "campaign:9")
+	assert.ErrorIs(t, err, ErrUnknown)
+}
+
+func TestManager_UnknownCounter(t *testing.T) {
+	_, managers := newNetwork(2)
+	_, err := managers[0].Increment(context.Background(), "campaign:1", 1)
+	assert.ErrorIs(t, err, ErrUnknown)
+	_, err = managers[0].Get("campaign:1")
+	assert.ErrorIs(t, err, ErrUnknown)
+}
+
+func TestManager_ConcurrentIncrementsNeverExceedCap(t *testing.T) {
+	_, managers := newNetwork(3)
+	_, err := managers[0].Create(context.Background(), "campaign:1", 100)
+	require.NoError(t, err)
+
+	var spent atomic.Int64
+	var wg sync.WaitGroup
+	for _, m := range managers {
+		for worker := 0; worker < 5; worker++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					if _, err := m.Increment(context.Background(), "campaign:1", 1); err == nil {
+						spent.Add(1)
+					} else {
+						assert.ErrorIs(t, err, ErrCapReached)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(100), spent.Load())
+	var shares int64
+	for _, m := range managers {
+		status, err := m.Get("campaign:1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), status.Used)
+		shares += status.Share
+	}
+	assert.Zero(t, shares)
+}
+
+func TestManager_LostTransferReplyUnderspends(t *testing.T) {
+	net, managers := newNetwork(2)
+	a, b := managers[0], managers[1]
+	_, err := a.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+
+	net.loseReplies.Store(true)
+	_, err = b.Increment(context.Background(), "campaign:1", 3)
+	assert.ErrorIs(t, err, ErrUnknown, "b never heard back")
+	net.loseReplies.Store(false)
+
+	status, err := a.Get("campaign:1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), status.Share, "the granted units are gone, not duplicated")
+}
+
+func TestManager_FailedRecordRestoresShare(t *testing.T) {
+	net, managers := newNetwork(1)
+	m := managers[0]
+	_, err := m.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+
+	net.recordErr = workpool.ErrSaturated
+	_, err = m.Increment(context.Background(), "campaign:1", 3)
+	assert.ErrorIs(t, err, workpool.ErrSaturated)
+
+	status, err := m.Get("campaign:1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), status.Share)
+}
+
+func TestManager_HandoffMovesSharesToPeers(t *testing.T) {
+	_, managers := newNetwork(2)
+	a, b := managers[0], managers[1]
+	_, err := a.Create(context.Background(), "campaign:1", 10)
+	require.NoError(t, err)
+
+	a.Handoff(context.Background())
+
+	status, err := b.Get("campaign:1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), status.Share)
+	status, err = a.Get("campaign:1")
+	require.NoError(t, err)
+	assert.Zero(t, status.Share)
+}
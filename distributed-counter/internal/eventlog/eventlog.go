@@ -0,0 +1,395 @@
+// Package eventlog records the increments a node applies, for auditing and
+// for debugging divergence between nodes.
+//
+// The most recent entries are kept in memory and can be paged through with
+// a cursor: every entry gets the next sequence number of this node's log,
+// and a query returns the entries after a given one. Optionally every entry
+// is also appended to a file of JSON lines, which keeps a longer history and
+// lets the log, and its sequence numbers, survive restarts. Queries for
+// entries older than those in memory are served from the file.
+package eventlog
+
+import (
+	"bufio"
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/hlc"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCapacity is how many entries are kept in memory by default.
+	DefaultCapacity = 10000
+	// DefaultMaxFileSize is the size at which the log file is rotated.
+	DefaultMaxFileSize = 64 << 20
+	// DefaultLimit and MaxLimit bound the entries a query returns.
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Config holds the tunables of a Log.
+type Config struct {
+	// Capacity is how many entries are kept in memory. Zero means
+	// DefaultCapacity.
+	Capacity int
+	// File, if set, is appended every entry. At MaxFileSize it is renamed to
+	// File+".1", replacing the previous one, and started over. Zero means
+	// DefaultMaxFileSize.
+	File        string
+	MaxFileSize int64
+	// Clock stamps when entries were applied; nil means the wall clock.
+	Clock clock.Clock
+}
+
+// Entry is one applied increment.
+type Entry struct {
+	Seq   uint64 `json:"seq"`
+	ID    string `json:"id"`
+	Node  string `json:"node"`
+	Epoch uint64 `json:"epoch"`
+	Key   string `json:"key,omitempty"`
+	Delta int64  `json:"delta"`
+	// HLC is the hybrid logical timestamp the origin node made the
+	// increment at, and Time its wall time. Applied is when this node
+	// applied it, on its own clock.
+	HLC     hlc.Timestamp `json:"hlc"`
+	Time    time.Time     `json:"time"`
+	Applied time.Time     `json:"applied"`
+}
+
+// Query selects entries. The zero value asks for the oldest DefaultLimit
+// entries in memory.
+type Query struct {
+	// Since is a cursor: only entries after it are returned.
+	Since uint64
+	// Node, if set, keeps only increments made on that node.
+	Node string
+	// Key, if set, keeps only increments with that key, such as those of a
+	// bounded counter. Unkeyed keeps only the increments of the counter
+	// itself, which have none.
+	Key     string
+	Unkeyed bool
+	// Limit caps the entries returned; zero means DefaultLimit, and it is
+	// capped at MaxLimit.
+	Limit int
+}
+
+// Page is the answer to a query.
+type Page struct {
+	Entries []Entry `json:"entries"`
+	// Next is the cursor to pass as Since to continue after this page.
+	Next uint64 `json:"next"`
+	// More is set when entries after Next matched but didn't fit the page.
+	More bool `json:"more"`
+	// Truncated is set when entries after Since are gone: evicted from
+	// memory and, with a file, rotated out of it.
+	Truncated bool `json:"truncated"`
+}
+
+func (q Query) matches(e Entry) bool {
+	return (q.Node == "" || e.Node == q.Node) && (q.Key == "" || e.Key == q.Key) && (!q.Unkeyed || e.Key == "")
+}
+
+// Log is a bounded log of applied increments. It is safe for concurrent use.
+type Log struct {
+	mu      sync.Mutex
+	cfg     Config
+	entries []Entry // ring buffer of the newest entries
+	start   int     // index of the oldest entry in entries
+	n       int     // number of entries in entries
+	seq     uint64  // sequence number of the newest entry
+	file    *os.File
+	size    int64
+	// rotating is held for writing while the file is rotated, and for
+	// reading by queries that scan it, without holding mu.
+	rotating sync.RWMutex
+}
+
+// New returns a log. With a file configured, it reloads the newest entries
+// from it and continues their numbering.
+func New(cfg Config) (*Log, error) {
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = DefaultCapacity
+	}
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = DefaultMaxFileSize
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+	l := &Log{cfg: cfg, entries: make([]Entry, cfg.Capacity)}
+	if cfg.File == "" {
+		return l, nil
+	}
+	for _, path := range []string{cfg.File + ".1", cfg.File} {
+		if err := l.load(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.openLocked(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// load replays the entries of a log file into memory.
+func (l *Log) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A crash can leave the last line half written.
+			log.Printf("Skipping malformed event log line in %s: %v", path, err)
+			continue
+		}
+		if e.Seq > l.seq {
+			l.seq = e.Seq
+			l.appendLocked(e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read event log: %w", err)
+	}
+	return nil
+}
+
+func (l *Log) openLocked() error {
+	f, err := os.OpenFile(l.cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	l.file, l.size = f, info.Size()
+	// End a line torn by a crash, so the next entry starts a line of its own.
+	if torn, err := endsTorn(l.cfg.File, l.size); err != nil || torn {
+		if err == nil {
+			_, err = f.Write([]byte{'\n'})
+			l.size++
+		}
+		if err != nil {
+			f.Close()
+			l.file = nil
+			return fmt.Errorf("failed to open event log: %w", err)
+		}
+	}
+	return nil
+}
+
+// endsTorn reports whether a file of the given size doesn't end with a newline.
+func endsTorn(path string, size int64) (bool, error) {
+	if size == 0 {
+		return false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return false, err
+	}
+	return last[0] != '\n', nil
+}
+
+// Close closes the log file. Entries recorded later are kept in memory only.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Observe records an applied increment. Register it with Counter.OnApply.
+func (l *Log) Observe(inc counter.Increment) {
+	e := Entry{
+		ID:      inc.ID,
+		Node:    inc.NodeID,
+		Epoch:   inc.Epoch,
+		Key:     inc.Key,
+		Delta:   inc.Amount(),
+		HLC:     inc.Timestamp(),
+		Applied: l.cfg.Clock.Now().UTC(),
+	}
+	if inc.Time != 0 {
+		e.Time = time.Unix(0, inc.Time).UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	e.Seq = l.seq
+	l.appendLocked(e)
+	if l.file != nil {
+		if err := l.writeLocked(e); err != nil {
+			log.Printf("Failed to write increment %s to the event log: %v", e.ID, err)
+		}
+	}
+}
+
+func (l *Log) appendLocked(e Entry) {
+	if l.n < len(l.entries) {
+		l.entries[(l.start+l.n)%len(l.entries)] = e
+		l.n++
+		return
+	}
+	l.entries[l.start] = e
+	l.start = (l.start + 1) % len(l.entries)
+}
+
+func (l *Log) writeLocked(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if l.size > 0 && l.size+int64(len(line)) > l.cfg.MaxFileSize {
+		if err := l.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Log) rotateLocked() error {
+	l.rotating.Lock()
+	defer l.rotating.Unlock()
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	if err := os.Rename(l.cfg.File, l.cfg.File+".1"); err != nil {
+		return err
+	}
+	return l.openLocked()
+}
+
+// entryLocked returns the i-th oldest entry in memory.
+func (l *Log) entryLocked(i int) Entry {
+	return l.entries[(l.start+i)%len(l.entries)]
+}
+
+// Query returns the entries after q.Since that match q, oldest first. With
+// a file, entries older than those in memory are read from it.
+func (l *Log) Query(q Query) Page {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+
+	page := Page{Entries: []Entry{}, Next: q.Since}
+	var fileOldest uint64
+	if l.cfg.File != "" && q.Since+1 < l.oldestInMemory() {
+		fileOldest = l.queryFiles(q, limit, &page)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.n == 0 {
+		return page
+	}
+	oldest := l.entryLocked(0).Seq
+	if fileOldest > 0 {
+		oldest = min(oldest, fileOldest)
+	}
+	page.Truncated = q.Since+1 < oldest
+	if page.More || page.Next >= l.seq {
+		return page
+	}
+	first := sort.Search(l.n, func(i int) bool { return l.entryLocked(i).Seq > page.Next })
+	for i := first; i < l.n; i++ {
+		e := l.entryLocked(i)
+		if !q.matches(e) {
+			page.Next = e.Seq
+			continue
+		}
+		if len(page.Entries) == limit {
+			page.More = true
+			break
+		}
+		page.Entries = append(page.Entries, e)
+		page.Next = e.Seq
+	}
+	return page
+}
+
+// oldestInMemory returns the sequence number of the oldest entry in memory,
+// or 0 if there is none.
+func (l *Log) oldestInMemory() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.n == 0 {
+		return 0
+	}
+	return l.entryLocked(0).Seq
+}
+
+// queryFiles adds the entries of the log files after page.Next that match q
+// to the page, up to limit. It returns the oldest sequence number in the
+// files, or 0 if they are empty.
+func (l *Log) queryFiles(q Query, limit int, page *Page) uint64 {
+	l.rotating.RLock()
+	defer l.rotating.RUnlock()
+	var oldest uint64
+	for _, path := range []string{l.cfg.File + ".1", l.cfg.File} {
+		f, err := os.Open(path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Failed to read event log %s: %v", path, err)
+			}
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e Entry
+			if json.Unmarshal(scanner.Bytes(), &e) != nil {
+				continue
+			}
+			if oldest == 0 {
+				oldest = e.Seq
+			}
+			if e.Seq <= page.Next {
+				continue
+			}
+			if !q.matches(e) {
+				page.Next = e.Seq
+				continue
+			}
+			if len(page.Entries) == limit {
+				page.More = true
+				f.Close()
+				return oldest
+			}
+			page.Entries = append(page.Entries, e)
+			page.Next = e.Seq
+		}
+		f.Close()
+	}
+	return oldest
+}
@@ -0,0 +1,186 @@
+package eventlog
+
+import (
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/hlc"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func observe(l *Log, n int, node string) {
+	for i := 0; i < n; i++ {
+		l.Observe(counter.Increment{ID: fmt.Sprintf("%s-%d", node, i), NodeID: node, Time: int64(i + 1)})
+	}
+}
+
+func seqs(entries []Entry) []uint64 {
+	var out []uint64
+	for _, e := range entries {
+		out = append(out, e.Seq)
+	}
+	return out
+}
+
+func TestLog_RecordsIncrements(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l, err := New(Config{Clock: clock.NewFake(start)})
+	require.NoError(t, err)
+	l.Observe(counter.Increment{ID: "a", NodeID: "node1:8080", Epoch: 2, Time: start.Add(-time.Second).UnixNano(), Logical: 1, Key: "bounded:ads", Delta: 3})
+	l.Observe(counter.Increment{ID: "b", NodeID: "node2:8080"})
+
+	page := l.Query(Query{})
+	assert.Equal(t, []Entry{
+		{Seq: 1, ID: "a", Node: "node1:8080", Epoch: 2, Key: "bounded:ads", Delta: 3, HLC: hlc.Timestamp{Wall: start.Add(-time.Second).UnixNano(), Logical: 1}, Time: start.Add(-time.Second), Applied: start},
+		{Seq: 2, ID: "b", Node: "node2:8080", Delta: 1, Applied: start},
+	}, page.Entries)
+	assert.Equal(t, uint64(2), page.Next)
+	assert.False(t, page.More)
+	assert.False(t, page.Truncated)
+}
+
+func TestLog_PagesWithCursor(t *testing.T) {
+	l, err := New(Config{})
+	require.NoError(t, err)
+	observe(l, 5, "node1:8080")
+
+	page := l.Query(Query{Limit: 2})
+	assert.Equal(t, []uint64{1, 2}, seqs(page.Entries))
+	assert.True(t, page.More)
+	page = l.Query(Query{Since: page.Next, Limit: 2})
+	assert.Equal(t, []uint64{3, 4}, seqs(page.Entries))
+	page = l.Query(Query{Since: page.Next, Limit: 2})
+	assert.Equal(t, []uint64{5}, seqs(page.Entries))
+	assert.False(t, page.More)
+
+	page = l.Query(Query{Since: page.Next})
+	assert.Empty(t, page.Entries)
+	assert.Equal(t, uint64(5), page.Next, "an exhausted cursor stays put")
+}
+
+func TestLog_FiltersByNode(t *testing.T) {
+	l, err := New(Config{})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		observe(l, 1, "node1:8080")
+		observe(l, 1, "node2:8080")
+	}
+	observe(l, 1, "node1:8080")
+
+	page := l.Query(Query{Node: "node2:8080", Limit: 2})
+	assert.Equal(t, []uint64{2, 4}, seqs(page.Entries))
+	assert.True(t, page.More)
+	page = l.Query(Query{Since: page.Next, Node: "node2:8080", Limit: 2})
+	assert.Equal(t, []uint64{6}, seqs(page.Entries))
+	assert.False(t, page.More)
+	assert.Equal(t, uint64(7), page.Next, "the cursor moves past entries of other nodes")
+}
+
+func TestLog_EvictsOldest(t *testing.T) {
+	l, err := New(Config{Capacity: 3})
+	require.NoError(t, err)
+	observe(l, 5, "node1:8080")
+
+	page := l.Query(Query{})
+	assert.Equal(t, []uint64{3, 4, 5}, seqs(page.Entries))
+	assert.True(t, page.Truncated)
+	page = l.Query(Query{Since: 2})
+	assert.Equal(t, []uint64{3, 4, 5}, seqs(page.Entries))
+	assert.False(t, page.Truncated)
+}
+
+func TestLog_PersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "increments.log")
+	l, err := New(Config{Capacity: 4, File: path})
+	require.NoError(t, err)
+	observe(l, 3, "node1:8080")
+	require.NoError(t, l.Close())
+
+	// A crash can leave a torn last line.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":4,"id":`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	l, err = New(Config{Capacity: 4, File: path})
+	require.NoError(t, err)
+	defer l.Close()
+	observe(l, 2, "node2:8080")
+	page := l.Query(Query{Since: 1})
+	assert.Equal(t, []uint64{2, 3, 4, 5}, seqs(page.Entries), "numbering continues after the restart")
+	assert.Equal(t, "node2:8080-0", page.Entries[2].ID)
+	assert.False(t, page.Truncated)
+}
+
+func TestLog_ServesOlderEntriesFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "increments.log")
+	l, err := New(Config{Capacity: 3, File: path, MaxFileSize: 1000})
+	require.NoError(t, err)
+	defer l.Close()
+	for i := 0; i < 10; i++ {
+		l.Observe(counter.Increment{ID: fmt.Sprintf("inc-%d", i), NodeID: "node1:8080", Time: int64(i + 1)})
+		l.Observe(counter.Increment{ID: fmt.Sprintf("bounded-%d", i), NodeID: "node1:8080", Time: int64(i + 1), Key: "bounded:ads"})
+	}
+
+	// The file was rotated once; its older half holds entries long evicted
+	// from memory.
+	page := l.Query(Query{Limit: 4})
+	require.NotEmpty(t, page.Entries)
+	first := page.Entries[0].Seq
+	assert.Greater(t, first, uint64(1))
+	assert.True(t, page.Truncated, "entries rotated out of the file are gone")
+
+	var all []uint64
+	for page = l.Query(Query{Since: first - 1, Limit: 4}); ; page = l.Query(Query{Since: page.Next, Limit: 4}) {
+		assert.False(t, page.Truncated)
+		all = append(all, seqs(page.Entries)...)
+		if !page.More {
+			break
+		}
+	}
+	var want []uint64
+	for seq := first; seq <= 20; seq++ {
+		want = append(want, seq)
+	}
+	assert.Equal(t, want, all, "pages continue from the file into memory")
+
+	page = l.Query(Query{Since: first - 1, Key: "bounded:ads", Limit: MaxLimit})
+	for _, e := range page.Entries {
+		assert.Equal(t, "bounded:ads", e.Key)
+	}
+	assert.Equal(t, uint64(20), page.Entries[len(page.Entries)-1].Seq)
+	page = l.Query(Query{Since: first - 1, Unkeyed: true, Limit: MaxLimit})
+	for _, e := range page.Entries {
+		assert.Empty(t, e.Key)
+	}
+	assert.Equal(t, uint64(19), page.Entries[len(page.Entries)-1].Seq)
+	assert.Equal(t, uint64(20), page.Next, "the cursor moves past keyed entries")
+}
+
+func TestLog_RotatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "increments.log")
+	l, err := New(Config{File: path, MaxFileSize: 400})
+	require.NoError(t, err)
+	observe(l, 10, "node1:8080")
+	require.NoError(t, l.Close())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(400))
+	_, err = os.Stat(path + ".1")
+	require.NoError(t, err)
+
+	l, err = New(Config{File: path, MaxFileSize: 400})
+	require.NoError(t, err)
+	defer l.Close()
+	entries := l.Query(Query{}).Entries
+	require.NotEmpty(t, entries)
+	assert.Equal(t, uint64(10), entries[len(entries)-1].Seq)
+}
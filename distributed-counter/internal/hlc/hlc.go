@@ -0,0 +1,124 @@
+// Package hlc implements hybrid logical clocks.
+//
+// A hybrid logical clock reads like the wall clock but never goes backwards
+// and never falls behind a timestamp it has received: every event gets a
+// timestamp greater than those of the events that may have caused it, even
+// across nodes whose clocks are skewed. Timestamps stay within the maximum
+// clock offset of physical time, so they can be bucketed like wall times.
+package hlc
+
+import (
+	"distributed-counter/internal/clock"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical time: a wall time in Unix nanoseconds and a
+// logical counter that orders events sharing a wall time.
+type Timestamp struct {
+	Wall    int64  `json:"wall"`
+	Logical uint32 `json:"logical"`
+}
+
+// IsZero reports whether t is unset.
+func (t Timestamp) IsZero() bool { return t == Timestamp{} }
+
+// Less reports whether t is before u.
+func (t Timestamp) Less(u Timestamp) bool {
+	return t.Wall < u.Wall || (t.Wall == u.Wall && t.Logical < u.Logical)
+}
+
+// Time returns the wall time of t.
+func (t Timestamp) Time() time.Time { return time.Unix(0, t.Wall) }
+
+func (t Timestamp) String() string {
+	if t.IsZero() {
+		return "unstamped"
+	}
+	return fmt.Sprintf("%s+%d", t.Time().UTC().Format(time.RFC3339Nano), t.Logical)
+}
+
+// OffsetError is returned for a remote timestamp too far ahead of the local
+// physical clock to be adopted.
+type OffsetError struct {
+	Remote Timestamp
+	Offset time.Duration
+}
+
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("remote timestamp %s is %s ahead of the local clock", e.Remote, e.Offset)
+}
+
+// Clock is a hybrid logical clock. It is safe for concurrent use.
+type Clock struct {
+	mu        sync.Mutex
+	physical  clock.Clock
+	maxOffset time.Duration
+	last      Timestamp
+}
+
+// New returns a clock driven by physical. Remote timestamps more than
+// maxOffset ahead of it are refused; zero accepts any.
+func New(physical clock.Clock, maxOffset time.Duration) *Clock {
+	return &Clock{physical: physical, maxOffset: maxOffset}
+}
+
+// Now returns a timestamp for a local or send event, greater than every
+// timestamp returned or received before.
+func (c *Clock) Now() Timestamp {
+	pt := c.physical.Now().UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt > c.last.Wall {
+		c.last = Timestamp{Wall: pt}
+	} else {
+		c.tickLocked()
+	}
+	return c.last
+}
+
+// Peek returns the current reading without moving the clock: the latest
+// timestamp, or physical time if that is ahead.
+func (c *Clock) Peek() Timestamp {
+	pt := c.physical.Now().UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt > c.last.Wall {
+		return Timestamp{Wall: pt}
+	}
+	return c.last
+}
+
+// Update advances the clock past a received timestamp, so later local
+// events order after the remote one. A remote timestamp more than the
+// maximum offset ahead of physical time leaves the clock unchanged and
+// returns an *OffsetError.
+func (c *Clock) Update(remote Timestamp) error {
+	pt := c.physical.Now().UnixNano()
+	if c.maxOffset > 0 && remote.Wall-pt > int64(c.maxOffset) {
+		return &OffsetError{Remote: remote, Offset: time.Duration(remote.Wall - pt)}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case pt > c.last.Wall && pt > remote.Wall:
+		c.last = Timestamp{Wall: pt}
+	case !c.last.Less(remote):
+		c.tickLocked()
+	default:
+		c.last = remote
+		c.tickLocked()
+	}
+	return nil
+}
+
+// tickLocked moves the clock to the next logical time.
+func (c *Clock) tickLocked() {
+	if c.last.Logical == math.MaxUint32 {
+		c.last = Timestamp{Wall: c.last.Wall + 1}
+		return
+	}
+	c.last.Logical++
+}
@@ -0,0 +1,76 @@
+package hlc
+
+import (
+	"distributed-counter/internal/clock"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestClock_NowFollowsPhysicalTime(t *testing.T) {
+	physical := clock.NewFake(start)
+	c := New(physical, 0)
+
+	assert.Equal(t, Timestamp{Wall: start.UnixNano()}, c.Now())
+	assert.Equal(t, Timestamp{Wall: start.UnixNano(), Logical: 1}, c.Now(), "same wall time ticks the logical counter")
+	physical.Advance(time.Millisecond)
+	assert.Equal(t, Timestamp{Wall: start.Add(time.Millisecond).UnixNano()}, c.Now())
+}
+
+func TestClock_NeverGoesBackwards(t *testing.T) {
+	physical := clock.NewFake(start)
+	c := New(physical, 0)
+	first := c.Now()
+
+	physical.Advance(-time.Second)
+	second := c.Now()
+	assert.True(t, first.Less(second))
+	assert.Equal(t, first.Wall, second.Wall)
+	assert.Equal(t, second, c.Peek())
+}
+
+func TestClock_UpdateOrdersAfterRemote(t *testing.T) {
+	physical := clock.NewFake(start)
+	c := New(physical, time.Second)
+
+	// A peer whose clock runs 100ms ahead.
+	remote := Timestamp{Wall: start.Add(100 * time.Millisecond).UnixNano(), Logical: 4}
+	require.NoError(t, c.Update(remote))
+	next := c.Now()
+	assert.True(t, remote.Less(next), "%s is not after %s", next, remote)
+	assert.Equal(t, remote.Wall, next.Wall)
+
+	// Older remote timestamps still tick the clock.
+	before := c.Peek()
+	require.NoError(t, c.Update(Timestamp{Wall: start.UnixNano()}))
+	assert.True(t, before.Less(c.Peek()))
+
+	// Once physical time passes the remote wall time, it takes over again.
+	physical.Advance(time.Second)
+	assert.Equal(t, Timestamp{Wall: start.Add(time.Second).UnixNano()}, c.Now())
+}
+
+func TestClock_RefusesTimestampsBeyondMaxOffset(t *testing.T) {
+	c := New(clock.NewFake(start), time.Second)
+	err := c.Update(Timestamp{Wall: start.Add(time.Minute).UnixNano()})
+	var offset *OffsetError
+	require.True(t, errors.As(err, &offset))
+	assert.Equal(t, time.Minute, offset.Offset)
+	assert.Equal(t, start.UnixNano(), c.Peek().Wall, "the clock didn't move")
+}
+
+func TestClock_LogicalOverflowAdvancesWallTime(t *testing.T) {
+	c := New(clock.NewFake(start), 0)
+	require.NoError(t, c.Update(Timestamp{Wall: start.UnixNano(), Logical: math.MaxUint32 - 1}))
+	assert.Equal(t, Timestamp{Wall: start.UnixNano() + 1}, c.Now())
+}
+
+func TestTimestamp_String(t *testing.T) {
+	assert.Equal(t, "2024-01-01T00:00:00.5Z+3", Timestamp{Wall: start.Add(500 * time.Millisecond).UnixNano(), Logical: 3}.String())
+}
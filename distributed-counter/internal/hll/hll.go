@@ -0,0 +1,156 @@
+// Package hll implements HyperLogLog, a fixed-size sketch that estimates the
+// number of distinct elements added to it.
+//
+// A sketch of precision p has m = 2^p one-byte registers. Each element is
+// hashed; the first p bits pick a register, which keeps the longest run of
+// leading zeros seen in the remaining bits. Merging takes the per-register
+// maximum, so sketches form a state-based CRDT: merges are commutative,
+// associative and idempotent, and a register only ever grows. The relative
+// standard error of the estimate is about 1.04/sqrt(m), 0.81% at the default
+// precision of 14 (16 KiB per sketch).
+package hll
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// MinPrecision and MaxPrecision bound the precision of a sketch.
+	MinPrecision = 4
+	MaxPrecision = 18
+	// DefaultPrecision trades 16 KiB per sketch for a 0.81% standard error.
+	DefaultPrecision = 14
+)
+
+// Sketch is a HyperLogLog sketch. It is not safe for concurrent use.
+type Sketch struct {
+	p         uint8
+	registers []uint8
+}
+
+// New returns an empty sketch. It panics if precision is out of range.
+func New(precision uint8) *Sketch {
+	if precision < MinPrecision || precision > MaxPrecision {
+		panic(fmt.Sprintf("hll: precision %d out of range [%d, %d]", precision, MinPrecision, MaxPrecision))
+	}
+	return &Sketch{p: precision, registers: make([]uint8, 1<<precision)}
+}
+
+// FromRegisters rebuilds a sketch from the registers of another one.
+func FromRegisters(registers []uint8) (*Sketch, error) {
+	p := uint8(bits.TrailingZeros(uint(len(registers))))
+	if len(registers) != 1<<p || p < MinPrecision || p > MaxPrecision {
+		return nil, fmt.Errorf("hll: %d registers is not a valid sketch size", len(registers))
+	}
+	s := New(p)
+	copy(s.registers, registers)
+	return s, nil
+}
+
+// StdError is the relative standard error of estimates at a precision.
+func StdError(precision uint8) float64 {
+	return 1.04 / math.Sqrt(float64(uint64(1)<<precision))
+}
+
+// Precision returns the precision the sketch was created with.
+func (s *Sketch) Precision() uint8 {
+	return s.p
+}
+
+// Registers returns a copy of the registers.
+func (s *Sketch) Registers() []uint8 {
+	return append([]uint8(nil), s.registers...)
+}
+
+// Position returns the register an element maps to and the rank it would
+// store there. The hash is fixed so that every node agrees.
+func (s *Sketch) Position(element string) (uint32, uint8) {
+	h := fnv.New64a()
+	h.Write([]byte(element))
+	x := mix(h.Sum64())
+	index := uint32(x >> (64 - s.p))
+	// Rank is the 1-based position of the first set bit after the index
+	// bits; the sentinel bit caps it when they are all zero.
+	rest := x<<s.p | 1<<(s.p-1)
+	return index, uint8(bits.LeadingZeros64(rest)) + 1
+}
+
+// Add adds an element, returning true if the sketch changed.
+func (s *Sketch) Add(element string) bool {
+	return s.Set(s.Position(element))
+}
+
+// Rank returns the value of a register.
+func (s *Sketch) Rank(index uint32) uint8 {
+	return s.registers[index]
+}
+
+// Set raises a register to rank, returning true if it was lower.
+func (s *Sketch) Set(index uint32, rank uint8) bool {
+	if s.registers[index] >= rank {
+		return false
+	}
+	s.registers[index] = rank
+	return true
+}
+
+// Merge folds other into s, as if every element added to other had been
+// added to s. Both must have the same precision.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other.p != s.p {
+		return fmt.Errorf("hll: cannot merge precision %d into %d", other.p, s.p)
+	}
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+	return nil
+}
+
+// Estimate returns the approximate number of distinct elements added.
+func (s *Sketch) Estimate() uint64 {
+	m := float64(len(s.registers))
+	var sum float64
+	zeros := 0
+	for _, rank := range s.registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := alpha(len(s.registers)) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Small cardinalities: linear counting of the empty registers is
+		// more accurate. With 64-bit hashes no large range correction is needed.
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// alpha is the bias correction constant for m registers.
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+// mix is the MurmurHash3 finalizer, which spreads FNV's weak high bits.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
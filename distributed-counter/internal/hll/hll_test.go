@@ -0,0 +1,77 @@
+package hll
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addRange(s *Sketch, prefix string, from, to int) {
+	for i := from; i < to; i++ {
+		s.Add(fmt.Sprintf("%s-%d", prefix, i))
+	}
+}
+
+// assertWithin checks an estimate against the true count within three
+// standard errors.
+func assertWithin(t *testing.T, s *Sketch, want int) {
+	t.Helper()
+	tolerance := 3 * StdError(s.Precision()) * float64(want)
+	assert.InDelta(t, want, float64(s.Estimate()), math.Max(tolerance, 1), "estimate of %d", want)
+}
+
+func TestSketch_EstimatesWithinErrorBounds(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 50000, 500000} {
+		s := New(DefaultPrecision)
+		addRange(s, "user", 0, n)
+		assertWithin(t, s, n)
+	}
+}
+
+func TestSketch_DuplicatesDontCount(t *testing.T) {
+	s := New(DefaultPrecision)
+	addRange(s, "user", 0, 1000)
+	before := s.Registers()
+	addRange(s, "user", 0, 1000)
+	assert.Equal(t, before, s.Registers())
+	assert.False(t, s.Add("user-1"))
+}
+
+func TestSketch_MergeIsUnion(t *testing.T) {
+	a, b, union := New(12), New(12), New(12)
+	addRange(a, "user", 0, 30000)
+	addRange(b, "user", 20000, 50000)
+	addRange(union, "user", 0, 50000)
+
+	require.NoError(t, a.Merge(b))
+	assert.Equal(t, union.Registers(), a.Registers())
+	assertWithin(t, a, 50000)
+
+	// Merging again changes nothing.
+	require.NoError(t, a.Merge(b))
+	assert.Equal(t, union.Registers(), a.Registers())
+
+	assert.Error(t, a.Merge(New(10)))
+}
+
+func TestFromRegisters(t *testing.T) {
+	s := New(10)
+	addRange(s, "user", 0, 500)
+	copied, err := FromRegisters(s.Registers())
+	require.NoError(t, err)
+	assert.Equal(t, uint8(10), copied.Precision())
+	assert.Equal(t, s.Estimate(), copied.Estimate())
+
+	_, err = FromRegisters(make([]byte, 1000))
+	assert.Error(t, err)
+	_, err = FromRegisters(make([]byte, 8))
+	assert.Error(t, err)
+}
+
+func TestStdError(t *testing.T) {
+	assert.InDelta(t, 0.008125, StdError(14), 1e-6)
+	assert.Panics(t, func() { New(MaxPrecision + 1) })
+}
@@ -0,0 +1,180 @@
+package httpclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the peer while its circuit is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// BreakerState is the state of a per-destination circuit breaker.
+type BreakerState string
+
+const (
+	// Closed lets requests through and counts consecutive failures.
+	Closed BreakerState = "closed"
+	// Open fails requests fast until OpenTimeout has elapsed.
+	Open BreakerState = "open"
+	// HalfOpen lets a limited number of probe requests through to test recovery.
+	HalfOpen BreakerState = "half-open"
+)
+
+// BreakerConfig holds the circuit breaker thresholds. Zero values are replaced with defaults.
+type BreakerConfig struct {
+	FailureThreshold int           // consecutive failures that open the circuit
+	OpenTimeout      time.Duration // how long an open circuit fails fast before probing
+	HalfOpenProbes   int           // concurrent probes allowed while half-open
+	SuccessThreshold int           // probe successes needed to close the circuit
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 5 * time.Second
+	}
+	if c.HalfOpenProbes <= 0 {
+		c.HalfOpenProbes = 1
+	}
+	if c.SuccessThreshold <= 0 {
+		c.SuccessThreshold = 1
+	}
+	return c
+}
+
+type breaker struct {
+	state     BreakerState
+	failures  int
+	successes int
+	probes    int
+	openedAt  time.Time
+}
+
+// Breakers keeps one circuit breaker per destination address. It is safe
+// for concurrent use, and can guard any transport.
+type Breakers struct {
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu sync.Mutex
+	m  map[string]*breaker
+}
+
+// NewBreakers returns breakers with the given thresholds.
+func NewBreakers(cfg BreakerConfig) *Breakers {
+	return &Breakers{
+		cfg: cfg.withDefaults(),
+		now: time.Now,
+		m:   make(map[string]*breaker),
+	}
+}
+
+// Allow reports whether a request to dest may proceed, returning
+// ErrCircuitOpen if not. Every allowed request must be followed by a call to
+// Record.
+func (bs *Breakers) Allow(dest string) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b := bs.get(dest)
+
+	if b.state == Open {
+		if bs.now().Sub(b.openedAt) < bs.cfg.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.state = HalfOpen
+		b.successes = 0
+		b.probes = 0
+	}
+	if b.state == HalfOpen {
+		if b.probes >= bs.cfg.HalfOpenProbes {
+			return ErrCircuitOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Record updates the breaker for dest with the outcome of an allowed
+// request; failed means the peer was unreachable or unhealthy.
+func (bs *Breakers) Record(dest string, failed bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b := bs.get(dest)
+
+	switch b.state {
+	case Closed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= bs.cfg.FailureThreshold {
+			bs.open(b)
+		}
+	case HalfOpen:
+		b.probes--
+		if failed {
+			bs.open(b)
+			return
+		}
+		b.successes++
+		if b.successes >= bs.cfg.SuccessThreshold {
+			*b = breaker{state: Closed}
+		}
+	}
+}
+
+func (bs *Breakers) open(b *breaker) {
+	b.state = Open
+	b.openedAt = bs.now()
+	b.failures = 0
+	b.probes = 0
+}
+
+func (bs *Breakers) get(dest string) *breaker {
+	b, ok := bs.m[dest]
+	if !ok {
+		b = &breaker{state: Closed}
+		bs.m[dest] = b
+	}
+	return b
+}
+
+// State returns the current state of dest's breaker. An open circuit whose
+// timeout has elapsed is reported as half-open, since the next request probes.
+func (bs *Breakers) State(dest string) BreakerState {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.m[dest]
+	if !ok {
+		return Closed
+	}
+	if b.state == Open && bs.now().Sub(b.openedAt) >= bs.cfg.OpenTimeout {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// CircuitOpen reports whether requests to dest currently fail fast.
+func (bs *Breakers) CircuitOpen(dest string) bool {
+	return bs.State(dest) == Open
+}
+
+// States returns the breaker state of every destination contacted so far.
+func (bs *Breakers) States() map[string]BreakerState {
+	bs.mu.Lock()
+	dests := make([]string, 0, len(bs.m))
+	for dest := range bs.m {
+		dests = append(dests, dest)
+	}
+	bs.mu.Unlock()
+
+	out := make(map[string]BreakerState, len(dests))
+	for _, dest := range dests {
+		out[dest] = bs.State(dest)
+	}
+	return out
+}
@@ -6,52 +6,98 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
 // Client is a simple wrapper around http.Client for inter-node communication.
+// Each destination host has its own circuit breaker.
 type Client struct {
 	httpClient *http.Client
+	breakers   *Breakers
 }
 
 func New() *Client {
+	return NewWithBreaker(BreakerConfig{})
+}
+
+// NewWithBreaker creates a client with the given circuit breaker thresholds.
+func NewWithBreaker(cfg BreakerConfig) *Client {
 	return &Client{
 		httpClient: &http.Client{Timeout: 5 * time.Second},
+		breakers:   NewBreakers(cfg),
 	}
 }
 
-// Post sends a POST request with a JSON body.
-func (c *Client) Post(ctx context.Context, url string, body interface{}, responseBody interface{}) error {
+// CircuitState returns the breaker state for a destination (host:port).
+func (c *Client) CircuitState(addr string) BreakerState {
+	return c.breakers.State(addr)
+}
+
+// CircuitOpen reports whether requests to addr currently fail fast.
+func (c *Client) CircuitOpen(addr string) bool {
+	return c.breakers.CircuitOpen(addr)
+}
+
+// CircuitStates returns the breaker state of every destination contacted so far.
+func (c *Client) CircuitStates() map[string]BreakerState {
+	return c.breakers.States()
+}
+
+// Send posts an internal message to path on the node at addr.
+func (c *Client) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	return c.Post(ctx, "http://"+addr+path, body, reply)
+}
+
+// Post sends a POST request with a JSON body. It fails fast with ErrCircuitOpen
+// while the destination's circuit is open.
+func (c *Client) Post(ctx context.Context, rawURL string, body interface{}, responseBody interface{}) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if err := c.breakers.Allow(u.Host); err != nil {
+		return fmt.Errorf("request to %s failed: %w", u.Host, err)
+	}
+
+	failed, err := c.post(ctx, rawURL, body, responseBody)
+	c.breakers.Record(u.Host, failed)
+	return err
+}
+
+// post performs the request. The boolean is true when the error means the peer is
+// unreachable or unhealthy, as opposed to rejecting this particular request.
+func (c *Client) post(ctx context.Context, url string, body interface{}, responseBody interface{}) (bool, error) {
 	var reqBody []byte
 	var err error
 	if body != nil {
 		reqBody, err = json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("failed to marshal request body: %w", err)
+			return false, fmt.Errorf("failed to marshal request body: %w", err)
 		}
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return false, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return true, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received non-OK status code: %d", resp.StatusCode)
+		return resp.StatusCode >= http.StatusInternalServerError, fmt.Errorf("received non-OK status code: %d", resp.StatusCode)
 	}
 
 	if responseBody != nil {
 		if err := json.NewDecoder(resp.Body).Decode(responseBody); err != nil {
-			return fmt.Errorf("failed to decode response body: %w", err)
+			return false, fmt.Errorf("failed to decode response body: %w", err)
 		}
 	}
 
-	return nil
-}
\ No newline at end of file
+	return false, nil
+}
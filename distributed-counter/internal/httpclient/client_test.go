@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -37,4 +39,80 @@ func TestClient_Post_ServerError(t *testing.T) {
 	err := client.Post(context.Background(), server.URL, nil, nil)
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "received non-OK status code: 500")
-}
\ No newline at end of file
+}
+func TestClient_Send(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/cluster/heartbeat", r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := New()
+	err := client.Send(context.Background(), server.Listener.Addr().String(), "/cluster/heartbeat", map[string]string{"id": "self"}, nil)
+	require.NoError(t, err)
+}
+
+func TestClient_CircuitOpensAndFailsFast(t *testing.T) {
+	var calls int32
+	healthy := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	client := NewWithBreaker(BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute})
+	now := time.Now()
+	client.breakers.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		require.Error(t, client.Send(context.Background(), addr, "/cluster/heartbeat", nil, nil))
+	}
+	assert.Equal(t, Open, client.CircuitState(addr))
+	assert.True(t, client.CircuitOpen(addr))
+
+	err := client.Send(context.Background(), addr, "/cluster/heartbeat", nil, nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "open circuit must not reach the server")
+
+	// After the timeout one probe goes through and closes the circuit.
+	atomic.StoreInt32(&healthy, 1)
+	now = now.Add(time.Minute)
+	assert.Equal(t, HalfOpen, client.CircuitState(addr))
+	require.NoError(t, client.Send(context.Background(), addr, "/cluster/heartbeat", nil, nil))
+	assert.Equal(t, Closed, client.CircuitState(addr))
+	assert.Equal(t, map[string]BreakerState{addr: Closed}, client.CircuitStates())
+}
+
+func TestClient_ClientErrorsDoNotOpenCircuit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	client := NewWithBreaker(BreakerConfig{FailureThreshold: 1})
+	require.Error(t, client.Send(context.Background(), addr, "/counter/propagate", nil, nil))
+	assert.Equal(t, Closed, client.CircuitState(addr))
+}
+
+func TestBreakers_HalfOpenFailureReopens(t *testing.T) {
+	bs := NewBreakers(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenProbes: 1})
+	now := time.Now()
+	bs.now = func() time.Time { return now }
+
+	require.NoError(t, bs.Allow("peer"))
+	bs.Record("peer", true)
+	assert.ErrorIs(t, bs.Allow("peer"), ErrCircuitOpen)
+
+	now = now.Add(time.Second)
+	require.NoError(t, bs.Allow("peer"))
+	assert.ErrorIs(t, bs.Allow("peer"), ErrCircuitOpen, "only one probe at a time")
+	bs.Record("peer", true)
+	assert.Equal(t, Open, bs.State("peer"))
+}
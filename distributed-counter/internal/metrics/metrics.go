@@ -0,0 +1,154 @@
+// Package metrics keeps latency histograms and writes them, with gauges, in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of latency
+// histograms: 1ms to 1m.
+var DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Histogram counts durations into buckets. It is safe for concurrent use.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, the last one for values above every bound
+	sum    float64
+}
+
+// NewHistogram returns a histogram with the given ascending upper bounds in
+// seconds.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// Observe records d. Negative durations, from skewed clocks, count as zero.
+func (h *Histogram) Observe(d time.Duration) {
+	v := max(d.Seconds(), 0)
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+}
+
+// Snapshot returns the current counts.
+func (h *Histogram) Snapshot() Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := Snapshot{Bounds: h.bounds, Cumulative: make([]uint64, len(h.bounds)), Sum: h.sum}
+	for i, n := range h.counts {
+		s.Count += n
+		if i < len(h.bounds) {
+			s.Cumulative[i] = s.Count
+		}
+	}
+	return s
+}
+
+// Snapshot is a point-in-time copy of a histogram.
+type Snapshot struct {
+	Bounds []float64
+	// Cumulative[i] is the number of observations at most Bounds[i].
+	Cumulative []uint64
+	Count      uint64
+	Sum        float64
+}
+
+// Quantile estimates the q-quantile, 0 < q <= 1, by interpolating within
+// its bucket. Values above the last bound report that bound.
+func (s Snapshot) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := q * float64(s.Count)
+	var lower float64
+	var below uint64
+	for i, upper := range s.Bounds {
+		if float64(s.Cumulative[i]) >= rank {
+			inBucket := s.Cumulative[i] - below
+			return lower + (upper-lower)*(rank-float64(below))/float64(inBucket)
+		}
+		lower, below = upper, s.Cumulative[i]
+	}
+	return lower
+}
+
+// Summary condenses a snapshot for JSON APIs.
+type Summary struct {
+	Count       uint64  `json:"count"`
+	MeanSeconds float64 `json:"mean_seconds"`
+	P50Seconds  float64 `json:"p50_seconds"`
+	P90Seconds  float64 `json:"p90_seconds"`
+	P99Seconds  float64 `json:"p99_seconds"`
+}
+
+func (s Snapshot) Summary() Summary {
+	if s.Count == 0 {
+		return Summary{}
+	}
+	return Summary{
+		Count:       s.Count,
+		MeanSeconds: s.Sum / float64(s.Count),
+		P50Seconds:  s.Quantile(0.5),
+		P90Seconds:  s.Quantile(0.9),
+		P99Seconds:  s.Quantile(0.99),
+	}
+}
+
+// WriteHeader writes the HELP and TYPE lines of a metric family; typ is
+// "gauge", "counter" or "histogram".
+func WriteHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// WriteGauge writes one sample. Labels are name, value pairs.
+func WriteGauge(w io.Writer, name string, value float64, labels ...string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+// WriteHistogram writes the bucket, sum and count samples of a snapshot.
+func WriteHistogram(w io.Writer, name string, s Snapshot, labels ...string) {
+	for i, bound := range s.Bounds {
+		le := append(labels[:len(labels):len(labels)], "le", formatValue(bound))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(le), s.Cumulative[i])
+	}
+	inf := append(labels[:len(labels):len(labels)], "le", "+Inf")
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(inf), s.Count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(labels), formatValue(s.Sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(labels), s.Count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
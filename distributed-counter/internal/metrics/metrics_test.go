@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram_Snapshot(t *testing.T) {
+	h := NewHistogram([]float64{0.01, 0.1, 1})
+	h.Observe(5 * time.Millisecond)
+	h.Observe(50 * time.Millisecond)
+	h.Observe(50 * time.Millisecond)
+	h.Observe(time.Minute)
+	h.Observe(-time.Second)
+
+	s := h.Snapshot()
+	assert.Equal(t, []uint64{2, 4, 4}, s.Cumulative)
+	assert.Equal(t, uint64(5), s.Count)
+	assert.InDelta(t, 60.105, s.Sum, 1e-9)
+}
+
+func TestSnapshot_Quantile(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 0.2, 0.4})
+	for i := 0; i < 10; i++ {
+		h.Observe(150 * time.Millisecond)
+	}
+	s := h.Snapshot()
+	assert.InDelta(t, 0.15, s.Quantile(0.5), 1e-9, "interpolates within the bucket")
+	assert.InDelta(t, 0.2, s.Quantile(1), 1e-9)
+
+	h.Observe(time.Hour)
+	assert.InDelta(t, 0.4, h.Snapshot().Quantile(1), 1e-9, "overflow reports the last bound")
+	assert.Zero(t, NewHistogram(DefaultLatencyBuckets).Snapshot().Quantile(0.5))
+	assert.Equal(t, Summary{}, NewHistogram(DefaultLatencyBuckets).Snapshot().Summary())
+}
+
+func TestWriteHistogram(t *testing.T) {
+	h := NewHistogram([]float64{0.5, 1})
+	h.Observe(250 * time.Millisecond)
+	h.Observe(2 * time.Second)
+
+	var b strings.Builder
+	WriteHeader(&b, "lag_seconds", "histogram", "Lag.")
+	WriteHistogram(&b, "lag_seconds", h.Snapshot(), "peer", `a"b`)
+	WriteGauge(&b, "value", 3)
+	assert.Equal(t, `# HELP lag_seconds Lag.
+# TYPE lag_seconds histogram
+lag_seconds_bucket{peer="a\"b",le="0.5"} 1
+lag_seconds_bucket{peer="a\"b",le="1"} 1
+lag_seconds_bucket{peer="a\"b",le="+Inf"} 2
+lag_seconds_sum{peer="a\"b"} 2.25
+lag_seconds_count{peer="a\"b"} 2
+value 3
+`, b.String())
+}
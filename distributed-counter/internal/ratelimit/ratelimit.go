@@ -0,0 +1,319 @@
+// Package ratelimit enforces per-key quotas across the cluster.
+//
+// Admissions are counted locally, per key, window and node. Every
+// ExchangeInterval each node sends the usage of its current windows to its
+// peers, which merge it by taking the maximum per node, like a G-counter, so
+// each node knows, with some lag, how much of each key's window every node
+// has used. Messages grow with the number of active keys, not with the
+// number of requests. A node decides alone, without asking peers: it admits
+// a request while the cluster-wide usage it has seen stays within the limit
+// and, unless borrowing is allowed, while its own usage stays within its
+// local budget, an equal share of the limit among the known nodes.
+package ratelimit
+
+import (
+	"distributed-counter/internal/clock"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoRule is returned for keys that no rule covers.
+var ErrNoRule = errors.New("no rate limit configured for key")
+
+// DefaultExchangeInterval is how often usage is sent to peers.
+const DefaultExchangeInterval = 1 * time.Second
+
+// Rule is the quota of a key: at most Limit per fixed Window.
+type Rule struct {
+	Limit  int64         `json:"limit"`
+	Window time.Duration `json:"window"`
+}
+
+// ParseRule parses "pattern=limit/window", e.g. "advertiser:*=1000/1m". A
+// pattern ending in * matches every key with that prefix.
+func ParseRule(s string) (string, Rule, error) {
+	pattern, quota, ok := strings.Cut(s, "=")
+	limit, window, ok2 := strings.Cut(quota, "/")
+	if !ok || !ok2 || pattern == "" {
+		return "", Rule{}, fmt.Errorf("rate limit %q is not pattern=limit/window", s)
+	}
+	n, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil || n <= 0 {
+		return "", Rule{}, fmt.Errorf("rate limit %q has an invalid limit", s)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return "", Rule{}, fmt.Errorf("rate limit %q has an invalid window", s)
+	}
+	return pattern, Rule{Limit: n, Window: d}, nil
+}
+
+// Config holds the tunables of a Limiter.
+type Config struct {
+	// Rules maps exact keys, or prefixes ending in *, to quotas. An exact
+	// match wins, then the longest prefix.
+	Rules map[string]Rule
+	// Borrow lets a node that has used its local budget keep admitting while
+	// the cluster as a whole appears to be under the limit.
+	Borrow bool
+	// ExchangeInterval is how often usage is sent to peers. Zero means
+	// DefaultExchangeInterval.
+	ExchangeInterval time.Duration
+	// Clock defines the windows; nil means the wall clock.
+	Clock clock.Clock
+}
+
+// Members reports the other known nodes, for sizing local budgets.
+type Members interface {
+	GetPeerAddrs() []string
+}
+
+// Propagator queues a message for delivery to peers, retrying failures. It
+// returns workpool.ErrSaturated, queueing nothing, when its queues are full.
+// It is satisfied by counter.Counter, so usage reports share the bounded
+// propagation pool and the circuit breakers of increments.
+type Propagator interface {
+	BroadcastTo(peers []string, path string, body interface{}, what string) error
+}
+
+// Decision is the answer to a Take.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Key     string `json:"key"`
+	Limit   int64  `json:"limit"`
+	// Used is the cluster-wide usage in this window as seen by this node,
+	// including this request if it was allowed.
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+	// LocalUsed and LocalBudget describe this node's share of the limit.
+	LocalUsed   int64 `json:"local_used"`
+	LocalBudget int64 `json:"local_budget"`
+}
+
+// Usage is one key's usage in one window, per origin node. It is what nodes
+// exchange.
+type Usage struct {
+	Key    string           `json:"key"`
+	Start  int64            `json:"start"` // window start, Unix nanoseconds
+	Window time.Duration    `json:"window"`
+	Nodes  map[string]int64 `json:"nodes"`
+}
+
+// usage is one key's usage in its current window.
+type usage struct {
+	start  int64
+	window time.Duration
+	nodes  map[string]int64
+}
+
+// Limiter answers Take requests for configured keys.
+type Limiter struct {
+	selfID     string
+	members    Members
+	propagator Propagator
+	cfg        Config
+	clock      clock.Clock
+
+	mu    sync.Mutex
+	usage map[string]*usage
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// expireInterval is how often usage of ended windows is dropped.
+const expireInterval = 1 * time.Minute
+
+// New creates a limiter and starts exchanging usage with peers. Route
+// /ratelimit/usage messages to its Merge method.
+func New(selfID string, members Members, propagator Propagator, cfg Config) *Limiter {
+	if cfg.ExchangeInterval <= 0 {
+		cfg.ExchangeInterval = DefaultExchangeInterval
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+	l := &Limiter{
+		selfID:     selfID,
+		members:    members,
+		propagator: propagator,
+		cfg:        cfg,
+		clock:      cfg.Clock,
+		usage:      make(map[string]*usage),
+		stop:       make(chan struct{}),
+	}
+	l.wg.Add(1)
+	go l.loop()
+	return l
+}
+
+// Close stops the background exchange and expiry.
+func (l *Limiter) Close() {
+	l.stopOnce.Do(func() { close(l.stop) })
+	l.wg.Wait()
+}
+
+func (l *Limiter) loop() {
+	defer l.wg.Done()
+	expire := l.clock.NewTicker(expireInterval)
+	defer expire.Stop()
+	exchange := l.clock.NewTicker(l.cfg.ExchangeInterval)
+	defer exchange.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-expire.C():
+			l.expire()
+		case <-exchange.C():
+			l.exchange()
+		}
+	}
+}
+
+// Rule returns the quota that applies to key.
+func (l *Limiter) Rule(key string) (Rule, bool) {
+	if rule, ok := l.cfg.Rules[key]; ok {
+		return rule, true
+	}
+	best := -1
+	var match Rule
+	for pattern, rule := range l.cfg.Rules {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if ok && strings.HasPrefix(key, prefix) && len(prefix) > best {
+			best, match = len(prefix), rule
+		}
+	}
+	return match, best >= 0
+}
+
+// Take asks to use n units of key's quota. Allowed requests are counted
+// here and reach peers with the next exchange; denied ones leave no trace.
+func (l *Limiter) Take(key string, n int64) (Decision, error) {
+	rule, ok := l.Rule(key)
+	if !ok {
+		return Decision{}, ErrNoRule
+	}
+	if n <= 0 {
+		return Decision{}, errors.New("n must be positive")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u := l.currentLocked(key, rule, l.clock.Now())
+	var used int64
+	for _, count := range u.nodes {
+		used += count
+	}
+	local := u.nodes[l.selfID]
+	budget := l.budget(rule)
+
+	d := Decision{
+		Key:         key,
+		Limit:       rule.Limit,
+		ResetAt:     time.Unix(0, u.start).Add(rule.Window),
+		LocalBudget: budget,
+	}
+	d.Allowed = used+n <= rule.Limit && (local+n <= budget || l.cfg.Borrow)
+	if !d.Allowed {
+		d.Used, d.LocalUsed = used, local
+		d.Remaining = max(rule.Limit-used, 0)
+		return d, nil
+	}
+	u.nodes[l.selfID] += n
+	d.Used, d.LocalUsed = used+n, local+n
+	d.Remaining = rule.Limit - d.Used
+	return d, nil
+}
+
+// budget is this node's equal share of the limit, rounded up.
+func (l *Limiter) budget(rule Rule) int64 {
+	nodes := int64(len(l.members.GetPeerAddrs()) + 1)
+	return (rule.Limit + nodes - 1) / nodes
+}
+
+// currentLocked returns key's usage in the window containing now, starting
+// a fresh one when the previous window has ended.
+func (l *Limiter) currentLocked(key string, rule Rule, now time.Time) *usage {
+	start := now.Truncate(rule.Window).UnixNano()
+	u, ok := l.usage[key]
+	if !ok || u.start != start || u.window != rule.Window {
+		u = &usage{start: start, window: rule.Window, nodes: make(map[string]int64)}
+		l.usage[key] = u
+	}
+	return u
+}
+
+// Usage returns the usage of every key in its current window.
+func (l *Limiter) Usage() []Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now().UnixNano()
+	report := make([]Usage, 0, len(l.usage))
+	for key, u := range l.usage {
+		if now >= u.start+int64(u.window) {
+			continue
+		}
+		nodes := make(map[string]int64, len(u.nodes))
+		for node, n := range u.nodes {
+			nodes[node] = n
+		}
+		report = append(report, Usage{Key: key, Start: u.start, Window: u.window, Nodes: nodes})
+	}
+	return report
+}
+
+// Merge takes a peer's usage into account. Usage of a window other than the
+// key's current one is ignored, and each node's count only ever grows, so
+// merging the same report twice or out of order changes nothing.
+func (l *Limiter) Merge(report []Usage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	for _, r := range report {
+		rule, ok := l.Rule(r.Key)
+		if !ok {
+			continue
+		}
+		u := l.currentLocked(r.Key, rule, now)
+		if r.Start != u.start || r.Window != u.window {
+			continue
+		}
+		for node, n := range r.Nodes {
+			u.nodes[node] = max(u.nodes[node], n)
+		}
+	}
+}
+
+// exchange queues the usage of the current windows for every peer, best
+// effort; a peer that misses it gets the same counts, or higher ones, with
+// the next exchange.
+func (l *Limiter) exchange() {
+	report := l.Usage()
+	if len(report) == 0 {
+		return
+	}
+	if err := l.propagator.BroadcastTo(l.members.GetPeerAddrs(), "/ratelimit/usage", report, "rate limit usage"); err != nil {
+		log.Printf("Failed to queue rate limit usage: %v", err)
+	}
+}
+
+// expire forgets keys whose window has ended, bounding memory to the keys
+// used recently.
+func (l *Limiter) expire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now().UnixNano()
+	for key, u := range l.usage {
+		if now >= u.start+int64(u.window) {
+			delete(l.usage, key)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/workpool"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePropagator records the usage queued for each peer.
+type fakePropagator struct {
+	mu   sync.Mutex
+	sent map[string][][]Usage
+	err  error // returned instead of queueing
+}
+
+func (f *fakePropagator) BroadcastTo(peers []string, path string, body interface{}, what string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if path != "/ratelimit/usage" {
+		return fmt.Errorf("unexpected path %s", path)
+	}
+	if f.err != nil {
+		return f.err
+	}
+	for _, addr := range peers {
+		f.sent[addr] = append(f.sent[addr], body.([]Usage))
+	}
+	return nil
+}
+
+func (f *fakePropagator) saturate(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakePropagator) reports(addr string) [][]Usage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sent[addr]
+}
+
+type fakeMembers []string
+
+func (m fakeMembers) GetPeerAddrs() []string { return m }
+
+func newLimiter(t *testing.T, borrow bool, peers ...string) (*Limiter, *fakePropagator, *clock.Fake) {
+	fake := clock.NewFake(time.Unix(1_000_000_000, 0).Truncate(time.Minute))
+	tr := &fakePropagator{sent: make(map[string][][]Usage)}
+	l := New("self:8080", fakeMembers(peers), tr, Config{
+		Rules: map[string]Rule{
+			"advertiser:*":  {Limit: 10, Window: time.Minute},
+			"advertiser:42": {Limit: 2, Window: time.Minute},
+		},
+		Borrow:           borrow,
+		ExchangeInterval: time.Second,
+		Clock:            fake,
+	})
+	t.Cleanup(l.Close)
+	return l, tr, fake
+}
+
+func take(t *testing.T, l *Limiter, key string, n int64) Decision {
+	d, err := l.Take(key, n)
+	require.NoError(t, err)
+	return d
+}
+
+func TestParseRule(t *testing.T) {
+	pattern, rule, err := ParseRule("advertiser:*=1000/1m")
+	require.NoError(t, err)
+	assert.Equal(t, "advertiser:*", pattern)
+	assert.Equal(t, Rule{Limit: 1000, Window: time.Minute}, rule)
+
+	for _, bad := range []string{"x", "x=10", "=10/1m", "x=0/1m", "x=10/soon", "x=10/-1s"} {
+		_, _, err := ParseRule(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestLimiter_RuleMatching(t *testing.T) {
+	l, _, _ := newLimiter(t, true)
+	rule, ok := l.Rule("advertiser:42")
+	require.True(t, ok)
+	assert.Equal(t, int64(2), rule.Limit, "exact keys win over prefixes")
+
+	rule, ok = l.Rule("advertiser:7")
+	require.True(t, ok)
+	assert.Equal(t, int64(10), rule.Limit)
+
+	_, err := l.Take("campaign:1", 1)
+	assert.ErrorIs(t, err, ErrNoRule)
+}
+
+func TestLimiter_EnforcesLimitPerWindow(t *testing.T) {
+	l, _, fake := newLimiter(t, true)
+
+	assert.True(t, take(t, l, "advertiser:7", 6).Allowed)
+	d := take(t, l, "advertiser:7", 4)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, int64(0), d.Remaining)
+	assert.False(t, take(t, l, "advertiser:7", 1).Allowed)
+	assert.Equal(t, []Usage{{
+		Key:    "advertiser:7",
+		Start:  fake.Now().UnixNano(),
+		Window: time.Minute,
+		Nodes:  map[string]int64{"self:8080": 10},
+	}}, l.Usage(), "denied requests are not counted")
+
+	fake.Advance(time.Minute)
+	assert.True(t, take(t, l, "advertiser:7", 1).Allowed, "a new window starts empty")
+}
+
+func TestLimiter_MergesUsageFromPeers(t *testing.T) {
+	l, _, fake := newLimiter(t, true, "peer1:8081")
+	start := fake.Now().UnixNano()
+	l.Merge([]Usage{
+		{Key: "advertiser:7", Start: start, Window: time.Minute, Nodes: map[string]int64{"peer1:8081": 9}},
+		// Usage from a previous window doesn't count.
+		{Key: "advertiser:7", Start: start - int64(time.Minute), Window: time.Minute, Nodes: map[string]int64{"peer2:8082": 9}},
+		{Key: "campaign:1", Start: start, Window: time.Minute, Nodes: map[string]int64{"peer1:8081": 9}},
+	})
+	// An older report of the same window doesn't lower the count.
+	l.Merge([]Usage{{Key: "advertiser:7", Start: start, Window: time.Minute, Nodes: map[string]int64{"peer1:8081": 4}}})
+
+	d := take(t, l, "advertiser:7", 1)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, int64(10), d.Used)
+	assert.False(t, take(t, l, "advertiser:7", 1).Allowed)
+	assert.Len(t, l.Usage(), 1, "keys without a rule are not kept")
+}
+
+func TestLimiter_ExchangesUsageWithPeers(t *testing.T) {
+	l, tr, fake := newLimiter(t, true, "peer1:8081", "peer2:8082")
+	fake.Advance(time.Second)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, tr.reports("peer1:8081"), "nothing to send without usage")
+
+	for i := 0; i < 5; i++ {
+		take(t, l, "advertiser:7", 1)
+	}
+	fake.Advance(time.Second)
+	for _, peer := range []string{"peer1:8081", "peer2:8082"} {
+		assert.Eventually(t, func() bool { return len(tr.reports(peer)) == 1 }, time.Second, time.Millisecond)
+		report := tr.reports(peer)[0]
+		require.Len(t, report, 1, "one entry per key, however many requests")
+		assert.Equal(t, map[string]int64{"self:8080": 5}, report[0].Nodes)
+	}
+
+	peer := New("peer1:8081", fakeMembers{"self:8080"}, tr, Config{Rules: l.cfg.Rules, Borrow: true, Clock: fake})
+	defer peer.Close()
+	peer.Merge(tr.reports("peer1:8081")[0])
+	d := take(t, peer, "advertiser:7", 1)
+	assert.Equal(t, int64(6), d.Used)
+	assert.Equal(t, int64(1), d.LocalUsed)
+}
+
+func TestLimiter_LocalBudgetWithoutBorrowing(t *testing.T) {
+	l, _, _ := newLimiter(t, false, "peer1:8081")
+
+	d := take(t, l, "advertiser:7", 5)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, int64(5), d.LocalBudget)
+	assert.False(t, take(t, l, "advertiser:7", 1).Allowed, "the other half belongs to the peer")
+
+	borrowing, _, _ := newLimiter(t, true, "peer1:8081")
+	assert.True(t, take(t, borrowing, "advertiser:7", 8).Allowed)
+}
+
+func TestLimiter_ExpiresEndedWindows(t *testing.T) {
+	l, _, fake := newLimiter(t, true)
+	take(t, l, "advertiser:7", 1)
+	fake.Advance(time.Minute)
+	l.expire()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	assert.Empty(t, l.usage)
+}
+
+func TestLimiter_ExchangeSkippedWhileQueuesAreFull(t *testing.T) {
+	l, tr, fake := newLimiter(t, true, "peer1:8081")
+	take(t, l, "advertiser:7", 3)
+	tr.saturate(workpool.ErrSaturated)
+	fake.Advance(time.Second)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, tr.reports("peer1:8081"))
+
+	tr.saturate(nil)
+	fake.Advance(time.Second)
+	assert.Eventually(t, func() bool { return len(tr.reports("peer1:8081")) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, map[string]int64{"self:8080": 3}, tr.reports("peer1:8081")[0][0].Nodes, "the next exchange carries the usage")
+}
@@ -0,0 +1,118 @@
+// Package ring places keys on nodes with consistent hashing.
+//
+// Every member owns several points on a 64-bit hash ring. A key belongs to
+// the members owning the first points at or after the key's hash, walking
+// clockwise, so adding or removing a member only moves the keys next to its
+// points: about 1/N of them. Shards keeps such a ring over the changing
+// membership for the structures that shard their keys, and works out what
+// each rebalance has to move.
+package ring
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// DefaultVirtualNodes is how many points each member has by default. More
+// points spread keys more evenly at the cost of a larger ring.
+const DefaultVirtualNodes = 128
+
+type point struct {
+	hash   uint64
+	member string
+}
+
+// Ring is an immutable consistent-hash ring. It is safe for concurrent use.
+type Ring struct {
+	members []string // sorted
+	points  []point  // sorted by hash
+}
+
+// New returns a ring of the given members, each with vnodes points; zero
+// means DefaultVirtualNodes. Duplicate members count once.
+func New(members []string, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	r := &Ring{}
+	seen := make(map[string]bool, len(members))
+	for _, m := range members {
+		if !seen[m] {
+			seen[m] = true
+			r.members = append(r.members, m)
+		}
+	}
+	sort.Strings(r.members)
+	r.points = make([]point, 0, len(r.members)*vnodes)
+	for _, m := range r.members {
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, point{hash: hash(m, uint64(i)), member: m})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		a, b := r.points[i], r.points[j]
+		return a.hash < b.hash || (a.hash == b.hash && a.member < b.member)
+	})
+	return r
+}
+
+// Members returns the members of the ring, sorted.
+func (r *Ring) Members() []string {
+	return append([]string(nil), r.members...)
+}
+
+// SameMembers reports whether r and other have the same members.
+func (r *Ring) SameMembers(other *Ring) bool {
+	if len(r.members) != len(other.members) {
+		return false
+	}
+	for i, m := range r.members {
+		if other.members[i] != m {
+			return false
+		}
+	}
+	return true
+}
+
+// Owners returns the n distinct members that own key, in preference order,
+// or every member if there are fewer.
+func (r *Ring) Owners(key string, n int) []string {
+	n = min(n, len(r.members))
+	if n <= 0 {
+		return nil
+	}
+	h := hash(key, 0)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	owners := make([]string, 0, n)
+	for i := 0; len(owners) < n; i++ {
+		m := r.points[(start+i)%len(r.points)].member
+		if !contains(owners, m) {
+			owners = append(owners, m)
+		}
+	}
+	return owners
+}
+
+func contains(members []string, m string) bool {
+	for _, o := range members {
+		if o == m {
+			return true
+		}
+	}
+	return false
+}
+
+// hash places a string on the ring. FNV-1a alone clusters similar short
+// strings, such as "node-1" and "node-2", so its result goes through the
+// SplitMix64 finalizer.
+func hash(s string, seed uint64) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64() ^ (seed * 0x9e3779b97f4a7c15)
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,48 @@
+package ring
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing_Owners(t *testing.T) {
+	r := New([]string{"c", "a", "b", "a"}, 0)
+	assert.Equal(t, []string{"a", "b", "c"}, r.Members())
+
+	owners := r.Owners("key", 2)
+	assert.Len(t, owners, 2)
+	assert.NotEqual(t, owners[0], owners[1])
+	assert.Equal(t, owners, New([]string{"b", "c", "a"}, 0).Owners("key", 2), "placement doesn't depend on member order")
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, r.Owners("key", 5), "capped at the member count")
+	assert.Nil(t, New(nil, 0).Owners("key", 2))
+}
+
+func TestRing_SpreadsKeysEvenly(t *testing.T) {
+	r := New([]string{"node-0:8080", "node-1:8080", "node-2:8080", "node-3:8080"}, 0)
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[r.Owners(fmt.Sprintf("key-%d", i), 1)[0]]++
+	}
+	for member, n := range counts {
+		assert.InDelta(t, 2500, n, 500, "member %s", member)
+	}
+}
+
+func TestRing_AddingAMemberMovesFewKeys(t *testing.T) {
+	before := New([]string{"node-0:8080", "node-1:8080", "node-2:8080", "node-3:8080"}, 0)
+	after := New(append(before.Members(), "node-4:8080"), 0)
+	assert.False(t, before.SameMembers(after))
+	assert.True(t, after.SameMembers(New(after.Members(), 0)))
+
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if owner := after.Owners(key, 1)[0]; owner != before.Owners(key, 1)[0] {
+			assert.Equal(t, "node-4:8080", owner, "keys only move to the new member")
+			moved++
+		}
+	}
+	assert.InDelta(t, 2000, moved, 500)
+}
@@ -0,0 +1,214 @@
+package ring
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRebalanceInterval is how often a sharded node checks membership
+	// for changes by default.
+	DefaultRebalanceInterval = time.Second
+	// handoffTimeout bounds each message handed to a new owner.
+	handoffTimeout = 2 * time.Second
+)
+
+// ShardingConfig places each key, such as the name of a keyed or distinct
+// counter, on a few nodes instead of all of them.
+type ShardingConfig struct {
+	// Replicas is how many nodes hold each key, chosen by a consistent-hash
+	// ring over the membership. Zero keeps every key on every node.
+	Replicas int
+	// VirtualNodes is how many points each node has on the ring. Zero means
+	// DefaultVirtualNodes.
+	VirtualNodes int
+	// RebalanceInterval is how often membership is checked for changes. Zero
+	// means DefaultRebalanceInterval.
+	RebalanceInterval time.Duration
+}
+
+func (s *ShardingConfig) setDefaults() {
+	if s.RebalanceInterval <= 0 {
+		s.RebalanceInterval = DefaultRebalanceInterval
+	}
+}
+
+// Members reports the other known nodes.
+type Members interface {
+	GetPeerAddrs() []string
+}
+
+// Transport sends internal messages to peers.
+type Transport interface {
+	Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error
+}
+
+// Shards places keys on the ring of the current membership and tracks what
+// the next rebalance has to move. Replicated structures that shard their
+// keys embed it and supply what they hand off.
+type Shards struct {
+	selfID    string
+	members   Members
+	transport Transport
+	cfg       ShardingConfig
+
+	mu       sync.Mutex
+	current  *Ring // of the latest membership seen
+	balanced *Ring // as of the last rebalance
+	// retry holds the keys the next rebalance sends to all of their owners:
+	// those the last one failed to hand off, and those that arrived here
+	// although this node doesn't own them.
+	retry map[string]bool
+}
+
+// NewShards returns the placement of keys for the node selfID.
+func NewShards(selfID string, members Members, transport Transport, cfg ShardingConfig) *Shards {
+	cfg.setDefaults()
+	return &Shards{selfID: selfID, members: members, transport: transport, cfg: cfg}
+}
+
+// Sharded reports whether keys are placed on a subset of nodes.
+func (s *Shards) Sharded() bool {
+	return s.cfg.Replicas > 0
+}
+
+// ring returns the hash ring of the current membership: this node and its
+// peers.
+func (s *Shards) ring() *Ring {
+	members := append(s.members.GetPeerAddrs(), s.selfID)
+	sort.Strings(members)
+	members = slices.Compact(members)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil || !slices.Equal(s.current.Members(), members) {
+		s.current = New(members, s.cfg.VirtualNodes)
+	}
+	return s.current
+}
+
+// Owners returns the nodes that hold a key, in preference order, or nil
+// when every node holds it: unsharded, or for the empty key, such as that of
+// unkeyed increments.
+func (s *Shards) Owners(key string) []string {
+	if !s.Sharded() || key == "" {
+		return nil
+	}
+	return s.ring().Owners(key, s.cfg.Replicas)
+}
+
+// Owns reports whether this node holds a key.
+func (s *Shards) Owns(key string) bool {
+	owners := s.Owners(key)
+	return owners == nil || slices.Contains(owners, s.selfID)
+}
+
+// OtherOwners returns the owners of a key other than this node, or nil when
+// unsharded.
+func (s *Shards) OtherOwners(key string) []string {
+	var peers []string
+	for _, addr := range s.Owners(key) {
+		if addr != s.selfID {
+			peers = append(peers, addr)
+		}
+	}
+	return peers
+}
+
+// Retry schedules a key for the next rebalance, which sends it to all of its
+// owners.
+func (s *Shards) Retry(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retry == nil {
+		s.retry = make(map[string]bool)
+	}
+	s.retry[key] = true
+}
+
+// SendHandoff sends one message of keys to an owner, within a timeout.
+func (s *Shards) SendHandoff(ctx context.Context, addr, path string, body interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, handoffTimeout)
+	defer cancel()
+	return s.transport.Send(ctx, addr, path, body, nil)
+}
+
+// RebalanceLoop calls rebalance every RebalanceInterval until stop closes.
+func (s *Shards) RebalanceLoop(clk clock.Clock, stop <-chan struct{}, rebalance func(context.Context)) {
+	ticker := clk.NewTicker(s.cfg.RebalanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+		}
+		rebalance(context.Background())
+	}
+}
+
+// StartRebalance begins a rebalance if membership changed since the last
+// one, keys were scheduled for retry, or none ran yet. Otherwise it returns
+// false. Call Finish on the result when done.
+func (s *Shards) StartRebalance() (*Rebalance, bool) {
+	if !s.Sharded() {
+		return nil, false
+	}
+	current := s.ring()
+	s.mu.Lock()
+	previous, retry := s.balanced, s.retry
+	s.retry = nil
+	s.mu.Unlock()
+	if len(retry) == 0 && previous != nil && previous.SameMembers(current) {
+		return nil, false
+	}
+	return &Rebalance{Current: current, shards: s, previous: previous, retry: retry}, true
+}
+
+// Rebalance is one pass over the keys held here, moving them to the owners
+// they gained.
+type Rebalance struct {
+	// Current is the ring keys are placed on.
+	Current  *Ring
+	shards   *Shards
+	previous *Ring // nil before the first rebalance
+	retry    map[string]bool
+}
+
+// Owns reports whether this node holds a key on the current ring.
+func (r *Rebalance) Owns(key string) bool {
+	return slices.Contains(r.Current.Owners(key, r.shards.cfg.Replicas), r.shards.selfID)
+}
+
+// Targets returns the owners other than this node that a key goes to: all
+// of them if all is set, the key was scheduled for retry or no rebalance
+// ran before, otherwise only those it gained since the last one.
+func (r *Rebalance) Targets(key string, all bool) []string {
+	replicas := r.shards.cfg.Replicas
+	all = all || r.previous == nil || r.retry[key]
+	var targets []string
+	for _, addr := range r.Current.Owners(key, replicas) {
+		if addr != r.shards.selfID && (all || !slices.Contains(r.previous.Owners(key, replicas), addr)) {
+			targets = append(targets, addr)
+		}
+	}
+	return targets
+}
+
+// Finish records the rebalance as done, scheduling the keys that failed for
+// the next one.
+func (r *Rebalance) Finish(failed map[string]bool) {
+	s := r.shards
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balanced = r.Current
+	for key := range failed {
+		if s.retry == nil {
+			s.retry = make(map[string]bool)
+		}
+		s.retry[key] = true
+	}
+}
@@ -0,0 +1,96 @@
+package ring
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type peers []string
+
+func (p *peers) GetPeerAddrs() []string { return append([]string(nil), *p...) }
+
+type sends struct{ to []string }
+
+func (s *sends) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("no timeout")
+	}
+	s.to = append(s.to, addr)
+	return nil
+}
+
+// gainedKey returns a key whose owner changed between the rings to a node
+// other than self.
+func gainedKey(t *testing.T, before, after *Ring, self string) string {
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		if len(after.Owners(key, 1)) == 1 && after.Owners(key, 1)[0] != before.Owners(key, 1)[0] && after.Owners(key, 1)[0] != self {
+			return key
+		}
+	}
+	t.Fatal("no key moved")
+	return ""
+}
+
+func TestShards_Owners(t *testing.T) {
+	members := &peers{"peer1:8081", "peer2:8082"}
+	unsharded := NewShards("self:8080", members, &sends{}, ShardingConfig{})
+	assert.Nil(t, unsharded.Owners("key"))
+	assert.True(t, unsharded.Owns("key"))
+	assert.Nil(t, unsharded.OtherOwners("key"))
+
+	s := NewShards("self:8080", members, &sends{}, ShardingConfig{Replicas: 2})
+	owners := s.Owners("key")
+	assert.Equal(t, New([]string{"self:8080", "peer1:8081", "peer2:8082"}, 0).Owners("key", 2), owners)
+	assert.Equal(t, s.Owns("key"), len(s.OtherOwners("key")) == 1)
+	assert.Nil(t, s.Owners(""), "the empty key is everywhere")
+}
+
+func TestShards_RebalanceOnlyWhenNeeded(t *testing.T) {
+	members := &peers{"peer1:8081"}
+	s := NewShards("self:8080", members, &sends{}, ShardingConfig{Replicas: 1})
+
+	rb, ok := s.StartRebalance()
+	require.True(t, ok, "the first rebalance always runs")
+	assert.Equal(t, s.OtherOwners("a"), rb.Targets("a", false))
+	rb.Finish(nil)
+	_, ok = s.StartRebalance()
+	assert.False(t, ok, "nothing changed")
+
+	s.Retry("a")
+	rb, ok = s.StartRebalance()
+	require.True(t, ok)
+	rb.Finish(map[string]bool{"a": true})
+	_, ok = s.StartRebalance()
+	assert.True(t, ok, "failed keys are retried")
+}
+
+func TestShards_TargetsAreTheOwnersGained(t *testing.T) {
+	members := &peers{"peer1:8081"}
+	s := NewShards("self:8080", members, &sends{}, ShardingConfig{Replicas: 1})
+	rb, _ := s.StartRebalance()
+	before := rb.Current
+	rb.Finish(nil)
+
+	*members = append(*members, "peer2:8082", "peer3:8083")
+	rb, ok := s.StartRebalance()
+	require.True(t, ok, "membership changed")
+	key := gainedKey(t, before, rb.Current, "self:8080")
+	assert.Equal(t, rb.Current.Owners(key, 1), rb.Targets(key, false))
+	for _, unmoved := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		if before.Owners(unmoved, 1)[0] == rb.Current.Owners(unmoved, 1)[0] {
+			assert.Empty(t, rb.Targets(unmoved, false), "key %s didn't move", unmoved)
+			assert.Equal(t, s.OtherOwners(unmoved), rb.Targets(unmoved, true))
+		}
+	}
+}
+
+func TestShards_SendHandoffHasATimeout(t *testing.T) {
+	tr := &sends{}
+	s := NewShards("self:8080", &peers{"peer1:8081"}, tr, ShardingConfig{Replicas: 1})
+	require.NoError(t, s.SendHandoff(context.Background(), "peer1:8081", "/handoff", nil))
+	assert.Equal(t, []string{"peer1:8081"}, tr.to)
+}
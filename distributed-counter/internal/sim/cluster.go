@@ -0,0 +1,291 @@
+package sim
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/distinct"
+	"distributed-counter/internal/transport"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNodeDown is returned for operations on a killed node.
+var ErrNodeDown = errors.New("sim: node is down")
+
+// stepInterval is how far the clock moves per poll while awaiting a condition.
+const stepInterval = 100 * time.Millisecond
+
+// Options configures a simulated cluster.
+type Options struct {
+	Nodes int
+	// Seed makes fault injection reproducible.
+	Seed int64
+	// Counter configures every node's counter. Zero retry settings are
+	// shortened so that failed propagations settle quickly in tests.
+	Counter counter.Config
+	// Distinct configures every node's distinct counters.
+	Distinct distinct.Config
+}
+
+// Node is one simulated process.
+type Node struct {
+	Addr     string
+	Registry *cluster.Registry
+	Counter  *counter.Counter
+	Distinct *distinct.Counters
+	Server   *transport.Server
+	up       bool
+}
+
+// Cluster runs several nodes in process over a simulated network.
+type Cluster struct {
+	Net   *Network
+	Clock *clock.Fake
+
+	opts  Options
+	mu    sync.Mutex
+	nodes []*Node
+}
+
+// NewCluster starts opts.Nodes nodes. Node 0 is the seed for the others.
+func NewCluster(opts Options) *Cluster {
+	if opts.Counter.RetryInitialInterval == 0 {
+		opts.Counter.RetryInitialInterval = 10 * time.Millisecond
+	}
+	if opts.Counter.RetryMaxElapsedTime == 0 {
+		opts.Counter.RetryMaxElapsedTime = 3 * time.Second
+	}
+	fake := clock.NewFake(time.Unix(0, 0))
+	c := &Cluster{
+		Net:   NewNetwork(fake, opts.Seed),
+		Clock: fake,
+		opts:  opts,
+	}
+	for i := 0; i < opts.Nodes; i++ {
+		n := &Node{Addr: fmt.Sprintf("node-%d:8080", i)}
+		c.nodes = append(c.nodes, n)
+		var seeds []string
+		if i > 0 {
+			seeds = []string{c.nodes[0].Addr}
+		}
+		c.start(n, seeds)
+	}
+	return c
+}
+
+func (c *Cluster) start(n *Node, seeds []string) {
+	ep := c.Net.Endpoint(n.Addr)
+	n.Registry = cluster.NewRegistryWithConfig(n.Addr, ep, cluster.Config{
+		Heartbeats: cluster.DefaultHeartbeatPool,
+		Clock:      c.Clock,
+	})
+	cfg := c.opts.Counter
+	cfg.Clock = c.Clock
+	n.Counter = counter.NewCounterWithConfig(n.Addr, n.Registry, ep, cfg)
+	distinctCfg := c.opts.Distinct
+	distinctCfg.Clock = c.Clock
+	n.Distinct = distinct.New(n.Addr, n.Registry, ep, n.Counter, distinctCfg)
+	n.Server = transport.NewServer(n.Registry, n.Counter)
+	n.Server.SetDistinct(n.Distinct)
+	c.Net.Attach(n.Addr, n.Server.InternalRoutes())
+	n.up = true
+	n.Registry.Start(seeds)
+}
+
+// Node returns the i-th node.
+func (c *Cluster) Node(i int) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodes[i]
+}
+
+// Addrs returns the addresses of the nodes with the given indexes.
+func (c *Cluster) Addrs(idx ...int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	addrs := make([]string, len(idx))
+	for i, j := range idx {
+		addrs[i] = c.nodes[j].Addr
+	}
+	return addrs
+}
+
+// Increment counts one increment on the i-th node.
+func (c *Cluster) Increment(i int) error {
+	n := c.Node(i)
+	c.mu.Lock()
+	up := n.up
+	c.mu.Unlock()
+	if !up {
+		return ErrNodeDown
+	}
+	return n.Counter.IncrementAndPropagate()
+}
+
+// Kill takes the i-th node off the network and stops it, losing its state.
+func (c *Cluster) Kill(i int) {
+	c.mu.Lock()
+	n := c.nodes[i]
+	if !n.up {
+		c.mu.Unlock()
+		return
+	}
+	n.up = false
+	c.mu.Unlock()
+
+	c.Net.Detach(n.Addr)
+	n.Registry.Stop()
+	n.Distinct.Close()
+	// Propagations still running give up once their retries run out.
+	go n.Counter.Close()
+}
+
+// Restart brings a killed node back with empty state, joining through the
+// live nodes and catching up from them in the background.
+func (c *Cluster) Restart(i int) {
+	c.mu.Lock()
+	n := c.nodes[i]
+	if n.up {
+		c.mu.Unlock()
+		return
+	}
+	var seeds []string
+	for _, other := range c.nodes {
+		if other != n && other.up {
+			seeds = append(seeds, other.Addr)
+		}
+	}
+	fresh := &Node{Addr: n.Addr}
+	c.nodes[i] = fresh
+	c.mu.Unlock()
+
+	c.start(fresh, seeds)
+	if len(seeds) > 0 {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			fresh.Counter.CatchUp(ctx)
+			fresh.Distinct.CatchUp(ctx)
+		}()
+	}
+}
+
+// Up reports whether the i-th node is running.
+func (c *Cluster) Up(i int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodes[i].up
+}
+
+// Partition splits the nodes into groups by index; see Network.Partition.
+func (c *Cluster) Partition(groups ...[]int) {
+	addrs := make([][]string, len(groups))
+	for i, group := range groups {
+		addrs[i] = c.Addrs(group...)
+	}
+	c.Net.Partition(addrs...)
+}
+
+// Heal removes any partition.
+func (c *Cluster) Heal() {
+	c.Net.Heal()
+}
+
+// Step advances the simulated clock and gives the nodes a moment to react.
+func (c *Cluster) Step(d time.Duration) {
+	c.Clock.Advance(d)
+	time.Sleep(time.Millisecond)
+}
+
+// Values returns the counter value of every running node, by address.
+func (c *Cluster) Values() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make(map[string]int64)
+	for _, n := range c.nodes {
+		if n.up {
+			values[n.Addr] = n.Counter.Value()
+		}
+	}
+	return values
+}
+
+// AwaitConvergence steps the clock until every running node reports want,
+// or returns an error with the last values after timeout of real time.
+func (c *Cluster) AwaitConvergence(want int64, timeout time.Duration) error {
+	return c.await(timeout, func() error {
+		for addr, v := range c.Values() {
+			if v != want {
+				return fmt.Errorf("node %s has %d, want %d (values: %v)", addr, v, want, c.Values())
+			}
+		}
+		return nil
+	})
+}
+
+// AwaitMembership steps the clock until every running node knows exactly
+// the other running nodes.
+func (c *Cluster) AwaitMembership(timeout time.Duration) error {
+	return c.await(timeout, func() error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var live []string
+		for _, n := range c.nodes {
+			if n.up {
+				live = append(live, n.Addr)
+			}
+		}
+		for _, n := range c.nodes {
+			if !n.up {
+				continue
+			}
+			got := append(n.Registry.GetPeerAddrs(), n.Addr)
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(live) {
+				return fmt.Errorf("node %s knows %v, want %v", n.Addr, got, live)
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Cluster) await(timeout time.Duration, check func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		c.Step(stepInterval)
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Close stops every node.
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	nodes := append([]*Node(nil), c.nodes...)
+	c.mu.Unlock()
+	for _, n := range nodes {
+		c.Net.Detach(n.Addr)
+	}
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.Registry.Stop()
+			n.Distinct.Close()
+			n.Counter.Close()
+		}()
+	}
+	wg.Wait()
+}
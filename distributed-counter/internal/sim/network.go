@@ -0,0 +1,251 @@
+// Package sim runs several nodes in one process over a simulated network
+// with fault injection and a manually advanced clock, to test convergence.
+package sim
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"distributed-counter/internal/wire"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDropped is returned when the network loses a message.
+	ErrDropped = errors.New("sim: message dropped")
+	// ErrUnreachable is returned when the destination is down or partitioned away.
+	ErrUnreachable = errors.New("sim: destination unreachable")
+)
+
+// Faults describes how the network misbehaves on a link.
+type Faults struct {
+	DropRate      float64       // fraction of messages lost before delivery
+	DuplicateRate float64       // fraction of messages delivered twice
+	ReorderRate   float64       // fraction of messages held back by up to MaxDelay extra
+	Latency       time.Duration // base one-way delay
+	MaxDelay      time.Duration
+}
+
+// Stats counts what happened to messages sent over the network.
+type Stats struct {
+	Sent        uint64 `json:"sent"`
+	Delivered   uint64 `json:"delivered"`
+	Dropped     uint64 `json:"dropped"`
+	Duplicated  uint64 `json:"duplicated"`
+	Reordered   uint64 `json:"reordered"`
+	Unreachable uint64 `json:"unreachable"`
+}
+
+type link struct{ from, to string }
+
+type attachment struct {
+	routes map[string]wire.HandlerFunc
+	down   chan struct{}
+}
+
+// Network delivers messages between attached nodes. Delays are measured on
+// the network's clock, so with a fake clock they elapse only as it advances.
+type Network struct {
+	clock clock.Clock
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	nodes     map[string]*attachment
+	faults    Faults
+	links     map[link]Faults
+	partition map[string]int // addr -> group; nil when healed
+	stats     Stats
+}
+
+func NewNetwork(c clock.Clock, seed int64) *Network {
+	return &Network{
+		clock: c,
+		rng:   rand.New(rand.NewSource(seed)),
+		nodes: make(map[string]*attachment),
+		links: make(map[link]Faults),
+	}
+}
+
+// Attach makes a node reachable at addr, serving the given internal routes.
+func (n *Network) Attach(addr string, routes map[string]wire.HandlerFunc) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[addr] = &attachment{routes: routes, down: make(chan struct{})}
+}
+
+// Detach takes a node off the network, as if its process died. Messages it
+// is still sending are abandoned.
+func (n *Network) Detach(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if a, ok := n.nodes[addr]; ok {
+		close(a.down)
+		delete(n.nodes, addr)
+	}
+}
+
+// SetFaults sets the faults for every link without its own settings.
+func (n *Network) SetFaults(f Faults) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.faults = f
+}
+
+// SetLinkFaults sets the faults for messages from one node to another.
+func (n *Network) SetLinkFaults(from, to string, f Faults) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.links[link{from, to}] = f
+}
+
+// Partition splits the network so that only nodes in the same group can
+// talk. Nodes not listed form a group of their own.
+func (n *Network) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.partition = make(map[string]int)
+	for i, group := range groups {
+		for _, addr := range group {
+			n.partition[addr] = i + 1
+		}
+	}
+}
+
+// Heal removes any partition.
+func (n *Network) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.partition = nil
+}
+
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// Endpoint returns the transport a node at addr uses to send messages.
+func (n *Network) Endpoint(addr string) *Endpoint {
+	return &Endpoint{net: n, addr: addr}
+}
+
+// Endpoint satisfies the counter and cluster Transport interfaces.
+type Endpoint struct {
+	net  *Network
+	addr string
+}
+
+func (e *Endpoint) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	return e.net.deliver(ctx, e.addr, addr, path, body, reply)
+}
+
+func (n *Network) reachableLocked(from, to string) (*attachment, *attachment, bool) {
+	src, srcOK := n.nodes[from]
+	dst, dstOK := n.nodes[to]
+	if !srcOK || !dstOK {
+		return nil, nil, false
+	}
+	if n.partition != nil && n.partition[from] != n.partition[to] {
+		return nil, nil, false
+	}
+	return src, dst, true
+}
+
+func (n *Network) deliver(ctx context.Context, from, to, path string, body interface{}, reply interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+	}
+
+	n.mu.Lock()
+	n.stats.Sent++
+	src, _, ok := n.reachableLocked(from, to)
+	if !ok {
+		n.stats.Unreachable++
+		n.mu.Unlock()
+		return ErrUnreachable
+	}
+	f, hasLink := n.links[link{from, to}]
+	if !hasLink {
+		f = n.faults
+	}
+	drop := n.rng.Float64() < f.DropRate
+	duplicate := n.rng.Float64() < f.DuplicateRate
+	delay := f.Latency
+	if f.MaxDelay > 0 && n.rng.Float64() < f.ReorderRate {
+		delay += time.Duration(n.rng.Int63n(int64(f.MaxDelay)))
+		n.stats.Reordered++
+	}
+	n.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-n.clock.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-src.down:
+			return ErrUnreachable
+		}
+	}
+
+	n.mu.Lock()
+	_, dst, ok := n.reachableLocked(from, to)
+	switch {
+	case !ok:
+		n.stats.Unreachable++
+	case drop:
+		n.stats.Dropped++
+	default:
+		n.stats.Delivered++
+		if duplicate {
+			n.stats.Duplicated++
+		}
+	}
+	n.mu.Unlock()
+	if !ok {
+		return ErrUnreachable
+	}
+	if drop {
+		return ErrDropped
+	}
+
+	h, ok := dst.routes[path]
+	if !ok {
+		return fmt.Errorf("received non-OK status code: %d", 404)
+	}
+	decode := func(v interface{}) error {
+		if payload == nil || json.Unmarshal(payload, v) != nil {
+			return wire.ErrBadRequest
+		}
+		return nil
+	}
+	result, err := h(ctx, decode)
+	if duplicate {
+		h(ctx, decode)
+	}
+	if err != nil {
+		var se *wire.StatusError
+		if errors.As(err, &se) {
+			return fmt.Errorf("received non-OK status code: %d", se.Code)
+		}
+		return fmt.Errorf("received non-OK status code: %d", 500)
+	}
+
+	if reply != nil && result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("failed to encode response body: %w", err)
+		}
+		if err := json.Unmarshal(data, reply); err != nil {
+			return fmt.Errorf("failed to decode response body: %w", err)
+		}
+	}
+	return nil
+}
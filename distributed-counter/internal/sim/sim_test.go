@@ -0,0 +1,381 @@
+package sim
+
+import (
+	"context"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/distinct"
+	"distributed-counter/internal/ring"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	// Every applied increment is logged; keep test output readable.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestNetwork_DropAndPartition(t *testing.T) {
+	c := NewCluster(Options{Nodes: 2, Seed: 1})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+
+	ep := c.Net.Endpoint(c.Node(0).Addr)
+	c.Partition([]int{0}, []int{1})
+	assert.ErrorIs(t, ep.Send(context.Background(), c.Node(1).Addr, "/counter/state", nil, nil), ErrUnreachable)
+	c.Heal()
+	assert.NoError(t, ep.Send(context.Background(), c.Node(1).Addr, "/counter/state", nil, nil))
+
+	c.Net.SetLinkFaults(c.Node(0).Addr, c.Node(1).Addr, Faults{DropRate: 1})
+	assert.ErrorIs(t, ep.Send(context.Background(), c.Node(1).Addr, "/counter/state", nil, nil), ErrDropped)
+	assert.NotZero(t, c.Net.Stats().Dropped)
+}
+
+func TestNetwork_LatencyFollowsClock(t *testing.T) {
+	c := NewCluster(Options{Nodes: 2, Seed: 1})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+	c.Net.SetFaults(Faults{Latency: time.Second})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Net.Endpoint(c.Node(0).Addr).Send(context.Background(), c.Node(1).Addr, "/counter/state", nil, nil)
+	}()
+	select {
+	case <-done:
+		t.Fatal("message delivered before the clock advanced")
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.Step(time.Second)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered after the clock advanced")
+	}
+}
+
+func TestCluster_ConvergesWithoutFaults(t *testing.T) {
+	c := NewCluster(Options{Nodes: 3, Seed: 1})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+
+	for i := 0; i < 30; i++ {
+		require.NoError(t, c.Increment(i%3))
+	}
+	assert.NoError(t, c.AwaitConvergence(30, 5*time.Second))
+}
+
+func TestCluster_NodesAgreeOnClusterID(t *testing.T) {
+	c := NewCluster(Options{Nodes: 3, Seed: 1})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+
+	c.Step(counter.DefaultDigestInterval)
+	assert.NoError(t, c.await(5*time.Second, func() error {
+		for i := 1; i < 3; i++ {
+			if got, want := c.Node(i).Counter.ClusterID(), c.Node(0).Counter.ClusterID(); got != want {
+				return fmt.Errorf("node %d is in cluster %s, node 0 in %s", i, got, want)
+			}
+		}
+		return nil
+	}))
+}
+
+func TestCluster_ConvergesUnderMessageFaults(t *testing.T) {
+	c := NewCluster(Options{Nodes: 4, Seed: 42})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+
+	c.Net.SetFaults(Faults{
+		DropRate:      0.2,
+		DuplicateRate: 0.3,
+		ReorderRate:   0.5,
+		Latency:       10 * time.Millisecond,
+		MaxDelay:      300 * time.Millisecond,
+	})
+	for i := 0; i < 40; i++ {
+		require.NoError(t, c.Increment(i%4))
+	}
+	// Duplicated deliveries must not be counted twice.
+	assert.NoError(t, c.AwaitConvergence(40, 10*time.Second))
+	stats := c.Net.Stats()
+	assert.NotZero(t, stats.Dropped)
+	assert.NotZero(t, stats.Duplicated)
+	assert.NotZero(t, stats.Reordered)
+}
+
+func TestCluster_PartitionHeal(t *testing.T) {
+	c := NewCluster(Options{Nodes: 3, Seed: 7})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+
+	c.Partition([]int{0, 1}, []int{2})
+	for i := 0; i < 10; i++ {
+		require.NoError(t, c.Increment(i%3))
+	}
+	// Within each side the increments still spread; across it they retry.
+	time.Sleep(50 * time.Millisecond)
+	assert.NotEqual(t, c.Node(0).Counter.Value(), c.Node(2).Counter.Value())
+
+	c.Heal()
+	assert.NoError(t, c.AwaitConvergence(10, 5*time.Second))
+}
+
+func TestCluster_PartitionedHalvesRemerge(t *testing.T) {
+	c := NewCluster(Options{Nodes: 4, Seed: 3})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+
+	c.Partition([]int{0, 1}, []int{2, 3})
+	// Long enough for both sides to expire each other.
+	for i := 0; i < 20; i++ {
+		c.Step(time.Second)
+	}
+	assert.ElementsMatch(t, c.Addrs(1), c.Node(0).Registry.GetPeerAddrs())
+	assert.ElementsMatch(t, c.Addrs(3), c.Node(2).Registry.GetPeerAddrs())
+
+	c.Heal()
+	assert.NoError(t, c.AwaitMembership(10*time.Second))
+}
+
+func TestCluster_KillAndRestartCatchesUp(t *testing.T) {
+	c := NewCluster(Options{Nodes: 3, Seed: 5})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, c.Increment(i%3))
+	}
+	require.NoError(t, c.AwaitConvergence(5, 5*time.Second))
+
+	c.Kill(2)
+	assert.ErrorIs(t, c.Increment(2), ErrNodeDown)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, c.Increment(i%2))
+	}
+
+	c.Restart(2)
+	assert.True(t, c.Up(2))
+	assert.NoError(t, c.AwaitConvergence(10, 5*time.Second))
+	assert.NoError(t, c.AwaitMembership(5*time.Second))
+}
+
+func TestCluster_ResetStartsNewEpochEverywhere(t *testing.T) {
+	c := NewCluster(Options{Nodes: 3, Seed: 9})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+
+	for i := 0; i < 6; i++ {
+		require.NoError(t, c.Increment(i%3))
+	}
+	require.NoError(t, c.AwaitConvergence(6, 5*time.Second))
+
+	_, err := c.Node(1).Counter.Reset()
+	require.NoError(t, err)
+	require.NoError(t, c.AwaitConvergence(0, 5*time.Second))
+	require.Eventually(t, func() bool {
+		return c.Node(0).Counter.Epoch() == 1 && c.Node(2).Counter.Epoch() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, c.Increment(2))
+	require.NoError(t, c.AwaitConvergence(1, 5*time.Second))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, int64(6), c.Node(i).Counter.Epochs()[0].Count)
+	}
+}
+
+func TestCluster_DistinctCountsConvergeUnderMessageFaults(t *testing.T) {
+	c := NewCluster(Options{Nodes: 3, Seed: 5})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(5*time.Second))
+	c.Net.SetFaults(Faults{DuplicateRate: 0.3, ReorderRate: 0.3, MaxDelay: 50 * time.Millisecond})
+
+	// Every node sees an overlapping slice of the same 3000 users.
+	for i := 0; i < 3; i++ {
+		var users []string
+		for u := i * 1000; u < i*1000+1500; u++ {
+			users = append(users, fmt.Sprintf("user-%d", u%3000))
+		}
+		_, err := c.Node(i).Distinct.Add("campaign:1", users)
+		require.NoError(t, err)
+	}
+
+	err := c.await(5*time.Second, func() error {
+		want, _ := c.Node(0).Distinct.Get("campaign:1")
+		if want.Estimate < 2500 {
+			return fmt.Errorf("node 0 estimates %d, missing updates", want.Estimate)
+		}
+		for i := 1; i < 3; i++ {
+			got, _ := c.Node(i).Distinct.Get("campaign:1")
+			if got != want {
+				return fmt.Errorf("node %d estimates %d, node 0 %d", i, got.Estimate, want.Estimate)
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	count, _ := c.Node(2).Distinct.Get("campaign:1")
+	assert.InDelta(t, 3000, float64(count.Estimate), 3*count.StdError*3000)
+}
+
+func TestCluster_GossipConverges(t *testing.T) {
+	c := NewCluster(Options{Nodes: 8, Seed: 11, Counter: counter.Config{
+		Mode:           counter.Gossip,
+		Gossip:         counter.GossipConfig{Fanout: 2},
+		DigestInterval: time.Second,
+	}})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(10*time.Second))
+	before := c.Net.Stats().Sent
+
+	c.Net.SetFaults(Faults{DropRate: 0.2, DuplicateRate: 0.2, ReorderRate: 0.3, MaxDelay: 50 * time.Millisecond})
+	for i := 0; i < 40; i++ {
+		require.NoError(t, c.Increment(i%8))
+	}
+	assert.NoError(t, c.AwaitConvergence(40, 10*time.Second))
+	assert.NotZero(t, c.Net.Stats().Sent-before)
+	assert.NotZero(t, c.Net.Stats().Dropped)
+}
+
+func TestCluster_GossipResyncsGapsOlderThanRepair(t *testing.T) {
+	c := NewCluster(Options{Nodes: 4, Seed: 17, Counter: counter.Config{
+		Mode:                counter.Gossip,
+		Gossip:              counter.GossipConfig{Fanout: 2},
+		DigestInterval:      time.Second,
+		RetryMaxElapsedTime: 100 * time.Millisecond,
+	}})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(10*time.Second))
+
+	// Node 3 loses every message until retries give up and the increments
+	// it missed are older than a repair reaches.
+	isolated := c.Node(3).Addr
+	for i := 0; i < 3; i++ {
+		c.Net.SetLinkFaults(c.Node(i).Addr, isolated, Faults{DropRate: 1})
+		c.Net.SetLinkFaults(isolated, c.Node(i).Addr, Faults{DropRate: 1})
+	}
+	for i := 0; i < 30; i++ {
+		require.NoError(t, c.Increment(i%3))
+	}
+	// Retries back off in real time, digest repair on the simulated clock.
+	for i := 0; i < 10; i++ {
+		c.Step(time.Second)
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Zero(t, c.Node(3).Counter.Value())
+
+	for i := 0; i < 3; i++ {
+		c.Net.SetLinkFaults(c.Node(i).Addr, isolated, Faults{})
+		c.Net.SetLinkFaults(isolated, c.Node(i).Addr, Faults{})
+	}
+	assert.NoError(t, c.AwaitConvergence(30, 10*time.Second))
+}
+
+func TestCluster_ShardedDistinctCountersMoveWithMembership(t *testing.T) {
+	c := NewCluster(Options{Nodes: 5, Seed: 13, Distinct: distinct.Config{Sharding: ring.ShardingConfig{Replicas: 2}}})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(10*time.Second))
+
+	names := make([]string, 10)
+	for i := range names {
+		names[i] = fmt.Sprintf("campaign:%d", i)
+		users := make([]string, 100*(i+1))
+		for u := range users {
+			users[u] = fmt.Sprintf("user-%d", u)
+		}
+		_, err := c.Node(i%5).Distinct.RouteAdd(context.Background(), names[i], users)
+		require.NoError(t, err)
+	}
+
+	// placed checks that each counter is held by exactly its owners, and
+	// reads the same from every node.
+	placed := func() error {
+		var live []int
+		for i := 0; i < 5; i++ {
+			if c.Up(i) {
+				live = append(live, i)
+			}
+		}
+		for i, name := range names {
+			owners := c.Node(live[0]).Distinct.Owners(name)
+			for _, j := range live {
+				n := c.Node(j)
+				_, held := n.Distinct.Get(name)
+				if owns := slices.Contains(owners, n.Addr); held != owns {
+					return fmt.Errorf("%s holds %s: %v, owns it: %v", n.Addr, name, held, owns)
+				}
+				count, ok, err := n.Distinct.RouteGet(context.Background(), name)
+				if err != nil || !ok {
+					return fmt.Errorf("%s can't read %s: %v", n.Addr, name, err)
+				}
+				if want := 100 * (i + 1); math.Abs(float64(count.Estimate)-float64(want)) > float64(want)/10 {
+					return fmt.Errorf("%s reads %d for %s, want about %d", n.Addr, count.Estimate, name, want)
+				}
+			}
+		}
+		return nil
+	}
+	require.NoError(t, c.await(5*time.Second, placed))
+
+	c.Kill(3)
+	require.NoError(t, c.AwaitMembership(10*time.Second))
+	require.NoError(t, c.await(5*time.Second, placed), "the survivors take over node 3's counters")
+
+	c.Restart(3)
+	require.NoError(t, c.AwaitMembership(10*time.Second))
+	require.NoError(t, c.await(5*time.Second, placed), "node 3 gets its counters back, the others drop them")
+}
+
+func TestCluster_ShardedKeyedIncrementsMoveWithMembership(t *testing.T) {
+	c := NewCluster(Options{Nodes: 5, Seed: 19, Counter: counter.Config{Sharding: ring.ShardingConfig{Replicas: 2}}})
+	defer c.Close()
+	require.NoError(t, c.AwaitMembership(10*time.Second))
+
+	ids := make(map[string][]string) // by key
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("bounded:campaign:%d", i%10)
+		inc, _, err := c.Node(i % 5).Counter.Submit(counter.Increment{ID: fmt.Sprintf("inc-%d", i), Key: key})
+		require.NoError(t, err)
+		ids[key] = append(ids[key], inc.ID)
+	}
+
+	// placed checks that the owners of each key hold all of its increments,
+	// and that other nodes hold only those they made.
+	placed := func() error {
+		for i := 0; i < 5; i++ {
+			if !c.Up(i) {
+				continue
+			}
+			n := c.Node(i)
+			for key, keyIDs := range ids {
+				owns := n.Counter.Owns(key)
+				for _, id := range keyIDs {
+					inc, held := n.Counter.Lookup(id)
+					if owns && !held {
+						return fmt.Errorf("%s owns %s but misses %s", n.Addr, key, id)
+					}
+					if held && !owns && inc.NodeID != n.Addr {
+						return fmt.Errorf("%s holds %s of %s, which it doesn't own", n.Addr, id, key)
+					}
+				}
+			}
+		}
+		return nil
+	}
+	require.NoError(t, c.await(5*time.Second, placed))
+	assert.Zero(t, c.Node(0).Counter.Value(), "keyed increments don't count towards the counter")
+
+	c.Kill(3)
+	require.NoError(t, c.AwaitMembership(10*time.Second))
+	require.NoError(t, c.await(5*time.Second, placed), "the survivors take over node 3's keys")
+}
@@ -0,0 +1,361 @@
+// Package topk ranks items, such as ad IDs, by count across the cluster
+// without a counter per item, with count-min sketches.
+//
+// Every node counts its own adds in a sketch of its own and propagates the
+// cells an add raised, with their new values. Nodes keep each origin's
+// sketch and apply updates by taking the per-cell maximum, so they can
+// arrive in any order and any number of times; a counter is the sum of its
+// origins' sketches.
+package topk
+
+import (
+	"context"
+	"distributed-counter/internal/cms"
+	"distributed-counter/internal/wire"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// DefaultCapacity is how many heavy hitters each counter tracks by default.
+const DefaultCapacity = 100
+
+// Members reports the other known nodes.
+type Members interface {
+	GetPeerAddrs() []string
+}
+
+// Transport sends internal messages to peers.
+type Transport interface {
+	Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error
+}
+
+// Propagator queues a message for delivery to peers, retrying failures. It
+// returns workpool.ErrSaturated, queueing nothing, when its queues are full.
+// It is satisfied by counter.Counter.
+type Propagator interface {
+	BroadcastTo(peers []string, path string, body interface{}, what string) error
+}
+
+// Config sizes the sketches behind top-K counters. Width and depth must
+// match across the cluster.
+type Config struct {
+	// Width and Depth size each count-min sketch. Zero values mean
+	// cms.DefaultWidth and cms.DefaultDepth.
+	Width int
+	Depth int
+	// Capacity is the most items a query can ask for. Zero means
+	// DefaultCapacity.
+	Capacity int
+}
+
+// Cell is one counter of an origin's sketch, by its index in cms.Sketch.Cells.
+type Cell struct {
+	Index uint32 `json:"index"`
+	Count int64  `json:"count"`
+}
+
+// Update carries the cells of its origin's sketch that an add of Item
+// raised, with their new values.
+type Update struct {
+	Name   string `json:"name"`
+	Origin string `json:"origin"`
+	Item   string `json:"item"`
+	Width  int    `json:"width"`
+	Depth  int    `json:"depth"`
+	Cells  []Cell `json:"cells"`
+}
+
+// MarshalBinary implements the compact encoding used by the wire transport.
+func (u Update) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, len(u.Name)+len(u.Origin)+len(u.Item)+8+len(u.Cells)*6)
+	b = wire.AppendString(b, u.Name)
+	b = wire.AppendString(b, u.Origin)
+	b = wire.AppendString(b, u.Item)
+	b = binary.AppendUvarint(b, uint64(u.Width))
+	b = binary.AppendUvarint(b, uint64(u.Depth))
+	b = binary.AppendUvarint(b, uint64(len(u.Cells)))
+	for _, c := range u.Cells {
+		b = binary.AppendUvarint(b, uint64(c.Index))
+		b = binary.AppendVarint(b, c.Count)
+	}
+	return b, nil
+}
+
+func (u *Update) UnmarshalBinary(data []byte) error {
+	r := wire.NewReader(data)
+	u.Name = r.ReadString()
+	u.Origin = r.ReadString()
+	u.Item = r.ReadString()
+	u.Width = int(r.ReadUvarint())
+	u.Depth = int(r.ReadUvarint())
+	n := r.ReadUvarint()
+	u.Cells = nil
+	for i := uint64(0); i < n && r.Err() == nil; i++ {
+		index, count := r.ReadUvarint(), r.ReadVarint()
+		u.Cells = append(u.Cells, Cell{Index: uint32(index), Count: count})
+	}
+	return r.Err()
+}
+
+// Origin is the sketch of the adds made on one node.
+type Origin struct {
+	Node  string  `json:"node"`
+	Cells []int64 `json:"cells"`
+}
+
+// Sketch is the full state of a counter, for catch-up and snapshots.
+type Sketch struct {
+	Name    string   `json:"name"`
+	Origins []Origin `json:"origins"`
+	// Candidates are the currently tracked items.
+	Candidates []string `json:"candidates"`
+}
+
+// Result is the answer to a top-K query.
+type Result struct {
+	Name  string     `json:"name"`
+	Items []cms.Item `json:"items"`
+	// Total is the sum of all adds to the counter, tracked or not.
+	Total int64 `json:"total"`
+	// MaxOvercount bounds how much any count may exceed the true count,
+	// with probability Confidence.
+	MaxOvercount int64   `json:"max_overcount"`
+	Confidence   float64 `json:"confidence"`
+}
+
+// counter is one top-K counter: the sketch of every origin, their sum and
+// the heaviest items in it.
+type counter struct {
+	origins map[string][]int64
+	sum     *cms.Sketch
+	top     *cms.TopK
+}
+
+// Counters holds this node's top-K counters.
+type Counters struct {
+	selfID     string
+	members    Members
+	transport  Transport
+	propagator Propagator
+	cfg        Config
+
+	mu       sync.RWMutex
+	counters map[string]*counter
+}
+
+// New creates the top-K counters of a node.
+func New(selfID string, members Members, transport Transport, propagator Propagator, cfg Config) *Counters {
+	if cfg.Width <= 0 {
+		cfg.Width = cms.DefaultWidth
+	}
+	if cfg.Depth <= 0 {
+		cfg.Depth = cms.DefaultDepth
+	}
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = DefaultCapacity
+	}
+	return &Counters{
+		selfID:     selfID,
+		members:    members,
+		transport:  transport,
+		propagator: propagator,
+		cfg:        cfg,
+		counters:   make(map[string]*counter),
+	}
+}
+
+// Add counts n of item in the named counter and propagates the cells it
+// raised to peers. It returns the item's new estimate, or
+// workpool.ErrSaturated, without counting anything, when the propagation
+// queues are full.
+func (t *Counters) Add(name, item string, n int64) (cms.Item, error) {
+	if name == "" || item == "" {
+		return cms.Item{}, errors.New("top-K name and item must be set")
+	}
+	if n <= 0 {
+		return cms.Item{}, errors.New("n must be positive")
+	}
+	// Adds hold the lock while queueing, so that the updates of one origin
+	// carry ever larger values.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, existed := t.counters[name]
+	c := t.counterLocked(name)
+	own := c.origin(t.selfID, t.cfg.Width*t.cfg.Depth)
+	update := Update{Name: name, Origin: t.selfID, Item: item, Width: t.cfg.Width, Depth: t.cfg.Depth}
+	for _, i := range c.sum.Positions(item) {
+		update.Cells = append(update.Cells, Cell{Index: uint32(i), Count: own[i] + n})
+	}
+	what := fmt.Sprintf("top-K %s item %s", name, item)
+	if err := t.propagator.BroadcastTo(t.members.GetPeerAddrs(), "/topk/update", update, what); err != nil {
+		if !existed {
+			delete(t.counters, name)
+		}
+		return cms.Item{}, err
+	}
+	t.applyLocked(c, update)
+	return cms.Item{Item: item, Count: c.sum.Estimate(item)}, nil
+}
+
+// Apply merges an update into a counter, creating it if needed. Updates
+// from a node with other sketch dimensions are rejected.
+func (t *Counters) Apply(u Update) error {
+	if u.Width != t.cfg.Width || u.Depth != t.cfg.Depth {
+		return fmt.Errorf("top-K counter %s is %dx%d, this node uses %dx%d", u.Name, u.Width, u.Depth, t.cfg.Width, t.cfg.Depth)
+	}
+	if u.Origin == "" {
+		return errors.New("update has no origin")
+	}
+	for _, c := range u.Cells {
+		if int(c.Index) >= t.cfg.Width*t.cfg.Depth || c.Count < 0 {
+			return fmt.Errorf("cell %d out of range", c.Index)
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.applyLocked(t.counterLocked(u.Name), u)
+	return nil
+}
+
+func (t *Counters) applyLocked(c *counter, u Update) {
+	cells := c.origin(u.Origin, t.cfg.Width*t.cfg.Depth)
+	for _, cell := range u.Cells {
+		if raise := cell.Count - cells[cell.Index]; raise > 0 {
+			cells[cell.Index] = cell.Count
+			c.sum.AddCell(int(cell.Index), raise)
+		}
+	}
+	if u.Item != "" {
+		c.top.Offer(u.Item, c.sum.Estimate(u.Item))
+	}
+}
+
+// Get returns up to k of the heaviest items of the named counter, capped at
+// the configured capacity.
+func (t *Counters) Get(name string, k int) (Result, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	c, ok := t.counters[name]
+	if !ok {
+		return Result{}, false
+	}
+	return Result{
+		Name:         name,
+		Items:        c.top.Top(min(k, t.cfg.Capacity)),
+		Total:        c.sum.Total(),
+		MaxOvercount: c.sum.MaxOvercount(),
+		Confidence:   c.sum.Confidence(),
+	}, true
+}
+
+func (t *Counters) counterLocked(name string) *counter {
+	c, ok := t.counters[name]
+	if !ok {
+		c = &counter{
+			origins: make(map[string][]int64),
+			sum:     cms.New(t.cfg.Width, t.cfg.Depth),
+			top:     cms.NewTopK(t.cfg.Capacity),
+		}
+		t.counters[name] = c
+	}
+	return c
+}
+
+// origin returns the cells of an origin's sketch, creating it if needed.
+func (c *counter) origin(node string, size int) []int64 {
+	cells, ok := c.origins[node]
+	if !ok {
+		cells = make([]int64, size)
+		c.origins[node] = cells
+	}
+	return cells
+}
+
+// Sketches returns every counter, ordered by name.
+func (t *Counters) Sketches() []Sketch {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	sketches := make([]Sketch, 0, len(t.counters))
+	for name, c := range t.counters {
+		s := Sketch{Name: name, Candidates: c.top.Items()}
+		for node, cells := range c.origins {
+			s.Origins = append(s.Origins, Origin{Node: node, Cells: append([]int64(nil), cells...)})
+		}
+		sort.Slice(s.Origins, func(i, j int) bool { return s.Origins[i].Node < s.Origins[j].Node })
+		sort.Strings(s.Candidates)
+		sketches = append(sketches, s)
+	}
+	sort.Slice(sketches, func(i, j int) bool { return sketches[i].Name < sketches[j].Name })
+	return sketches
+}
+
+// Merge merges a full sketch into a counter, creating it if needed, taking
+// the per-cell maximum of every origin like Apply, and re-ranks the tracked
+// items of both.
+func (t *Counters) Merge(s Sketch) error {
+	size := t.cfg.Width * t.cfg.Depth
+	for _, o := range s.Origins {
+		if o.Node == "" || len(o.Cells) != size {
+			return fmt.Errorf("origin %q has %d cells, this node uses %dx%d sketches", o.Node, len(o.Cells), t.cfg.Width, t.cfg.Depth)
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.counterLocked(s.Name)
+	for _, o := range s.Origins {
+		cells := c.origin(o.Node, size)
+		for i, n := range o.Cells {
+			if raise := n - cells[i]; raise > 0 {
+				cells[i] = n
+				c.sum.AddCell(i, raise)
+			}
+		}
+	}
+	for _, item := range append(c.top.Items(), s.Candidates...) {
+		if estimate := c.sum.Estimate(item); estimate > 0 {
+			c.top.Offer(item, estimate)
+		}
+	}
+	return nil
+}
+
+// Import merges sketches, such as those of a snapshot, here and sends them
+// to every peer. Like Add it returns workpool.ErrSaturated when the
+// propagation queues are full; sketches merged before that stay merged, and
+// importing them again is harmless.
+func (t *Counters) Import(sketches []Sketch) error {
+	for _, s := range sketches {
+		if err := t.Merge(s); err != nil {
+			return fmt.Errorf("top-K counter %s: %w", s.Name, err)
+		}
+		if err := t.propagator.BroadcastTo(t.members.GetPeerAddrs(), "/topk/merge", s, "top-K "+s.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CatchUp merges the counters of the first peer that answers, for a node
+// that just joined.
+func (t *Counters) CatchUp(ctx context.Context) error {
+	var lastErr error
+	for _, addr := range t.members.GetPeerAddrs() {
+		var sketches []Sketch
+		if err := t.transport.Send(ctx, addr, "/topk/state", nil, &sketches); err != nil {
+			lastErr = err
+			continue
+		}
+		for _, s := range sketches {
+			if err := t.Merge(s); err != nil {
+				log.Printf("Skipping top-K counter %s from %s: %v", s.Name, addr, err)
+			}
+		}
+		log.Printf("Caught up %d top-K counters from %s", len(sketches), addr)
+		return nil
+	}
+	return lastErr
+}
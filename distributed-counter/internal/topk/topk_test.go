@@ -0,0 +1,187 @@
+package topk
+
+import (
+	"context"
+	"distributed-counter/internal/cms"
+	"distributed-counter/internal/workpool"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type peerList []string
+
+func (p peerList) GetPeerAddrs() []string { return p }
+
+// fakeNetwork records every message by path, answers /topk/state, and is
+// both the Transport and, delivering right away, the Propagator of the
+// counters under test.
+type fakeNetwork struct {
+	mu        sync.Mutex
+	bodies    map[string][]interface{}
+	saturated bool
+	state     []Sketch
+}
+
+func newFakeNetwork() *fakeNetwork {
+	return &fakeNetwork{bodies: make(map[string][]interface{})}
+}
+
+func (n *fakeNetwork) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.bodies[path] = append(n.bodies[path], body)
+	if r, ok := reply.(*[]Sketch); ok {
+		*r = n.state
+	}
+	return nil
+}
+
+func (n *fakeNetwork) BroadcastTo(peers []string, path string, body interface{}, what string) error {
+	if n.saturated {
+		return workpool.ErrSaturated
+	}
+	for _, addr := range peers {
+		n.Send(context.Background(), addr, path, body, nil)
+	}
+	return nil
+}
+
+func (n *fakeNetwork) updates() []Update {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var updates []Update
+	for _, body := range n.bodies["/topk/update"] {
+		updates = append(updates, body.(Update))
+	}
+	return updates
+}
+
+func newCounters(t *testing.T, self string, cfg Config, peers ...string) (*Counters, *fakeNetwork) {
+	net := newFakeNetwork()
+	return New(self, peerList(peers), net, net, cfg), net
+}
+
+func TestCounters_AddPropagatesRaisedCells(t *testing.T) {
+	c, net := newCounters(t, "node1:8080", Config{}, "node2:8080")
+
+	item, err := c.Add("ads", "ad-1", 3)
+	require.NoError(t, err)
+	assert.Equal(t, cms.Item{Item: "ad-1", Count: 3}, item)
+	_, err = c.Add("ads", "ad/2", 5)
+	require.NoError(t, err)
+	_, err = c.Add("ads", "ad-1", 2)
+	require.NoError(t, err)
+	_, err = c.Add("", "ad-1", 1)
+	assert.Error(t, err)
+
+	result, ok := c.Get("ads", 10)
+	require.True(t, ok)
+	assert.Equal(t, []cms.Item{{Item: "ad-1", Count: 5}, {Item: "ad/2", Count: 5}}, result.Items)
+	assert.Equal(t, int64(10), result.Total)
+
+	updates := net.updates()
+	require.Len(t, updates, 3)
+	assert.Len(t, updates[0].Cells, cms.DefaultDepth)
+
+	// A peer applying the updates, in whatever order or repetition, ends up
+	// with the same ranking.
+	peer, _ := newCounters(t, "node2:8080", Config{})
+	for _, i := range []int{2, 0, 1, 2, 0} {
+		require.NoError(t, peer.Apply(updates[i]))
+	}
+	got, ok := peer.Get("ads", 10)
+	require.True(t, ok)
+	assert.Equal(t, result, got)
+}
+
+func TestCounters_AddsOfSeveralOriginsSum(t *testing.T) {
+	a, netA := newCounters(t, "node1:8080", Config{}, "node2:8080")
+	b, netB := newCounters(t, "node2:8080", Config{}, "node1:8080")
+	_, err := a.Add("ads", "ad-1", 3)
+	require.NoError(t, err)
+	_, err = b.Add("ads", "ad-1", 4)
+	require.NoError(t, err)
+	require.NoError(t, b.Apply(netA.updates()[0]))
+	require.NoError(t, a.Apply(netB.updates()[0]))
+
+	for _, c := range []*Counters{a, b} {
+		result, ok := c.Get("ads", 10)
+		require.True(t, ok)
+		assert.Equal(t, []cms.Item{{Item: "ad-1", Count: 7}}, result.Items)
+		assert.Equal(t, int64(7), result.Total)
+	}
+}
+
+func TestCounters_AddWhenSaturated(t *testing.T) {
+	c, net := newCounters(t, "node1:8080", Config{}, "node2:8080")
+	net.saturated = true
+	_, err := c.Add("ads", "ad-1", 1)
+	assert.ErrorIs(t, err, workpool.ErrSaturated)
+	_, ok := c.Get("ads", 10)
+	assert.False(t, ok)
+}
+
+func TestCounters_CapsK(t *testing.T) {
+	c, _ := newCounters(t, "node1:8080", Config{Width: 64, Depth: 2, Capacity: 2})
+	for _, item := range []string{"a", "b", "c"} {
+		_, err := c.Add("ads", item, 1)
+		require.NoError(t, err)
+	}
+	result, _ := c.Get("ads", 20)
+	assert.Len(t, result.Items, 2)
+	_, ok := c.Get("other", 20)
+	assert.False(t, ok)
+}
+
+func TestCounters_ApplyRejectsOtherDimensions(t *testing.T) {
+	c, _ := newCounters(t, "node1:8080", Config{Width: 64, Depth: 2})
+	assert.Error(t, c.Apply(Update{Name: "ads", Origin: "node2:8080", Width: 128, Depth: 2}))
+	assert.Error(t, c.Apply(Update{Name: "ads", Origin: "node2:8080", Width: 64, Depth: 2, Cells: []Cell{{Index: 128, Count: 1}}}))
+	assert.Error(t, c.Merge(Sketch{Name: "ads", Origins: []Origin{{Node: "node2:8080", Cells: make([]int64, 10)}}}))
+}
+
+func TestCounters_CatchUpMergesSketches(t *testing.T) {
+	source, _ := newCounters(t, "node1:8080", Config{})
+	_, err := source.Add("ads", "ad-1", 10)
+	require.NoError(t, err)
+	_, err = source.Add("ads", "ad-2", 4)
+	require.NoError(t, err)
+
+	joiner, net := newCounters(t, "node2:8080", Config{}, "node1:8080")
+	net.state = source.Sketches()
+	require.NoError(t, joiner.CatchUp(context.Background()))
+	// A second catch-up from the same state changes nothing.
+	require.NoError(t, joiner.CatchUp(context.Background()))
+
+	want, _ := source.Get("ads", 10)
+	got, ok := joiner.Get("ads", 10)
+	require.True(t, ok)
+	assert.Equal(t, want, got)
+	assert.Equal(t, []cms.Item{{Item: "ad-1", Count: 10}, {Item: "ad-2", Count: 4}}, got.Items)
+}
+
+func TestCounters_ImportSendsSketchesToPeers(t *testing.T) {
+	source, _ := newCounters(t, "node1:8080", Config{})
+	_, err := source.Add("ads", "ad-1", 2)
+	require.NoError(t, err)
+
+	c, net := newCounters(t, "node2:8080", Config{}, "node3:8080")
+	require.NoError(t, c.Import(source.Sketches()))
+	require.NoError(t, c.Import(source.Sketches()))
+	result, ok := c.Get("ads", 10)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), result.Total)
+	assert.Len(t, net.bodies["/topk/merge"], 2)
+}
+
+func TestUpdate_BinaryRoundTrip(t *testing.T) {
+	u := Update{Name: "ads", Origin: "node1:8080", Item: "ad-1", Width: 64, Depth: 2, Cells: []Cell{{Index: 3, Count: 7}, {Index: 70, Count: 7}}}
+	b, err := u.MarshalBinary()
+	require.NoError(t, err)
+	var got Update
+	require.NoError(t, got.UnmarshalBinary(b))
+	assert.Equal(t, u, got)
+}
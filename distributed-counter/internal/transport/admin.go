@@ -0,0 +1,176 @@
+package transport
+
+import (
+	"context"
+	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/distinct"
+	"distributed-counter/internal/topk"
+	"distributed-counter/internal/wire"
+	"distributed-counter/internal/workpool"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// maxSnapshotSize caps the body of POST /admin/import.
+const maxSnapshotSize = 1 << 30
+
+// StateDump is everything a node knows, returned by GET /admin/state.
+type StateDump struct {
+	Status  NodeStatus                `json:"status"`
+	Peers   []cluster.PeerInfo        `json:"peers"`
+	Workers map[string]workpool.Stats `json:"workers"`
+	Counter counter.State             `json:"counter"`
+	// Distinct are the distinct counters held by the node.
+	Distinct []distinct.Sketch `json:"distinct,omitempty"`
+	// TopK are the top-K counters of the node.
+	TopK []topk.Sketch `json:"topk,omitempty"`
+}
+
+// Snapshot is what GET /admin/export returns and POST /admin/import takes:
+// a counter snapshot and the distinct and top-K counters of the node that
+// took it.
+type Snapshot struct {
+	counter.Snapshot
+	Distinct []distinct.Sketch `json:"distinct,omitempty"`
+	TopK     []topk.Sketch     `json:"topk,omitempty"`
+}
+
+// DrainStatus is returned by POST /admin/drain.
+type DrainStatus struct {
+	Draining            bool `json:"draining"`
+	PendingPropagations int  `json:"pending_propagations"`
+}
+
+func (s *Server) handleWorkerStats(w http.ResponseWriter, r *http.Request) {
+	s.respondJSON(w, http.StatusOK, s.workerStats())
+}
+
+func (s *Server) workerStats() map[string]workpool.Stats {
+	return map[string]workpool.Stats{
+		"propagation": s.counter.PropagationStats(),
+		"heartbeat":   s.registry.HeartbeatStats(),
+	}
+}
+
+func (s *Server) handleDumpState(w http.ResponseWriter, r *http.Request) {
+	dump := StateDump{
+		Status:  s.nodeStatus(),
+		Peers:   s.peerInfos(),
+		Workers: s.workerStats(),
+		Counter: s.counter.State(),
+	}
+	if s.distinct != nil {
+		dump.Distinct = s.distinct.Sketches()
+	}
+	if s.topK != nil {
+		dump.TopK = s.topK.Sketches()
+	}
+	s.respondJSON(w, http.StatusOK, dump)
+}
+
+// handleExport returns a snapshot of this node's counter state, which POST
+// /admin/import on any node of this or another cluster merges back.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	snapshot := Snapshot{Snapshot: s.counter.Snapshot()}
+	if s.distinct != nil {
+		snapshot.Distinct = s.distinct.Sketches()
+	}
+	if s.topK != nil {
+		snapshot.TopK = s.topK.Sketches()
+	}
+	s.respondJSON(w, http.StatusOK, snapshot)
+}
+
+// handleImport merges a snapshot into every node. Importing the same
+// snapshot again changes nothing. Distinct counters go to the nodes that
+// hold them and top-K counters to every node, and both merge idempotently
+// too. When a peer didn't import the counter snapshot the result comes back
+// with 502, and importing again completes it.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	var snapshot Snapshot
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshotSize)).Decode(&snapshot); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Snapshot is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid request body, expected a snapshot from GET /admin/export", http.StatusBadRequest)
+		return
+	}
+	if s.draining.Load() {
+		http.Error(w, "Node is draining, send writes elsewhere", http.StatusServiceUnavailable)
+		return
+	}
+	result, err := s.counter.ImportAndPropagate(r.Context(), snapshot.Snapshot)
+	if err == nil && s.distinct != nil {
+		err = s.distinct.Import(snapshot.Distinct)
+	}
+	if err == nil && s.topK != nil {
+		err = s.topK.Import(snapshot.TopK)
+	}
+	if errors.Is(err, workpool.ErrSaturated) {
+		http.Error(w, "Propagation queue is full, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid snapshot: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.stream.notify()
+	if !result.Delivered() {
+		s.respondJSON(w, http.StatusBadGateway, map[string]interface{}{
+			"error":  "Not every peer imported the snapshot, import it again",
+			"result": result,
+		})
+		return
+	}
+	s.respondJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleCounterImport(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	var snapshot counter.Snapshot
+	if err := decode(&snapshot); err != nil {
+		return nil, err
+	}
+	result, err := s.counter.Import(snapshot)
+	if err != nil {
+		return nil, wire.Errorf(http.StatusBadRequest, "Invalid snapshot: %v", err)
+	}
+	if !result.Duplicate {
+		s.stream.notify()
+	}
+	return nil, nil
+}
+
+// handleEvict removes a peer from the membership list. A peer that is still
+// alive is re-added by its next heartbeat.
+func (s *Server) handleEvict(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	peerID := body["id"]
+	if peerID == "" {
+		http.Error(w, "Peer ID is required", http.StatusBadRequest)
+		return
+	}
+	if !s.registry.Evict(peerID) {
+		http.Error(w, "Unknown peer", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleDrain stops accepting increments and reports not ready, while
+// queued propagations keep flowing out. It is not reversible.
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	s.draining.Store(true)
+	stats := s.counter.PropagationStats()
+	s.respondJSON(w, http.StatusOK, DrainStatus{
+		Draining:            true,
+		PendingPropagations: stats.Running + stats.Queued,
+	})
+}
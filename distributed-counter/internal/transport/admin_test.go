@@ -0,0 +1,160 @@
+package transport
+
+import (
+	"bytes"
+	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/ratelimit"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleEvict(t *testing.T) {
+	s := setupTestServer()
+	s.registry.HandleHeartbeat("peer1:8081")
+
+	evict := func(body string) int {
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/evict", bytes.NewReader([]byte(body))))
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusOK, evict(`{"id":"peer1:8081"}`))
+	assert.NotContains(t, s.registry.GetPeerAddrs(), "peer1:8081")
+
+	assert.Equal(t, http.StatusNotFound, evict(`{"id":"peer1:8081"}`))
+	assert.Equal(t, http.StatusNotFound, evict(`{"id":"self:8080"}`), "a node cannot evict itself")
+	assert.Equal(t, http.StatusBadRequest, evict(`{}`))
+	assert.Equal(t, http.StatusBadRequest, evict(`{invalid`))
+}
+
+func TestHandleDrain(t *testing.T) {
+	s := setupTestServer()
+	limiter := ratelimit.New(s.registry.SelfID(), s.registry, nil, ratelimit.Config{
+		Rules: map[string]ratelimit.Rule{"advertiser:*": {Limit: 3, Window: time.Hour}},
+	})
+	defer limiter.Close()
+	s.SetRateLimiter(limiter)
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/drain", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var status DrainStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.True(t, status.Draining)
+
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/increment", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, int64(0), s.counter.Value())
+
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/reset", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, uint64(0), s.counter.Epoch(), "resets are writes too")
+	assert.Equal(t, http.StatusServiceUnavailable, takeRateLimit(s, "/ratelimit/advertiser:1/take").Code)
+
+	code, resp := getReadyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, resp.Checks["shutdown"].OK)
+}
+
+func TestHandleDumpState(t *testing.T) {
+	s := setupTestServer()
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/increment", nil))
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/state", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var dump StateDump
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &dump))
+	assert.Equal(t, int64(1), dump.Status.Count)
+	assert.Len(t, dump.Counter.Increments, 1)
+	assert.Contains(t, dump.Workers, "propagation")
+	assert.Len(t, dump.Peers, 1)
+}
+
+func TestHandleExportAndImport(t *testing.T) {
+	src := setupDistinctServer()
+	src.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/increment", nil))
+	src.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/increment", nil))
+	_, err := src.distinct.Add("visitors", []string{"u1", "u2", "u3"})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	src.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	exported := rr.Body.Bytes()
+	var snapshot Snapshot
+	require.NoError(t, json.Unmarshal(exported, &snapshot))
+	assert.Equal(t, counter.SnapshotVersion, snapshot.Version)
+	assert.Equal(t, []counter.Component{{Node: "self:8080", Epoch: 0, Count: 2}}, snapshot.Components)
+	assert.Len(t, snapshot.Distinct, 1)
+
+	dst := setupDistinctServer()
+	importSnapshot := func(path string, body []byte) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		dst.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+		return rr
+	}
+	rr = importSnapshot("/admin/import", exported)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var result counter.ImportResult
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, snapshot.ID, result.ID)
+	assert.Equal(t, 2, result.Increments)
+	assert.Equal(t, int64(2), dst.counter.Value())
+	count, ok := dst.distinct.Get("visitors")
+	require.True(t, ok)
+	assert.Equal(t, uint64(3), count.Estimate)
+
+	rr = importSnapshot("/admin/import", exported)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.True(t, result.Duplicate)
+	assert.Equal(t, http.StatusOK, importSnapshot("/counter/import", exported).Code)
+	assert.Equal(t, int64(2), dst.counter.Value(), "importing again changes nothing")
+
+	unsupported := []byte(`{"version": 99, "id": "s1"}`)
+	assert.Equal(t, http.StatusBadRequest, importSnapshot("/admin/import", unsupported).Code)
+	assert.Equal(t, http.StatusBadRequest, importSnapshot("/counter/import", unsupported).Code)
+	assert.Equal(t, http.StatusBadRequest, importSnapshot("/admin/import", []byte(`{invalid`)).Code)
+
+	dst.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/admin/drain", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, importSnapshot("/admin/import", exported).Code)
+}
+
+func TestHandleImportReportsPeersThatMissedIt(t *testing.T) {
+	registry := cluster.NewRegistry("self:8080", nil)
+	registry.HandleHeartbeat("self:8080")
+	registry.HandleHeartbeat("peer1:8081")
+	fail := true
+	s := NewServer(registry, counter.NewCounter("self:8080", registry, transportFunc(func() error {
+		if fail {
+			return errors.New("connection refused")
+		}
+		return nil
+	})))
+	exported, err := json.Marshal(Snapshot{Snapshot: setupTestServer().counter.Snapshot()})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/import", bytes.NewReader(exported)))
+	require.Equal(t, http.StatusBadGateway, rr.Code)
+	var body struct {
+		Result counter.ImportResult `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, []counter.PeerImport{{Peer: "peer1:8081", Error: "connection refused"}}, body.Result.Peers)
+
+	fail = false
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/import", bytes.NewReader(exported)))
+	assert.Equal(t, http.StatusOK, rr.Code, "importing again reaches the peer")
+}
@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"context"
+	"distributed-counter/internal/distinct"
+	"distributed-counter/internal/wire"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// maxDistinctElements bounds the elements of one POST /distinct/{name}/add.
+const maxDistinctElements = 10000
+
+// addDistinctRequest is the body of POST /distinct/{name}/add.
+type addDistinctRequest struct {
+	Elements []string `json:"elements"`
+}
+
+// SetDistinct enables the distinct counter API.
+func (s *Server) SetDistinct(d *distinct.Counters) {
+	s.distinct = d
+}
+
+// handleAddDistinct adds element IDs, such as user IDs, to a distinct counter.
+func (s *Server) handleAddDistinct(w http.ResponseWriter, r *http.Request) {
+	if s.distinct == nil {
+		http.Error(w, "Distinct counters are not configured", http.StatusNotFound)
+		return
+	}
+	var body addDistinctRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil || len(body.Elements) == 0 {
+		http.Error(w, `Invalid request body, expected {"elements": ["id", ...]}`, http.StatusBadRequest)
+		return
+	}
+	if len(body.Elements) > maxDistinctElements {
+		http.Error(w, "Too many elements in one request", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if s.draining.Load() {
+		http.Error(w, "Node is draining, send writes elsewhere", http.StatusServiceUnavailable)
+		return
+	}
+	count, err := s.distinct.RouteAdd(r.Context(), r.PathValue("name"), body.Elements)
+	if errors.Is(err, distinct.ErrOwnersUnreachable) {
+		http.Error(w, "No owner of the distinct counter is reachable", http.StatusBadGateway)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Propagation queue is full, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, count)
+}
+
+func (s *Server) handleGetDistinct(w http.ResponseWriter, r *http.Request) {
+	if s.distinct == nil {
+		http.Error(w, "Distinct counters are not configured", http.StatusNotFound)
+		return
+	}
+	count, ok, err := s.distinct.RouteGet(r.Context(), r.PathValue("name"))
+	if err != nil {
+		http.Error(w, "No owner of the distinct counter is reachable", http.StatusBadGateway)
+		return
+	}
+	if !ok {
+		http.Error(w, "Unknown distinct counter", http.StatusNotFound)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, count)
+}
+
+// --- Internal Handlers ---
+
+func (s *Server) handleDistinctUpdate(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.distinct == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Distinct counters are not configured")
+	}
+	var update distinct.Update
+	if err := decode(&update); err != nil {
+		return nil, err
+	}
+	if update.Name == "" {
+		return nil, wire.Errorf(http.StatusBadRequest, "Distinct counter name is required")
+	}
+	if err := s.distinct.Apply(update); err != nil {
+		return nil, wire.Errorf(http.StatusBadRequest, "Invalid distinct update: %v", err)
+	}
+	return nil, nil
+}
+
+// handleDistinctAdd adds elements forwarded by a node that doesn't own the
+// counter. It never forwards again: if membership views differ, the elements
+// stay here until the next rebalance hands them on.
+func (s *Server) handleDistinctAdd(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.distinct == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Distinct counters are not configured")
+	}
+	var add distinct.AddRequest
+	if err := decode(&add); err != nil {
+		return nil, err
+	}
+	if add.Name == "" || len(add.Elements) == 0 || len(add.Elements) > maxDistinctElements {
+		return nil, wire.Errorf(http.StatusBadRequest, "Distinct counter name and up to %d elements are required", maxDistinctElements)
+	}
+	count, err := s.distinct.Add(add.Name, add.Elements)
+	if err != nil {
+		return nil, wire.Errorf(http.StatusServiceUnavailable, "Propagation queue is full, retry later")
+	}
+	return count, nil
+}
+
+func (s *Server) handleDistinctRead(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.distinct == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Distinct counters are not configured")
+	}
+	var read distinct.ReadRequest
+	if err := decode(&read); err != nil {
+		return nil, err
+	}
+	count, ok := s.distinct.Get(read.Name)
+	return distinct.ReadReply{Count: count, Found: ok}, nil
+}
+
+func (s *Server) handleDistinctHandoff(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.distinct == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Distinct counters are not configured")
+	}
+	var sketch distinct.Sketch
+	if err := decode(&sketch); err != nil {
+		return nil, err
+	}
+	if sketch.Name == "" {
+		return nil, wire.Errorf(http.StatusBadRequest, "Distinct counter name is required")
+	}
+	if err := s.distinct.Merge(sketch); err != nil {
+		return nil, wire.Errorf(http.StatusBadRequest, "Invalid distinct sketch: %v", err)
+	}
+	return nil, nil
+}
+
+// handleDistinctState returns every distinct counter held here, for a
+// joining node to catch up from.
+func (s *Server) handleDistinctState(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.distinct == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Distinct counters are not configured")
+	}
+	return s.distinct.Sketches(), nil
+}
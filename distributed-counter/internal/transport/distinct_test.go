@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/distinct"
+	"distributed-counter/internal/ring"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupDistinctServer() *Server {
+	s := setupTestServer()
+	s.SetDistinct(distinct.New(s.registry.SelfID(), s.registry, nil, s.counter, distinct.Config{}))
+	return s
+}
+
+func serveDistinct(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rr
+}
+
+func TestDistinctAPI(t *testing.T) {
+	s := setupDistinctServer()
+
+	assert.Equal(t, http.StatusNotFound, serveDistinct(s, http.MethodGet, "/distinct/campaign:1", "").Code)
+
+	ids := make([]string, 100)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("user-%d", i)
+	}
+	body, _ := json.Marshal(map[string][]string{"elements": ids})
+	rr := serveDistinct(s, http.MethodPost, "/distinct/campaign:1/add", string(body))
+	require.Equal(t, http.StatusOK, rr.Code)
+	// Adding the same users again doesn't change the reach.
+	require.Equal(t, http.StatusOK, serveDistinct(s, http.MethodPost, "/distinct/campaign:1/add", string(body)).Code)
+
+	rr = serveDistinct(s, http.MethodGet, "/distinct/campaign:1", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var count distinct.Count
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &count))
+	assert.Equal(t, "campaign:1", count.Name)
+	assert.InDelta(t, 100, float64(count.Estimate), 3)
+	assert.Greater(t, count.StdError, 0.0)
+
+	assert.Equal(t, http.StatusBadRequest, serveDistinct(s, http.MethodPost, "/distinct/campaign:1/add", `{"elements": []}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serveDistinct(s, http.MethodPost, "/distinct/campaign:1/add", `not json`).Code)
+}
+
+func TestDistinctUpdateRoute(t *testing.T) {
+	s := setupDistinctServer()
+	rr := serveDistinct(s, http.MethodPost, "/distinct/update", `{"name": "campaign:1", "precision": 14, "registers": [{"index": 7, "rank": 3}]}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	count, ok := s.distinct.Get("campaign:1")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), count.Estimate)
+
+	rr = serveDistinct(s, http.MethodPost, "/distinct/update", `{"name": "campaign:1", "precision": 10, "registers": []}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestDistinctShardRoutes(t *testing.T) {
+	s := setupDistinctServer()
+	rr := serveDistinct(s, http.MethodPost, "/distinct/add", `{"name": "campaign:1", "elements": ["u1", "u2"]}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var count distinct.Count
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &count))
+	assert.Equal(t, uint64(2), count.Estimate)
+	assert.Equal(t, http.StatusBadRequest, serveDistinct(s, http.MethodPost, "/distinct/add", `{"name": "campaign:1"}`).Code)
+
+	rr = serveDistinct(s, http.MethodPost, "/distinct/read", `{"name": "campaign:1"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var reply distinct.ReadReply
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &reply))
+	assert.True(t, reply.Found)
+	assert.Equal(t, uint64(2), reply.Count.Estimate)
+
+	sketch, _ := json.Marshal(distinct.Sketch{Name: "campaign:2", Registers: make([]byte, 1<<14)})
+	require.Equal(t, http.StatusOK, serveDistinct(s, http.MethodPost, "/distinct/handoff", string(sketch)).Code)
+	_, ok := s.distinct.Get("campaign:2")
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, serveDistinct(s, http.MethodPost, "/distinct/handoff", `{"name": "campaign:3", "registers": "AAA="}`).Code)
+}
+
+func TestDistinctAPI_OwnersUnreachable(t *testing.T) {
+	registry := cluster.NewRegistry("self:8080", nil)
+	registry.HandleHeartbeat("self:8080")
+	registry.HandleHeartbeat("peer1:8081")
+	unreachable := transportFunc(func() error { return errors.New("connection refused") })
+	cntr := counter.NewCounter("self:8080", registry, unreachable)
+	defer cntr.Close()
+	d := distinct.New("self:8080", registry, unreachable, cntr, distinct.Config{Sharding: ring.ShardingConfig{Replicas: 1}})
+	defer d.Close()
+	s := NewServer(registry, cntr)
+	s.SetDistinct(d)
+
+	name := "campaign:1"
+	for i := 2; d.Owns(name); i++ {
+		name = fmt.Sprintf("campaign:%d", i)
+	}
+	assert.Equal(t, http.StatusBadGateway, serveDistinct(s, http.MethodPost, "/distinct/"+name+"/add", `{"elements": ["u1"]}`).Code)
+	assert.Equal(t, http.StatusBadGateway, serveDistinct(s, http.MethodGet, "/distinct/"+name, "").Code)
+}
+
+func TestDistinctAPI_NotConfigured(t *testing.T) {
+	s := setupTestServer()
+	assert.Equal(t, http.StatusNotFound, serveDistinct(s, http.MethodGet, "/distinct/campaign:1", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveDistinct(s, http.MethodPost, "/distinct/campaign:1/add", `{"elements": ["u1"]}`).Code)
+	assert.Equal(t, http.StatusNotFound, serveDistinct(s, http.MethodPost, "/distinct/state", "").Code)
+}
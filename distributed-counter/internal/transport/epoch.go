@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"context"
+	"distributed-counter/internal/counter"
+	"errors"
+	"net/http"
+)
+
+// ResetResult is returned by POST /reset.
+type ResetResult struct {
+	// Epoch is the epoch that just started.
+	Epoch uint64 `json:"epoch"`
+	// Closed is the epoch that ended, with its count at the time of the reset.
+	Closed counter.EpochTotal `json:"closed"`
+}
+
+// Epochs is returned by GET /epochs.
+type Epochs struct {
+	Current uint64               `json:"current"`
+	Epochs  []counter.EpochTotal `json:"epochs"`
+}
+
+// handleReset starts a new epoch across the cluster, zeroing the counter.
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		http.Error(w, "Node is draining, send writes elsewhere", http.StatusServiceUnavailable)
+		return
+	}
+	closed, err := s.counter.Reset()
+	if errors.Is(err, counter.ErrResetConflict) {
+		http.Error(w, "The epoch was already closed by another reset", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Propagation queue is full, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	s.stream.notify()
+	s.respondJSON(w, http.StatusOK, ResetResult{Epoch: closed.Epoch + 1, Closed: closed})
+}
+
+func (s *Server) handleEpochs(w http.ResponseWriter, r *http.Request) {
+	// The current epoch is always the newest one.
+	epochs := s.counter.Epochs()
+	s.respondJSON(w, http.StatusOK, Epochs{Current: epochs[len(epochs)-1].Epoch, Epochs: epochs})
+}
+
+func (s *Server) handleCounterEpoch(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	var advance counter.EpochAdvance
+	if err := decode(&advance); err != nil {
+		return nil, err
+	}
+	if s.counter.AdvanceEpoch(advance.Epoch) {
+		s.stream.notify()
+	}
+	return nil, nil
+}
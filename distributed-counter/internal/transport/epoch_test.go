@@ -0,0 +1,48 @@
+package transport
+
+import (
+	"bytes"
+	"distributed-counter/internal/counter"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleReset(t *testing.T) {
+	s := setupTestServer()
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/increment", nil))
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/reset", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var result ResetResult
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, ResetResult{Epoch: 1, Closed: counter.EpochTotal{Epoch: 0, Count: 1}}, result)
+
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/count", nil))
+	var count map[string]int64
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &count))
+	assert.Equal(t, map[string]int64{"count": 0, "epoch": 1}, count)
+
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/epochs", nil))
+	var epochs Epochs
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &epochs))
+	assert.Equal(t, uint64(1), epochs.Current)
+	assert.Equal(t, []counter.EpochTotal{{Epoch: 0, Count: 1}, {Epoch: 1, Count: 0}}, epochs.Epochs)
+}
+
+func TestHandleCounterEpoch(t *testing.T) {
+	s := setupTestServer()
+	body, _ := json.Marshal(counter.EpochAdvance{Epoch: 4})
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/counter/epoch", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, uint64(4), s.counter.Epoch())
+}
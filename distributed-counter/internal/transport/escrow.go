@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"context"
+	"distributed-counter/internal/escrow"
+	"distributed-counter/internal/wire"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// createBoundedRequest is the body of POST /bounded/{name}.
+type createBoundedRequest struct {
+	Cap int64 `json:"cap"`
+}
+
+// SetEscrow enables the bounded counter API.
+func (s *Server) SetEscrow(m *escrow.Manager) {
+	s.escrow = m
+}
+
+// handleCreateBounded defines a bounded counter whose budget starts out on
+// this node, unless a peer already has it.
+func (s *Server) handleCreateBounded(w http.ResponseWriter, r *http.Request) {
+	if s.escrow == nil {
+		http.Error(w, "Bounded counters are not configured", http.StatusNotFound)
+		return
+	}
+	var body createBoundedRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil || body.Cap <= 0 {
+		http.Error(w, `Invalid request body, expected {"cap": <positive integer>}`, http.StatusBadRequest)
+		return
+	}
+	status, err := s.escrow.Create(r.Context(), r.PathValue("name"), body.Cap)
+	if errors.Is(err, escrow.ErrExists) {
+		http.Error(w, "Bounded counter already exists with a different cap", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, escrow.ErrUnconfirmed) {
+		http.Error(w, "Could not check every peer for the bounded counter, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handleGetBounded(w http.ResponseWriter, r *http.Request) {
+	if s.escrow == nil {
+		http.Error(w, "Bounded counters are not configured", http.StatusNotFound)
+		return
+	}
+	status, err := s.escrow.RouteGet(r.Context(), r.PathValue("name"))
+	switch {
+	case errors.Is(err, escrow.ErrOwnersUnreachable):
+		http.Error(w, "No owner of the bounded counter is reachable", http.StatusBadGateway)
+	case err != nil:
+		http.Error(w, "Unknown bounded counter", http.StatusNotFound)
+	default:
+		s.respondJSON(w, http.StatusOK, status)
+	}
+}
+
+func (s *Server) handleListBounded(w http.ResponseWriter, r *http.Request) {
+	if s.escrow == nil {
+		http.Error(w, "Bounded counters are not configured", http.StatusNotFound)
+		return
+	}
+	statuses, err := s.escrow.RouteList(r.Context())
+	if err != nil {
+		http.Error(w, "No owner of a bounded counter is reachable", http.StatusBadGateway)
+		return
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	s.respondJSON(w, http.StatusOK, statuses)
+}
+
+// handleIncrementBounded spends n (default 1) of a bounded counter's budget,
+// answering 409 when the cluster has less than that left.
+func (s *Server) handleIncrementBounded(w http.ResponseWriter, r *http.Request) {
+	if s.escrow == nil {
+		http.Error(w, "Bounded counters are not configured", http.StatusNotFound)
+		return
+	}
+	n := int64(1)
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.ParseInt(param, 10, 64); err != nil || n <= 0 {
+			http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if s.draining.Load() {
+		http.Error(w, "Node is draining, send writes elsewhere", http.StatusServiceUnavailable)
+		return
+	}
+
+	status, err := s.escrow.Increment(r.Context(), r.PathValue("name"), n)
+	switch {
+	case errors.Is(err, escrow.ErrUnknown):
+		http.Error(w, "Unknown bounded counter", http.StatusNotFound)
+	case errors.Is(err, escrow.ErrCapReached):
+		s.respondJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":  "Cap reached, the cluster has less than n left",
+			"status": status,
+		})
+	case err != nil:
+		http.Error(w, "Propagation queue is full, retry later", http.StatusServiceUnavailable)
+	default:
+		s.respondJSON(w, http.StatusOK, status)
+	}
+}
+
+// --- Internal Handlers ---
+
+func (s *Server) handleEscrowDefine(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.escrow == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Bounded counters are not configured")
+	}
+	var def escrow.Definition
+	if err := decode(&def); err != nil {
+		return nil, err
+	}
+	if def.Name == "" || def.Cap <= 0 {
+		return nil, wire.Errorf(http.StatusBadRequest, "Invalid bounded counter definition")
+	}
+	s.escrow.HandleDefine(def)
+	return nil, nil
+}
+
+func (s *Server) handleEscrowTransfer(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.escrow == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Bounded counters are not configured")
+	}
+	var req escrow.TransferRequest
+	if err := decode(&req); err != nil {
+		return nil, err
+	}
+	return s.escrow.HandleTransfer(req), nil
+}
+
+func (s *Server) handleEscrowDeposit(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.escrow == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Bounded counters are not configured")
+	}
+	var dep escrow.Deposit
+	if err := decode(&dep); err != nil {
+		return nil, err
+	}
+	if dep.Name == "" || dep.Cap <= 0 {
+		return nil, wire.Errorf(http.StatusBadRequest, "Invalid bounded counter definition")
+	}
+	s.escrow.HandleDeposit(dep)
+	return nil, nil
+}
+
+func (s *Server) handleEscrowUsage(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.escrow == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Bounded counters are not configured")
+	}
+	var req escrow.UsageRequest
+	if err := decode(&req); err != nil {
+		return nil, err
+	}
+	return s.escrow.HandleUsage(req), nil
+}
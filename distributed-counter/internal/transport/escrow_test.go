@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"distributed-counter/internal/escrow"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupEscrowServer() *Server {
+	s := setupTestServer()
+	budgets := escrow.New(s.registry.SelfID(), s.counter, s.registry, nil, escrow.Config{})
+	s.counter.OnApply(budgets.Observe)
+	s.SetEscrow(budgets)
+	return s
+}
+
+func serveBounded(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rr
+}
+
+func TestBoundedCounterAPI(t *testing.T) {
+	s := setupEscrowServer()
+
+	rr := serveBounded(s, http.MethodPost, "/bounded/campaign:1", `{"cap": 3}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.StatusConflict, serveBounded(s, http.MethodPost, "/bounded/campaign:1", `{"cap": 5}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serveBounded(s, http.MethodPost, "/bounded/campaign:2", `{"cap": 0}`).Code)
+
+	rr = serveBounded(s, http.MethodPost, "/bounded/campaign:1/increment?n=2", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var status escrow.Status
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.Equal(t, escrow.Status{Name: "campaign:1", Cap: 3, Used: 2, Remaining: 1, Share: 1}, status)
+
+	assert.Equal(t, http.StatusConflict, serveBounded(s, http.MethodPost, "/bounded/campaign:1/increment?n=2", "").Code)
+	assert.Equal(t, http.StatusOK, serveBounded(s, http.MethodPost, "/bounded/campaign:1/increment", "").Code)
+	assert.Equal(t, http.StatusConflict, serveBounded(s, http.MethodPost, "/bounded/campaign:1/increment", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serveBounded(s, http.MethodPost, "/bounded/campaign:1/increment?n=-1", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveBounded(s, http.MethodPost, "/bounded/campaign:9/increment", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveBounded(s, http.MethodGet, "/bounded/campaign:9", "").Code)
+
+	rr = serveBounded(s, http.MethodGet, "/bounded", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var statuses []escrow.Status
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 1)
+	assert.Equal(t, int64(3), statuses[0].Used)
+	assert.Equal(t, int64(0), s.counter.Value(), "bounded counters don't count towards the counter")
+}
+
+func TestEscrowTransferRoute(t *testing.T) {
+	s := setupEscrowServer()
+	require.Equal(t, http.StatusOK, serveBounded(s, http.MethodPost, "/bounded/campaign:1", `{"cap": 10}`).Code)
+
+	rr := serveBounded(s, http.MethodPost, "/escrow/transfer", `{"name": "campaign:1", "want": 4, "from": "peer:8081"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var transfer escrow.Transfer
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &transfer))
+	assert.True(t, transfer.Known)
+	assert.Equal(t, int64(4), transfer.Granted)
+	assert.Equal(t, int64(10), transfer.Cap)
+
+	rr = serveBounded(s, http.MethodGet, "/bounded/campaign:1", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"share":6`)
+}
+
+func TestEscrowUsageRoute(t *testing.T) {
+	s := setupEscrowServer()
+	require.Equal(t, http.StatusOK, serveBounded(s, http.MethodPost, "/bounded/campaign:1", `{"cap": 10}`).Code)
+	require.Equal(t, http.StatusOK, serveBounded(s, http.MethodPost, "/bounded/campaign:1/increment?n=3", "").Code)
+
+	rr := serveBounded(s, http.MethodPost, "/escrow/usage", `{"name": "campaign:1"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var usage escrow.Usage
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &usage))
+	assert.Equal(t, int64(3), usage.Used)
+}
+
+func TestBoundedCounterAPI_NotConfigured(t *testing.T) {
+	s := setupTestServer()
+	assert.Equal(t, http.StatusNotFound, serveBounded(s, http.MethodGet, "/bounded/campaign:1", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveBounded(s, http.MethodPost, "/escrow/transfer", `{}`).Code)
+	assert.Equal(t, http.StatusNotFound, serveBounded(s, http.MethodPost, "/escrow/usage", `{}`).Code)
+}
@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"distributed-counter/internal/eventlog"
+	"net/http"
+	"strconv"
+)
+
+// SetEventLog enables GET /increments.
+func (s *Server) SetEventLog(l *eventlog.Log) {
+	s.events = l
+}
+
+// handleIncrements pages through the increments this node applied:
+// ?since= is the cursor from the previous page's "next", ?node= keeps the
+// increments made on one node, ?key= those with one key, ?unkeyed=true
+// those of the counter itself, and ?limit= sizes the page.
+func (s *Server) handleIncrements(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		http.Error(w, "The event log is not configured", http.StatusNotFound)
+		return
+	}
+	params := r.URL.Query()
+	q := eventlog.Query{Node: params.Get("node"), Key: params.Get("key")}
+	if unkeyed := params.Get("unkeyed"); unkeyed != "" {
+		var err error
+		if q.Unkeyed, err = strconv.ParseBool(unkeyed); err != nil || (q.Unkeyed && q.Key != "") {
+			http.Error(w, "unkeyed must be true or false, and can't be combined with key", http.StatusBadRequest)
+			return
+		}
+	}
+	if since := params.Get("since"); since != "" {
+		var err error
+		if q.Since, err = strconv.ParseUint(since, 10, 64); err != nil {
+			http.Error(w, "since must be a cursor returned as next", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	s.respondJSON(w, http.StatusOK, s.events.Query(q))
+}
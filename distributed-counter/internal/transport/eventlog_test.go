@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"distributed-counter/internal/eventlog"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleIncrements(t *testing.T) {
+	s := setupTestServer()
+	events, err := eventlog.New(eventlog.Config{})
+	require.NoError(t, err)
+	s.counter.OnApply(events.Observe)
+	s.SetEventLog(events)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.counter.IncrementAndPropagate())
+	}
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/increments?limit=2&node=self:8080", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var page eventlog.Page
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Len(t, page.Entries, 2)
+	assert.True(t, page.More)
+	assert.Equal(t, "self:8080", page.Entries[0].Node)
+
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/increments?since=2", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Len(t, page.Entries, 1)
+	assert.Equal(t, uint64(3), page.Next)
+
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/increments?node=other:8080", nil))
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Empty(t, page.Entries)
+
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/increments?key=bounded:ads", nil))
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Empty(t, page.Entries)
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/increments?unkeyed=true", nil))
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Len(t, page.Entries, 3)
+
+	for _, query := range []string{"since=-1", "since=abc", "limit=0", "unkeyed=maybe", "unkeyed=true&key=bounded:ads"} {
+		rr = httptest.NewRecorder()
+		s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/increments?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+}
+
+func TestHandleIncrements_NotConfigured(t *testing.T) {
+	s := setupTestServer()
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/increments", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
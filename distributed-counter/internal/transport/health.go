@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// maxReadySaturation is the propagation queue fill above which the node stops
+// reporting ready, so new writes are steered elsewhere.
+const maxReadySaturation = 0.9
+
+// check is the outcome of a single readiness condition.
+type check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// BeginShutdown makes the node report not ready while it drains.
+func (s *Server) BeginShutdown() {
+	s.shuttingDown.Store(true)
+}
+
+// handleHealthz reports liveness: the process is up and serving requests.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("verbose") == "1" {
+		s.respondJSON(w, http.StatusOK, map[string]interface{}{
+			"status":        "ok",
+			"shutting_down": s.shuttingDown.Load(),
+		})
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// handleReadyz reports whether the node should receive traffic.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := s.readinessChecks()
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.OK
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	if r.URL.Query().Get("verbose") == "1" {
+		s.respondJSON(w, status, map[string]interface{}{
+			"ready":  ready,
+			"checks": checks,
+		})
+		return
+	}
+	w.WriteHeader(status)
+	if ready {
+		w.Write([]byte("ok"))
+	} else {
+		w.Write([]byte("not ready"))
+	}
+}
+
+func (s *Server) readinessChecks() map[string]check {
+	checks := make(map[string]check)
+
+	announced := s.registry.Announced()
+	checks["announce"] = check{OK: announced, Detail: fmt.Sprintf("announced=%t, state=%s", announced, s.registry.State())}
+
+	sync := s.counter.SyncState()
+	checks["catch_up"] = check{OK: sync.Ready(), Detail: string(sync)}
+
+	stats := s.counter.PropagationStats()
+	checks["propagation_queue"] = check{
+		OK:     stats.Saturation < maxReadySaturation,
+		Detail: fmt.Sprintf("%d/%d queued (%.0f%%)", stats.Queued, stats.Config.MaxQueued, stats.Saturation*100),
+	}
+
+	shuttingDown, draining := s.shuttingDown.Load(), s.draining.Load()
+	checks["shutdown"] = check{
+		OK:     !shuttingDown && !draining,
+		Detail: fmt.Sprintf("shutting_down=%t, draining=%t", shuttingDown, draining),
+	}
+
+	return checks
+}
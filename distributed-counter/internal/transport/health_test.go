@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"context"
+	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/counter"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type readyResponse struct {
+	Ready  bool             `json:"ready"`
+	Checks map[string]check `json:"checks"`
+}
+
+func getReadyz(t *testing.T, s *Server) (int, readyResponse) {
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz?verbose=1", nil))
+	var resp readyResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	return rr.Code, resp
+}
+
+func TestHealthz(t *testing.T) {
+	s := setupTestServer()
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok", rr.Body.String())
+
+	// Liveness stays OK while draining.
+	s.BeginShutdown()
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestReadyz_BootstrapNodeIsReady(t *testing.T) {
+	s := setupTestServer()
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok", rr.Body.String())
+
+	code, resp := getReadyz(t, s)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Ready)
+	assert.Equal(t, "bootstrap", resp.Checks["catch_up"].Detail)
+	assert.Len(t, resp.Checks, 4)
+}
+
+func TestReadyz_NotReadyWhileShuttingDown(t *testing.T) {
+	s := setupTestServer()
+	s.BeginShutdown()
+
+	code, resp := getReadyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, resp.Ready)
+	assert.False(t, resp.Checks["shutdown"].OK)
+}
+
+func TestReadyz_NotReadyUntilAnnouncedAndCaughtUp(t *testing.T) {
+	registry := cluster.NewRegistry("self:8080", transportFunc(func() error { return context.DeadlineExceeded }))
+	cntr := counter.NewCounter("self:8080", registry, transportFunc(func() error { return context.DeadlineExceeded }))
+	s := NewServer(registry, cntr)
+	registry.Start([]string{"seed:8081"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cntr.CatchUp(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return cntr.SyncState() == counter.SyncCatchingUp }, time.Second, time.Millisecond)
+
+	code, resp := getReadyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, resp.Checks["announce"].OK)
+	assert.False(t, resp.Checks["catch_up"].OK)
+	assert.True(t, resp.Checks["propagation_queue"].OK)
+
+	cancel()
+	<-done
+	assert.Equal(t, counter.SyncFailed, cntr.SyncState())
+	code, resp = getReadyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, resp.Checks["catch_up"].OK, "a node that gave up catching up is not ready")
+}
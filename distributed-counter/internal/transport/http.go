@@ -1,25 +1,52 @@
 package transport
 
 import (
+	"context"
 	"distributed-counter/internal/cluster"
 	"distributed-counter/internal/counter"
+	"distributed-counter/internal/distinct"
+	"distributed-counter/internal/escrow"
+	"distributed-counter/internal/eventlog"
+	"distributed-counter/internal/ratelimit"
+	"distributed-counter/internal/topk"
+	"distributed-counter/internal/webhook"
+	"distributed-counter/internal/wire"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 // Server encapsulates all HTTP handling logic.
 type Server struct {
 	registry *cluster.Registry
 	counter  *counter.Counter
+	limiter  *ratelimit.Limiter
+	distinct *distinct.Counters
+	topK     *topk.Counters
+	escrow   *escrow.Manager
+	webhooks *webhook.Manager
+	events   *eventlog.Log
+	stream   *streamHub
 	router   *http.ServeMux
+
+	startedAt    time.Time
+	shuttingDown atomic.Bool
+	draining     atomic.Bool
 }
 
 func NewServer(registry *cluster.Registry, counter *counter.Counter) *Server {
 	s := &Server{
-		registry: registry,
-		counter:  counter,
-		router:   http.NewServeMux(),
+		registry:  registry,
+		counter:   counter,
+		stream:    newStreamHub(DefaultStreamConfig),
+		router:    http.NewServeMux(),
+		startedAt: time.Now(),
 	}
+	counter.OnApply(s.observeIncrement)
 	s.registerHandlers()
 	return s
 }
@@ -32,68 +59,239 @@ func (s *Server) registerHandlers() {
 	// Public API
 	s.router.HandleFunc("POST /increment", s.handleIncrement)
 	s.router.HandleFunc("GET /count", s.handleGetCount)
+	s.router.HandleFunc("GET /count/stream", s.handleCountStream)
+	s.router.HandleFunc("POST /reset", s.handleReset)
+	s.router.HandleFunc("GET /epochs", s.handleEpochs)
+	s.router.HandleFunc("GET /increments", s.handleIncrements)
+	s.router.HandleFunc("POST /ratelimit/{key}/take", s.handleRateLimitTake)
+	s.router.HandleFunc("POST /distinct/{name}/add", s.handleAddDistinct)
+	s.router.HandleFunc("GET /distinct/{name}", s.handleGetDistinct)
+	s.router.HandleFunc("POST /topk/{name}/increment", s.handleIncrementTopK)
+	s.router.HandleFunc("GET /topk/{name}", s.handleTopK)
+	s.router.HandleFunc("GET /bounded", s.handleListBounded)
+	s.router.HandleFunc("POST /bounded/{name}", s.handleCreateBounded)
+	s.router.HandleFunc("GET /bounded/{name}", s.handleGetBounded)
+	s.router.HandleFunc("POST /bounded/{name}/increment", s.handleIncrementBounded)
+
+	// Health API
+	s.router.HandleFunc("GET /healthz", s.handleHealthz)
+	s.router.HandleFunc("GET /readyz", s.handleReadyz)
+
+	// Introspection API
+	s.router.HandleFunc("GET /cluster/peers", s.handleClusterPeers)
+	s.router.HandleFunc("GET /cluster/status", s.handleClusterStatus)
+	s.router.HandleFunc("GET /metrics", s.handleMetrics)
+
+	// Admin API
+	s.router.HandleFunc("GET /admin/workers", s.handleWorkerStats)
+	s.router.HandleFunc("GET /admin/state", s.handleDumpState)
+	s.router.HandleFunc("GET /admin/export", s.handleExport)
+	s.router.HandleFunc("POST /admin/import", s.handleImport)
+	s.router.HandleFunc("POST /admin/evict", s.handleEvict)
+	s.router.HandleFunc("POST /admin/drain", s.handleDrain)
+	s.router.HandleFunc("GET /admin/webhooks", s.handleListWebhooks)
+	s.router.HandleFunc("POST /admin/webhooks", s.handleAddWebhook)
+	s.router.HandleFunc("PUT /admin/webhooks/{id}", s.handleUpdateWebhook)
+	s.router.HandleFunc("DELETE /admin/webhooks/{id}", s.handleDeleteWebhook)
+
+	// Internal API, also served by the wire transport
+	for path, h := range s.internalRoutes() {
+		s.router.HandleFunc("POST "+path, s.serveInternal(h))
+	}
+}
+
+// internalRoutes lists the node-to-node messages. Each handler is transport
+// agnostic so the same table backs HTTP and the binary wire protocol.
+func (s *Server) internalRoutes() map[string]wire.HandlerFunc {
+	return map[string]wire.HandlerFunc{
+		// Cluster API
+		"/cluster/join":      s.handleClusterJoin,
+		"/cluster/heartbeat": s.handleClusterHeartbeat,
+
+		// Counter API
+		"/counter/propagate": s.handleCounterPropagate,
+		"/counter/gossip":    s.handleCounterGossip,
+		"/counter/handoff":   s.handleCounterHandoff,
+		"/counter/state":     s.handleCounterState,
+		"/counter/digest":    s.handleCounterDigest,
+		"/counter/recent":    s.handleCounterRecent,
+		"/counter/epoch":     s.handleCounterEpoch,
+		"/counter/import":    s.handleCounterImport,
+
+		// Distinct counter API
+		"/distinct/update":  s.handleDistinctUpdate,
+		"/distinct/add":     s.handleDistinctAdd,
+		"/distinct/read":    s.handleDistinctRead,
+		"/distinct/handoff": s.handleDistinctHandoff,
+		"/distinct/state":   s.handleDistinctState,
+
+		// Top-K API
+		"/topk/update": s.handleTopKUpdate,
+		"/topk/merge":  s.handleTopKMerge,
+		"/topk/state":  s.handleTopKState,
 
-	// Internal Cluster API
-	s.router.HandleFunc("POST /cluster/join", s.handleClusterJoin)
-	s.router.HandleFunc("POST /cluster/heartbeat", s.handleClusterHeartbeat)
+		// Rate limit API
+		"/ratelimit/usage": s.handleRateLimitUsage,
 
-	// Internal Counter API
-	s.router.HandleFunc("POST /counter/propagate", s.handleCounterPropagate)
+		// Escrow API
+		"/escrow/define":   s.handleEscrowDefine,
+		"/escrow/transfer": s.handleEscrowTransfer,
+		"/escrow/deposit":  s.handleEscrowDeposit,
+		"/escrow/usage":    s.handleEscrowUsage,
+
+		// Webhook API
+		"/webhook/state": s.handleWebhookState,
+		"/webhook/merge": s.handleWebhookMerge,
+	}
+}
+
+// InternalRoutes returns the node-to-node handlers by path, for transports
+// other than HTTP and wire such as the in-process simulation network.
+func (s *Server) InternalRoutes() map[string]wire.HandlerFunc {
+	return s.internalRoutes()
+}
+
+// RegisterWire registers the internal API on a wire server.
+func (s *Server) RegisterWire(ws *wire.Server) {
+	for path, h := range s.internalRoutes() {
+		ws.Handle(path, h)
+	}
 }
 
 // --- Public Handlers ---
 
 func (s *Server) handleIncrement(w http.ResponseWriter, r *http.Request) {
-	s.counter.IncrementAndPropagate()
-	w.WriteHeader(http.StatusOK)
+	key, err := idempotencyKey(r)
+	if err != nil {
+		http.Error(w, "Invalid increment request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	keyed := key != ""
+	if !keyed {
+		key = uuid.NewString()
+	} else if inc, seen := s.counter.Lookup(key); seen {
+		// A retry of an increment that already counted, answered even while draining.
+		s.respondIncrement(w, inc, true, true)
+		return
+	}
+
+	if s.draining.Load() {
+		http.Error(w, "Node is draining, send writes elsewhere", http.StatusServiceUnavailable)
+		return
+	}
+	inc, replayed, err := s.counter.IncrementWithID(key)
+	if err != nil {
+		http.Error(w, "Propagation queue is full, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	s.respondIncrement(w, inc, keyed, replayed)
 }
 
+// handleGetCount returns the count of the current epoch, or with
+// ?window=1h only the increments made in that trailing window.
 func (s *Server) handleGetCount(w http.ResponseWriter, r *http.Request) {
-	value := s.counter.Value()
-	response := map[string]int64{"count": value}
+	current := s.counter.Current()
+	response := map[string]interface{}{"count": current.Count, "epoch": current.Epoch}
+	if param := r.URL.Query().Get("window"); param != "" {
+		window, err := time.ParseDuration(param)
+		if err != nil {
+			http.Error(w, "Invalid window, use a duration such as 1m or 24h", http.StatusBadRequest)
+			return
+		}
+		result, err := s.counter.Window(window)
+		if err != nil {
+			http.Error(w, "Invalid window: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		response["count"] = result.Count
+		response["window_seconds"] = int64(window.Seconds())
+		response["from"] = result.From
+		response["as_of"] = result.AsOf
+	}
 	s.respondJSON(w, http.StatusOK, response)
 }
 
 // --- Internal Handlers ---
 
-func (s *Server) handleClusterJoin(w http.ResponseWriter, r *http.Request) {
-	var body map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+func (s *Server) handleClusterJoin(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	var hello cluster.Hello
+	if err := decode(&hello); err != nil {
+		return nil, err
 	}
-	peerID := body["id"]
-	if peerID == "" {
-		http.Error(w, "Peer ID is required", http.StatusBadRequest)
-		return
+	if hello.ID == "" {
+		return nil, wire.Errorf(http.StatusBadRequest, "Peer ID is required")
 	}
-	peerList := s.registry.HandleJoinRequest(peerID)
-	s.respondJSON(w, http.StatusOK, peerList)
+	return s.registry.HandleJoinRequest(hello.ID), nil
 }
 
-func (s *Server) handleClusterHeartbeat(w http.ResponseWriter, r *http.Request) {
-	var body map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+func (s *Server) handleClusterHeartbeat(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	var hello cluster.Hello
+	if err := decode(&hello); err != nil {
+		return nil, err
 	}
-	peerID := body["id"]
-	if peerID == "" {
-		http.Error(w, "Peer ID is required", http.StatusBadRequest)
-		return
+	if hello.ID == "" {
+		return nil, wire.Errorf(http.StatusBadRequest, "Peer ID is required")
 	}
-	s.registry.HandleHeartbeat(peerID)
-	w.WriteHeader(http.StatusOK)
+	s.registry.HandleHeartbeat(hello.ID)
+	return nil, nil
 }
 
-func (s *Server) handleCounterPropagate(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleCounterPropagate(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
 	var inc counter.Increment
-	if err := json.NewDecoder(r.Body).Decode(&inc); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+	if err := decode(&inc); err != nil {
+		return nil, err
+	}
+	s.counter.ApplyPropagated(inc)
+	return nil, nil
+}
+
+func (s *Server) handleCounterGossip(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	var rumor counter.Rumor
+	if err := decode(&rumor); err != nil {
+		return nil, err
+	}
+	s.counter.ApplyRumor(rumor)
+	return nil, nil
+}
+
+func (s *Server) handleCounterHandoff(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	var handoff counter.Handoff
+	if err := decode(&handoff); err != nil {
+		return nil, err
+	}
+	s.counter.ApplyHandoff(handoff)
+	return nil, nil
+}
+
+func (s *Server) handleCounterState(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	return s.counter.State(), nil
+}
+
+// serveInternal adapts an internal handler to HTTP with a JSON body.
+func (s *Server) serveInternal(h wire.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decode := func(v interface{}) error {
+			if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+				return wire.ErrBadRequest
+			}
+			return nil
+		}
+		reply, err := h(r.Context(), decode)
+		if err != nil {
+			var se *wire.StatusError
+			if errors.As(err, &se) {
+				http.Error(w, se.Message, se.Code)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if reply == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, reply)
 	}
-	s.counter.ApplyIncrement(inc)
-	w.WriteHeader(http.StatusOK)
 }
 
 func (s *Server) respondJSON(w http.ResponseWriter, status int, payload interface{}) {
@@ -105,4 +303,4 @@ func (s *Server) respondJSON(w http.ResponseWriter, status int, payload interfac
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	w.Write(response)
-}
\ No newline at end of file
+}
@@ -2,12 +2,18 @@ package transport
 
 import (
 	"bytes"
+	"context"
 	"distributed-counter/internal/cluster"
 	"distributed-counter/internal/counter"
+	"distributed-counter/internal/hlc"
+	"distributed-counter/internal/wire"
+	"distributed-counter/internal/workpool"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -17,7 +23,7 @@ import (
 func setupTestServer() *Server {
 	registry := cluster.NewRegistry("self:8080", nil)
 	// **THIS IS THE FIX**: Manually add self, simulating what Start() does.
-	registry.HandleHeartbeat("self:8080") 
+	registry.HandleHeartbeat("self:8080")
 
 	// The counter needs a registry that implements its interface.
 	// The cluster.Registry works perfectly for this.
@@ -27,7 +33,7 @@ func setupTestServer() *Server {
 
 func TestHandleIncrementAndGetCount(t *testing.T) {
 	s := setupTestServer()
-	
+
 	// Test Increment
 	req := httptest.NewRequest(http.MethodPost, "/increment", nil)
 	rr := httptest.NewRecorder()
@@ -39,7 +45,7 @@ func TestHandleIncrementAndGetCount(t *testing.T) {
 	rr = httptest.NewRecorder()
 	s.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusOK, rr.Code)
-	
+
 	var resp map[string]int64
 	err := json.Unmarshal(rr.Body.Bytes(), &resp)
 	require.NoError(t, err)
@@ -49,13 +55,13 @@ func TestHandleIncrementAndGetCount(t *testing.T) {
 func TestHandleClusterJoin(t *testing.T) {
 	s := setupTestServer()
 	body, _ := json.Marshal(map[string]string{"id": "peer1:8081"})
-	
+
 	req := httptest.NewRequest(http.MethodPost, "/cluster/join", bytes.NewReader(body))
 	rr := httptest.NewRecorder()
 	s.ServeHTTP(rr, req)
-	
+
 	assert.Equal(t, http.StatusOK, rr.Code)
-	
+
 	var peerList []cluster.Peer
 	err := json.Unmarshal(rr.Body.Bytes(), &peerList)
 	require.NoError(t, err)
@@ -77,10 +83,41 @@ func TestHandleCounterPropagate(t *testing.T) {
 	assert.Equal(t, int64(1), s.counter.Value())
 }
 
+func TestHandleCounterGossip(t *testing.T) {
+	s := setupTestServer()
+	rumor := counter.Rumor{Increment: counter.Increment{ID: "inc-123", NodeID: "peer1:8081"}, TTL: 1}
+	body, _ := json.Marshal(rumor)
+
+	req := httptest.NewRequest(http.MethodPost, "/counter/gossip", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(1), s.counter.Value())
+}
+
+func TestHandleCounterHandoff(t *testing.T) {
+	s := setupTestServer()
+	handoff := counter.Handoff{Increments: []counter.Increment{
+		{ID: "inc-1", NodeID: "peer1:8081", Key: "bounded:ads", Delta: 2},
+		{ID: "inc-2", NodeID: "peer1:8081"},
+	}}
+	body, _ := json.Marshal(handoff)
+
+	req := httptest.NewRequest(http.MethodPost, "/counter/handoff", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(1), s.counter.Value())
+	_, seen := s.counter.Lookup("inc-1")
+	assert.True(t, seen)
+}
+
 func TestInvalidJSONRequests(t *testing.T) {
 	s := setupTestServer()
-	
-	endpoints := []string{"/cluster/join", "/cluster/heartbeat", "/counter/propagate"}
+
+	endpoints := []string{"/cluster/join", "/cluster/heartbeat", "/counter/propagate", "/counter/gossip", "/counter/handoff", "/counter/import"}
 	for _, endpoint := range endpoints {
 		t.Run(endpoint, func(t *testing.T) {
 			req := httptest.NewRequest(http.MethodPost, endpoint, bytes.NewReader([]byte("{invalid json")))
@@ -89,4 +126,105 @@ func TestInvalidJSONRequests(t *testing.T) {
 			assert.Equal(t, http.StatusBadRequest, rr.Code)
 		})
 	}
-}
\ No newline at end of file
+}
+func TestRegisterWire(t *testing.T) {
+	s := setupTestServer()
+	ws := wire.NewServer()
+	s.RegisterWire(ws)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go ws.Serve(l)
+	defer ws.Close()
+
+	client := wire.NewClient(nil)
+	defer client.Close()
+	addr := l.Addr().String()
+
+	inc := counter.Increment{ID: "inc-wire", NodeID: "peer1:8081"}
+	require.NoError(t, client.Send(context.Background(), addr, "/counter/propagate", inc, nil))
+	assert.Equal(t, int64(1), s.counter.Value())
+
+	var peerList []cluster.Peer
+	require.NoError(t, client.Send(context.Background(), addr, "/cluster/join", cluster.Hello{ID: "peer1:8081"}, &peerList))
+	assert.Len(t, peerList, 2)
+
+	err = client.Send(context.Background(), addr, "/cluster/heartbeat", cluster.Hello{}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400")
+}
+
+func TestHandleIncrement_SaturatedReturns503(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	registry := cluster.NewRegistry("self:8080", nil)
+	registry.HandleHeartbeat("peer1:8081")
+	blocking := transportFunc(func() error { <-release; return nil })
+	cntr := counter.NewCounterWithConfig("self:8080", registry, blocking, counter.Config{
+		Propagation: workpool.Config{MaxWorkers: 1, MaxPerKey: 1, MaxQueued: 1},
+	})
+	s := NewServer(registry, cntr)
+
+	codes := make([]int, 3)
+	for i := range codes {
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/increment", nil))
+		codes[i] = rr.Code
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable}, codes)
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/workers", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var stats map[string]workpool.Stats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	assert.Equal(t, uint64(1), stats["propagation"].Rejected)
+	assert.Equal(t, 1, stats["propagation"].PerKey["peer1:8081"].Queued)
+}
+
+// transportFunc adapts a function to the counter.Transport interface.
+type transportFunc func() error
+
+func (f transportFunc) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	return f()
+}
+
+func TestHandleCounterState(t *testing.T) {
+	s := setupTestServer()
+	s.counter.ApplyIncrement(counter.Increment{ID: "inc-1", NodeID: "peer1:8081"})
+
+	req := httptest.NewRequest(http.MethodPost, "/counter/state", nil)
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var state counter.State
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &state))
+	assert.Equal(t, []counter.Increment{{ID: "inc-1", NodeID: "peer1:8081"}}, state.Increments)
+}
+
+func TestHandleGetCount_Window(t *testing.T) {
+	s := setupTestServer()
+	require.NoError(t, s.counter.IncrementAndPropagate())
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/count?window=1h", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp struct {
+		Count         int64         `json:"count"`
+		Epoch         uint64        `json:"epoch"`
+		WindowSeconds int64         `json:"window_seconds"`
+		From          int64         `json:"from"`
+		AsOf          hlc.Timestamp `json:"as_of"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, int64(1), resp.Count)
+	assert.Equal(t, int64(3600), resp.WindowSeconds)
+	assert.False(t, resp.AsOf.IsZero())
+	assert.LessOrEqual(t, resp.From, resp.AsOf.Time().Add(-time.Hour).Unix())
+
+	for _, window := range []string{"soon", "-1m", "48h"} {
+		rr = httptest.NewRecorder()
+		s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/count?window="+window, nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, window)
+	}
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"distributed-counter/internal/counter"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// IdempotencyKeyHeader carries a client-chosen ID for POST /increment.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ReplayedHeader is set on answers to a repeated idempotency key.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLen bounds keys, which are kept for the dedup window.
+const maxIdempotencyKeyLen = 255
+
+// IncrementResult is returned by POST /increment. KeyExpires, set when the
+// client sent an idempotency key, is when the key leaves the dedup window:
+// a retry after it counts again.
+type IncrementResult struct {
+	ID         string     `json:"id"`
+	Epoch      uint64     `json:"epoch"`
+	KeyExpires *time.Time `json:"key_expires,omitempty"`
+}
+
+// incrementRequest is the optional JSON body of POST /increment.
+type incrementRequest struct {
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+// idempotencyKey returns the client's key from the header or, failing that,
+// the JSON body. An empty key means the client didn't send one.
+func idempotencyKey(r *http.Request) (string, error) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" && r.Body != nil {
+		var body incrementRequest
+		err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", errors.New("invalid request body")
+		}
+		key = body.IdempotencyKey
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		return "", errors.New("idempotency key is too long")
+	}
+	return key, nil
+}
+
+func (s *Server) respondIncrement(w http.ResponseWriter, inc counter.Increment, keyed, replayed bool) {
+	if replayed {
+		w.Header().Set(ReplayedHeader, "true")
+	}
+	result := IncrementResult{ID: inc.ID, Epoch: inc.Epoch}
+	if keyed && inc.Time != 0 {
+		expires := inc.Timestamp().Time().Add(s.counter.DedupRetention()).UTC()
+		result.KeyExpires = &expires
+	}
+	s.respondJSON(w, http.StatusOK, result)
+}
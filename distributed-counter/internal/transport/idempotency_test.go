@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"distributed-counter/internal/counter"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postIncrement(s *Server, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/increment", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleIncrement_IdempotencyKeyHeader(t *testing.T) {
+	s := setupTestServer()
+
+	first := postIncrement(s, "order-42", "")
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+	var result IncrementResult
+	require.NoError(t, json.Unmarshal(first.Body.Bytes(), &result))
+	assert.Equal(t, "order-42", result.ID)
+	require.NotNil(t, result.KeyExpires)
+	assert.WithinDuration(t, time.Now().Add(counter.DefaultDedupRetention), *result.KeyExpires, time.Minute)
+
+	retry := postIncrement(s, "order-42", "")
+	require.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, int64(1), s.counter.Value())
+}
+
+func TestHandleIncrement_IdempotencyKeyBody(t *testing.T) {
+	s := setupTestServer()
+	body := `{"idempotency_key": "order-7"}`
+
+	require.Equal(t, http.StatusOK, postIncrement(s, "", body).Code)
+	retry := postIncrement(s, "", body)
+	require.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.Equal(t, int64(1), s.counter.Value())
+}
+
+func TestHandleIncrement_KeySeenOnAnotherNode(t *testing.T) {
+	s := setupTestServer()
+	s.counter.ApplyIncrement(counter.Increment{ID: "order-9", NodeID: "peer1:8081"})
+
+	rr := postIncrement(s, "order-9", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get(ReplayedHeader))
+	assert.Equal(t, int64(1), s.counter.Value())
+}
+
+func TestHandleIncrement_ReplayWhileDraining(t *testing.T) {
+	s := setupTestServer()
+	require.Equal(t, http.StatusOK, postIncrement(s, "order-1", "").Code)
+	s.draining.Store(true)
+
+	assert.Equal(t, http.StatusOK, postIncrement(s, "order-1", "").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, postIncrement(s, "order-2", "").Code)
+}
+
+func TestHandleIncrement_BadIdempotencyKeys(t *testing.T) {
+	s := setupTestServer()
+	assert.Equal(t, http.StatusBadRequest, postIncrement(s, strings.Repeat("k", 256), "").Code)
+	assert.Equal(t, http.StatusBadRequest, postIncrement(s, "", "{not json").Code)
+	assert.Equal(t, int64(0), s.counter.Value())
+}
+
+func TestHandleIncrement_NoKeyExpiryWithoutKey(t *testing.T) {
+	s := setupTestServer()
+	rr := postIncrement(s, "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "key_expires")
+}
@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"context"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/metrics"
+	"net/http"
+	"sort"
+)
+
+// handleMetrics exposes the node's gauges and the per-peer propagation
+// histograms in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	current := s.counter.Current()
+	metrics.WriteHeader(w, "counter_value", "gauge", "Count of the current epoch.")
+	metrics.WriteGauge(w, "counter_value", float64(current.Count))
+	metrics.WriteHeader(w, "counter_epoch", "gauge", "Current epoch.")
+	metrics.WriteGauge(w, "counter_epoch", float64(current.Epoch))
+
+	stats := s.counter.PropagationStats()
+	metrics.WriteHeader(w, "counter_propagation_pending", "gauge", "Propagations queued or running, per peer.")
+	peers := make([]string, 0, len(stats.PerKey))
+	for peer := range stats.PerKey {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	for _, peer := range peers {
+		load := stats.PerKey[peer]
+		metrics.WriteGauge(w, "counter_propagation_pending", float64(load.Running+load.Queued), "peer", peer)
+	}
+
+	hists := s.counter.PeerHistograms()
+	metrics.WriteHeader(w, "counter_propagation_lag_seconds", "histogram", "Delay from an increment being made on its origin node to being applied here.")
+	for _, h := range hists {
+		if h.PropagationLag.Count > 0 {
+			metrics.WriteHistogram(w, "counter_propagation_lag_seconds", h.PropagationLag, "origin", h.Peer)
+		}
+	}
+	metrics.WriteHeader(w, "counter_propagation_ack_seconds", "histogram", "Round trip of successful propagations to a peer.")
+	for _, h := range hists {
+		if h.AckLatency.Count > 0 {
+			metrics.WriteHistogram(w, "counter_propagation_ack_seconds", h.AckLatency, "peer", h.Peer)
+		}
+	}
+	metrics.WriteHeader(w, "counter_peer_divergence_increments", "gauge", "Local count minus the peer's, from its last digest.")
+	for _, h := range hists {
+		if h.Divergence != nil {
+			metrics.WriteGauge(w, "counter_peer_divergence_increments", float64(h.Divergence.Increments), "peer", h.Peer)
+		}
+	}
+	metrics.WriteHeader(w, "counter_peer_behind_seconds", "gauge", "How far the peer trails the newest increments applied here, from its last digest.")
+	for _, h := range hists {
+		if h.Divergence != nil {
+			metrics.WriteGauge(w, "counter_peer_behind_seconds", h.Divergence.BehindSeconds, "peer", h.Peer)
+		}
+	}
+}
+
+// --- Internal Handlers ---
+
+func (s *Server) handleCounterDigest(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	return s.counter.Digest(), nil
+}
+
+func (s *Server) handleCounterRecent(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	var req counter.RepairRequest
+	if err := decode(&req); err != nil {
+		return nil, err
+	}
+	return s.counter.Recent(req.Within), nil
+}
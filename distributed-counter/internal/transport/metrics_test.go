@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"distributed-counter/internal/counter"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	s := setupTestServer()
+	require.NoError(t, s.counter.IncrementAndPropagate())
+	s.counter.ApplyPropagated(counter.Increment{ID: "remote", NodeID: "peer1:8081", Time: time.Now().Add(-20 * time.Millisecond).UnixNano()})
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "counter_value 2\n")
+	assert.Contains(t, body, "# TYPE counter_propagation_lag_seconds histogram\n")
+	assert.Contains(t, body, `counter_propagation_lag_seconds_bucket{origin="peer1:8081",le="+Inf"} 1`)
+	assert.Contains(t, body, `counter_propagation_lag_seconds_count{origin="peer1:8081"} 1`)
+}
+
+func TestHandleCounterDigest(t *testing.T) {
+	s := setupTestServer()
+	inc := counter.Increment{ID: "remote", NodeID: "peer1:8081", Time: time.Now().UnixNano(), Logical: 2}
+	s.counter.ApplyIncrement(inc)
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/counter/digest", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var digest counter.Digest
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &digest))
+	assert.Equal(t, int64(1), digest.Count)
+	assert.Equal(t, inc.Timestamp(), digest.Latest["peer1:8081"])
+}
+
+func TestHandleClusterStatus_Convergence(t *testing.T) {
+	s := setupTestServer()
+	s.counter.ApplyPropagated(counter.Increment{ID: "remote", NodeID: "peer1:8081", Time: time.Now().UnixNano()})
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/cluster/status", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var status NodeStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	require.Len(t, status.Convergence, 1)
+	assert.Equal(t, "peer1:8081", status.Convergence[0].Peer)
+	assert.Equal(t, uint64(1), status.Convergence[0].PropagationLag.Count)
+}
+
+func TestHandleCounterRecent(t *testing.T) {
+	s := setupTestServer()
+	s.counter.ApplyIncrement(counter.Increment{ID: "remote", NodeID: "peer1:8081", Time: time.Now().UnixNano()})
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/counter/recent", strings.NewReader(`{"within":60000000000}`)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp counter.RepairResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.Increments, 1)
+	assert.Equal(t, "remote", resp.Increments[0].ID)
+}
@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"context"
+	"distributed-counter/internal/ratelimit"
+	"distributed-counter/internal/wire"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetRateLimiter enables POST /ratelimit/{key}/take.
+func (s *Server) SetRateLimiter(l *ratelimit.Limiter) {
+	s.limiter = l
+}
+
+// handleRateLimitTake answers 200 with the decision if n units of the key's
+// quota were granted, and 429 if not.
+func (s *Server) handleRateLimitTake(w http.ResponseWriter, r *http.Request) {
+	if s.limiter == nil {
+		http.Error(w, "Rate limiting is not configured", http.StatusNotFound)
+		return
+	}
+	n := int64(1)
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.ParseInt(param, 10, 64); err != nil || n <= 0 {
+			http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if s.draining.Load() {
+		http.Error(w, "Node is draining, send writes elsewhere", http.StatusServiceUnavailable)
+		return
+	}
+
+	decision, err := s.limiter.Take(r.PathValue("key"), n)
+	switch {
+	case errors.Is(err, ratelimit.ErrNoRule):
+		http.Error(w, "No rate limit configured for key", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !decision.Allowed {
+		retry := math.Ceil(time.Until(decision.ResetAt).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(max(retry, 1))))
+		s.respondJSON(w, http.StatusTooManyRequests, decision)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, decision)
+}
+
+// --- Internal Handlers ---
+
+func (s *Server) handleRateLimitUsage(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.limiter == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Rate limiting is not configured")
+	}
+	var report []ratelimit.Usage
+	if err := decode(&report); err != nil {
+		return nil, err
+	}
+	s.limiter.Merge(report)
+	return nil, nil
+}
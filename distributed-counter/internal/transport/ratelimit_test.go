@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"distributed-counter/internal/ratelimit"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func takeRateLimit(s *Server, path string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, nil))
+	return rr
+}
+
+func TestHandleRateLimitTake(t *testing.T) {
+	s := setupTestServer()
+	limiter := ratelimit.New(s.registry.SelfID(), s.registry, nil, ratelimit.Config{
+		Rules: map[string]ratelimit.Rule{"advertiser:*": {Limit: 3, Window: time.Hour}},
+	})
+	defer limiter.Close()
+	s.SetRateLimiter(limiter)
+
+	rr := takeRateLimit(s, "/ratelimit/advertiser:1/take?n=2")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var d ratelimit.Decision
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &d))
+	assert.True(t, d.Allowed)
+	assert.Equal(t, int64(1), d.Remaining)
+
+	assert.Equal(t, http.StatusOK, takeRateLimit(s, "/ratelimit/advertiser:1/take").Code)
+	rr = takeRateLimit(s, "/ratelimit/advertiser:1/take")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	assert.Equal(t, int64(0), s.counter.Value(), "rate limit usage doesn't count towards the counter")
+	assert.Empty(t, s.counter.State().Increments, "admissions are not increments")
+	assert.Equal(t, http.StatusNotFound, takeRateLimit(s, "/ratelimit/campaign:1/take").Code)
+	assert.Equal(t, http.StatusBadRequest, takeRateLimit(s, "/ratelimit/advertiser:2/take?n=0").Code)
+}
+
+func TestHandleRateLimitTake_NotConfigured(t *testing.T) {
+	s := setupTestServer()
+	assert.Equal(t, http.StatusNotFound, takeRateLimit(s, "/ratelimit/advertiser:1/take").Code)
+}
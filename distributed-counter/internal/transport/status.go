@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/counter"
+	"net/http"
+	"time"
+)
+
+// NodeStatus is the node-level summary returned by GET /cluster/status.
+type NodeStatus struct {
+	SelfID        string            `json:"self_id"`
+	State         cluster.NodeState `json:"state"`
+	StartedAt     time.Time         `json:"started_at"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	Count         int64             `json:"count"`
+	Epoch         uint64            `json:"epoch"`
+	SyncState     counter.SyncState `json:"sync_state"`
+	Peers         int               `json:"peers"`
+	SuspectPeers  int               `json:"suspect_peers"`
+	ShuttingDown  bool              `json:"shutting_down"`
+	Draining      bool              `json:"draining"`
+	// Convergence is what this node measured about propagation to and
+	// from each peer.
+	Convergence []counter.PeerMetrics `json:"convergence"`
+}
+
+// handleClusterPeers lists every known peer with its health and backlog.
+func (s *Server) handleClusterPeers(w http.ResponseWriter, r *http.Request) {
+	s.respondJSON(w, http.StatusOK, s.peerInfos())
+}
+
+func (s *Server) handleClusterStatus(w http.ResponseWriter, r *http.Request) {
+	s.respondJSON(w, http.StatusOK, s.nodeStatus())
+}
+
+func (s *Server) nodeStatus() NodeStatus {
+	current := s.counter.Current()
+	status := NodeStatus{
+		SelfID:        s.registry.SelfID(),
+		State:         s.registry.State(),
+		StartedAt:     s.startedAt,
+		UptimeSeconds: time.Since(s.startedAt).Seconds(),
+		Count:         current.Count,
+		Epoch:         current.Epoch,
+		SyncState:     s.counter.SyncState(),
+		ShuttingDown:  s.shuttingDown.Load(),
+		Draining:      s.draining.Load(),
+		Convergence:   s.counter.PeerMetrics(),
+	}
+	for _, p := range s.registry.Peers() {
+		switch {
+		case p.Self:
+		case p.Liveness == cluster.LivenessSuspect:
+			status.SuspectPeers++
+		default:
+			status.Peers++
+		}
+	}
+	return status
+}
+
+// peerInfos joins the registry's view of each peer with its propagation backlog.
+func (s *Server) peerInfos() []cluster.PeerInfo {
+	peers := s.registry.Peers()
+	backlog := s.counter.PropagationStats().PerKey
+	for i := range peers {
+		load := backlog[peers[i].Addr]
+		peers[i].PendingPropagations = load.Running + load.Queued
+	}
+	return peers
+}
@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"distributed-counter/internal/cluster"
+	"distributed-counter/internal/counter"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleClusterPeers(t *testing.T) {
+	s := setupTestServer()
+	s.registry.HandleHeartbeat("peer1:8081")
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/cluster/peers", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var peers []cluster.PeerInfo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &peers))
+	require.Len(t, peers, 2)
+	assert.Equal(t, "peer1:8081", peers[0].ID)
+	assert.False(t, peers[0].Self)
+	assert.Equal(t, cluster.LivenessAlive, peers[0].Liveness)
+	assert.Equal(t, "self:8080", peers[1].ID)
+	assert.True(t, peers[1].Self)
+}
+
+func TestHandleClusterStatus(t *testing.T) {
+	s := setupTestServer()
+	s.registry.HandleHeartbeat("peer1:8081")
+	s.counter.ApplyIncrement(counter.Increment{ID: "inc-1", NodeID: "peer1:8081"})
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/cluster/status", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var status NodeStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.Equal(t, "self:8080", status.SelfID)
+	assert.Equal(t, cluster.StateJoined, status.State)
+	assert.Equal(t, int64(1), status.Count)
+	assert.Equal(t, 1, status.Peers)
+	assert.Equal(t, counter.SyncBootstrap, status.SyncState)
+	assert.GreaterOrEqual(t, status.UptimeSeconds, 0.0)
+}
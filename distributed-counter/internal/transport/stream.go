@@ -0,0 +1,187 @@
+package transport
+
+import (
+	"distributed-counter/internal/counter"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StreamConfig tunes GET /count/stream.
+type StreamConfig struct {
+	// Interval is the least time between two events to one subscriber;
+	// changes in between are coalesced into the next event.
+	Interval time.Duration
+	// MaxSubscribers bounds the concurrent streams; more are refused with 503.
+	MaxSubscribers int
+	// KeepAlive is how often an idle stream gets a comment line, so proxies
+	// don't time it out.
+	KeepAlive time.Duration
+}
+
+// DefaultStreamConfig is used until SetStreamConfig is called.
+var DefaultStreamConfig = StreamConfig{
+	Interval:       250 * time.Millisecond,
+	MaxSubscribers: 1000,
+	KeepAlive:      15 * time.Second,
+}
+
+var (
+	errTooManySubscribers = errors.New("too many subscribers")
+	errStreamsClosed      = errors.New("streams are closed")
+)
+
+// streamHub tells subscribers that the count changed. Each subscriber has a
+// one-slot buffer: a change arriving while one is pending is merged into it,
+// since the subscriber reads the latest count when it sends anyway.
+type streamHub struct {
+	mu     sync.Mutex
+	cfg    StreamConfig
+	subs   map[chan struct{}]struct{}
+	done   chan struct{}
+	closed bool
+}
+
+func newStreamHub(cfg StreamConfig) *streamHub {
+	return &streamHub{cfg: cfg, subs: make(map[chan struct{}]struct{}), done: make(chan struct{})}
+}
+
+func (h *streamHub) config() StreamConfig {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cfg
+}
+
+func (h *streamHub) subscribe() (chan struct{}, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errStreamsClosed
+	}
+	if len(h.subs) >= h.cfg.MaxSubscribers {
+		return nil, errTooManySubscribers
+	}
+	ch := make(chan struct{}, 1)
+	h.subs[ch] = struct{}{}
+	return ch, nil
+}
+
+func (h *streamHub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
+
+// notify marks every subscriber's count as changed without blocking.
+func (h *streamHub) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- struct{}{}:
+		default: // a change is already pending
+		}
+	}
+}
+
+// close ends every stream and refuses new ones.
+func (h *streamHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		h.closed = true
+		close(h.done)
+	}
+}
+
+// SetStreamConfig tunes GET /count/stream for subscribers that connect later.
+func (s *Server) SetStreamConfig(cfg StreamConfig) {
+	s.stream.mu.Lock()
+	defer s.stream.mu.Unlock()
+	s.stream.cfg = cfg
+}
+
+// CloseStreams ends every GET /count/stream response, which would otherwise
+// keep http.Server.Shutdown waiting. Register it with RegisterOnShutdown.
+func (s *Server) CloseStreams() {
+	s.stream.close()
+}
+
+// observeIncrement wakes the streams when the count changed.
+func (s *Server) observeIncrement(inc counter.Increment) {
+	if inc.Key == "" {
+		s.stream.notify()
+	}
+}
+
+// handleCountStream pushes the count of the current epoch as Server-Sent
+// Events: one when the stream opens, then one per change, at most one per
+// Interval. Each event is the same JSON as GET /count.
+func (s *Server) handleCountStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	changed, err := s.stream.subscribe()
+	if err != nil {
+		http.Error(w, "Too many streams open, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.stream.unsubscribe(changed)
+	cfg := s.stream.config()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	last := counter.EpochTotal{Count: -1}
+	send := func() error {
+		current := s.counter.Current()
+		if current == last {
+			return nil
+		}
+		last = current
+		data, _ := json.Marshal(map[string]int64{"count": current.Count, "epoch": int64(current.Epoch)})
+		if _, err := fmt.Fprintf(w, "event: count\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if send() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(cfg.KeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stream.done:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-changed:
+			if send() != nil {
+				return
+			}
+			// Changes until the interval is over leave one pending signal.
+			select {
+			case <-r.Context().Done():
+				return
+			case <-s.stream.done:
+				return
+			case <-time.After(cfg.Interval):
+			}
+		}
+	}
+}
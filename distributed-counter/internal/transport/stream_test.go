@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openStream connects to GET /count/stream and returns the data lines of
+// the events as they arrive.
+func openStream(t *testing.T, url string) (*http.Response, <-chan string) {
+	t.Helper()
+	resp, err := http.Get(url + "/count/stream")
+	require.NoError(t, err)
+	events := make(chan string, 100)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				events <- data
+			}
+		}
+	}()
+	return resp, events
+}
+
+func nextEvent(t *testing.T, events <-chan string) string {
+	t.Helper()
+	select {
+	case data, ok := <-events:
+		require.True(t, ok, "stream ended")
+		return data
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+		return ""
+	}
+}
+
+func TestCountStream_PushesChanges(t *testing.T) {
+	s := setupTestServer()
+	s.SetStreamConfig(StreamConfig{Interval: time.Millisecond, MaxSubscribers: 10, KeepAlive: time.Minute})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, events := openStream(t, ts.URL)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"count":0,"epoch":0}`, nextEvent(t, events))
+
+	require.NoError(t, s.counter.IncrementAndPropagate())
+	assert.Equal(t, `{"count":1,"epoch":0}`, nextEvent(t, events))
+
+	reset, err := http.Post(ts.URL+"/reset", "", nil)
+	require.NoError(t, err)
+	reset.Body.Close()
+	assert.Equal(t, `{"count":0,"epoch":1}`, nextEvent(t, events))
+}
+
+func TestCountStream_CoalescesToInterval(t *testing.T) {
+	s := setupTestServer()
+	s.SetStreamConfig(StreamConfig{Interval: 300 * time.Millisecond, MaxSubscribers: 10, KeepAlive: time.Minute})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, events := openStream(t, ts.URL)
+	defer resp.Body.Close()
+	nextEvent(t, events)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, s.counter.IncrementAndPropagate())
+	}
+	// However the increments interleave with the first event, the second
+	// one carries the final count.
+	var last string
+	for last != `{"count":100,"epoch":0}` {
+		last = nextEvent(t, events)
+	}
+	select {
+	case data := <-events:
+		t.Fatalf("unexpected event %s", data)
+	case <-time.After(400 * time.Millisecond):
+	}
+}
+
+func TestCountStream_LimitsSubscribers(t *testing.T) {
+	s := setupTestServer()
+	s.SetStreamConfig(StreamConfig{Interval: time.Millisecond, MaxSubscribers: 2, KeepAlive: time.Minute})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, events := openStream(t, ts.URL)
+		defer resp.Body.Close()
+		nextEvent(t, events)
+	}
+	resp, err := http.Get(ts.URL + "/count/stream")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestCountStream_EndsOnShutdown(t *testing.T) {
+	s := setupTestServer()
+	ts := httptest.NewUnstartedServer(s)
+	ts.Config.RegisterOnShutdown(s.CloseStreams)
+	ts.Start()
+	defer ts.Close()
+
+	var streams []<-chan string
+	for i := 0; i < 5; i++ {
+		resp, events := openStream(t, ts.URL)
+		defer resp.Body.Close()
+		nextEvent(t, events)
+		streams = append(streams, events)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, ts.Config.Shutdown(ctx), "shutdown waits for the streams to end")
+	for i, events := range streams {
+		select {
+		case _, ok := <-events:
+			assert.False(t, ok, fmt.Sprintf("stream %d still open", i))
+		case <-time.After(time.Second):
+			t.Fatalf("stream %d still open", i)
+		}
+	}
+}
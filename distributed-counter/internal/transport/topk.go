@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"context"
+	"distributed-counter/internal/topk"
+	"distributed-counter/internal/wire"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// defaultTopK is how many items GET /topk/{name} returns without ?k=.
+const defaultTopK = 10
+
+// incrementTopKRequest is the body of POST /topk/{name}/increment.
+type incrementTopKRequest struct {
+	Item string `json:"item"`
+	N    int64  `json:"n"`
+}
+
+// SetTopK enables the top-K counter API.
+func (s *Server) SetTopK(t *topk.Counters) {
+	s.topK = t
+}
+
+// handleIncrementTopK counts n (default 1) of an item, such as an ad ID, in
+// a top-K counter.
+func (s *Server) handleIncrementTopK(w http.ResponseWriter, r *http.Request) {
+	if s.topK == nil {
+		http.Error(w, "Top-K counters are not configured", http.StatusNotFound)
+		return
+	}
+	var body incrementTopKRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil || body.Item == "" || body.N < 0 {
+		http.Error(w, `Invalid request body, expected {"item": "<id>", "n": <positive integer>}`, http.StatusBadRequest)
+		return
+	}
+	if body.N == 0 {
+		body.N = 1
+	}
+	if s.draining.Load() {
+		http.Error(w, "Node is draining, send writes elsewhere", http.StatusServiceUnavailable)
+		return
+	}
+	item, err := s.topK.Add(r.PathValue("name"), body.Item, body.N)
+	if err != nil {
+		http.Error(w, "Propagation queue is full, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, item)
+}
+
+// handleTopK returns the heaviest items of a top-K counter across the cluster.
+func (s *Server) handleTopK(w http.ResponseWriter, r *http.Request) {
+	if s.topK == nil {
+		http.Error(w, "Top-K counters are not configured", http.StatusNotFound)
+		return
+	}
+	k := defaultTopK
+	if param := r.URL.Query().Get("k"); param != "" {
+		var err error
+		if k, err = strconv.Atoi(param); err != nil || k <= 0 {
+			http.Error(w, "k must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	result, ok := s.topK.Get(r.PathValue("name"), k)
+	if !ok {
+		http.Error(w, "Unknown top-K counter", http.StatusNotFound)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, result)
+}
+
+// --- Internal Handlers ---
+
+func (s *Server) handleTopKUpdate(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.topK == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Top-K counters are not configured")
+	}
+	var update topk.Update
+	if err := decode(&update); err != nil {
+		return nil, err
+	}
+	if update.Name == "" {
+		return nil, wire.Errorf(http.StatusBadRequest, "Top-K counter name is required")
+	}
+	if err := s.topK.Apply(update); err != nil {
+		return nil, wire.Errorf(http.StatusBadRequest, "Invalid top-K update: %v", err)
+	}
+	return nil, nil
+}
+
+func (s *Server) handleTopKMerge(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.topK == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Top-K counters are not configured")
+	}
+	var sketch topk.Sketch
+	if err := decode(&sketch); err != nil {
+		return nil, err
+	}
+	if sketch.Name == "" {
+		return nil, wire.Errorf(http.StatusBadRequest, "Top-K counter name is required")
+	}
+	if err := s.topK.Merge(sketch); err != nil {
+		return nil, wire.Errorf(http.StatusBadRequest, "Invalid top-K sketch: %v", err)
+	}
+	return nil, nil
+}
+
+// handleTopKState returns every top-K counter, for a joining node to catch
+// up from.
+func (s *Server) handleTopKState(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.topK == nil {
+		return nil, wire.Errorf(http.StatusNotFound, "Top-K counters are not configured")
+	}
+	return s.topK.Sketches(), nil
+}
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"distributed-counter/internal/cms"
+	"distributed-counter/internal/topk"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveTopK(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rr
+}
+
+func setupTopKServer() *Server {
+	s := setupTestServer()
+	s.SetTopK(topk.New(s.registry.SelfID(), s.registry, nil, s.counter, topk.Config{}))
+	return s
+}
+
+func TestTopKAPI(t *testing.T) {
+	s := setupTopKServer()
+	assert.Equal(t, http.StatusNotFound, serveTopK(s, http.MethodGet, "/topk/ads", "").Code)
+
+	require.Equal(t, http.StatusOK, serveTopK(s, http.MethodPost, "/topk/ads/increment", `{"item": "ad-1"}`).Code)
+	rr := serveTopK(s, http.MethodPost, "/topk/ads/increment", `{"item": "ad-2", "n": 5}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var item cms.Item
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &item))
+	assert.Equal(t, cms.Item{Item: "ad-2", Count: 5}, item)
+	require.Equal(t, http.StatusOK, serveTopK(s, http.MethodPost, "/topk/ads/increment", `{"item": "ad-3", "n": 2}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serveTopK(s, http.MethodPost, "/topk/ads/increment", `{"n": 2}`).Code)
+
+	rr = serveTopK(s, http.MethodGet, "/topk/ads?k=2", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var result topk.Result
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	require.Len(t, result.Items, 2)
+	assert.Equal(t, "ad-2", result.Items[0].Item)
+	assert.Equal(t, int64(5), result.Items[0].Count)
+	assert.Equal(t, "ad-3", result.Items[1].Item)
+	assert.Equal(t, int64(8), result.Total)
+
+	assert.Equal(t, http.StatusBadRequest, serveTopK(s, http.MethodGet, "/topk/ads?k=zero", "").Code)
+}
+
+func TestTopKUpdateRoute(t *testing.T) {
+	s := setupTopKServer()
+	rr := serveTopK(s, http.MethodPost, "/topk/update", `{"name": "ads", "origin": "peer1:8081", "item": "ad-1", "width": 2048, "depth": 5, "cells": [{"index": 7, "count": 3}]}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	result, ok := s.topK.Get("ads", 10)
+	require.True(t, ok)
+	assert.Equal(t, int64(3), result.Total)
+
+	rr = serveTopK(s, http.MethodPost, "/topk/update", `{"name": "ads", "origin": "peer1:8081", "width": 64, "depth": 5, "cells": []}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestTopKAPI_NotConfigured(t *testing.T) {
+	s := setupTestServer()
+	assert.Equal(t, http.StatusNotFound, serveTopK(s, http.MethodGet, "/topk/ads", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveTopK(s, http.MethodPost, "/topk/ads/increment", `{"item": "ad-1"}`).Code)
+	assert.Equal(t, http.StatusNotFound, serveTopK(s, http.MethodPost, "/topk/state", "").Code)
+}
@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"context"
+	"distributed-counter/internal/webhook"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// SetWebhooks enables the threshold webhook admin API.
+func (s *Server) SetWebhooks(m *webhook.Manager) {
+	s.webhooks = m
+}
+
+// handleAddWebhook registers a rule from {"counter", "threshold", "url"}.
+func (s *Server) handleAddWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		http.Error(w, "Webhooks are not configured", http.StatusNotFound)
+		return
+	}
+	var rule webhook.Rule
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&rule); err != nil {
+		http.Error(w, `Invalid request body, expected {"counter", "threshold", "url"}`, http.StatusBadRequest)
+		return
+	}
+	rule, err := s.webhooks.Add(rule)
+	if err != nil {
+		http.Error(w, "Invalid webhook rule: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.respondJSON(w, http.StatusCreated, rule)
+}
+
+// handleUpdateWebhook replaces a rule's counter, threshold and URL, re-arming
+// crossings whose delivery was given up.
+func (s *Server) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		http.Error(w, "Webhooks are not configured", http.StatusNotFound)
+		return
+	}
+	var rule webhook.Rule
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&rule); err != nil {
+		http.Error(w, `Invalid request body, expected {"counter", "threshold", "url"}`, http.StatusBadRequest)
+		return
+	}
+	rule, err := s.webhooks.Update(r.PathValue("id"), rule)
+	if errors.Is(err, webhook.ErrUnknownRule) {
+		http.Error(w, "Unknown webhook rule", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid webhook rule: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, rule)
+}
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		http.Error(w, "Webhooks are not configured", http.StatusNotFound)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, s.webhooks.Rules())
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		http.Error(w, "Webhooks are not configured", http.StatusNotFound)
+		return
+	}
+	if err := s.webhooks.Delete(r.PathValue("id")); errors.Is(err, webhook.ErrUnknownRule) {
+		http.Error(w, "Unknown webhook rule", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Internal Handlers ---
+
+func (s *Server) handleWebhookState(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	if s.webhooks == nil {
+		return webhook.State{}, nil
+	}
+	return s.webhooks.State(), nil
+}
+
+func (s *Server) handleWebhookMerge(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+	var state webhook.State
+	if err := decode(&state); err != nil {
+		return nil, err
+	}
+	if s.webhooks != nil {
+		s.webhooks.Merge(state)
+	}
+	return nil, nil
+}
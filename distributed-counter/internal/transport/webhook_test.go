@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"distributed-counter/internal/webhook"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveWebhooks(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rr
+}
+
+func TestWebhookAdminAPI(t *testing.T) {
+	s := setupTestServer()
+	hooks, err := webhook.New(s.registry.SelfID(), webhook.Counters{Counter: s.counter}, s.registry, nil, webhook.Config{})
+	require.NoError(t, err)
+	defer hooks.Close()
+	s.SetWebhooks(hooks)
+
+	rr := serveWebhooks(s, http.MethodPost, "/admin/webhooks", `{"counter": "count", "threshold": 1000, "url": "http://example.com/pause"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var rule webhook.Rule
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rule))
+	assert.NotEmpty(t, rule.ID)
+
+	assert.Equal(t, http.StatusBadRequest, serveWebhooks(s, http.MethodPost, "/admin/webhooks", `{"counter": "count", "threshold": 1000, "url": "ftp://x"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serveWebhooks(s, http.MethodPost, "/admin/webhooks", `nope`).Code)
+
+	rr = serveWebhooks(s, http.MethodGet, "/admin/webhooks", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var rules []webhook.Rule
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rules))
+	assert.Equal(t, []webhook.Rule{rule}, rules)
+
+	rr = serveWebhooks(s, http.MethodPut, "/admin/webhooks/"+rule.ID, `{"counter": "count", "threshold": 2000, "url": "http://example.com/pause"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rule))
+	assert.Equal(t, int64(2000), rule.Threshold)
+	assert.Equal(t, int64(1), rule.Version)
+	assert.Equal(t, http.StatusBadRequest, serveWebhooks(s, http.MethodPut, "/admin/webhooks/"+rule.ID, `{"counter": "count", "threshold": 0, "url": "http://example.com/pause"}`).Code)
+	assert.Equal(t, http.StatusNotFound, serveWebhooks(s, http.MethodPut, "/admin/webhooks/nope", `{"counter": "count", "threshold": 1, "url": "http://example.com/pause"}`).Code)
+
+	// Peers pull every rule, deleted ones included, over the internal API.
+	assert.Equal(t, http.StatusNoContent, serveWebhooks(s, http.MethodDelete, "/admin/webhooks/"+rule.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, serveWebhooks(s, http.MethodDelete, "/admin/webhooks/"+rule.ID, "").Code)
+	rr = serveWebhooks(s, http.MethodPost, "/webhook/state", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"deleted":true`)
+}
+
+func TestWebhookAdminAPI_NotConfigured(t *testing.T) {
+	s := setupTestServer()
+	assert.Equal(t, http.StatusNotFound, serveWebhooks(s, http.MethodGet, "/admin/webhooks", "").Code)
+}
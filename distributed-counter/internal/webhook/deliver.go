@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+const (
+	// DeliveryHeader carries the delivery ID, the same on every retry and on
+	// every node, for receivers to deduplicate.
+	DeliveryHeader = "X-Webhook-Delivery"
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>" of
+	// "<unix seconds>.<body>" under the shared secret.
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Event is the JSON body of a callback.
+type Event struct {
+	Delivery  string    `json:"delivery"`
+	Rule      Rule      `json:"rule"`
+	Counter   string    `json:"counter"`
+	Threshold int64     `json:"threshold"`
+	Value     int64     `json:"value"`
+	Epoch     uint64    `json:"epoch"`
+	Node      string    `json:"node"`
+	Time      time.Time `json:"time"`
+}
+
+// Sign returns the signature of body sent at timestamp, as put in
+// SignatureHeader. Receivers recompute it to authenticate a callback, and
+// reject old timestamps to stop replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Verify checks a SignatureHeader value against body.
+func Verify(secret, header string, body []byte) bool {
+	var timestamp int64
+	var sig string
+	if _, err := fmt.Sscanf(header, "t=%d,v1=%s", &timestamp, &sig); err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(header))
+}
+
+// deliver posts the event until the receiver answers 2xx, it answers a
+// client error other than 429, or the retry time runs out.
+func (m *Manager) deliver(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	op := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, event.Rule.URL, bytes.NewReader(body))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(DeliveryHeader, event.Delivery)
+		if m.cfg.Secret != "" {
+			req.Header.Set(SignatureHeader, Sign(m.cfg.Secret, m.cfg.Clock.Now().Unix(), body))
+		}
+		resp, err := m.cfg.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+			return backoff.Permanent(fmt.Errorf("receiver rejected the callback: %s", resp.Status))
+		default:
+			return fmt.Errorf("receiver answered %s", resp.Status)
+		}
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = m.cfg.RetryMaxElapsedTime
+	if m.cfg.RetryInitialInterval > 0 {
+		b.InitialInterval = m.cfg.RetryInitialInterval
+	}
+	notify := func(err error, wait time.Duration) {
+		log.Printf("Failed to deliver webhook %s, retrying in %s: %v", event.Delivery, wait.Round(time.Millisecond), err)
+	}
+	return backoff.RetryNotify(op, backoff.WithContext(b, ctx), notify)
+}
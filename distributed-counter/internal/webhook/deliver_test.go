@@ -0,0 +1,18 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"delivery":"rule@0"}`)
+	header := Sign("s3cret", 1700000000, body)
+	assert.Regexp(t, `^t=1700000000,v1=[0-9a-f]{64}$`, header)
+
+	assert.True(t, Verify("s3cret", header, body))
+	assert.False(t, Verify("other", header, body))
+	assert.False(t, Verify("s3cret", header, []byte(`{"delivery":"rule@1"}`)))
+	assert.False(t, Verify("s3cret", "garbage", body))
+}
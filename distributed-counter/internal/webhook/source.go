@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"context"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/distinct"
+	"distributed-counter/internal/escrow"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ownerReadTimeout bounds reading a sharded distinct or bounded counter
+// from its owners.
+const ownerReadTimeout = 2 * time.Second
+
+// Counters resolves the counters rules refer to on this node:
+//
+//   - "count" is the main counter, in its current epoch
+//   - "distinct:<name>" is the estimate of a distinct counter
+//   - "bounded:<name>" is what has been spent of a bounded counter
+//
+// Named counters that don't exist yet read zero, so rules can be registered
+// before traffic starts. Sharded distinct and bounded counters are read
+// from their owners. A distinct counter reads zero while none is
+// reachable; a bounded counter can't be read then, as the spends that
+// reached this node alone would be too few.
+type Counters struct {
+	Counter *counter.Counter
+	// Distinct and Budgets may be nil, leaving distinct and bounded
+	// counters unknown.
+	Distinct *distinct.Counters
+	Budgets  *escrow.Manager
+}
+
+func (c Counters) Value(name string) (int64, uint64, bool) {
+	if name == "count" {
+		current := c.Counter.Current()
+		return current.Count, current.Epoch, true
+	}
+	if !c.Known(name) {
+		return 0, 0, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ownerReadTimeout)
+	defer cancel()
+	if name, ok := strings.CutPrefix(name, "distinct:"); ok {
+		count, _, _ := c.Distinct.RouteGet(ctx, name)
+		return int64(count.Estimate), 0, true
+	}
+	bounded := strings.TrimPrefix(name, "bounded:")
+	status, err := c.Budgets.RouteGet(ctx, bounded)
+	if errors.Is(err, escrow.ErrOwnersUnreachable) {
+		return 0, 0, false
+	}
+	return status.Used, 0, true
+}
+
+// Known reports whether name is a counter rules can refer to.
+func (c Counters) Known(name string) bool {
+	if name == "count" {
+		return true
+	}
+	if name, ok := strings.CutPrefix(name, "distinct:"); ok {
+		return name != "" && c.Distinct != nil
+	}
+	if name, ok := strings.CutPrefix(name, "bounded:"); ok {
+		return name != "" && c.Budgets != nil
+	}
+	return false
+}
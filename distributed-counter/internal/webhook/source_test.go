@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"context"
+	"distributed-counter/internal/counter"
+	"distributed-counter/internal/distinct"
+	"distributed-counter/internal/escrow"
+	"distributed-counter/internal/ring"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type noPeers struct{}
+
+func (noPeers) GetPeerAddrs() []string { return nil }
+
+// usageNetwork answers the usage requests of bounded counters with used,
+// unless down.
+type usageNetwork struct {
+	peers []string
+	used  int64
+	down  bool
+}
+
+func (n *usageNetwork) GetPeerAddrs() []string { return n.peers }
+func (n *usageNetwork) KnownAddrs() []string   { return n.peers }
+func (n *usageNetwork) Announced() bool        { return true }
+
+func (n *usageNetwork) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	if n.down || path != "/escrow/usage" {
+		return errors.New("unreachable")
+	}
+	*reply.(*escrow.Usage) = escrow.Usage{Used: n.used}
+	return nil
+}
+
+func TestCounters_Value(t *testing.T) {
+	c := counter.NewCounter("node1:8080", noPeers{}, nil)
+	defer c.Close()
+	c.ApplyIncrement(counter.Increment{ID: "a", Epoch: 2})
+	d := distinct.New("node1:8080", noPeers{}, nil, c, distinct.Config{})
+	defer d.Close()
+	_, err := d.Add("campaign:1", []string{"u1", "u2"})
+	assert.NoError(t, err)
+	source := Counters{Counter: c, Distinct: d}
+
+	value, epoch, ok := source.Value("count")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), value)
+	assert.Equal(t, uint64(2), epoch)
+
+	value, _, ok = source.Value("distinct:campaign:1")
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), value)
+
+	value, _, ok = source.Value("distinct:campaign:2")
+	assert.True(t, ok, "counters that don't exist yet read zero")
+	assert.Zero(t, value)
+
+	_, _, ok = source.Value("bounded:campaign:1")
+	assert.False(t, ok, "no escrow manager")
+	_, _, ok = Counters{Counter: c}.Value("distinct:campaign:1")
+	assert.False(t, ok, "no distinct counters")
+	_, _, ok = source.Value("impressions")
+	assert.False(t, ok)
+}
+
+func TestCounters_ValueReadsShardedBoundedCountersFromOwners(t *testing.T) {
+	net := &usageNetwork{peers: []string{"node2:8080", "node3:8080"}, used: 7}
+	c := counter.NewCounterWithConfig("node1:8080", net, net, counter.Config{
+		Sharding: ring.ShardingConfig{Replicas: 1, RebalanceInterval: time.Hour},
+	})
+	defer c.Close()
+	budgets := escrow.New("node1:8080", c, net, net, escrow.Config{})
+	name := ""
+	for i := 0; name == "" || c.Owns("bounded:"+name); i++ {
+		name = fmt.Sprintf("campaign:%d", i)
+	}
+	budgets.HandleDefine(escrow.Definition{Name: name, Cap: 10, Origin: "node2:8080"})
+	source := Counters{Counter: c, Budgets: budgets}
+
+	value, _, ok := source.Value("bounded:" + name)
+	require.True(t, ok)
+	assert.Equal(t, int64(7), value, "the spends are read from the owner, not this node")
+
+	net.down = true
+	_, _, ok = source.Value("bounded:" + name)
+	assert.False(t, ok, "no partial value while the owners are unreachable")
+	assert.True(t, source.Known("bounded:"+name))
+
+	value, _, ok = source.Value("bounded:campaign:none")
+	assert.True(t, ok, "counters that don't exist yet read zero")
+	assert.Zero(t, value)
+}
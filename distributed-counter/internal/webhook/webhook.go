@@ -0,0 +1,557 @@
+// Package webhook calls back HTTP endpoints when counters cross thresholds.
+//
+// Rules are registered on any node and replicated to all of them: pushed to
+// peers when they change, pulled from a peer at startup and periodically
+// after that, and persisted to a file. Only the rule's owner evaluates and
+// fires it. The owner is picked by rendezvous hashing
+// of the rule ID over the members, so all nodes agree on it once membership
+// settles. A crossing is recorded as fired once its callback succeeded, or
+// as given up once it failed for good, and that record is replicated too, so
+// a new owner doesn't fire it again. Changing a rule re-arms its given-up
+// crossings, and changing its counter, threshold or URL its fired ones too.
+// Deliveries are at least once: while membership changes, or if the owner
+// dies between delivering and recording, a crossing can be delivered twice,
+// always with the same delivery ID for receivers to deduplicate.
+package webhook
+
+import (
+	"context"
+	"distributed-counter/internal/clock"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUnknownRule is returned when deleting a rule that doesn't exist.
+var ErrUnknownRule = errors.New("unknown webhook rule")
+
+const (
+	// DefaultCheckInterval is how often rules are evaluated without changes.
+	DefaultCheckInterval = 1 * time.Second
+	// DefaultSyncInterval is how often rules are pulled from a random peer.
+	DefaultSyncInterval = 30 * time.Second
+	// DefaultRetryMaxElapsedTime is how long a callback is retried.
+	DefaultRetryMaxElapsedTime = 10 * time.Minute
+)
+
+// Rule calls URL once Counter reaches Threshold.
+type Rule struct {
+	ID        string `json:"id"`
+	Counter   string `json:"counter"`
+	Threshold int64  `json:"threshold"`
+	URL       string `json:"url"`
+	// Deleted marks a removed rule, so that merging with peers that still
+	// have it doesn't bring it back.
+	Deleted bool `json:"deleted,omitempty"`
+	// Version counts the updates of the rule; merging keeps the latest.
+	Version int64 `json:"version,omitempty"`
+	// Armed is the version that last changed the counter, threshold or
+	// URL. Crossings of earlier versions don't hold back later ones.
+	Armed int64 `json:"armed,omitempty"`
+}
+
+// delivery returns the delivery ID of the rule's crossing in an epoch.
+func (r Rule) delivery(epoch uint64) string {
+	if r.Armed == 0 {
+		return fmt.Sprintf("%s@%d", r.ID, epoch)
+	}
+	return fmt.Sprintf("%s.%d@%d", r.ID, r.Armed, epoch)
+}
+
+// newer reports whether r supersedes cur, a version of the same rule.
+// Concurrent updates to the same version are ordered by their content, so
+// that every node keeps the same one.
+func (r Rule) newer(cur Rule) bool {
+	if r.Version != cur.Version {
+		return r.Version > cur.Version
+	}
+	return fmt.Sprintf("%s|%d|%s", r.Counter, r.Threshold, r.URL) > fmt.Sprintf("%s|%d|%s", cur.Counter, cur.Threshold, cur.URL)
+}
+
+// GiveUp records a crossing whose callback failed for good, and the rule
+// version it was given up on.
+type GiveUp struct {
+	Delivery string `json:"delivery"`
+	Version  int64  `json:"version"`
+}
+
+// State is the replicated and persisted part of a Manager.
+type State struct {
+	Rules []Rule `json:"rules"`
+	// Fired lists the delivery IDs of crossings that were delivered.
+	Fired []string `json:"fired"`
+	// GivenUp lists the crossings that are not retried until their rule
+	// changes.
+	GivenUp []GiveUp `json:"given_up,omitempty"`
+}
+
+// Source reads the counters that rules refer to. The epoch re-arms rules on
+// counters that reset; counters that don't reset report epoch 0. Value
+// reports ok false for counters it doesn't know, or can't read right now.
+type Source interface {
+	Value(counter string) (value int64, epoch uint64, ok bool)
+	Known(counter string) bool
+}
+
+// Members reports the other known nodes.
+type Members interface {
+	GetPeerAddrs() []string
+}
+
+// Transport sends internal messages to peers.
+type Transport interface {
+	Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error
+}
+
+// Config holds the tunables of a Manager.
+type Config struct {
+	// Secret signs every callback with HMAC-SHA256; empty sends them unsigned.
+	Secret string
+	// RulesFile persists rules and fired crossings; empty keeps them in memory.
+	RulesFile string
+	// CheckInterval and SyncInterval default to DefaultCheckInterval and
+	// DefaultSyncInterval.
+	CheckInterval time.Duration
+	SyncInterval  time.Duration
+	// RetryInitialInterval and RetryMaxElapsedTime shape the exponential
+	// backoff of failed callbacks. Zero values keep the defaults.
+	RetryInitialInterval time.Duration
+	RetryMaxElapsedTime  time.Duration
+	// Client sends the callbacks; nil means a client with a 10s timeout.
+	Client *http.Client
+	// Clock drives the check and sync loops; nil means the wall clock.
+	Clock clock.Clock
+}
+
+// Manager evaluates threshold rules and fires their callbacks.
+type Manager struct {
+	selfID    string
+	source    Source
+	members   Members
+	transport Transport
+	cfg       Config
+
+	mu         sync.Mutex
+	rules      map[string]Rule
+	fired      map[string]bool
+	givenUp    map[string]int64 // delivery ID -> rule version
+	delivering map[string]bool
+
+	changed  chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// New creates a manager, loading the rules file if there is one, and starts
+// evaluating rules.
+func New(selfID string, source Source, members Members, transport Transport, cfg Config) (*Manager, error) {
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = DefaultCheckInterval
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+	if cfg.RetryMaxElapsedTime <= 0 {
+		cfg.RetryMaxElapsedTime = DefaultRetryMaxElapsedTime
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		selfID:     selfID,
+		source:     source,
+		members:    members,
+		transport:  transport,
+		cfg:        cfg,
+		rules:      make(map[string]Rule),
+		fired:      make(map[string]bool),
+		givenUp:    make(map[string]int64),
+		delivering: make(map[string]bool),
+		changed:    make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	if err := m.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	m.wg.Add(1)
+	go m.loop()
+	return m, nil
+}
+
+// Close stops evaluating rules and cancels callbacks in flight.
+func (m *Manager) Close() {
+	m.stopOnce.Do(m.cancel)
+	m.wg.Wait()
+}
+
+// Add registers a rule, assigning its ID, and pushes it to peers.
+func (m *Manager) Add(rule Rule) (Rule, error) {
+	if err := m.validate(rule); err != nil {
+		return Rule{}, err
+	}
+	rule.ID = uuid.NewString()
+	rule.Deleted = false
+	rule.Version = 0
+	rule.Armed = 0
+
+	m.mu.Lock()
+	m.rules[rule.ID] = rule
+	m.saveLocked()
+	m.mu.Unlock()
+
+	log.Printf("Added webhook rule %s: %s >= %d calls %s", rule.ID, rule.Counter, rule.Threshold, rule.URL)
+	m.push(State{Rules: []Rule{rule}})
+	m.Notify()
+	return rule, nil
+}
+
+// Update replaces the counter, threshold and URL of a rule on every node. It
+// re-arms the crossings of the rule whose delivery was given up, and, when
+// the counter, threshold or URL changed, those that fired too.
+func (m *Manager) Update(id string, rule Rule) (Rule, error) {
+	if err := m.validate(rule); err != nil {
+		return Rule{}, err
+	}
+	m.mu.Lock()
+	cur, ok := m.rules[id]
+	if !ok || cur.Deleted {
+		m.mu.Unlock()
+		return Rule{}, ErrUnknownRule
+	}
+	rule.ID = id
+	rule.Deleted = false
+	rule.Version = cur.Version + 1
+	rule.Armed = cur.Armed
+	if rule.Counter != cur.Counter || rule.Threshold != cur.Threshold || rule.URL != cur.URL {
+		rule.Armed = rule.Version
+	}
+	m.rules[id] = rule
+	m.saveLocked()
+	m.mu.Unlock()
+
+	log.Printf("Updated webhook rule %s: %s >= %d calls %s", rule.ID, rule.Counter, rule.Threshold, rule.URL)
+	m.push(State{Rules: []Rule{rule}})
+	m.Notify()
+	return rule, nil
+}
+
+func (m *Manager) validate(rule Rule) error {
+	if rule.Threshold <= 0 {
+		return errors.New("threshold must be positive")
+	}
+	if u, err := url.Parse(rule.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if !m.source.Known(rule.Counter) {
+		return fmt.Errorf("unknown counter %q", rule.Counter)
+	}
+	return nil
+}
+
+// Delete removes a rule on every node.
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	rule, ok := m.rules[id]
+	if !ok || rule.Deleted {
+		m.mu.Unlock()
+		return ErrUnknownRule
+	}
+	rule.Deleted = true
+	m.rules[id] = rule
+	m.saveLocked()
+	m.mu.Unlock()
+
+	log.Printf("Deleted webhook rule %s", id)
+	m.push(State{Rules: []Rule{rule}})
+	return nil
+}
+
+// Rules returns the active rules, ordered by counter and threshold.
+func (m *Manager) Rules() []Rule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rules := make([]Rule, 0, len(m.rules))
+	for _, r := range m.rules {
+		if !r.Deleted {
+			rules = append(rules, r)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Counter != rules[j].Counter {
+			return rules[i].Counter < rules[j].Counter
+		}
+		if rules[i].Threshold != rules[j].Threshold {
+			return rules[i].Threshold < rules[j].Threshold
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules
+}
+
+// State returns every rule, deleted ones included, and every fired or given
+// up crossing.
+func (m *Manager) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stateLocked()
+}
+
+// Merge adopts a peer's rules and fired and given-up crossings. Deletions
+// win, then the latest version of a rule.
+func (m *Manager) Merge(state State) {
+	m.mu.Lock()
+	changed := false
+	for _, r := range state.Rules {
+		if cur, ok := m.rules[r.ID]; !ok || (r.Deleted && !cur.Deleted) || (!cur.Deleted && !r.Deleted && r.newer(cur)) {
+			m.rules[r.ID] = r
+			changed = true
+		}
+	}
+	for _, id := range state.Fired {
+		if !m.fired[id] {
+			m.fired[id] = true
+			changed = true
+		}
+	}
+	for _, g := range state.GivenUp {
+		if v, ok := m.givenUp[g.Delivery]; !ok || g.Version > v {
+			m.givenUp[g.Delivery] = g.Version
+			changed = true
+		}
+	}
+	if changed {
+		m.saveLocked()
+	}
+	m.mu.Unlock()
+	if changed {
+		m.Notify()
+	}
+}
+
+// Sync pulls the state of one peer that answers.
+func (m *Manager) Sync(ctx context.Context) error {
+	peers := m.members.GetPeerAddrs()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	for _, addr := range peers {
+		var state State
+		if err := m.transport.Send(ctx, addr, "/webhook/state", nil, &state); err != nil {
+			log.Printf("Failed to fetch webhook rules from %s: %v", addr, err)
+			continue
+		}
+		m.Merge(state)
+		return nil
+	}
+	return errors.New("no peer answered")
+}
+
+// Notify asks for the rules to be evaluated soon, e.g. after an increment.
+func (m *Manager) Notify() {
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) loop() {
+	defer m.wg.Done()
+	check := m.cfg.Clock.NewTicker(m.cfg.CheckInterval)
+	defer check.Stop()
+	syncTicker := m.cfg.Clock.NewTicker(m.cfg.SyncInterval)
+	defer syncTicker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-syncTicker.C():
+			ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+			m.Sync(ctx)
+			cancel()
+		case <-check.C():
+			m.check()
+		case <-m.changed:
+			m.check()
+		}
+	}
+}
+
+// check fires the crossings this node owns that weren't delivered or given
+// up on the rule's current version yet.
+func (m *Manager) check() {
+	for _, rule := range m.Rules() {
+		if m.owner(rule.ID) != m.selfID {
+			continue
+		}
+		value, epoch, ok := m.source.Value(rule.Counter)
+		if !ok || value < rule.Threshold {
+			continue
+		}
+		delivery := rule.delivery(epoch)
+		m.mu.Lock()
+		version, givenUp := m.givenUp[delivery]
+		skip := m.fired[delivery] || m.delivering[delivery] || (givenUp && version >= rule.Version)
+		if !skip {
+			m.delivering[delivery] = true
+		}
+		m.mu.Unlock()
+		if skip {
+			continue
+		}
+
+		event := Event{
+			Delivery:  delivery,
+			Rule:      rule,
+			Value:     value,
+			Epoch:     epoch,
+			Node:      m.selfID,
+			Time:      m.cfg.Clock.Now().UTC(),
+			Threshold: rule.Threshold,
+			Counter:   rule.Counter,
+		}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			err := m.deliver(m.ctx, event)
+			if err != nil && m.ctx.Err() != nil {
+				// Shutting down; the crossing fires again after the restart.
+				m.mu.Lock()
+				delete(m.delivering, delivery)
+				m.mu.Unlock()
+				return
+			}
+			m.mu.Lock()
+			delete(m.delivering, delivery)
+			if err == nil {
+				m.fired[delivery] = true
+			} else if v, ok := m.givenUp[delivery]; !ok || rule.Version > v {
+				m.givenUp[delivery] = rule.Version
+			}
+			m.saveLocked()
+			m.mu.Unlock()
+			if err != nil {
+				log.Printf("Giving up webhook %s for rule %s until the rule changes: %v", delivery, rule.ID, err)
+				m.push(State{GivenUp: []GiveUp{{Delivery: delivery, Version: rule.Version}}})
+				return
+			}
+			log.Printf("Delivered webhook %s: %s reached %d (threshold %d)", delivery, rule.Counter, value, rule.Threshold)
+			m.push(State{Fired: []string{delivery}})
+		}()
+	}
+}
+
+// owner picks the node that fires a rule: the highest hash of node and rule
+// ID among this node and its peers.
+func (m *Manager) owner(ruleID string) string {
+	best, bestHash := m.selfID, rendezvous(m.selfID, ruleID)
+	for _, addr := range m.members.GetPeerAddrs() {
+		if h := rendezvous(addr, ruleID); h > bestHash || (h == bestHash && addr < best) {
+			best, bestHash = addr, h
+		}
+	}
+	return best
+}
+
+func rendezvous(node, ruleID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(node))
+	h.Write([]byte{0})
+	h.Write([]byte(ruleID))
+	return h.Sum64()
+}
+
+// push sends a change to every peer, best effort; peers that miss it catch
+// up with their next sync.
+func (m *Manager) push(state State) {
+	for _, addr := range m.members.GetPeerAddrs() {
+		go func() {
+			ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+			defer cancel()
+			if err := m.transport.Send(ctx, addr, "/webhook/merge", state, nil); err != nil {
+				log.Printf("Failed to push webhook rules to %s: %v", addr, err)
+			}
+		}()
+	}
+}
+
+func (m *Manager) stateLocked() State {
+	state := State{Rules: make([]Rule, 0, len(m.rules)), Fired: make([]string, 0, len(m.fired))}
+	for _, r := range m.rules {
+		state.Rules = append(state.Rules, r)
+	}
+	for id := range m.fired {
+		state.Fired = append(state.Fired, id)
+	}
+	for id, version := range m.givenUp {
+		state.GivenUp = append(state.GivenUp, GiveUp{Delivery: id, Version: version})
+	}
+	sort.Slice(state.Rules, func(i, j int) bool { return state.Rules[i].ID < state.Rules[j].ID })
+	sort.Strings(state.Fired)
+	sort.Slice(state.GivenUp, func(i, j int) bool { return state.GivenUp[i].Delivery < state.GivenUp[j].Delivery })
+	return state
+}
+
+// load reads the rules file, if configured and present.
+func (m *Manager) load() error {
+	if m.cfg.RulesFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.cfg.RulesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read webhook rules: %w", err)
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse webhook rules %s: %w", m.cfg.RulesFile, err)
+	}
+	for _, r := range state.Rules {
+		m.rules[r.ID] = r
+	}
+	for _, id := range state.Fired {
+		m.fired[id] = true
+	}
+	for _, g := range state.GivenUp {
+		m.givenUp[g.Delivery] = g.Version
+	}
+	log.Printf("Loaded %d webhook rules from %s", len(state.Rules), m.cfg.RulesFile)
+	return nil
+}
+
+// saveLocked writes the rules file atomically, logging failures: the rules
+// survive in memory and on peers.
+func (m *Manager) saveLocked() {
+	if m.cfg.RulesFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(m.stateLocked(), "", "  ")
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(m.cfg.RulesFile), "."+filepath.Base(m.cfg.RulesFile)+".tmp")
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, m.cfg.RulesFile)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to save webhook rules to %s: %v", m.cfg.RulesFile, err)
+	}
+}
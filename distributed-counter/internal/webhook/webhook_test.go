@@ -0,0 +1,383 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// values is a Source whose counters tests set directly.
+type values struct {
+	mu     sync.Mutex
+	values map[string]int64
+	epoch  uint64
+	reads  int
+}
+
+func newValues() *values { return &values{values: map[string]int64{"count": 0}} }
+
+func (v *values) Value(name string) (int64, uint64, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.reads++
+	n, ok := v.values[name]
+	return n, v.epoch, ok
+}
+
+func (v *values) Known(name string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, ok := v.values[name]
+	return ok
+}
+
+func (v *values) set(name string, n int64, epoch uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[name] = n
+	v.epoch = epoch
+}
+
+// receiver records the callbacks it gets, answering with the statuses in
+// fail first.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests int
+	events   []Event
+	heads    []http.Header
+	fail     []int
+}
+
+func newReceiver(t *testing.T, fail ...int) *receiver {
+	rc := &receiver{fail: fail}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.requests++
+		if len(rc.fail) > 0 {
+			w.WriteHeader(rc.fail[0])
+			rc.fail = rc.fail[1:]
+			return
+		}
+		var event Event
+		require.NoError(t, json.Unmarshal(body, &event))
+		if !Verify("s3cret", r.Header.Get(SignatureHeader), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rc.events = append(rc.events, event)
+		rc.heads = append(rc.heads, r.Header.Clone())
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) received() []Event {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]Event(nil), rc.events...)
+}
+
+func (rc *receiver) requestCount() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.requests
+}
+
+// cluster connects managers in process, JSON encoding their messages.
+type cluster struct {
+	mu       sync.Mutex
+	managers map[string]*Manager
+}
+
+type peers struct {
+	c    *cluster
+	self string
+}
+
+func (p peers) GetPeerAddrs() []string {
+	p.c.mu.Lock()
+	defer p.c.mu.Unlock()
+	var addrs []string
+	for addr := range p.c.managers {
+		if addr != p.self {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func (p peers) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	p.c.mu.Lock()
+	m := p.c.managers[addr]
+	p.c.mu.Unlock()
+	if m == nil {
+		return errors.New("unreachable")
+	}
+	switch path {
+	case "/webhook/merge":
+		data, _ := json.Marshal(body)
+		var state State
+		json.Unmarshal(data, &state)
+		m.Merge(state)
+	case "/webhook/state":
+		data, _ := json.Marshal(m.State())
+		return json.Unmarshal(data, reply)
+	}
+	return nil
+}
+
+func newCluster(t *testing.T, source Source, n int, cfg Config) (*cluster, []*Manager) {
+	c := &cluster{managers: make(map[string]*Manager)}
+	var managers []*Manager
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("node-%d:8080", i)
+		p := peers{c: c, self: addr}
+		m, err := New(addr, source, p, p, cfg)
+		require.NoError(t, err)
+		t.Cleanup(m.Close)
+		c.mu.Lock()
+		c.managers[addr] = m
+		c.mu.Unlock()
+		managers = append(managers, m)
+	}
+	return c, managers
+}
+
+var testConfig = Config{Secret: "s3cret", CheckInterval: 10 * time.Millisecond, SyncInterval: time.Hour, RetryInitialInterval: time.Millisecond}
+
+func TestManager_FiresSignedCallbackOncePerCrossing(t *testing.T) {
+	rc := newReceiver(t)
+	source := newValues()
+	_, managers := newCluster(t, source, 1, testConfig)
+	m := managers[0]
+
+	rule, err := m.Add(Rule{Counter: "count", Threshold: 10, URL: rc.URL})
+	require.NoError(t, err)
+	source.set("count", 9, 0)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, rc.received())
+
+	source.set("count", 10, 0)
+	require.Eventually(t, func() bool { return len(rc.received()) == 1 }, 2*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	events := rc.received()
+	require.Len(t, events, 1, "a crossing fires once")
+	assert.Equal(t, rule.ID+"@0", events[0].Delivery)
+	assert.Equal(t, int64(10), events[0].Value)
+	assert.Equal(t, rule.ID+"@0", rc.heads[0].Get(DeliveryHeader))
+
+	// A reset re-arms the rule for the new epoch.
+	source.set("count", 12, 1)
+	require.Eventually(t, func() bool { return len(rc.received()) == 2 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, rule.ID+"@1", rc.received()[1].Delivery)
+}
+
+func TestManager_RetriesFailedCallbacks(t *testing.T) {
+	rc := newReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	source := newValues()
+	source.set("count", 5, 0)
+	_, managers := newCluster(t, source, 1, testConfig)
+
+	_, err := managers[0].Add(Rule{Counter: "count", Threshold: 5, URL: rc.URL})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(rc.received()) == 1 }, 2*time.Second, 5*time.Millisecond)
+	// The crossing is recorded once the delivery's response comes back.
+	assert.Eventually(t, func() bool { return len(managers[0].State().Fired) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestManager_GivesUpOnClientErrors(t *testing.T) {
+	rc := newReceiver(t, http.StatusGone)
+	source := newValues()
+	source.set("count", 5, 0)
+	_, managers := newCluster(t, source, 2, testConfig)
+
+	rule, err := managers[0].Add(Rule{Counter: "count", Threshold: 5, URL: rc.URL})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		for _, m := range managers {
+			if len(m.State().GivenUp) != 1 {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 5*time.Millisecond, "the given-up crossing replicates to every node")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, rc.requestCount(), "later checks don't deliver a given-up crossing again")
+	assert.Empty(t, rc.received())
+	assert.Empty(t, managers[0].State().Fired)
+
+	// Changing the rule re-arms the crossing.
+	_, err = managers[1].Update(rule.ID, Rule{Counter: "count", Threshold: 4, URL: rc.URL})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(rc.received()) == 1 }, 2*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, rc.requestCount())
+	assert.Equal(t, int64(4), rc.received()[0].Threshold)
+	_, err = managers[0].Update("nope", Rule{Counter: "count", Threshold: 4, URL: rc.URL})
+	assert.ErrorIs(t, err, ErrUnknownRule)
+}
+
+func TestManager_PersistsGivenUpCrossings(t *testing.T) {
+	rc := newReceiver(t, http.StatusGone)
+	source := newValues()
+	source.set("count", 5, 0)
+	cfg := testConfig
+	cfg.RulesFile = filepath.Join(t.TempDir(), "webhooks.json")
+
+	_, managers := newCluster(t, source, 1, cfg)
+	_, err := managers[0].Add(Rule{Counter: "count", Threshold: 5, URL: rc.URL})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(managers[0].State().GivenUp) == 1 }, 2*time.Second, 5*time.Millisecond)
+	managers[0].Close()
+
+	newCluster(t, source, 1, cfg)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, rc.requestCount(), "a restarted node doesn't deliver a given-up crossing again")
+}
+
+func TestManager_OnlyOwnerFires(t *testing.T) {
+	rc := newReceiver(t)
+	source := newValues()
+	_, managers := newCluster(t, source, 3, testConfig)
+
+	rule, err := managers[1].Add(Rule{Counter: "count", Threshold: 3, URL: rc.URL})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		for _, m := range managers {
+			if len(m.Rules()) != 1 {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 5*time.Millisecond, "rules replicate to every node")
+
+	source.set("count", 3, 0)
+	require.Eventually(t, func() bool {
+		for _, m := range managers {
+			if len(m.State().Fired) != 1 {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 5*time.Millisecond, "the fired crossing replicates to every node")
+	time.Sleep(50 * time.Millisecond)
+	events := rc.received()
+	require.Len(t, events, 1)
+	assert.Equal(t, managers[0].owner(rule.ID), events[0].Node)
+}
+
+// remotePeers is a membership of peers that can't be reached.
+type remotePeers []string
+
+func (p remotePeers) GetPeerAddrs() []string { return p }
+
+func (p remotePeers) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	return errors.New("unreachable")
+}
+
+func TestManager_OnlyOwnerReadsCounters(t *testing.T) {
+	source := newValues()
+	source.set("count", 5, 0)
+	m, err := New("node-0:8080", source, remotePeers{"node-1:8080"}, remotePeers{"node-1:8080"}, testConfig)
+	require.NoError(t, err)
+	defer m.Close()
+	rule := Rule{Counter: "count", Threshold: 1, URL: "http://example.com/hook"}
+	for i := 0; m.owner(rule.ID) == "node-0:8080"; i++ {
+		rule.ID = fmt.Sprintf("rule-%d", i)
+	}
+	m.Merge(State{Rules: []Rule{rule}})
+
+	time.Sleep(50 * time.Millisecond)
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	assert.Zero(t, source.reads, "a node doesn't read the counters of rules it doesn't fire")
+}
+
+func TestManager_UpdateRearmsFiredRule(t *testing.T) {
+	rc := newReceiver(t)
+	source := newValues()
+	source.set("count", 5, 0)
+	_, managers := newCluster(t, source, 2, testConfig)
+
+	rule, err := managers[0].Add(Rule{Counter: "count", Threshold: 5, URL: rc.URL})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(rc.received()) == 1 }, 2*time.Second, 5*time.Millisecond)
+	require.Eventually(t, func() bool { return len(managers[1].State().Fired) == 1 }, time.Second, 5*time.Millisecond)
+
+	// Updating a rule without changing it leaves the fired crossing alone.
+	_, err = managers[1].Update(rule.ID, Rule{Counter: "count", Threshold: 5, URL: rc.URL})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, rc.received(), 1)
+
+	_, err = managers[1].Update(rule.ID, Rule{Counter: "count", Threshold: 3, URL: rc.URL})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(rc.received()) == 2 }, 2*time.Second, 5*time.Millisecond, "a new threshold fires again")
+	events := rc.received()
+	assert.Equal(t, int64(3), events[1].Threshold)
+	assert.NotEqual(t, events[0].Delivery, events[1].Delivery)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, rc.received(), 2, "and only once")
+}
+
+func TestManager_PersistsRules(t *testing.T) {
+	rc := newReceiver(t)
+	source := newValues()
+	source.set("count", 7, 0)
+	cfg := testConfig
+	cfg.RulesFile = filepath.Join(t.TempDir(), "webhooks.json")
+
+	_, managers := newCluster(t, source, 1, cfg)
+	kept, err := managers[0].Add(Rule{Counter: "count", Threshold: 5, URL: rc.URL})
+	require.NoError(t, err)
+	deleted, err := managers[0].Add(Rule{Counter: "count", Threshold: 100, URL: rc.URL})
+	require.NoError(t, err)
+	require.NoError(t, managers[0].Delete(deleted.ID))
+	require.Eventually(t, func() bool { return len(managers[0].State().Fired) == 1 }, 2*time.Second, 5*time.Millisecond)
+	managers[0].Close()
+
+	_, restarted := newCluster(t, source, 1, cfg)
+	assert.Equal(t, []Rule{kept}, restarted[0].Rules())
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, rc.received(), 1, "a restarted node doesn't fire a delivered crossing again")
+}
+
+func TestManager_MergeKeepsDeletions(t *testing.T) {
+	_, managers := newCluster(t, newValues(), 1, testConfig)
+	m := managers[0]
+	rule, err := m.Add(Rule{Counter: "count", Threshold: 5, URL: "http://example.com/hook"})
+	require.NoError(t, err)
+	require.NoError(t, m.Delete(rule.ID))
+
+	m.Merge(State{Rules: []Rule{rule}})
+	rule.Version = 3
+	m.Merge(State{Rules: []Rule{rule}})
+	assert.Empty(t, m.Rules())
+	assert.ErrorIs(t, m.Delete(rule.ID), ErrUnknownRule)
+}
+
+func TestManager_ValidatesRules(t *testing.T) {
+	_, managers := newCluster(t, newValues(), 1, testConfig)
+	for _, rule := range []Rule{
+		{Counter: "count", Threshold: 0, URL: "http://example.com"},
+		{Counter: "count", Threshold: 1, URL: "example.com/hook"},
+		{Counter: "nope", Threshold: 1, URL: "http://example.com"},
+	} {
+		_, err := managers[0].Add(rule)
+		assert.Error(t, err, rule)
+	}
+}
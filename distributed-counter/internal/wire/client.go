@@ -0,0 +1,255 @@
+package wire
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultTimeout = 5 * time.Second
+
+var errConnClosed = errors.New("connection closed")
+
+// Breaker guards each destination, failing requests fast while the peer is
+// considered down. httpclient.Breakers implements it.
+type Breaker interface {
+	Allow(dest string) error
+	Record(dest string, failed bool)
+}
+
+// Client sends internal messages over persistent wire connections, one per peer.
+type Client struct {
+	resolve func(addr string) (string, error)
+	timeout time.Duration
+	breaker Breaker
+
+	mu    sync.Mutex
+	conns map[string]*conn
+}
+
+// NewClient creates a client. resolve maps a node address to its wire
+// listener address; nil dials the node address as is.
+func NewClient(resolve func(addr string) (string, error)) *Client {
+	return NewClientWithBreaker(resolve, nil)
+}
+
+// NewClientWithBreaker creates a client whose sends go through breaker,
+// which sees connection failures, timeouts and 5xx answers as failures.
+func NewClientWithBreaker(resolve func(addr string) (string, error), breaker Breaker) *Client {
+	if resolve == nil {
+		resolve = func(addr string) (string, error) { return addr, nil }
+	}
+	return &Client{
+		resolve: resolve,
+		timeout: defaultTimeout,
+		breaker: breaker,
+		conns:   make(map[string]*conn),
+	}
+}
+
+// Send delivers body to path on the node at addr and decodes the reply, if any, into reply.
+// It fails fast, without contacting the peer, while the breaker is open.
+func (c *Client) Send(ctx context.Context, addr, path string, body interface{}, reply interface{}) error {
+	if c.breaker == nil {
+		_, err := c.send(ctx, addr, path, body, reply)
+		return err
+	}
+	if err := c.breaker.Allow(addr); err != nil {
+		return fmt.Errorf("request to %s failed: %w", addr, err)
+	}
+	failed, err := c.send(ctx, addr, path, body, reply)
+	c.breaker.Record(addr, failed)
+	return err
+}
+
+// send performs the request. The boolean is true when the error means the
+// peer is unreachable or unhealthy, as opposed to rejecting this request.
+func (c *Client) send(ctx context.Context, addr, path string, body interface{}, reply interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	enc, payload, err := encodePayload(body)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode request body: %w", err)
+	}
+
+	cn, err := c.conn(ctx, addr)
+	if err != nil {
+		return true, fmt.Errorf("request failed: %w", err)
+	}
+	resp, err := cn.roundTrip(ctx, frame{enc: enc, path: path, payload: payload})
+	if err != nil {
+		return true, fmt.Errorf("request failed: %w", err)
+	}
+
+	if resp.status != 200 {
+		err := &StatusError{Code: int(resp.status), Message: string(resp.payload)}
+		return err.Code >= 500, fmt.Errorf("received non-OK status code: %w", err)
+	}
+	if reply != nil && resp.enc != encNone {
+		if err := decodePayload(resp.enc, resp.payload, reply); err != nil {
+			return false, fmt.Errorf("failed to decode response body: %w", err)
+		}
+	}
+	return false, nil
+}
+
+// Close closes all open connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	conns := c.conns
+	c.conns = make(map[string]*conn)
+	c.mu.Unlock()
+
+	for _, cn := range conns {
+		cn.close(errConnClosed)
+	}
+	return nil
+}
+
+func (c *Client) conn(ctx context.Context, addr string) (*conn, error) {
+	c.mu.Lock()
+	cn, ok := c.conns[addr]
+	c.mu.Unlock()
+	if ok {
+		return cn, nil
+	}
+
+	target, err := c.resolve(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.conns[addr]; ok {
+		// Another sender dialed concurrently; keep theirs.
+		nc.Close()
+		return existing, nil
+	}
+	cn = newConn(nc, func() {
+		c.mu.Lock()
+		if c.conns[addr] == cn {
+			delete(c.conns, addr)
+		}
+		c.mu.Unlock()
+	})
+	c.conns[addr] = cn
+	go cn.readLoop()
+	return cn, nil
+}
+
+// conn multiplexes concurrent requests over one TCP connection.
+type conn struct {
+	nc      net.Conn
+	onClose func()
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan frame
+	err     error
+}
+
+func newConn(nc net.Conn, onClose func()) *conn {
+	cn := &conn{
+		nc:      nc,
+		onClose: onClose,
+		w:       bufio.NewWriter(nc),
+		pending: make(map[uint32]chan frame),
+	}
+	return cn
+}
+
+func (cn *conn) roundTrip(ctx context.Context, req frame) (frame, error) {
+	ch := make(chan frame, 1)
+	cn.mu.Lock()
+	if cn.err != nil {
+		cn.mu.Unlock()
+		return frame{}, cn.err
+	}
+	cn.nextID++
+	req.id = cn.nextID
+	cn.pending[req.id] = ch
+	cn.mu.Unlock()
+
+	defer func() {
+		cn.mu.Lock()
+		delete(cn.pending, req.id)
+		cn.mu.Unlock()
+	}()
+
+	cn.wmu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.nc.SetWriteDeadline(deadline)
+	}
+	err := writeFrame(cn.w, req)
+	cn.wmu.Unlock()
+	if err != nil {
+		cn.close(err)
+		return frame{}, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return frame{}, cn.closeErr()
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return frame{}, ctx.Err()
+	}
+}
+
+func (cn *conn) readLoop() {
+	r := bufio.NewReader(cn.nc)
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			cn.close(err)
+			return
+		}
+		cn.mu.Lock()
+		ch, ok := cn.pending[f.id]
+		delete(cn.pending, f.id)
+		cn.mu.Unlock()
+		if ok {
+			ch <- f
+		}
+	}
+}
+
+func (cn *conn) closeErr() error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.err
+}
+
+// close fails all pending requests and detaches the connection from its client.
+func (cn *conn) close(err error) {
+	cn.mu.Lock()
+	if cn.err != nil {
+		cn.mu.Unlock()
+		return
+	}
+	cn.err = err
+	for id, ch := range cn.pending {
+		close(ch)
+		delete(cn.pending, id)
+	}
+	cn.mu.Unlock()
+
+	cn.nc.Close()
+	cn.onClose()
+}
@@ -0,0 +1,193 @@
+package wire
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("wire: server closed")
+
+// DefaultMaxInflightPerConn is how many frames of one connection are handled
+// concurrently by default. Further frames wait unread, which pushes back on
+// the sender through TCP flow control.
+const DefaultMaxInflightPerConn = 32
+
+// Server dispatches incoming wire frames to handlers registered by path.
+type Server struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.RWMutex
+	handlers  map[string]HandlerFunc
+	inflight  int // per connection
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a server that handles up to DefaultMaxInflightPerConn
+// frames per connection at once.
+func NewServer() *Server {
+	return NewServerWithLimit(DefaultMaxInflightPerConn)
+}
+
+// NewServerWithLimit creates a server that handles up to maxInflight frames
+// per connection at once; zero or less means DefaultMaxInflightPerConn.
+func NewServerWithLimit(maxInflight int) *Server {
+	if maxInflight <= 0 {
+		maxInflight = DefaultMaxInflightPerConn
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		ctx:       ctx,
+		cancel:    cancel,
+		handlers:  make(map[string]HandlerFunc),
+		inflight:  maxInflight,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Handle registers the handler for messages sent to path.
+func (s *Server) Handle(path string, h HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[path] = h
+}
+
+// Serve accepts connections on l until the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.RLock()
+			closed := s.closed
+			s.mu.RUnlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return ErrServerClosed
+		}
+		s.conns[nc] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(nc)
+	}
+}
+
+// Close stops all listeners, closes open connections and waits for them to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for nc := range s.conns {
+		nc.Close()
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		nc.Close()
+	}()
+
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+	var wmu sync.Mutex
+	var inflight sync.WaitGroup
+	defer inflight.Wait()
+	slots := make(chan struct{}, s.inflight)
+
+	for {
+		req, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		// Stop reading until a handler finishes once the limit is reached.
+		select {
+		case slots <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+		inflight.Add(1)
+		go func() {
+			defer func() { <-slots }()
+			defer inflight.Done()
+			resp := s.dispatch(req)
+			wmu.Lock()
+			defer wmu.Unlock()
+			if err := writeFrame(w, resp); err != nil {
+				log.Printf("Failed to write wire response to %s: %v", nc.RemoteAddr(), err)
+				nc.Close()
+			}
+		}()
+	}
+}
+
+func (s *Server) dispatch(req frame) frame {
+	s.mu.RLock()
+	h, ok := s.handlers[req.path]
+	s.mu.RUnlock()
+	if !ok {
+		return errorFrame(req.id, &StatusError{Code: http.StatusNotFound, Message: "unknown path " + req.path})
+	}
+
+	decode := func(v interface{}) error {
+		if err := decodePayload(req.enc, req.payload, v); err != nil {
+			return ErrBadRequest
+		}
+		return nil
+	}
+	reply, err := h(s.ctx, decode)
+	if err != nil {
+		return errorFrame(req.id, err)
+	}
+
+	enc, payload, err := encodePayload(reply)
+	if err != nil {
+		return errorFrame(req.id, err)
+	}
+	return frame{id: req.id, status: http.StatusOK, enc: enc, payload: payload}
+}
+
+func errorFrame(id uint32, err error) frame {
+	status, msg := http.StatusInternalServerError, err.Error()
+	var se *StatusError
+	if errors.As(err, &se) {
+		status, msg = se.Code, se.Message
+	}
+	return frame{id: id, status: uint16(status), enc: encText, payload: []byte(msg)}
+}
@@ -0,0 +1,228 @@
+// Package wire implements the compact binary protocol used for node-to-node
+// messages. Requests and responses are length-prefixed frames multiplexed over
+// persistent TCP connections.
+package wire
+
+import (
+	"bufio"
+	"context"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const maxFrameSize = 16 << 20
+
+// Payload encodings carried in each frame.
+const (
+	encNone byte = iota
+	encJSON
+	encBinary
+	encText
+)
+
+// HandlerFunc serves one internal message. decode reads the request body into v.
+// A non-nil reply is sent back to the caller.
+type HandlerFunc func(ctx context.Context, decode func(v interface{}) error) (interface{}, error)
+
+// StatusError carries an HTTP-style status code back to the caller, whichever
+// transport delivered the message.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// Errorf returns a StatusError with a formatted message.
+func Errorf(code int, format string, args ...interface{}) error {
+	return &StatusError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrBadRequest is returned by decode functions when a body cannot be parsed.
+var ErrBadRequest = &StatusError{Code: http.StatusBadRequest, Message: "Invalid request body"}
+
+// PortOffset returns a resolver that maps a node's HTTP address to its wire
+// listener by adding offset to the port.
+func PortOffset(offset int) func(addr string) (string, error) {
+	return func(addr string) (string, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", err
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return "", fmt.Errorf("invalid port in %q: %w", addr, err)
+		}
+		return net.JoinHostPort(host, strconv.Itoa(p+offset)), nil
+	}
+}
+
+// frame is the unit exchanged on a connection. Requests carry a path; responses
+// carry a status.
+type frame struct {
+	id      uint32
+	status  uint16
+	enc     byte
+	path    string
+	payload []byte
+}
+
+// Layout: len u32 | id u32 | status u16 | enc u8 | uvarint path length | path | payload.
+func writeFrame(w *bufio.Writer, f frame) error {
+	var hdr [4 + 4 + 2 + 1 + binary.MaxVarintLen64]byte
+	n := 4
+	binary.BigEndian.PutUint32(hdr[n:], f.id)
+	n += 4
+	binary.BigEndian.PutUint16(hdr[n:], f.status)
+	n += 2
+	hdr[n] = f.enc
+	n++
+	n += binary.PutUvarint(hdr[n:], uint64(len(f.path)))
+	size := n - 4 + len(f.path) + len(f.payload)
+	if size > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds limit", size)
+	}
+	binary.BigEndian.PutUint32(hdr[:4], uint32(size))
+
+	w.Write(hdr[:n])
+	w.WriteString(f.path)
+	w.Write(f.payload)
+	return w.Flush()
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return frame{}, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrameSize || n < 7 {
+		return frame{}, fmt.Errorf("invalid frame size %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		id:     binary.BigEndian.Uint32(buf[0:]),
+		status: binary.BigEndian.Uint16(buf[4:]),
+		enc:    buf[6],
+	}
+	rd := NewReader(buf[7:])
+	f.path = rd.ReadString()
+	f.payload = rd.Rest()
+	if err := rd.Err(); err != nil {
+		return frame{}, fmt.Errorf("malformed frame: %w", err)
+	}
+	return f, nil
+}
+
+// encodePayload prefers a type's own binary encoding and falls back to JSON.
+func encodePayload(v interface{}) (byte, []byte, error) {
+	if v == nil {
+		return encNone, nil, nil
+	}
+	if m, ok := v.(encoding.BinaryMarshaler); ok {
+		b, err := m.MarshalBinary()
+		return encBinary, b, err
+	}
+	b, err := json.Marshal(v)
+	return encJSON, b, err
+}
+
+func decodePayload(enc byte, data []byte, v interface{}) error {
+	switch enc {
+	case encNone:
+		return io.EOF
+	case encJSON:
+		return json.Unmarshal(data, v)
+	case encBinary:
+		u, ok := v.(encoding.BinaryUnmarshaler)
+		if !ok {
+			return fmt.Errorf("%T cannot decode a binary payload", v)
+		}
+		return u.UnmarshalBinary(data)
+	default:
+		return fmt.Errorf("unknown payload encoding %d", enc)
+	}
+}
+
+// AppendString appends a length-prefixed string, for use in MarshalBinary.
+func AppendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// Reader decodes values written by AppendString and binary.AppendUvarint/AppendVarint.
+// The first error is sticky and reported by Err.
+type Reader struct {
+	buf []byte
+	err error
+}
+
+var errShortBuffer = errors.New("short buffer")
+
+func NewReader(b []byte) *Reader {
+	return &Reader{buf: b}
+}
+
+func (r *Reader) ReadUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errShortBuffer
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *Reader) ReadVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errShortBuffer
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *Reader) ReadString() string {
+	n := r.ReadUvarint()
+	if r.err != nil {
+		return ""
+	}
+	if uint64(len(r.buf)) < n {
+		r.err = errShortBuffer
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+// Rest returns the unread bytes.
+func (r *Reader) Rest() []byte {
+	b := r.buf
+	r.buf = nil
+	return b
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
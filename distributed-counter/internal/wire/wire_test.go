@@ -0,0 +1,240 @@
+package wire
+
+import (
+	"context"
+	"distributed-counter/internal/httpclient"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// message exercises the binary payload path.
+type message struct {
+	ID     string `json:"id"`
+	NodeID string `json:"node_id"`
+}
+
+func (m message) MarshalBinary() ([]byte, error) {
+	return AppendString(AppendString(nil, m.ID), m.NodeID), nil
+}
+
+func (m *message) UnmarshalBinary(data []byte) error {
+	r := NewReader(data)
+	m.ID = r.ReadString()
+	m.NodeID = r.ReadString()
+	return r.Err()
+}
+
+func startServer(t testing.TB, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func echoServer() *Server {
+	s := NewServer()
+	s.Handle("/echo", func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		var m message
+		if err := decode(&m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	})
+	s.Handle("/json", func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		var body map[string]string
+		if err := decode(&body); err != nil {
+			return nil, err
+		}
+		return map[string]string{"got": body["id"]}, nil
+	})
+	s.Handle("/teapot", func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		return nil, Errorf(http.StatusTeapot, "short and stout")
+	})
+	return s
+}
+
+func TestClient_SendBinaryAndJSON(t *testing.T) {
+	addr := startServer(t, echoServer())
+	c := NewClient(nil)
+	defer c.Close()
+
+	var reply message
+	err := c.Send(context.Background(), addr, "/echo", message{ID: "inc-1", NodeID: "node1"}, &reply)
+	require.NoError(t, err)
+	assert.Equal(t, message{ID: "inc-1", NodeID: "node1"}, reply)
+
+	var jsonReply map[string]string
+	err = c.Send(context.Background(), addr, "/json", map[string]string{"id": "node2"}, &jsonReply)
+	require.NoError(t, err)
+	assert.Equal(t, "node2", jsonReply["got"])
+}
+
+func TestClient_SendErrors(t *testing.T) {
+	addr := startServer(t, echoServer())
+	c := NewClient(nil)
+	defer c.Close()
+
+	err := c.Send(context.Background(), addr, "/teapot", nil, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "received non-OK status code: 418 short and stout")
+	var se *StatusError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, http.StatusTeapot, se.Code)
+
+	err = c.Send(context.Background(), addr, "/missing", nil, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "received non-OK status code: 404")
+
+	// An empty body cannot be decoded into a message.
+	err = c.Send(context.Background(), addr, "/echo", nil, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "received non-OK status code: 400")
+}
+
+func TestClient_ReconnectsAfterServerRestart(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	s := echoServer()
+	go s.Serve(l)
+
+	c := NewClient(nil)
+	defer c.Close()
+	require.NoError(t, c.Send(context.Background(), addr, "/echo", message{ID: "a"}, nil))
+
+	s.Close()
+	assert.Error(t, c.Send(context.Background(), addr, "/echo", message{ID: "b"}, nil))
+
+	l, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	s = echoServer()
+	go s.Serve(l)
+	defer s.Close()
+
+	// The broken connection was dropped, so the next send dials again.
+	assert.Eventually(t, func() bool {
+		return c.Send(context.Background(), addr, "/echo", message{ID: "c"}, nil) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_BoundsInflightFramesPerConn(t *testing.T) {
+	s := NewServerWithLimit(2)
+	var running, peak atomic.Int32
+	release := make(chan struct{})
+	s.Handle("/slow", func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		return nil, nil
+	})
+	addr := startServer(t, s)
+	c := NewClient(nil)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, c.Send(context.Background(), addr, "/slow", nil, nil))
+		}()
+	}
+	assert.Eventually(t, func() bool { return running.Load() == 2 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+func TestClient_BreakerFailsFast(t *testing.T) {
+	addr := startServer(t, echoServer())
+	breakers := httpclient.NewBreakers(httpclient.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute})
+	c := NewClientWithBreaker(nil, breakers)
+	defer c.Close()
+
+	require.Error(t, c.Send(context.Background(), addr, "/teapot", nil, nil))
+	assert.False(t, breakers.CircuitOpen(addr), "a rejected request doesn't open the circuit")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	down := l.Addr().String()
+	l.Close()
+	require.Error(t, c.Send(context.Background(), down, "/echo", message{ID: "a"}, nil))
+	assert.True(t, breakers.CircuitOpen(down))
+	err = c.Send(context.Background(), down, "/echo", message{ID: "b"}, nil)
+	assert.ErrorIs(t, err, httpclient.ErrCircuitOpen)
+}
+
+func TestPortOffset(t *testing.T) {
+	resolve := PortOffset(1000)
+	addr, err := resolve("localhost:8081")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:9081", addr)
+
+	_, err = resolve("localhost")
+	assert.Error(t, err)
+}
+
+// The benchmarks compare a propagate-sized message over JSON/HTTP and over the
+// binary wire protocol, both with keep-alive connections.
+
+func BenchmarkSend_HTTP(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	client := httpclient.New()
+	msg := message{ID: "0f8fad5b-d9cb-469f-a165-70867728950e", NodeID: "localhost:8080"}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := client.Send(context.Background(), addr, "/counter/propagate", msg, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSend_Binary(b *testing.B) {
+	s := NewServer()
+	s.Handle("/counter/propagate", func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		var m message
+		return nil, decode(&m)
+	})
+	addr := startServer(b, s)
+	client := NewClient(nil)
+	defer client.Close()
+	msg := message{ID: "0f8fad5b-d9cb-469f-a165-70867728950e", NodeID: "localhost:8080"}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := client.Send(context.Background(), addr, "/counter/propagate", msg, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,296 @@
+// Package workpool runs background jobs with bounded concurrency, both overall
+// and per key (typically a peer address), and bounded queues.
+package workpool
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+// ErrSaturated is returned when a job cannot be queued under the Reject policy.
+var ErrSaturated = errors.New("workpool: saturated")
+
+// ErrClosed is returned when submitting to a closed pool.
+var ErrClosed = errors.New("workpool: closed")
+
+// Policy decides what happens to a new job when its queue is full.
+type Policy string
+
+const (
+	// Reject refuses the new job with ErrSaturated.
+	Reject Policy = "reject"
+	// DropOldest makes room by discarding the oldest queued job for the same
+	// key when that key's queue is full, or the oldest queued job of any key
+	// when the pool's queue is full.
+	DropOldest Policy = "drop-oldest"
+)
+
+// Config holds the pool limits. Zero values are replaced with defaults.
+type Config struct {
+	MaxWorkers      int    `json:"max_workers"`
+	MaxPerKey       int    `json:"max_per_key"`
+	MaxQueued       int    `json:"max_queued"`
+	MaxQueuedPerKey int    `json:"max_queued_per_key"`
+	Policy          Policy `json:"policy"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxWorkers <= 0 {
+		c.MaxWorkers = 64
+	}
+	if c.MaxPerKey <= 0 {
+		c.MaxPerKey = 4
+	}
+	if c.MaxQueued <= 0 {
+		c.MaxQueued = 10000
+	}
+	if c.MaxQueuedPerKey <= 0 || c.MaxQueuedPerKey > c.MaxQueued {
+		c.MaxQueuedPerKey = c.MaxQueued
+	}
+	if c.Policy == "" {
+		c.Policy = Reject
+	}
+	return c
+}
+
+// Stats is a snapshot of the pool's load.
+type Stats struct {
+	Config     Config              `json:"config"`
+	Running    int                 `json:"running"`
+	Queued     int                 `json:"queued"`
+	Saturation float64             `json:"saturation"` // queued / max queued
+	Submitted  uint64              `json:"submitted"`
+	Completed  uint64              `json:"completed"`
+	Rejected   uint64              `json:"rejected"`
+	Dropped    uint64              `json:"dropped"`
+	PerKey     map[string]KeyStats `json:"per_key"`
+}
+
+// KeyStats is the load for a single key.
+type KeyStats struct {
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+}
+
+type keyState struct {
+	running int
+	queue   []queuedJob
+}
+
+type queuedJob struct {
+	seq uint64 // submission order across keys
+	run func()
+}
+
+// Pool is a bounded, keyed worker pool.
+type Pool struct {
+	cfg Config
+
+	mu      sync.Mutex
+	keys    map[string]*keyState
+	order   []string // keys with queued jobs, in round-robin order
+	running int
+	queued  int
+	seq     uint64
+	closed  bool
+	wg      sync.WaitGroup
+
+	submitted, completed, rejected, dropped uint64
+}
+
+func New(cfg Config) *Pool {
+	return &Pool{
+		cfg:  cfg.withDefaults(),
+		keys: make(map[string]*keyState),
+	}
+}
+
+// Submit runs job for key now if limits allow, or queues it.
+func (p *Pool) Submit(key string, job func()) error {
+	return p.SubmitAll(map[string]func(){key: job})
+}
+
+// SubmitAll submits one job per key atomically: under the Reject policy
+// either every job is accepted or none is.
+func (p *Pool) SubmitAll(jobs map[string]func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+
+	if p.cfg.Policy == Reject && !p.fitsLocked(jobs) {
+		p.rejected += uint64(len(jobs))
+		return ErrSaturated
+	}
+
+	for key, job := range jobs {
+		p.submitLocked(key, job)
+	}
+	return nil
+}
+
+// fitsLocked reports whether every job can start or be queued within limits.
+func (p *Pool) fitsLocked(jobs map[string]func()) bool {
+	running, queued := p.running, p.queued
+	for key := range jobs {
+		var ks keyState
+		if existing, ok := p.keys[key]; ok {
+			ks = *existing
+		}
+		if len(ks.queue) == 0 && running < p.cfg.MaxWorkers && ks.running < p.cfg.MaxPerKey {
+			running++
+			continue
+		}
+		if len(ks.queue) >= p.cfg.MaxQueuedPerKey || queued >= p.cfg.MaxQueued {
+			return false
+		}
+		queued++
+	}
+	return true
+}
+
+func (p *Pool) submitLocked(key string, job func()) {
+	p.submitted++
+	ks, ok := p.keys[key]
+	if !ok {
+		ks = &keyState{}
+		p.keys[key] = ks
+	}
+	if len(ks.queue) == 0 && p.canStartLocked(ks) {
+		p.startLocked(key, ks, job)
+		return
+	}
+
+	// Only reachable with DropOldest: Reject checked that the jobs fit.
+	if len(ks.queue) >= p.cfg.MaxQueuedPerKey {
+		p.dropOldestLocked(key)
+	}
+	if p.queued >= p.cfg.MaxQueued {
+		p.dropOldestLocked(p.oldestKeyLocked())
+	}
+	p.keys[key] = ks // dropping may have forgotten an idle key
+	if len(ks.queue) == 0 {
+		p.order = append(p.order, key)
+	}
+	p.seq++
+	ks.queue = append(ks.queue, queuedJob{seq: p.seq, run: job})
+	p.queued++
+}
+
+// oldestKeyLocked returns the key whose first queued job was queued first.
+func (p *Pool) oldestKeyLocked() string {
+	var oldest string
+	var seq uint64
+	for _, key := range p.order {
+		if head := p.keys[key].queue[0]; oldest == "" || head.seq < seq {
+			oldest, seq = key, head.seq
+		}
+	}
+	return oldest
+}
+
+// dropOldestLocked discards the oldest queued job of key.
+func (p *Pool) dropOldestLocked(key string) {
+	ks := p.keys[key]
+	ks.queue = ks.queue[1:]
+	p.queued--
+	p.dropped++
+	if len(ks.queue) > 0 {
+		return
+	}
+	p.order = slices.DeleteFunc(p.order, func(k string) bool { return k == key })
+	if ks.running == 0 {
+		delete(p.keys, key)
+	}
+}
+
+func (p *Pool) canStartLocked(ks *keyState) bool {
+	return p.running < p.cfg.MaxWorkers && ks.running < p.cfg.MaxPerKey
+}
+
+func (p *Pool) startLocked(key string, ks *keyState, job func()) {
+	p.running++
+	ks.running++
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		job()
+		p.finish(key)
+	}()
+}
+
+func (p *Pool) finish(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running--
+	p.completed++
+	ks := p.keys[key]
+	ks.running--
+	p.dispatchLocked()
+	if ks.running == 0 && len(ks.queue) == 0 {
+		delete(p.keys, key)
+	}
+}
+
+// dispatchLocked starts queued jobs, taking keys in round-robin order so a
+// slow key cannot starve the others.
+func (p *Pool) dispatchLocked() {
+	for i := 0; i < len(p.order) && p.running < p.cfg.MaxWorkers; {
+		key := p.order[i]
+		ks := p.keys[key]
+		if !p.canStartLocked(ks) {
+			i++
+			continue
+		}
+		job := ks.queue[0].run
+		ks.queue = ks.queue[1:]
+		p.queued--
+		// Move the key to the back of the rotation, or drop it if drained.
+		p.order = append(p.order[:i], p.order[i+1:]...)
+		if len(ks.queue) > 0 {
+			p.order = append(p.order, key)
+		}
+		p.startLocked(key, ks, job)
+	}
+}
+
+// Stats returns a snapshot of the pool's load.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	perKey := make(map[string]KeyStats, len(p.keys))
+	for key, ks := range p.keys {
+		perKey[key] = KeyStats{Running: ks.running, Queued: len(ks.queue)}
+	}
+	return Stats{
+		Config:     p.cfg,
+		Running:    p.running,
+		Queued:     p.queued,
+		Saturation: float64(p.queued) / float64(p.cfg.MaxQueued),
+		Submitted:  p.submitted,
+		Completed:  p.completed,
+		Rejected:   p.rejected,
+		Dropped:    p.dropped,
+		PerKey:     perKey,
+	}
+}
+
+// Close stops accepting jobs, discards queued ones and waits for running jobs to finish.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	for key, ks := range p.keys {
+		p.dropped += uint64(len(ks.queue))
+		ks.queue = nil
+		if ks.running == 0 {
+			delete(p.keys, key)
+		}
+	}
+	p.order = nil
+	p.queued = 0
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
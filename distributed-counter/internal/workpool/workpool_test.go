@@ -0,0 +1,146 @@
+package workpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blocker returns a job that waits for release, and counts how many ran.
+func blocker(release <-chan struct{}, ran *int32) func() {
+	return func() {
+		<-release
+		atomic.AddInt32(ran, 1)
+	}
+}
+
+func TestPool_LimitsConcurrencyPerKeyAndOverall(t *testing.T) {
+	p := New(Config{MaxWorkers: 3, MaxPerKey: 2, MaxQueued: 100})
+	release := make(chan struct{})
+	var ran int32
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, p.Submit("slow-peer", blocker(release, &ran)))
+	}
+	require.NoError(t, p.Submit("fast-peer", blocker(release, &ran)))
+	require.NoError(t, p.Submit("fast-peer", blocker(release, &ran)))
+
+	stats := p.Stats()
+	assert.Equal(t, 3, stats.Running)
+	assert.Equal(t, KeyStats{Running: 2, Queued: 3}, stats.PerKey["slow-peer"])
+	assert.Equal(t, KeyStats{Running: 1, Queued: 1}, stats.PerKey["fast-peer"])
+
+	close(release)
+	assert.Eventually(t, func() bool { return p.Stats().Completed == 7 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(7), atomic.LoadInt32(&ran))
+	assert.Empty(t, p.Stats().PerKey)
+}
+
+func TestPool_RejectWhenFull(t *testing.T) {
+	p := New(Config{MaxWorkers: 1, MaxPerKey: 1, MaxQueued: 10, MaxQueuedPerKey: 1})
+	release := make(chan struct{})
+	defer close(release)
+	var ran int32
+
+	require.NoError(t, p.Submit("peer", blocker(release, &ran)))
+	require.NoError(t, p.Submit("peer", blocker(release, &ran)))
+	assert.ErrorIs(t, p.Submit("peer", blocker(release, &ran)), ErrSaturated)
+
+	stats := p.Stats()
+	assert.Equal(t, uint64(1), stats.Rejected)
+	assert.Equal(t, 0.1, stats.Saturation)
+}
+
+func TestPool_SubmitAllIsAtomic(t *testing.T) {
+	p := New(Config{MaxWorkers: 1, MaxPerKey: 1, MaxQueued: 1})
+	release := make(chan struct{})
+	defer close(release)
+	var ran int32
+
+	require.NoError(t, p.Submit("a", blocker(release, &ran)))
+	err := p.SubmitAll(map[string]func(){
+		"b": blocker(release, &ran),
+		"c": blocker(release, &ran),
+	})
+	assert.ErrorIs(t, err, ErrSaturated)
+	assert.Equal(t, 0, p.Stats().Queued, "no job from a rejected batch may be queued")
+}
+
+func TestPool_DropOldest(t *testing.T) {
+	p := New(Config{MaxWorkers: 1, MaxPerKey: 1, MaxQueuedPerKey: 2, Policy: DropOldest})
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	var order []int
+	job := func(i int) func() {
+		return func() {
+			<-release
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}
+	}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, p.Submit("peer", job(i)))
+	}
+	assert.Equal(t, uint64(2), p.Stats().Dropped)
+
+	close(release)
+	assert.Eventually(t, func() bool { return p.Stats().Completed == 3 }, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []int{0, 3, 4}, order)
+	mu.Unlock()
+}
+
+func TestPool_DropOldestAcrossKeys(t *testing.T) {
+	p := New(Config{MaxWorkers: 1, MaxPerKey: 1, MaxQueued: 2, Policy: DropOldest})
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	var order []string
+	job := func(name string) func() {
+		return func() {
+			<-release
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+	require.NoError(t, p.Submit("a", job("a0")))
+	require.NoError(t, p.Submit("a", job("a1")))
+	require.NoError(t, p.Submit("b", job("b1")))
+	// The pool's queue is full and c has nothing queued: the oldest queued
+	// job of any key, a1, makes room.
+	require.NoError(t, p.Submit("c", job("c1")))
+	stats := p.Stats()
+	assert.Equal(t, uint64(1), stats.Dropped)
+	assert.Equal(t, 2, stats.Queued)
+	assert.Equal(t, 0, stats.PerKey["a"].Queued)
+
+	close(release)
+	assert.Eventually(t, func() bool { return p.Stats().Completed == 3 }, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"a0", "b1", "c1"}, order)
+	mu.Unlock()
+}
+
+func TestPool_CloseDiscardsQueuedJobs(t *testing.T) {
+	p := New(Config{MaxWorkers: 1, MaxPerKey: 1})
+	release := make(chan struct{})
+	var ran int32
+	require.NoError(t, p.Submit("peer", blocker(release, &ran)))
+	require.NoError(t, p.Submit("peer", blocker(release, &ran)))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	p.Close()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ran))
+	assert.ErrorIs(t, p.Submit("peer", func() {}), ErrClosed)
+}